@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/coinbase/rosetta-sdk-go/types"
+	"github.com/oasisprotocol/oasis-core/go/common/logging"
+
+	"github.com/oasisprotocol/oasis-core-rosetta-gateway/common"
+)
+
+// maxRequestIDLength is the maximum length of a request ID given in the
+// request's header, longer IDs are replaced by a new one.
+const maxRequestIDLength = 128
+
+// maxErrorBodyLength is the maximum length of an error response's body that
+// is recorded to log the error's code.
+const maxErrorBodyLength = 64 * 1024
+
+// maxRequestBodyLength is the maximum length of a request's body, longer
+// requests fail to be read.
+const maxRequestBodyLength = 1024 * 1024
+
+var accessLogger = logging.GetLogger("oasis-rosetta-gateway/access")
+
+// responseRecorder is an http.ResponseWriter that records the status and,
+// for errors, the body of the response.
+type responseRecorder struct {
+	http.ResponseWriter
+
+	status int
+	body   bytes.Buffer
+}
+
+func (rr *responseRecorder) WriteHeader(status int) {
+	rr.status = status
+	rr.ResponseWriter.WriteHeader(status)
+}
+
+func (rr *responseRecorder) Write(b []byte) (int, error) {
+	if rr.status == 0 {
+		rr.status = http.StatusOK
+	}
+	if rr.status != http.StatusOK && rr.body.Len() < maxErrorBodyLength {
+		rr.body.Write(b)
+	}
+	return rr.ResponseWriter.Write(b)
+}
+
+// withAccessLog returns a handler that assigns each request an ID (unless
+// the request's X-Request-ID header already has a valid one), passes it on to
+// the given handler in the request's context and in the response's
+// X-Request-ID header, and logs one line per request.
+func withAccessLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		requestID := r.Header.Get(common.RequestIDHeader)
+		if !isValidRequestID(requestID) {
+			requestID = newRequestID()
+		}
+		w.Header().Set(common.RequestIDHeader, requestID)
+		network := requestNetwork(w, r)
+
+		rr := &responseRecorder{ResponseWriter: w}
+		next.ServeHTTP(rr, r.WithContext(common.WithRequestID(r.Context(), requestID)))
+		if rr.status == 0 {
+			rr.status = http.StatusOK
+		}
+
+		keyvals := []interface{}{
+			common.RequestIDKey, requestID,
+			"method", r.Method,
+			"endpoint", r.URL.Path,
+			"network", network,
+			"status", rr.status,
+			"latency", time.Since(start),
+		}
+		if code, ok := responseErrorCode(rr.body.Bytes()); ok {
+			keyvals = append(keyvals, "error_code", code)
+		}
+		accessLogger.Info("request served", keyvals...)
+	})
+}
+
+// isValidRequestID returns true if the given request ID is non-empty, not too
+// long and only contains characters that are safe to log.
+func isValidRequestID(requestID string) bool {
+	if requestID == "" || len(requestID) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range requestID {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-' || c == '_' || c == '.' || c == ':':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// newRequestID returns a new random request ID.
+func newRequestID() string {
+	var b [16]byte
+	_, _ = rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// peekRequestBody returns the body of the given request, leaving the
+// request's body intact.
+//
+// Bodies longer than maxRequestBodyLength are not returned, and are left
+// failing to be read, so that the request's handler rejects them too.
+func peekRequestBody(w http.ResponseWriter, r *http.Request) []byte {
+	if r.Body == nil {
+		return nil
+	}
+	body := http.MaxBytesReader(w, r.Body, maxRequestBodyLength)
+	raw, err := ioutil.ReadAll(body)
+	if err != nil {
+		r.Body = body
+		return nil
+	}
+	body.Close()
+	r.Body = ioutil.NopCloser(bytes.NewReader(raw))
+	return raw
+}
+
+// requestNetwork returns the network of the given request's network
+// identifier (if any), leaving the request's body intact.
+func requestNetwork(w http.ResponseWriter, r *http.Request) string {
+	var request struct {
+		NetworkIdentifier *types.NetworkIdentifier `json:"network_identifier"`
+	}
+	if err := json.Unmarshal(peekRequestBody(w, r), &request); err != nil || request.NetworkIdentifier == nil {
+		return ""
+	}
+	return request.NetworkIdentifier.Network
+}
+
+// responseErrorCode returns the code of the Rosetta error in the given
+// response body (if any).
+func responseErrorCode(body []byte) (int32, bool) {
+	if len(body) == 0 {
+		return 0, false
+	}
+	var terr struct {
+		Code *int32 `json:"code"`
+	}
+	if err := json.Unmarshal(body, &terr); err != nil || terr.Code == nil {
+		return 0, false
+	}
+	return *terr.Code, true
+}
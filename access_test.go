@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/coinbase/rosetta-sdk-go/types"
+
+	"github.com/oasisprotocol/oasis-core-rosetta-gateway/common"
+	"github.com/oasisprotocol/oasis-core-rosetta-gateway/services"
+)
+
+func TestAccessLog(t *testing.T) {
+	var handledID, handledBody string
+	handler := withAccessLog(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handledID = common.RequestIDFromContext(r.Context())
+		body, _ := ioutil.ReadAll(r.Body)
+		handledBody = string(body)
+
+		w.WriteHeader(http.StatusInternalServerError)
+		_ = json.NewEncoder(w).Encode(services.ErrUnableToGetBlk)
+	}))
+
+	const reqBody = `{"network_identifier":{"blockchain":"Oasis","network":"test"}}`
+	for _, tc := range []struct {
+		name      string
+		requestID string
+		keep      bool
+	}{
+		{"Given", "client-id_1.2:3", true},
+		{"Missing", "", false},
+		{"Invalid", "bad id\n", false},
+		{"TooLong", strings.Repeat("a", maxRequestIDLength+1), false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/block", strings.NewReader(reqBody))
+			if tc.requestID != "" {
+				req.Header.Set(common.RequestIDHeader, tc.requestID)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			respID := rec.Header().Get(common.RequestIDHeader)
+			if handledID != respID || !isValidRequestID(respID) {
+				t.Fatalf("request ID %q differs from response ID %q", handledID, respID)
+			}
+			if tc.keep != (respID == tc.requestID) {
+				t.Fatalf("unexpected request ID: %q", respID)
+			}
+			if handledBody != reqBody {
+				t.Fatalf("request body not passed on: %q", handledBody)
+			}
+		})
+	}
+}
+
+func TestAccessLogHelpers(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/block", strings.NewReader(`{"network_identifier":{"network":"net"}}`))
+	if network := requestNetwork(httptest.NewRecorder(), req); network != "net" {
+		t.Fatalf("unexpected network: %q", network)
+	}
+	req = httptest.NewRequest(http.MethodPost, "/block", strings.NewReader("{"))
+	if network := requestNetwork(httptest.NewRecorder(), req); network != "" {
+		t.Fatalf("unexpected network for malformed request: %q", network)
+	}
+
+	// Oversized bodies are neither peeked at nor passed on.
+	body := `{"network_identifier":{"network":"net"},"padding":"` + strings.Repeat("x", maxRequestBodyLength) + `"}`
+	req = httptest.NewRequest(http.MethodPost, "/block", strings.NewReader(body))
+	if network := requestNetwork(httptest.NewRecorder(), req); network != "" {
+		t.Fatalf("unexpected network for oversized request: %q", network)
+	}
+	if _, err := ioutil.ReadAll(req.Body); err == nil {
+		t.Fatalf("expected an error reading an oversized request body")
+	}
+
+	raw, _ := json.Marshal(&types.Error{Code: 12, Message: "unable to get block"})
+	if code, ok := responseErrorCode(raw); !ok || code != 12 {
+		t.Fatalf("unexpected error code: %d %v", code, ok)
+	}
+	if _, ok := responseErrorCode([]byte(`{"block":{}}`)); ok {
+		t.Fatalf("expected no error code for a non-error response")
+	}
+
+	if newRequestID() == newRequestID() {
+		t.Fatalf("expected different request IDs")
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/coinbase/rosetta-sdk-go/types"
+	"github.com/oasisprotocol/oasis-core/go/common/crypto/signature"
+	staking "github.com/oasisprotocol/oasis-core/go/staking/api"
+
+	"github.com/oasisprotocol/oasis-core-rosetta-gateway/services"
+)
+
+const (
+	// addressCmdName is the name of the subcommand with the address
+	// utilities.
+	addressCmdName = "address"
+
+	addressDeriveCmdName   = "derive"
+	addressValidateCmdName = "validate"
+	addressKnownCmdName    = "known"
+)
+
+// knownAddresses are the well-known system addresses.
+var knownAddresses = []struct {
+	name    string
+	address staking.Address
+}{
+	{"FeeAccumulator", staking.FeeAccumulatorAddress},
+	{"CommonPool", staking.CommonPoolAddress},
+	{"GovernanceDeposits", staking.GovernanceDepositsAddress},
+}
+
+// runAddressCmd runs one of the address utilities.
+func runAddressCmd(args []string) error {
+	fs := flag.NewFlagSet(addressCmdName, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s %s SUBCOMMAND [ARG]\n\n", os.Args[0], addressCmdName)
+		fmt.Fprintf(fs.Output(), "Subcommands:\n")
+		fmt.Fprintf(fs.Output(), "  %s PUBLIC_KEY\tderive the account identifier of a hex or Base64-encoded "+
+			"Ed25519 public key\n", addressDeriveCmdName)
+		fmt.Fprintf(fs.Output(), "  %s ACCOUNT\tvalidate an address or an account identifier JSON\n",
+			addressValidateCmdName)
+		fmt.Fprintf(fs.Output(), "  %s\t\tlist the well-known system addresses\n", addressKnownCmdName)
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	switch {
+	case fs.Arg(0) == addressDeriveCmdName && fs.NArg() == 2:
+		return deriveAccount(os.Stdout, fs.Arg(1))
+	case fs.Arg(0) == addressValidateCmdName && fs.NArg() == 2:
+		return validateAccount(os.Stdout, os.Stderr, fs.Arg(1))
+	case fs.Arg(0) == addressKnownCmdName && fs.NArg() == 1:
+		for _, ka := range knownAddresses {
+			fmt.Printf("%-20s %s\n", ka.name, services.StringFromAddress(ka.address))
+		}
+		return nil
+	default:
+		fs.Usage()
+		return flag.ErrHelp
+	}
+}
+
+// deriveAccount writes the account identifier of the given hex or
+// Base64-encoded public key to the given writer, as returned by
+// /construction/derive.
+func deriveAccount(w io.Writer, rawPublicKey string) error {
+	var pkBytes []byte
+	var err error
+	switch rawPublicKey = strings.TrimSpace(rawPublicKey); len(rawPublicKey) {
+	case 2 * signature.PublicKeySize:
+		pkBytes, err = hex.DecodeString(rawPublicKey)
+	default:
+		pkBytes, err = base64.StdEncoding.DecodeString(rawPublicKey)
+	}
+	if err != nil {
+		return fmt.Errorf("malformed public key: neither hex nor Base64: %w", err)
+	}
+	var pk signature.PublicKey
+	if err = pk.UnmarshalBinary(pkBytes); err != nil {
+		return fmt.Errorf("malformed public key: %w", err)
+	}
+
+	return printAccountIdentifier(w, &types.AccountIdentifier{
+		Address: services.StringFromAddress(staking.NewAddress(pk)),
+	})
+}
+
+// validateAccount validates the given address or account identifier JSON and
+// writes its account identifier to the given writer.  If the address is one of
+// the well-known system addresses, a note saying so is written to the given
+// notes writer.
+func validateAccount(w, notes io.Writer, account string) error {
+	account = strings.TrimSpace(account)
+
+	var ai types.AccountIdentifier
+	if strings.HasPrefix(account, "{") {
+		if err := json.Unmarshal([]byte(account), &ai); err != nil {
+			return fmt.Errorf("malformed account identifier: %w", err)
+		}
+	} else {
+		ai.Address = account
+	}
+
+	var addr staking.Address
+	if err := addr.UnmarshalText([]byte(ai.Address)); err != nil {
+		return fmt.Errorf("invalid address: %w", err)
+	}
+	if ai.SubAccount != nil && ai.SubAccount.Address != services.SubAccountEscrow {
+		return fmt.Errorf("invalid sub-account: %s (expected: none or %s)",
+			ai.SubAccount.Address, services.SubAccountEscrow,
+		)
+	}
+	// Normalize the address, as Bech32 also accepts upper case.
+	ai.Address = services.StringFromAddress(addr)
+
+	if err := printAccountIdentifier(w, &ai); err != nil {
+		return err
+	}
+	for _, ka := range knownAddresses {
+		if addr.Equal(ka.address) {
+			fmt.Fprintf(notes, "Well-known system address: %s\n", ka.name)
+		}
+	}
+	return nil
+}
+
+func printAccountIdentifier(w io.Writer, ai *types.AccountIdentifier) error {
+	raw, err := json.MarshalIndent(ai, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal account identifier: %w", err)
+	}
+	fmt.Fprintln(w, string(raw))
+	return nil
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/coinbase/rosetta-sdk-go/types"
+	staking "github.com/oasisprotocol/oasis-core/go/staking/api"
+
+	"github.com/oasisprotocol/oasis-core-rosetta-gateway/services"
+)
+
+func TestDeriveAccount(t *testing.T) {
+	pk, _ := testSigner.Public().MarshalBinary()
+	expected, err := services.NewConstructionAPIService(nil, nil, nil, nil).ConstructionDerive(context.Background(),
+		&types.ConstructionDeriveRequest{
+			NetworkIdentifier: &types.NetworkIdentifier{
+				Blockchain: services.OasisBlockchainName,
+				Network:    testChainContext,
+			},
+			PublicKey: &types.PublicKey{Bytes: pk, CurveType: types.Edwards25519},
+		},
+	)
+	if err != nil {
+		t.Fatalf("unable to derive account: %v", types.PrettyPrintStruct(err))
+	}
+
+	for _, encoded := range []string{hex.EncodeToString(pk), base64.StdEncoding.EncodeToString(pk)} {
+		var out bytes.Buffer
+		if err := deriveAccount(&out, encoded); err != nil {
+			t.Fatalf("unable to derive account from %s: %v", encoded, err)
+		}
+		var ai types.AccountIdentifier
+		if err := json.Unmarshal(out.Bytes(), &ai); err != nil {
+			t.Fatalf("malformed account identifier: %v", err)
+		}
+		if ai.Address != expected.AccountIdentifier.Address || ai.SubAccount != nil {
+			t.Fatalf("unexpected account identifier: %s", out.String())
+		}
+	}
+
+	for _, encoded := range []string{"", "not a key", hex.EncodeToString(pk[:16])} {
+		if err := deriveAccount(ioutil.Discard, encoded); err == nil {
+			t.Fatalf("expected an error for public key %q", encoded)
+		}
+	}
+}
+
+func TestValidateAccount(t *testing.T) {
+	addr := services.StringFromAddress(staking.NewAddress(testSigner.Public()))
+	commonPool := services.StringFromAddress(staking.CommonPoolAddress)
+
+	for _, tc := range []struct {
+		name    string
+		account string
+		valid   bool
+		note    string
+	}{
+		{"Address", addr, true, ""},
+		{"UpperCase", strings.ToUpper(addr), true, ""},
+		{"AccountIdentifier", `{"address":"` + addr + `"}`, true, ""},
+		{"Escrow", `{"address":"` + addr + `","sub_account":{"address":"escrow"}}`, true, ""},
+		{"System", commonPool, true, "CommonPool"},
+		{"Checksum", addr[:len(addr)-1] + "x", false, ""},
+		{"HRP", "foo" + addr[len("oasis"):], false, ""},
+		{"SubAccount", `{"address":"` + addr + `","sub_account":{"address":"foo"}}`, false, ""},
+		{"MalformedJSON", `{"address":`, false, ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var out, notes bytes.Buffer
+			err := validateAccount(&out, &notes, tc.account)
+			switch {
+			case !tc.valid && err == nil:
+				t.Fatalf("expected an error")
+			case !tc.valid:
+				return
+			case err != nil:
+				t.Fatalf("unable to validate account: %v", err)
+			}
+			var ai types.AccountIdentifier
+			if err = json.Unmarshal(out.Bytes(), &ai); err != nil {
+				t.Fatalf("malformed account identifier: %v", err)
+			}
+			if ai.Address != strings.ToLower(ai.Address) {
+				t.Fatalf("address not normalized: %s", ai.Address)
+			}
+			if !strings.Contains(notes.String(), tc.note) || (tc.note == "" && notes.Len() > 0) {
+				t.Fatalf("unexpected notes: %q", notes.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coinbase/rosetta-sdk-go/server"
+	"github.com/oasisprotocol/oasis-core/go/common/logging"
+
+	"github.com/oasisprotocol/oasis-core-rosetta-gateway/services"
+)
+
+// credentialsReloadInterval is the interval at which the credentials file is
+// checked for changes.
+const credentialsReloadInterval = 5 * time.Second
+
+const (
+	// credentialKindToken is the kind of bearer token credentials.
+	credentialKindToken = "token"
+	// credentialKindCert is the kind of client certificate credentials,
+	// identified by the certificate's subject common name.
+	credentialKindCert = "cert"
+)
+
+var loggerAuth = logging.GetLogger("oasis-rosetta-gateway/auth")
+
+// permissions is a set of permissions to access endpoints.
+type permissions uint8
+
+const (
+	// permData permits access to the Data API.
+	permData permissions = 1 << iota
+	// permConstruction permits access to the Construction API, except for
+	// /construction/submit.
+	permConstruction
+	// permSubmit permits access to /construction/submit.
+	permSubmit
+
+	permAll = permData | permConstruction | permSubmit
+)
+
+var permissionNames = map[string]permissions{
+	"data":         permData,
+	"construction": permConstruction,
+	"submit":       permSubmit,
+}
+
+// endpointPermission returns the permission that the given endpoint requires.
+func endpointPermission(path string) permissions {
+	switch {
+	case path == "/construction/submit":
+		return permSubmit
+	case strings.HasPrefix(path, "/construction/"):
+		return permConstruction
+	default:
+		return permData
+	}
+}
+
+// credentials are the permissions of clients' credentials.
+type credentials struct {
+	// tokens are the permissions of bearer tokens, indexed by the tokens'
+	// SHA-256 hashes.
+	tokens map[[sha256.Size]byte]permissions
+	// certs are the permissions of client certificates, indexed by the
+	// certificates' subject common names.
+	certs map[string]permissions
+}
+
+// parseCredentials parses a credentials file, with one credential per line:
+//
+//	<kind> <credential> <permission>[,<permission>...]
+//
+// where kind is token or cert and the permissions are data, construction and
+// submit.  Empty lines and lines starting with # are ignored.
+func parseCredentials(raw []byte) (*credentials, error) {
+	creds := &credentials{
+		tokens: make(map[[sha256.Size]byte]permissions),
+		certs:  make(map[string]permissions),
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(raw))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("line %d: expected <kind> <credential> <permissions>", n)
+		}
+		var perms permissions
+		for _, name := range strings.Split(fields[2], ",") {
+			perm, ok := permissionNames[name]
+			if !ok {
+				return nil, fmt.Errorf("line %d: unknown permission: %s", n, name)
+			}
+			perms |= perm
+		}
+		switch fields[0] {
+		case credentialKindToken:
+			creds.tokens[sha256.Sum256([]byte(fields[1]))] = perms
+		case credentialKindCert:
+			creds.certs[fields[1]] = perms
+		default:
+			return nil, fmt.Errorf("line %d: unknown credential kind: %s", n, fields[0])
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return creds, nil
+}
+
+// credentialsFile is a credentials file that is reloaded when it changes.
+type credentialsFile struct {
+	sync.RWMutex
+
+	path    string
+	modTime time.Time
+	creds   *credentials
+}
+
+// loadCredentialsFile loads the given credentials file.
+func loadCredentialsFile(path string) (*credentialsFile, error) {
+	cf := &credentialsFile{path: path}
+	if err := cf.reload(); err != nil {
+		return nil, err
+	}
+	return cf, nil
+}
+
+// reload reloads the credentials file if it was modified since it was last
+// loaded.  If it is malformed, the previous credentials are kept.
+func (cf *credentialsFile) reload() error {
+	fi, err := os.Stat(cf.path)
+	if err != nil {
+		return fmt.Errorf("failed to stat credentials file: %w", err)
+	}
+	cf.RLock()
+	unchanged := cf.creds != nil && fi.ModTime().Equal(cf.modTime)
+	cf.RUnlock()
+	if unchanged {
+		return nil
+	}
+
+	raw, err := ioutil.ReadFile(cf.path)
+	if err != nil {
+		return fmt.Errorf("failed to read credentials file: %w", err)
+	}
+	creds, err := parseCredentials(raw)
+	if err != nil {
+		return fmt.Errorf("malformed credentials file: %w", err)
+	}
+
+	cf.Lock()
+	defer cf.Unlock()
+	cf.creds = creds
+	cf.modTime = fi.ModTime()
+	return nil
+}
+
+// watch reloads the credentials file whenever it changes.
+func (cf *credentialsFile) watch() {
+	for range time.Tick(credentialsReloadInterval) {
+		if err := cf.reload(); err != nil {
+			loggerAuth.Error("failed to reload credentials, keeping previous ones",
+				"err", err,
+				"path", cf.path,
+			)
+		}
+	}
+}
+
+func (cf *credentialsFile) current() *credentials {
+	cf.RLock()
+	defer cf.RUnlock()
+	return cf.creds
+}
+
+type clientIdentityContextKey struct{}
+
+// withClientIdentity returns a copy of the given context that carries the
+// identity of the authenticated client.
+func withClientIdentity(ctx context.Context, identity string) context.Context {
+	return context.WithValue(ctx, clientIdentityContextKey{}, identity)
+}
+
+// clientIdentityFromContext returns the identity of the authenticated client
+// carried by the given context or an empty string if there is none.
+func clientIdentityFromContext(ctx context.Context) string {
+	identity, _ := ctx.Value(clientIdentityContextKey{}).(string)
+	return identity
+}
+
+// authenticator authenticates the clients of requests.
+type authenticator interface {
+	// authenticate returns the identity and the permissions of the given
+	// request's client, or false if the request doesn't carry valid
+	// credentials of the authenticator's kind.
+	authenticate(r *http.Request) (string, permissions, bool)
+}
+
+// bearerTokenAuthenticator authenticates clients by the bearer token in the
+// request's Authorization header.
+type bearerTokenAuthenticator struct {
+	creds *credentialsFile
+}
+
+// The identity of a client is the hash of its token, so that the token
+// itself isn't kept around.
+func (a *bearerTokenAuthenticator) authenticate(r *http.Request) (string, permissions, bool) {
+	const prefix = "Bearer "
+	auth := r.Header.Get("Authorization")
+	if len(auth) <= len(prefix) || !strings.EqualFold(auth[:len(prefix)], prefix) {
+		return "", 0, false
+	}
+	tokenHash := sha256.Sum256([]byte(auth[len(prefix):]))
+	perms, ok := a.creds.current().tokens[tokenHash]
+	return credentialKindToken + ":" + hex.EncodeToString(tokenHash[:]), perms, ok
+}
+
+// clientCertAuthenticator authenticates clients by their verified TLS client
+// certificate.  Without a credentials file, all verified clients have all
+// permissions.
+type clientCertAuthenticator struct {
+	creds *credentialsFile
+}
+
+// The identity of a client is its certificate's subject common name.
+func (a *clientCertAuthenticator) authenticate(r *http.Request) (string, permissions, bool) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return "", 0, false
+	}
+	commonName := r.TLS.VerifiedChains[0][0].Subject.CommonName
+	identity := credentialKindCert + ":" + commonName
+	if a.creds == nil {
+		return identity, permAll, true
+	}
+	perms, ok := a.creds.current().certs[commonName]
+	return identity, perms, ok
+}
+
+// authMiddleware only passes on requests whose client is authenticated by
+// one of the authenticators and has the permission the endpoint requires.
+type authMiddleware struct {
+	authenticators []authenticator
+}
+
+// wrap returns a handler that passes permitted requests on to the given
+// handler, with the client's identity in the request's context, and rejects
+// the others with services.ErrUnauthorized or services.ErrForbidden.
+func (am *authMiddleware) wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var identity string
+		var perms permissions
+		var authenticated bool
+		for _, a := range am.authenticators {
+			if identity, perms, authenticated = a.authenticate(r); authenticated {
+				break
+			}
+		}
+
+		switch {
+		case !authenticated:
+			// Rosetta clients only decode errors of responses with this status.
+			server.EncodeJSONResponse(services.ErrUnauthorized, http.StatusInternalServerError, w)
+		case perms&endpointPermission(r.URL.Path) == 0:
+			server.EncodeJSONResponse(services.ErrForbidden, http.StatusInternalServerError, w)
+		default:
+			next.ServeHTTP(w, r.WithContext(withClientIdentity(r.Context(), identity)))
+		}
+	})
+}
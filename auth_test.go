@@ -0,0 +1,141 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/coinbase/rosetta-sdk-go/types"
+
+	"github.com/oasisprotocol/oasis-core-rosetta-gateway/services"
+)
+
+const testCredentials = `
+# Partners.
+token partner-token data
+# Internal callers.
+token internal-token data,construction,submit
+cert  internal-client data,construction,submit
+`
+
+func TestParseCredentials(t *testing.T) {
+	creds, err := parseCredentials([]byte(testCredentials))
+	if err != nil {
+		t.Fatalf("unable to parse credentials: %v", err)
+	}
+	if len(creds.tokens) != 2 || creds.certs["internal-client"] != permAll {
+		t.Fatalf("unexpected credentials: %+v", creds)
+	}
+
+	for _, raw := range []string{
+		"token foo",
+		"token foo data extra",
+		"token foo data,admin",
+		"password foo data",
+	} {
+		if _, err = parseCredentials([]byte(raw)); err == nil {
+			t.Fatalf("expected an error for %q", raw)
+		}
+	}
+}
+
+func TestAuthMiddleware(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "credentials")
+	if err := ioutil.WriteFile(path, []byte(testCredentials), 0o600); err != nil {
+		t.Fatalf("unable to write credentials: %v", err)
+	}
+	creds, err := loadCredentialsFile(path)
+	if err != nil {
+		t.Fatalf("unable to load credentials: %v", err)
+	}
+	am := &authMiddleware{
+		authenticators: []authenticator{
+			&bearerTokenAuthenticator{creds: creds},
+			&clientCertAuthenticator{creds: creds},
+		},
+	}
+	handler := am.wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	requireAccess := func(endpoint, token, certName string, expected *types.Error) {
+		t.Helper()
+
+		req := httptest.NewRequest(http.MethodPost, endpoint, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		if certName != "" {
+			req.TLS = &tls.ConnectionState{
+				VerifiedChains: [][]*x509.Certificate{{{Subject: pkix.Name{CommonName: certName}}}},
+			}
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if expected == nil {
+			if rec.Code != http.StatusOK {
+				t.Fatalf("%s: unexpected response: %d %s", endpoint, rec.Code, rec.Body.String())
+			}
+			return
+		}
+		var terr types.Error
+		if err := json.Unmarshal(rec.Body.Bytes(), &terr); err != nil || terr.Code != expected.Code {
+			t.Fatalf("%s: expected error %q, got %d %s", endpoint, expected.Message, rec.Code, rec.Body.String())
+		}
+	}
+
+	requireAccess("/block", "partner-token", "", nil)
+	requireAccess("/construction/payloads", "partner-token", "", services.ErrForbidden)
+	requireAccess("/construction/submit", "partner-token", "", services.ErrForbidden)
+	requireAccess("/construction/submit", "internal-token", "", nil)
+	requireAccess("/construction/submit", "", "internal-client", nil)
+	requireAccess("/block", "", "", services.ErrUnauthorized)
+	requireAccess("/block", "wrong-token", "", services.ErrUnauthorized)
+	requireAccess("/block", "", "other-client", services.ErrUnauthorized)
+
+	// Changes are picked up on reload.
+	if err = ioutil.WriteFile(path, []byte("token partner-token data,construction\n"), 0o600); err != nil {
+		t.Fatalf("unable to write credentials: %v", err)
+	}
+	future := time.Now().Add(time.Minute)
+	_ = os.Chtimes(path, future, future)
+	if err = creds.reload(); err != nil {
+		t.Fatalf("unable to reload credentials: %v", err)
+	}
+	requireAccess("/construction/payloads", "partner-token", "", nil)
+	requireAccess("/construction/submit", "internal-token", "", services.ErrUnauthorized)
+
+	// Malformed changes are not.
+	if err = ioutil.WriteFile(path, []byte("token partner-token\n"), 0o600); err != nil {
+		t.Fatalf("unable to write credentials: %v", err)
+	}
+	future = future.Add(time.Minute)
+	_ = os.Chtimes(path, future, future)
+	if err = creds.reload(); err == nil {
+		t.Fatalf("expected an error for malformed credentials")
+	}
+	requireAccess("/construction/payloads", "partner-token", "", nil)
+}
+
+func TestClientCertAuthenticatorWithoutCredentials(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/construction/submit", nil)
+	a := &clientCertAuthenticator{}
+	if _, _, ok := a.authenticate(req); ok {
+		t.Fatalf("expected no authentication without a certificate")
+	}
+	req.TLS = &tls.ConnectionState{
+		VerifiedChains: [][]*x509.Certificate{{{Subject: pkix.Name{CommonName: "any"}}}},
+	}
+	if identity, perms, ok := a.authenticate(req); !ok || perms != permAll || identity != "cert:any" {
+		t.Fatalf("expected all permissions for a verified certificate")
+	}
+}
@@ -0,0 +1,71 @@
+// Package badgerdb implements helpers shared by the Badger-backed stores of
+// the gateway.
+package badgerdb
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/dgraph-io/badger"
+
+	"github.com/oasisprotocol/oasis-core/go/common/logging"
+)
+
+// Open opens (or creates) a Badger database in the given directory, which
+// forwards its log messages to the given logger.
+func Open(dir string, logger *logging.Logger) (*badger.DB, error) {
+	return badger.Open(badger.DefaultOptions(dir).WithLogger(&badgerLogger{logger}))
+}
+
+// GetUint64 returns the big-endian encoded uint64 value of the given key. The
+// second return value is false if the key is not set.
+func GetUint64(txn *badger.Txn, key []byte) (uint64, bool, error) {
+	item, err := txn.Get(key)
+	switch {
+	case err == nil:
+	case errors.Is(err, badger.ErrKeyNotFound):
+		return 0, false, nil
+	default:
+		return 0, false, fmt.Errorf("failed to read %s: %w", key, err)
+	}
+	raw, err := item.ValueCopy(nil)
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to read %s: %w", key, err)
+	}
+	if len(raw) != 8 {
+		return 0, false, fmt.Errorf("malformed %s", key)
+	}
+	return binary.BigEndian.Uint64(raw), true, nil
+}
+
+// SetUint64 sets the given key to the big-endian encoding of the given value.
+func SetUint64(txn *badger.Txn, key []byte, value uint64) error {
+	var raw [8]byte
+	binary.BigEndian.PutUint64(raw[:], value)
+	if err := txn.Set(key, raw[:]); err != nil {
+		return fmt.Errorf("failed to write %s: %w", key, err)
+	}
+	return nil
+}
+
+// badgerLogger forwards Badger's log messages to a logger.
+type badgerLogger struct {
+	logger *logging.Logger
+}
+
+func (l *badgerLogger) Errorf(format string, args ...interface{}) {
+	l.logger.Error(fmt.Sprintf(format, args...))
+}
+
+func (l *badgerLogger) Warningf(format string, args ...interface{}) {
+	l.logger.Warn(fmt.Sprintf(format, args...))
+}
+
+func (l *badgerLogger) Infof(format string, args ...interface{}) {
+	l.logger.Debug(fmt.Sprintf(format, args...))
+}
+
+func (l *badgerLogger) Debugf(format string, args ...interface{}) {
+	l.logger.Debug(fmt.Sprintf(format, args...))
+}
@@ -0,0 +1,38 @@
+package common
+
+import (
+	"context"
+
+	"github.com/oasisprotocol/oasis-core/go/common/logging"
+)
+
+// RequestIDHeader is the HTTP header with the ID that correlates the logs of
+// a request.
+const RequestIDHeader = "X-Request-ID"
+
+// RequestIDKey is the key of the request ID in logs.
+const RequestIDKey = "request_id"
+
+type requestIDContextKey struct{}
+
+// WithRequestID returns a copy of the given context that carries the given
+// request ID.
+func WithRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, requestIDContextKey{}, requestID)
+}
+
+// RequestIDFromContext returns the request ID carried by the given context or
+// an empty string if there is none.
+func RequestIDFromContext(ctx context.Context) string {
+	requestID, _ := ctx.Value(requestIDContextKey{}).(string)
+	return requestID
+}
+
+// LoggerWithRequestID returns the given logger with the request ID carried by
+// the given context (if any) added to all of its logs.
+func LoggerWithRequestID(ctx context.Context, logger *logging.Logger) *logging.Logger {
+	if requestID := RequestIDFromContext(ctx); requestID != "" {
+		return logger.With(RequestIDKey, requestID)
+	}
+	return logger
+}
@@ -10,6 +10,11 @@ require (
 	github.com/dgraph-io/badger v1.6.2
 	github.com/oasisprotocol/ed25519 v0.0.0-20210127160119-f7017427c1ea
 	github.com/oasisprotocol/oasis-core/go v0.2101.0
+	github.com/prometheus/client_golang v1.10.0
+	github.com/tendermint/tendermint v0.34.9
 	github.com/vmihailenco/msgpack/v5 v5.1.4
+	golang.org/x/crypto v0.0.0-20201117144127-c1f2f97bffc9
+	golang.org/x/sync v0.0.0-20201207232520-09787c993a3a
+	golang.org/x/text v0.3.3
 	google.golang.org/grpc v1.37.0
 )
@@ -0,0 +1,345 @@
+// Package history implements a persistent store of historical account
+// balances, which can be used to answer balance queries at heights for which
+// the node no longer has state.
+package history
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+
+	"github.com/dgraph-io/badger"
+
+	"github.com/oasisprotocol/oasis-core/go/common/logging"
+
+	"github.com/oasisprotocol/oasis-core-rosetta-gateway/common/badgerdb"
+)
+
+var (
+	// ErrNotAvailable is the error returned when the requested height is not
+	// within the range of heights covered by the store.
+	ErrNotAvailable = errors.New("history: height not available")
+
+	// ErrNotContiguous is the error returned when applying a block that does
+	// not directly follow the latest applied block.
+	ErrNotContiguous = errors.New("history: block does not follow latest block")
+
+	// ErrAlreadyInitialized is the error returned when trying to set a
+	// checkpoint on a store that already has one.
+	ErrAlreadyInitialized = errors.New("history: store already initialized")
+)
+
+var (
+	// Key prefixes.
+	balancePrefix   = []byte("b/")
+	blockHashPrefix = []byte("h/")
+
+	// Metadata keys.
+	oldestKey = []byte("m/oldest")
+	latestKey = []byte("m/latest")
+	// checkpointKey is set to the checkpoint height while the checkpoint's
+	// balances are being written and cleared together with setting the
+	// latest block once they all are.
+	checkpointKey = []byte("m/checkpoint")
+)
+
+var logger = logging.GetLogger("history")
+
+// Block is the identifier of a block covered by the store.
+type Block struct {
+	Height int64
+	Hash   string
+}
+
+// Store is a persistent store of historical account balances.
+//
+// Balances are keyed by opaque account keys and are only stored at heights at
+// which they changed. The store is initialized from a checkpoint containing
+// all balances at some height and then fed balance changes block by block.
+type Store struct {
+	sync.Mutex
+
+	db *badger.DB
+}
+
+// Open opens (or creates) a balance history store in the given directory.
+func Open(dir string) (*Store, error) {
+	db, err := badgerdb.Open(dir, logger)
+	if err != nil {
+		return nil, fmt.Errorf("history: failed to open database: %w", err)
+	}
+	return &Store{db: db}, nil
+}
+
+// Close closes the store.
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// Range returns the oldest and the latest block for which balances can be
+// queried. The last return value is false if the store has no usable range
+// yet.
+func (s *Store) Range() (*Block, *Block, bool, error) {
+	var oldest, latest *Block
+	err := s.db.View(func(txn *badger.Txn) error {
+		var err error
+		if oldest, err = getBlockByMetaKey(txn, oldestKey); err != nil {
+			return err
+		}
+		latest, err = getBlockByMetaKey(txn, latestKey)
+		return err
+	})
+	if err != nil {
+		return nil, nil, false, err
+	}
+	if oldest == nil || latest == nil {
+		return nil, nil, false, nil
+	}
+	return oldest, latest, true, nil
+}
+
+// LatestHeight returns the height of the latest applied block (or the
+// checkpoint). The second return value is false if the store has not been
+// initialized yet.
+func (s *Store) LatestHeight() (int64, bool, error) {
+	var (
+		height int64
+		ok     bool
+	)
+	err := s.db.View(func(txn *badger.Txn) error {
+		var err error
+		height, ok, err = getHeight(txn, latestKey)
+		return err
+	})
+	return height, ok, err
+}
+
+// SetCheckpoint initializes the store with all balances at the given
+// checkpoint block.
+//
+// If the checkpoint block's hash is empty (e.g., when the checkpoint is the
+// genesis state, which precedes the first block), balances can only be
+// queried from the next applied block on.
+func (s *Store) SetCheckpoint(checkpoint *Block, balances map[string]*big.Int) error {
+	s.Lock()
+	defer s.Unlock()
+
+	if _, ok, err := s.LatestHeight(); err != nil {
+		return err
+	} else if ok {
+		return ErrAlreadyInitialized
+	}
+
+	// The checkpoint's balances are written in several transactions, so a
+	// checkpoint interrupted e.g. by a crash leaves some of them behind. As
+	// the latest block is only set once all of them are written, such a
+	// store is not served from and its leftovers are dropped here.
+	var interrupted bool
+	if err := s.db.View(func(txn *badger.Txn) error {
+		var err error
+		_, interrupted, err = badgerdb.GetUint64(txn, checkpointKey)
+		return err
+	}); err != nil {
+		return fmt.Errorf("history: %w", err)
+	}
+	if interrupted {
+		logger.Warn("dropping the balances of an interrupted checkpoint")
+		if err := s.db.DropPrefix(balancePrefix, blockHashPrefix); err != nil {
+			return fmt.Errorf("history: failed to drop interrupted checkpoint: %w", err)
+		}
+	}
+	if err := s.db.Update(func(txn *badger.Txn) error {
+		return badgerdb.SetUint64(txn, checkpointKey, uint64(checkpoint.Height))
+	}); err != nil {
+		return fmt.Errorf("history: %w", err)
+	}
+
+	wb := s.db.NewWriteBatch()
+	defer wb.Cancel()
+
+	for key, balance := range balances {
+		if err := wb.Set(balanceKey(key, checkpoint.Height), encodeBalance(balance)); err != nil {
+			return fmt.Errorf("history: failed to write checkpoint balance: %w", err)
+		}
+	}
+	if err := wb.Flush(); err != nil {
+		return fmt.Errorf("history: failed to write checkpoint: %w", err)
+	}
+
+	return s.db.Update(func(txn *badger.Txn) error {
+		if checkpoint.Hash != "" {
+			if err := setBlock(txn, oldestKey, checkpoint); err != nil {
+				return err
+			}
+		}
+		if err := setBlock(txn, latestKey, checkpoint); err != nil {
+			return err
+		}
+		if err := txn.Delete(checkpointKey); err != nil {
+			return fmt.Errorf("history: failed to clear checkpoint marker: %w", err)
+		}
+		return nil
+	})
+}
+
+// ApplyBlock applies the balance changes of the given block, which must
+// directly follow the latest applied block.
+func (s *Store) ApplyBlock(blk *Block, changes map[string]*big.Int) error {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.db.Update(func(txn *badger.Txn) error {
+		latest, ok, err := getHeight(txn, latestKey)
+		if err != nil {
+			return err
+		}
+		if !ok || blk.Height != latest+1 {
+			return ErrNotContiguous
+		}
+
+		for key, change := range changes {
+			balance, err2 := getBalance(txn, key, latest)
+			if err2 != nil {
+				return err2
+			}
+			balance.Add(balance, change)
+			if err = txn.Set(balanceKey(key, blk.Height), encodeBalance(balance)); err != nil {
+				return fmt.Errorf("history: failed to write balance: %w", err)
+			}
+		}
+
+		if _, ok, err = getHeight(txn, oldestKey); err != nil {
+			return err
+		} else if !ok {
+			if err = setBlock(txn, oldestKey, blk); err != nil {
+				return err
+			}
+		}
+		return setBlock(txn, latestKey, blk)
+	})
+}
+
+// Balance returns the balance of the given account and the block identifier
+// at the given height.
+func (s *Store) Balance(key string, height int64) (*big.Int, *Block, error) {
+	var (
+		balance *big.Int
+		blk     *Block
+	)
+	err := s.db.View(func(txn *badger.Txn) error {
+		oldest, err := getBlockByMetaKey(txn, oldestKey)
+		if err != nil {
+			return err
+		}
+		latest, err := getBlockByMetaKey(txn, latestKey)
+		if err != nil {
+			return err
+		}
+		if oldest == nil || latest == nil || height < oldest.Height || height > latest.Height {
+			return ErrNotAvailable
+		}
+
+		if blk, err = getBlock(txn, height); err != nil {
+			return err
+		}
+		balance, err = getBalance(txn, key, height)
+		return err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return balance, blk, nil
+}
+
+// getBalance returns the latest balance of the given account at or before
+// the given height (zero if there is none).
+func getBalance(txn *badger.Txn, key string, height int64) (*big.Int, error) {
+	prefix := balanceKeyPrefix(key)
+
+	opts := badger.DefaultIteratorOptions
+	opts.Reverse = true
+	opts.PrefetchValues = false
+	it := txn.NewIterator(opts)
+	defer it.Close()
+
+	it.Seek(balanceKey(key, height))
+	if !it.ValidForPrefix(prefix) {
+		return new(big.Int), nil
+	}
+	raw, err := it.Item().ValueCopy(nil)
+	if err != nil {
+		return nil, fmt.Errorf("history: failed to read balance: %w", err)
+	}
+	return decodeBalance(raw)
+}
+
+func getBlock(txn *badger.Txn, height int64) (*Block, error) {
+	item, err := txn.Get(blockHashKey(height))
+	if err != nil {
+		return nil, fmt.Errorf("history: failed to read block hash at height %d: %w", height, err)
+	}
+	hash, err := item.ValueCopy(nil)
+	if err != nil {
+		return nil, fmt.Errorf("history: failed to read block hash at height %d: %w", height, err)
+	}
+	return &Block{Height: height, Hash: string(hash)}, nil
+}
+
+func getBlockByMetaKey(txn *badger.Txn, metaKey []byte) (*Block, error) {
+	height, ok, err := getHeight(txn, metaKey)
+	if err != nil || !ok {
+		return nil, err
+	}
+	return getBlock(txn, height)
+}
+
+func getHeight(txn *badger.Txn, metaKey []byte) (int64, bool, error) {
+	height, ok, err := badgerdb.GetUint64(txn, metaKey)
+	if err != nil {
+		return 0, false, fmt.Errorf("history: %w", err)
+	}
+	return int64(height), ok, nil
+}
+
+func setBlock(txn *badger.Txn, metaKey []byte, blk *Block) error {
+	if err := badgerdb.SetUint64(txn, metaKey, uint64(blk.Height)); err != nil {
+		return fmt.Errorf("history: %w", err)
+	}
+	if err := txn.Set(blockHashKey(blk.Height), []byte(blk.Hash)); err != nil {
+		return fmt.Errorf("history: failed to write block hash: %w", err)
+	}
+	return nil
+}
+
+func balanceKeyPrefix(key string) []byte {
+	prefix := make([]byte, 0, len(balancePrefix)+len(key)+1)
+	prefix = append(prefix, balancePrefix...)
+	prefix = append(prefix, key...)
+	return append(prefix, 0)
+}
+
+func balanceKey(key string, height int64) []byte {
+	var h [8]byte
+	binary.BigEndian.PutUint64(h[:], uint64(height))
+	return append(balanceKeyPrefix(key), h[:]...)
+}
+
+func blockHashKey(height int64) []byte {
+	var h [8]byte
+	binary.BigEndian.PutUint64(h[:], uint64(height))
+	return append(append([]byte{}, blockHashPrefix...), h[:]...)
+}
+
+func encodeBalance(balance *big.Int) []byte {
+	return []byte(balance.String())
+}
+
+func decodeBalance(raw []byte) (*big.Int, error) {
+	balance, ok := new(big.Int).SetString(string(raw), 10)
+	if !ok {
+		return nil, fmt.Errorf("history: malformed balance")
+	}
+	return balance, nil
+}
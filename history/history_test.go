@@ -0,0 +1,194 @@
+package history
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/dgraph-io/badger"
+
+	"github.com/oasisprotocol/oasis-core-rosetta-gateway/common/badgerdb"
+)
+
+func requireBalance(t *testing.T, store *Store, key string, height int64, expected int64, expectedHash string) {
+	t.Helper()
+
+	balance, blk, err := store.Balance(key, height)
+	if err != nil {
+		t.Fatalf("unable to get balance of %s at height %d: %v", key, height, err)
+	}
+	if balance.Cmp(big.NewInt(expected)) != 0 {
+		t.Fatalf("unexpected balance of %s at height %d: %s (expected: %d)", key, height, balance, expected)
+	}
+	if blk.Height != height || blk.Hash != expectedHash {
+		t.Fatalf("unexpected block at height %d: %+v", height, blk)
+	}
+}
+
+func requireNotAvailable(t *testing.T, store *Store, height int64) {
+	t.Helper()
+
+	if _, _, err := store.Balance("a", height); !errors.Is(err, ErrNotAvailable) {
+		t.Fatalf("expected ErrNotAvailable at height %d, got %v", height, err)
+	}
+}
+
+func TestStore(t *testing.T) {
+	dir := t.TempDir()
+	store, err := Open(dir)
+	if err != nil {
+		t.Fatalf("unable to open store: %v", err)
+	}
+
+	// An uninitialized store has no range and can't apply blocks.
+	if _, _, ok, _ := store.Range(); ok {
+		t.Fatalf("uninitialized store must not have a range")
+	}
+	if _, ok, _ := store.LatestHeight(); ok {
+		t.Fatalf("uninitialized store must not have a latest height")
+	}
+	if err = store.ApplyBlock(&Block{Height: 1, Hash: "h1"}, nil); err != ErrNotContiguous {
+		t.Fatalf("expected ErrNotContiguous, got %v", err)
+	}
+	requireNotAvailable(t, store, 1)
+
+	checkpoint := &Block{Height: 10, Hash: "h10"}
+	if err = store.SetCheckpoint(checkpoint, map[string]*big.Int{
+		"a": big.NewInt(100),
+		"b": big.NewInt(50),
+	}); err != nil {
+		t.Fatalf("unable to set checkpoint: %v", err)
+	}
+	if err = store.SetCheckpoint(checkpoint, nil); err != ErrAlreadyInitialized {
+		t.Fatalf("expected ErrAlreadyInitialized, got %v", err)
+	}
+
+	// Only the block directly following the latest one can be applied.
+	for _, height := range []int64{10, 12} {
+		if err = store.ApplyBlock(&Block{Height: height}, nil); err != ErrNotContiguous {
+			t.Fatalf("expected ErrNotContiguous at height %d, got %v", height, err)
+		}
+	}
+	if err = store.ApplyBlock(&Block{Height: 11, Hash: "h11"}, map[string]*big.Int{
+		"a": big.NewInt(-30),
+		"c": big.NewInt(5),
+	}); err != nil {
+		t.Fatalf("unable to apply block: %v", err)
+	}
+	if err = store.ApplyBlock(&Block{Height: 12, Hash: "h12"}, nil); err != nil {
+		t.Fatalf("unable to apply block: %v", err)
+	}
+	if err = store.ApplyBlock(&Block{Height: 13, Hash: "h13"}, map[string]*big.Int{
+		"a": big.NewInt(10),
+	}); err != nil {
+		t.Fatalf("unable to apply block: %v", err)
+	}
+
+	// The store survives a restart.
+	store.Close()
+	if store, err = Open(dir); err != nil {
+		t.Fatalf("unable to reopen store: %v", err)
+	}
+	defer store.Close()
+
+	oldest, latest, ok, err := store.Range()
+	if err != nil || !ok {
+		t.Fatalf("unable to get range: %v", err)
+	}
+	if *oldest != *checkpoint || *latest != (Block{Height: 13, Hash: "h13"}) {
+		t.Fatalf("unexpected range: %+v - %+v", oldest, latest)
+	}
+
+	// Balances are only available within the range, including its bounds.
+	requireNotAvailable(t, store, 9)
+	requireNotAvailable(t, store, 14)
+	requireBalance(t, store, "a", 10, 100, "h10")
+	requireBalance(t, store, "a", 11, 70, "h11")
+	requireBalance(t, store, "a", 12, 70, "h12")
+	requireBalance(t, store, "a", 13, 80, "h13")
+	requireBalance(t, store, "b", 13, 50, "h13")
+	requireBalance(t, store, "c", 10, 0, "h10")
+	requireBalance(t, store, "c", 11, 5, "h11")
+	requireBalance(t, store, "unknown", 13, 0, "h13")
+}
+
+func TestStoreGenesisCheckpoint(t *testing.T) {
+	store, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatalf("unable to open store: %v", err)
+	}
+	defer store.Close()
+
+	// A checkpoint without a block hash precedes the first block, so it
+	// can't be queried.
+	if err = store.SetCheckpoint(&Block{Height: 1}, map[string]*big.Int{"a": big.NewInt(100)}); err != nil {
+		t.Fatalf("unable to set checkpoint: %v", err)
+	}
+	if height, ok, _ := store.LatestHeight(); !ok || height != 1 {
+		t.Fatalf("unexpected latest height: %d", height)
+	}
+	if _, _, ok, _ := store.Range(); ok {
+		t.Fatalf("store without applied blocks must not have a range")
+	}
+	requireNotAvailable(t, store, 1)
+
+	// The first applied block becomes the oldest one.
+	if err = store.ApplyBlock(&Block{Height: 2, Hash: "h2"}, map[string]*big.Int{"a": big.NewInt(1)}); err != nil {
+		t.Fatalf("unable to apply block: %v", err)
+	}
+	oldest, latest, ok, err := store.Range()
+	if err != nil || !ok {
+		t.Fatalf("unable to get range: %v", err)
+	}
+	if oldest.Height != 2 || latest.Height != 2 {
+		t.Fatalf("unexpected range: %+v - %+v", oldest, latest)
+	}
+	requireNotAvailable(t, store, 1)
+	requireBalance(t, store, "a", 2, 101, "h2")
+}
+
+func TestStoreInterruptedCheckpoint(t *testing.T) {
+	store, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatalf("unable to open store: %v", err)
+	}
+	defer store.Close()
+
+	// Leave a checkpoint at height 5 behind with only some of its balances
+	// written, as if it was interrupted.
+	if err = store.db.Update(func(txn *badger.Txn) error {
+		if err2 := badgerdb.SetUint64(txn, checkpointKey, 5); err2 != nil {
+			return err2
+		}
+		return txn.Set(balanceKey("a", 5), encodeBalance(big.NewInt(100)))
+	}); err != nil {
+		t.Fatalf("unable to write interrupted checkpoint: %v", err)
+	}
+
+	// The interrupted checkpoint is not served from.
+	if _, ok, _ := store.LatestHeight(); ok {
+		t.Fatalf("interrupted checkpoint must not have a latest height")
+	}
+	if _, _, ok, _ := store.Range(); ok {
+		t.Fatalf("interrupted checkpoint must not have a range")
+	}
+	requireNotAvailable(t, store, 5)
+
+	// Retrying drops its leftover balances.
+	if err = store.SetCheckpoint(&Block{Height: 10, Hash: "h10"}, map[string]*big.Int{
+		"b": big.NewInt(50),
+	}); err != nil {
+		t.Fatalf("unable to set checkpoint: %v", err)
+	}
+	requireBalance(t, store, "a", 10, 0, "h10")
+	requireBalance(t, store, "b", 10, 50, "h10")
+	if err = store.db.View(func(txn *badger.Txn) error {
+		_, ok, err2 := badgerdb.GetUint64(txn, checkpointKey)
+		if err2 == nil && ok {
+			err2 = errors.New("checkpoint marker not cleared")
+		}
+		return err2
+	}); err != nil {
+		t.Fatalf("unexpected checkpoint marker: %v", err)
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/oasisprotocol/oasis-core/go/common/cbor"
+	"github.com/oasisprotocol/oasis-core/go/common/crypto/signature"
+	"github.com/oasisprotocol/oasis-core/go/consensus/api/transaction"
+	staking "github.com/oasisprotocol/oasis-core/go/staking/api"
+
+	"github.com/oasisprotocol/oasis-core-rosetta-gateway/services"
+)
+
+// inspectCmdName is the name of the subcommand that decodes a transaction
+// blob.
+const inspectCmdName = "inspect"
+
+// runInspectCmd decodes a Base64-encoded signed or unsigned transaction blob,
+// as returned by /construction/combine and /construction/payloads, and writes
+// what it does to the standard output.
+func runInspectCmd(args []string) error {
+	fs := flag.NewFlagSet(inspectCmdName, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s %s [flags] [BLOB]\n\n", os.Args[0], inspectCmdName)
+		fmt.Fprintf(fs.Output(), "Decode a Base64-encoded signed or unsigned transaction BLOB "+
+			"(or read it from the standard input) and print what it does.\n\n")
+		fs.PrintDefaults()
+	}
+	chainContext := fs.String("chain-context", os.Getenv(services.OfflineModeChainIDEnvVar),
+		"chain context (genesis document's hash) to verify signatures in, defaults to $"+services.OfflineModeChainIDEnvVar,
+	)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	var blob string
+	switch fs.NArg() {
+	case 0:
+		raw, err := readFileOrStdin("")
+		if err != nil {
+			return fmt.Errorf("failed to read transaction: %w", err)
+		}
+		blob = string(raw)
+	case 1:
+		blob = fs.Arg(0)
+	default:
+		fs.Usage()
+		return fmt.Errorf("too many arguments")
+	}
+
+	if *chainContext != "" {
+		signature.SetChainContext(*chainContext)
+	}
+	return inspectTransaction(os.Stdout, strings.TrimSpace(blob), *chainContext != "")
+}
+
+// inspectTransaction writes what the given signed or unsigned transaction
+// blob does to the given writer.  The signature of a signed transaction is
+// only verified if verify is set, which requires the chain context to be set.
+func inspectTransaction(w io.Writer, blob string, verify bool) error {
+	var tx transaction.Transaction
+	if sigTx, err := services.DecodeSignedTransaction(blob); err == nil && len(sigTx.Blob) > 0 {
+		if err = cbor.Unmarshal(sigTx.Blob, &tx); err != nil {
+			return fmt.Errorf("malformed signed transaction: %w", err)
+		}
+
+		var sigStatus string
+		switch {
+		case !verify:
+			sigStatus = "not verified (no chain context given)"
+		case sigTx.Signature.Verify(transaction.SignatureContext, sigTx.Blob):
+			sigStatus = "valid"
+		default:
+			sigStatus = "INVALID"
+		}
+		fmt.Fprintf(w, "Type:       signed transaction\n")
+		fmt.Fprintf(w, "Hash:       %s\n", sigTx.Hash())
+		fmt.Fprintf(w, "Signature:  %s\n", sigStatus)
+		return printTransactionSummary(w, &tx, services.StringFromAddress(staking.NewAddress(sigTx.Signature.PublicKey)))
+	}
+
+	ut, err := services.DecodeUnsignedTransaction(blob)
+	if err != nil || len(ut.Tx) == 0 {
+		return fmt.Errorf("not a signed or an unsigned transaction")
+	}
+	if err = cbor.Unmarshal(ut.Tx, &tx); err != nil {
+		return fmt.Errorf("malformed unsigned transaction: %w", err)
+	}
+	fmt.Fprintf(w, "Type:       unsigned transaction\n")
+	return printTransactionSummary(w, &tx, ut.Signer)
+}
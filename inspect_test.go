@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"github.com/oasisprotocol/oasis-core/go/common/cbor"
+	"github.com/oasisprotocol/oasis-core/go/consensus/api/transaction"
+	staking "github.com/oasisprotocol/oasis-core/go/staking/api"
+
+	"github.com/oasisprotocol/oasis-core-rosetta-gateway/services"
+)
+
+func TestInspectTransaction(t *testing.T) {
+	payloads := newTestPayloads(t, testSigner)
+	ut, _ := services.DecodeUnsignedTransaction(payloads.UnsignedTransaction)
+	var tx transaction.Transaction
+	if err := cbor.Unmarshal(ut.Tx, &tx); err != nil {
+		t.Fatalf("unable to decode transaction: %v", err)
+	}
+	sigTx, err := transaction.Sign(testSigner, &tx)
+	if err != nil {
+		t.Fatalf("unable to sign transaction: %v", err)
+	}
+	signed := base64.StdEncoding.EncodeToString(cbor.Marshal(sigTx))
+
+	tampered := *sigTx
+	tampered.Signature.Signature[0] ^= 0xff
+
+	for _, tc := range []struct {
+		name     string
+		blob     string
+		verify   bool
+		expected []string
+	}{
+		{"Unsigned", payloads.UnsignedTransaction, true, []string{
+			"unsigned transaction", "Signer:     " + ut.Signer, "staking.Transfer", "Nonce:      7",
+			`"to": "` + services.StringFromAddress(staking.CommonPoolAddress) + `"`, "-1.5 ROSE",
+		}},
+		{"Signed", signed, true, []string{
+			"Type:       signed transaction", "Hash:       " + sigTx.Hash().String(), "Signature:  valid",
+			"Signer:     " + ut.Signer, "staking.Transfer", "1.5 ROSE",
+		}},
+		{"SignedNotVerified", signed, false, []string{"Signature:  not verified"}},
+		{"InvalidSignature", base64.StdEncoding.EncodeToString(cbor.Marshal(&tampered)), true, []string{
+			"Signature:  INVALID",
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var out bytes.Buffer
+			if err := inspectTransaction(&out, tc.blob, tc.verify); err != nil {
+				t.Fatalf("unable to inspect transaction: %v", err)
+			}
+			for _, s := range tc.expected {
+				if !strings.Contains(out.String(), s) {
+					t.Fatalf("output doesn't contain %q:\n%s", s, out.String())
+				}
+			}
+		})
+	}
+
+	for _, blob := range []string{"", "not base64", base64.StdEncoding.EncodeToString([]byte("not CBOR"))} {
+		if err := inspectTransaction(&bytes.Buffer{}, blob, true); err == nil {
+			t.Fatalf("expected an error for blob %q", blob)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/oasisprotocol/oasis-core/go/common/crypto/signature"
+	fileSigner "github.com/oasisprotocol/oasis-core/go/common/crypto/signature/signers/file"
+	"github.com/oasisprotocol/oasis-core/go/common/crypto/signature/signers/memory"
+	"golang.org/x/crypto/pbkdf2"
+	"golang.org/x/text/unicode/norm"
+)
+
+const (
+	// bip39Iterations is the number of PBKDF2 iterations used to derive a
+	// BIP-0039 seed from a mnemonic.
+	bip39Iterations = 2048
+
+	// slip10HardenedOffset is the offset of hardened child indexes in
+	// SLIP-0010 key derivation.
+	slip10HardenedOffset = 0x80000000
+
+	// slip10Ed25519Curve is the SLIP-0010 curve name of Ed25519.
+	slip10Ed25519Curve = "ed25519 seed"
+)
+
+// adr0008Path is the ADR 0008 key derivation path of Oasis accounts, without
+// the account index: m/44'/474'/x'.
+var adr0008Path = []uint32{44, 474}
+
+// keySource is where a signing key is loaded from.  Exactly one of the
+// fields must be set.
+type keySource struct {
+	// File is the path of a PEM file with an Ed25519 private key, as written
+	// by the Oasis Node.
+	File string
+	// EntityDir is the path of an Oasis entity directory.
+	EntityDir string
+	// MnemonicFile is the path of a file with a BIP-0039 mnemonic, from which
+	// the key is derived as specified by ADR 0008.
+	MnemonicFile string
+	// AccountIndex is the ADR 0008 account index of the key derived from the
+	// mnemonic.
+	AccountIndex uint32
+}
+
+// load loads the signer from the key source.
+func (ks *keySource) load() (signature.Signer, error) {
+	var sources int
+	for _, s := range []string{ks.File, ks.EntityDir, ks.MnemonicFile} {
+		if s != "" {
+			sources++
+		}
+	}
+	if sources != 1 {
+		return nil, fmt.Errorf("exactly one key source must be given")
+	}
+
+	switch {
+	case ks.File != "":
+		factory, err := fileSigner.NewFactory(filepath.Dir(ks.File), signature.SignerEntity)
+		if err != nil {
+			return nil, err
+		}
+		signer, err := factory.(*fileSigner.Factory).ForceLoad(ks.File)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load key file: %w", err)
+		}
+		return signer, nil
+	case ks.EntityDir != "":
+		factory, err := fileSigner.NewFactory(ks.EntityDir, signature.SignerEntity)
+		if err != nil {
+			return nil, err
+		}
+		signer, err := factory.Load(signature.SignerEntity)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load entity key: %w", err)
+		}
+		return signer, nil
+	default:
+		raw, err := ioutil.ReadFile(ks.MnemonicFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read mnemonic file: %w", err)
+		}
+		return signerFromMnemonic(string(raw), ks.AccountIndex)
+	}
+}
+
+// signerFromMnemonic derives the signer of the account with the given index
+// from a BIP-0039 mnemonic, as specified by ADR 0008.
+//
+// Note that the mnemonic's checksum isn't verified, so a mistyped mnemonic
+// derives a different key.
+func signerFromMnemonic(mnemonic string, index uint32) (signature.Signer, error) {
+	words := strings.Fields(mnemonic)
+	switch len(words) {
+	case 12, 15, 18, 21, 24:
+	default:
+		return nil, fmt.Errorf("malformed mnemonic: unexpected number of words: %d", len(words))
+	}
+	seed := bip39Seed(strings.Join(words, " "), "")
+
+	key, err := slip10DeriveEd25519(seed, append(append([]uint32{}, adr0008Path...), index))
+	if err != nil {
+		return nil, err
+	}
+	return memory.NewFromRuntime(ed25519.NewKeyFromSeed(key)), nil
+}
+
+// bip39Seed returns the BIP-0039 seed of the given mnemonic and passphrase.
+func bip39Seed(mnemonic, passphrase string) []byte {
+	return pbkdf2.Key(
+		[]byte(norm.NFKD.String(mnemonic)),
+		[]byte("mnemonic"+norm.NFKD.String(passphrase)),
+		bip39Iterations,
+		64,
+		sha512.New,
+	)
+}
+
+// slip10DeriveEd25519 derives the Ed25519 private key seed along the given
+// path of (hardened) child indexes from the given seed, as specified by
+// SLIP-0010.
+func slip10DeriveEd25519(seed []byte, path []uint32) ([]byte, error) {
+	mac := hmac.New(sha512.New, []byte(slip10Ed25519Curve))
+	_, _ = mac.Write(seed)
+	sum := mac.Sum(nil)
+	key, chainCode := sum[:32], sum[32:]
+
+	for _, index := range path {
+		if index >= slip10HardenedOffset {
+			return nil, fmt.Errorf("invalid child index: %d", index)
+		}
+		data := make([]byte, 1+32+4)
+		copy(data[1:], key)
+		binary.BigEndian.PutUint32(data[33:], index+slip10HardenedOffset)
+
+		mac = hmac.New(sha512.New, chainCode)
+		_, _ = mac.Write(data)
+		sum = mac.Sum(nil)
+		key, chainCode = sum[:32], sum[32:]
+	}
+	return key, nil
+}
@@ -2,10 +2,16 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"flag"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
 	"net/http"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -15,10 +21,13 @@ import (
 	"github.com/coinbase/rosetta-sdk-go/types"
 	"github.com/oasisprotocol/oasis-core/go/common/crypto/signature"
 	"github.com/oasisprotocol/oasis-core/go/common/logging"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 
 	"github.com/oasisprotocol/oasis-core-rosetta-gateway/common"
+	"github.com/oasisprotocol/oasis-core-rosetta-gateway/history"
 	"github.com/oasisprotocol/oasis-core-rosetta-gateway/oasis"
 	"github.com/oasisprotocol/oasis-core-rosetta-gateway/services"
+	"github.com/oasisprotocol/oasis-core-rosetta-gateway/webhook"
 )
 
 // GatewayPortEnvVar is the name of the environment variable that specifies
@@ -32,6 +41,141 @@ const GatewayPortEnvVar = "OASIS_ROSETTA_GATEWAY_PORT"
 // Don't forget to set services.OfflineModeChainIDEnvVar as well.
 const OfflineModeEnvVar = "OASIS_ROSETTA_GATEWAY_OFFLINE_MODE"
 
+// BalanceHistoryDirEnvVar is the name of the environment variable that
+// specifies the directory of the balance history store.  If set, the gateway
+// indexes the balance changes of all blocks and uses them to answer
+// historical balance queries for heights that the node no longer has state
+// for.
+const BalanceHistoryDirEnvVar = "OASIS_ROSETTA_GATEWAY_BALANCE_HISTORY_DIR"
+
+// BalanceHistoryCheckpointEnvVar is the name of the environment variable that
+// specifies where an empty balance history store starts indexing: either
+// "genesis" (the default), which requires the node to have all blocks since
+// genesis, or the height of a block that the node still has state for.
+const BalanceHistoryCheckpointEnvVar = "OASIS_ROSETTA_GATEWAY_BALANCE_HISTORY_CHECKPOINT"
+
+// balanceHistoryCheckpointGenesis is the value of the
+// BalanceHistoryCheckpointEnvVar that specifies the genesis checkpoint.
+const balanceHistoryCheckpointGenesis = "genesis"
+
+// WebhookURLEnvVar is the name of the environment variable that specifies
+// the URL to which deposit webhooks are POSTed.  If set, the gateway follows
+// new blocks and delivers a webhook for each operation crediting a watched
+// address.
+const WebhookURLEnvVar = "OASIS_ROSETTA_GATEWAY_WEBHOOK_URL"
+
+// WebhookSecretEnvVar is the name of the environment variable that specifies
+// the secret with which webhook requests are signed (required with
+// WebhookURLEnvVar).
+const WebhookSecretEnvVar = "OASIS_ROSETTA_GATEWAY_WEBHOOK_SECRET"
+
+// WebhookDirEnvVar is the name of the environment variable that specifies the
+// directory of the webhook store, which holds the watched addresses and the
+// outbox of webhook deliveries (required with WebhookURLEnvVar).
+const WebhookDirEnvVar = "OASIS_ROSETTA_GATEWAY_WEBHOOK_DIR"
+
+// WebhookAddressesFileEnvVar is the name of the environment variable that
+// specifies the path to a file of addresses, one per line, that are added to
+// the watched addresses on startup.
+const WebhookAddressesFileEnvVar = "OASIS_ROSETTA_GATEWAY_WEBHOOK_ADDRESSES_FILE"
+
+// WebhookMaxAttemptsEnvVar is the name of the environment variable that
+// specifies the number of failed attempts after which a webhook delivery is
+// given up on.
+const WebhookMaxAttemptsEnvVar = "OASIS_ROSETTA_GATEWAY_WEBHOOK_MAX_ATTEMPTS"
+
+// defaultWebhookMaxAttempts is the default value of the
+// WebhookMaxAttemptsEnvVar (about a day with the backoff capped at ten
+// minutes).
+const defaultWebhookMaxAttempts = 150
+
+// WebhookAdminAddrEnvVar is the name of the environment variable that
+// specifies the address (e.g. "127.0.0.1:9091") on which the gateway serves
+// the unauthenticated admin endpoint that manages the watched addresses.
+const WebhookAdminAddrEnvVar = "OASIS_ROSETTA_GATEWAY_WEBHOOK_ADMIN_ADDR"
+
+// SubmitPreflightEnvVar is the name of the environment variable that
+// specifies that /construction/submit should check signed transactions (nonce,
+// balance and gas price) against the latest state before submitting them, and
+// return a specific error if a check fails.
+const SubmitPreflightEnvVar = "OASIS_ROSETTA_GATEWAY_SUBMIT_PREFLIGHT"
+
+// SubmitPreflightMinGasPriceEnvVar is the name of the environment variable
+// that specifies the minimum gas price (in base units) that the submit
+// preflight requires, which should match the node's
+// consensus.tendermint.min_gas_price setting.  Defaults to zero.
+const SubmitPreflightMinGasPriceEnvVar = "OASIS_ROSETTA_GATEWAY_SUBMIT_PREFLIGHT_MIN_GAS_PRICE"
+
+// NonceManagerEnvVar is the name of the environment variable that specifies
+// that /construction/metadata should return the next free nonce of a signer,
+// taking its transactions in the mempool and the nonces already handed out
+// into account, instead of its next nonce in committed state.
+const NonceManagerEnvVar = "OASIS_ROSETTA_GATEWAY_NONCE_MANAGER"
+
+// NonceManagerTimeoutEnvVar is the name of the environment variable that
+// specifies the duration (e.g. 1m) after which a nonce handed out by the nonce
+// manager is released if no transaction with it was submitted.
+const NonceManagerTimeoutEnvVar = "OASIS_ROSETTA_GATEWAY_NONCE_MANAGER_TIMEOUT"
+
+// defaultNonceManagerTimeout is the default value of the
+// NonceManagerTimeoutEnvVar.
+const defaultNonceManagerTimeout = 1 * time.Minute
+
+// RateLimitEnvVar is the name of the environment variable that specifies the
+// number of requests per second that each client can make.  If set, requests
+// above the rate are rejected with a retriable error.
+const RateLimitEnvVar = "OASIS_ROSETTA_GATEWAY_RATE_LIMIT"
+
+// RateLimitBurstEnvVar is the name of the environment variable that specifies
+// the number of requests that each client can make at once.  Defaults to the
+// rate (rounded up).
+const RateLimitBurstEnvVar = "OASIS_ROSETTA_GATEWAY_RATE_LIMIT_BURST"
+
+// RateLimitExpensiveEnvVar is the name of the environment variable that
+// specifies the number of requests per second that each client can make to
+// expensive endpoints (/block and /account/balance of escrow accounts), which
+// have a separate budget.  If not set, these requests count against the
+// RateLimitEnvVar budget.
+const RateLimitExpensiveEnvVar = "OASIS_ROSETTA_GATEWAY_RATE_LIMIT_EXPENSIVE"
+
+// RateLimitExpensiveBurstEnvVar is the name of the environment variable that
+// specifies the number of requests that each client can make at once to
+// expensive endpoints.  Defaults to the rate (rounded up).
+const RateLimitExpensiveBurstEnvVar = "OASIS_ROSETTA_GATEWAY_RATE_LIMIT_EXPENSIVE_BURST"
+
+// AuthFileEnvVar is the name of the environment variable that specifies the
+// path to the credentials file.  If set, all requests must carry the bearer
+// token or TLS client certificate of a credential that permits access to
+// their endpoint.  The file is reloaded when it changes.
+const AuthFileEnvVar = "OASIS_ROSETTA_GATEWAY_AUTH_FILE"
+
+// TLSCertFileEnvVar is the name of the environment variable that specifies
+// the path to the PEM-encoded TLS certificate of the gateway.  If set, the
+// gateway serves HTTPS instead of HTTP.
+const TLSCertFileEnvVar = "OASIS_ROSETTA_GATEWAY_TLS_CERT_FILE"
+
+// TLSKeyFileEnvVar is the name of the environment variable that specifies the
+// path to the PEM-encoded private key of the gateway's TLS certificate.
+const TLSKeyFileEnvVar = "OASIS_ROSETTA_GATEWAY_TLS_KEY_FILE"
+
+// TLSClientCAFileEnvVar is the name of the environment variable that
+// specifies the path to the PEM-encoded CA certificates that TLS client
+// certificates are verified against.  If set without AuthFileEnvVar, all
+// requests must carry a verified client certificate.
+const TLSClientCAFileEnvVar = "OASIS_ROSETTA_GATEWAY_TLS_CLIENT_CA_FILE"
+
+// MetricsAddrEnvVar is the name of the environment variable that specifies
+// the address (e.g. ":9090") on which the gateway serves Prometheus metrics.
+const MetricsAddrEnvVar = "OASIS_ROSETTA_GATEWAY_METRICS_ADDR"
+
+// LogLevelEnvVar is the name of the environment variable that specifies the
+// log level: DEBUG (the default), INFO, WARN or ERROR.
+const LogLevelEnvVar = "OASIS_ROSETTA_GATEWAY_LOG_LEVEL"
+
+// LogFormatEnvVar is the name of the environment variable that specifies the
+// log format: logfmt (the default) or JSON.
+const LogFormatEnvVar = "OASIS_ROSETTA_GATEWAY_LOG_FORMAT"
+
 var (
 	logger = logging.GetLogger("oasis-rosetta-gateway")
 
@@ -40,7 +184,12 @@ var (
 
 // NewBlockchainRouter returns a Mux http.Handler from a collection of
 // Rosetta service controllers.
-func NewBlockchainRouter(oasisClient oasis.Client) (http.Handler, error) {
+func NewBlockchainRouter(
+	oasisClient oasis.Client,
+	balanceHistory *history.Store,
+	preflight *services.SubmitPreflight,
+	nonces *services.NonceManager,
+) (http.Handler, error) {
 	chainID, err := oasisClient.GetChainID(context.Background())
 	if err != nil {
 		return nil, err
@@ -55,27 +204,33 @@ func NewBlockchainRouter(oasisClient oasis.Client) (http.Handler, error) {
 				Network:    chainID,
 			},
 		},
-		[]string{},
-		false,
+		services.SupportedCallMethods,
+		true,
 	)
 	if err != nil {
 		return nil, err
 	}
 
+	// All services that look at the mempool share a single snapshot of it.
+	mempool := services.NewMempoolCache(oasisClient)
+
 	networkAPIController := server.NewNetworkAPIController(
-		services.NewNetworkAPIService(oasisClient), asserter,
+		services.NewNetworkAPIService(oasisClient, balanceHistory), asserter,
 	)
 	accountAPIController := server.NewAccountAPIController(
-		services.NewAccountAPIService(oasisClient), asserter,
+		services.NewAccountAPIService(oasisClient, mempool, balanceHistory), asserter,
 	)
 	blockAPIController := server.NewBlockAPIController(
 		services.NewBlockAPIService(oasisClient), asserter,
 	)
 	constructionAPIController := server.NewConstructionAPIController(
-		services.NewConstructionAPIService(oasisClient), asserter,
+		services.NewConstructionAPIService(oasisClient, mempool, preflight, nonces), asserter,
 	)
 	mempoolAPIController := server.NewMempoolAPIController(
-		services.NewMempoolAPIService(oasisClient), asserter,
+		services.NewMempoolAPIService(oasisClient, mempool), asserter,
+	)
+	callAPIController := server.NewCallAPIController(
+		services.NewCallAPIService(oasisClient), asserter,
 	)
 
 	return server.NewRouter(
@@ -84,6 +239,7 @@ func NewBlockchainRouter(oasisClient oasis.Client) (http.Handler, error) {
 		blockAPIController,
 		constructionAPIController,
 		mempoolAPIController,
+		callAPIController,
 	), nil
 }
 
@@ -105,11 +261,29 @@ func NewOfflineBlockchainRouter(chainID string) (http.Handler, error) {
 		return nil, err
 	}
 
-	constructionAPIController := server.NewConstructionAPIController(services.NewConstructionAPIService(nil), asserter)
+	constructionAPIController := server.NewConstructionAPIController(services.NewConstructionAPIService(nil, nil, nil, nil), asserter)
 
 	return server.NewRouter(constructionAPIController), nil
 }
 
+// Initialize logging to the given writer with the level and format given by
+// the environment.
+func initLogging(w io.Writer) error {
+	level := logging.LevelDebug
+	if v := os.Getenv(LogLevelEnvVar); v != "" {
+		if err := level.Set(v); err != nil {
+			return fmt.Errorf("malformed %s: %w", LogLevelEnvVar, err)
+		}
+	}
+	format := logging.FmtLogfmt
+	if v := os.Getenv(LogFormatEnvVar); v != "" {
+		if err := format.Set(v); err != nil {
+			return fmt.Errorf("malformed %s: %w", LogFormatEnvVar, err)
+		}
+	}
+	return logging.Initialize(w, format, level, nil)
+}
+
 // Return the value of the given environment variable or exit if it is
 // empty (or unset).
 func getEnvVarOrExit(name string) string {
@@ -140,6 +314,374 @@ func getPortOrExit() int {
 	return port
 }
 
+// Open the balance history store (if configured), initialize it if it is
+// empty and start indexing new blocks into it, or exit on failure.
+func startBalanceHistoryOrExit(oasisClient oasis.Client) *history.Store {
+	dir := os.Getenv(BalanceHistoryDirEnvVar)
+	if dir == "" {
+		return nil
+	}
+
+	store, err := history.Open(dir)
+	if err != nil {
+		logger.Error("failed to open balance history store",
+			"err", err,
+			"dir", dir,
+		)
+		os.Exit(1)
+	}
+
+	indexer := services.NewBalanceHistoryIndexer(oasisClient, store)
+
+	_, initialized, err := store.LatestHeight()
+	if err != nil {
+		logger.Error("failed to read balance history store", "err", err)
+		os.Exit(1)
+	}
+	if !initialized {
+		checkpoint := os.Getenv(BalanceHistoryCheckpointEnvVar)
+		if checkpoint == "" || checkpoint == balanceHistoryCheckpointGenesis {
+			err = indexer.InitializeFromGenesis(context.Background())
+		} else {
+			var height int64
+			height, err = strconv.ParseInt(checkpoint, 10, 64)
+			if err != nil {
+				logger.Error("malformed environment variable",
+					"err", err,
+					"name", BalanceHistoryCheckpointEnvVar,
+				)
+				os.Exit(1)
+			}
+			err = indexer.InitializeFromHeight(context.Background(), height)
+		}
+		if err != nil {
+			logger.Error("failed to initialize balance history store", "err", err)
+			os.Exit(1)
+		}
+	}
+
+	go indexer.Run(context.Background())
+
+	logger.Info("balance history enabled", "dir", dir)
+	return store
+}
+
+// Open the webhook store (if configured), add the watched addresses from the
+// addresses file, initialize the store if it is empty and start watching new
+// blocks and delivering webhooks, or exit on failure.
+func startWebhooksOrExit(oasisClient oasis.Client) {
+	url := os.Getenv(WebhookURLEnvVar)
+	if url == "" {
+		return
+	}
+	secret := getEnvVarOrExit(WebhookSecretEnvVar)
+	dir := getEnvVarOrExit(WebhookDirEnvVar)
+
+	maxAttempts := defaultWebhookMaxAttempts
+	if raw := os.Getenv(WebhookMaxAttemptsEnvVar); raw != "" {
+		var err error
+		if maxAttempts, err = strconv.Atoi(raw); err != nil || maxAttempts <= 0 {
+			logger.Error("malformed environment variable",
+				"err", err,
+				"name", WebhookMaxAttemptsEnvVar,
+			)
+			os.Exit(1)
+		}
+	}
+
+	store, err := webhook.Open(dir)
+	if err != nil {
+		logger.Error("failed to open webhook store",
+			"err", err,
+			"dir", dir,
+		)
+		os.Exit(1)
+	}
+
+	if path := os.Getenv(WebhookAddressesFileEnvVar); path != "" {
+		var addresses []string
+		if addresses, err = loadWatchedAddresses(path); err == nil {
+			err = store.Watch(addresses...)
+		}
+		if err != nil {
+			logger.Error("failed to load watched addresses",
+				"err", err,
+				"path", path,
+			)
+			os.Exit(1)
+		}
+	}
+
+	dispatcher := webhook.NewDispatcher(store, url, []byte(secret), maxAttempts)
+	watcher := services.NewDepositWatcher(oasisClient, store, dispatcher)
+
+	_, initialized, err := store.LatestHeight()
+	if err != nil {
+		logger.Error("failed to read webhook store", "err", err)
+		os.Exit(1)
+	}
+	if !initialized {
+		if err = watcher.InitializeFromLatest(context.Background()); err != nil {
+			logger.Error("failed to initialize webhook store", "err", err)
+			os.Exit(1)
+		}
+	}
+
+	go dispatcher.Run(context.Background())
+	go watcher.Run(context.Background())
+
+	if addr := os.Getenv(WebhookAdminAddrEnvVar); addr != "" {
+		go func() {
+			logger.Info("serving webhook admin endpoint", "addr", addr)
+			if err := http.ListenAndServe(addr, newWebhookAdminHandler(store)); err != nil {
+				logger.Error("webhook admin server exited", "err", err)
+			}
+		}()
+	}
+
+	logger.Info("deposit webhooks enabled",
+		"dir", dir,
+		"watched_addresses", len(store.Watched()),
+	)
+}
+
+// Return the submit preflight configuration (nil if disabled) or exit if it
+// is malformed.
+func getSubmitPreflightOrExit() *services.SubmitPreflight {
+	if os.Getenv(SubmitPreflightEnvVar) == "" {
+		return nil
+	}
+
+	var preflight services.SubmitPreflight
+	if minGasPrice := os.Getenv(SubmitPreflightMinGasPriceEnvVar); minGasPrice != "" {
+		if err := preflight.MinGasPrice.UnmarshalText([]byte(minGasPrice)); err != nil {
+			logger.Error("malformed environment variable",
+				"err", err,
+				"name", SubmitPreflightMinGasPriceEnvVar,
+			)
+			os.Exit(1)
+		}
+	}
+
+	logger.Info("submit preflight enabled", "min_gas_price", preflight.MinGasPrice)
+	return &preflight
+}
+
+// Return the nonce manager (nil if disabled) or exit if its configuration is
+// malformed.
+func getNonceManagerOrExit() *services.NonceManager {
+	if os.Getenv(NonceManagerEnvVar) == "" {
+		return nil
+	}
+
+	timeout := defaultNonceManagerTimeout
+	if raw := os.Getenv(NonceManagerTimeoutEnvVar); raw != "" {
+		var err error
+		if timeout, err = time.ParseDuration(raw); err != nil || timeout <= 0 {
+			logger.Error("malformed environment variable",
+				"err", err,
+				"name", NonceManagerTimeoutEnvVar,
+			)
+			os.Exit(1)
+		}
+	}
+
+	logger.Info("nonce manager enabled", "timeout", timeout)
+	return services.NewNonceManager(timeout)
+}
+
+// Return the rate limit given by the given environment variables (nil if the
+// rate isn't set) or exit if it is malformed.
+func getRateLimitOrExit(rateEnvVar, burstEnvVar string) *rateLimit {
+	rate := os.Getenv(rateEnvVar)
+	if rate == "" {
+		return nil
+	}
+
+	var limit rateLimit
+	var err error
+	if limit.Rate, err = strconv.ParseFloat(rate, 64); err != nil || limit.Rate <= 0 {
+		logger.Error("malformed environment variable",
+			"err", err,
+			"name", rateEnvVar,
+		)
+		os.Exit(1)
+	}
+	limit.Burst = int(math.Ceil(limit.Rate))
+	if burst := os.Getenv(burstEnvVar); burst != "" {
+		if limit.Burst, err = strconv.Atoi(burst); err != nil || limit.Burst <= 0 {
+			logger.Error("malformed environment variable",
+				"err", err,
+				"name", burstEnvVar,
+			)
+			os.Exit(1)
+		}
+	}
+	return &limit
+}
+
+// Return the rate limiter (nil if disabled) or exit if it is misconfigured.
+func getRateLimiterOrExit() *rateLimiter {
+	limit := getRateLimitOrExit(RateLimitEnvVar, RateLimitBurstEnvVar)
+	if limit == nil {
+		return nil
+	}
+	expensiveLimit := getRateLimitOrExit(RateLimitExpensiveEnvVar, RateLimitExpensiveBurstEnvVar)
+	keyvals := []interface{}{
+		"rate", limit.Rate,
+		"burst", limit.Burst,
+	}
+	if expensiveLimit != nil {
+		keyvals = append(keyvals,
+			"expensive_rate", expensiveLimit.Rate,
+			"expensive_burst", expensiveLimit.Burst,
+		)
+	}
+	logger.Info("rate limiting enabled", keyvals...)
+	return newRateLimiter(*limit, expensiveLimit)
+}
+
+// Return the authentication middleware (nil if disabled) or exit if it is
+// misconfigured.
+func getAuthOrExit(clientCerts bool) *authMiddleware {
+	path := os.Getenv(AuthFileEnvVar)
+	if path == "" {
+		if clientCerts {
+			logger.Info("client certificate authentication enabled")
+			return &authMiddleware{
+				authenticators: []authenticator{&clientCertAuthenticator{}},
+			}
+		}
+		return nil
+	}
+
+	creds, err := loadCredentialsFile(path)
+	if err != nil {
+		logger.Error("failed to load credentials",
+			"err", err,
+			"path", path,
+		)
+		os.Exit(1)
+	}
+	go creds.watch()
+
+	am := &authMiddleware{
+		authenticators: []authenticator{&bearerTokenAuthenticator{creds: creds}},
+	}
+	if clientCerts {
+		am.authenticators = append(am.authenticators, &clientCertAuthenticator{creds: creds})
+	}
+	logger.Info("authentication enabled", "path", path, "client_certs", clientCerts)
+	return am
+}
+
+// Return the TLS configuration of the server (nil if it should serve HTTP)
+// or exit if it is misconfigured.
+func getTLSConfigOrExit() *tls.Config {
+	certFile := os.Getenv(TLSCertFileEnvVar)
+	clientCAFile := os.Getenv(TLSClientCAFileEnvVar)
+	if certFile == "" {
+		if clientCAFile != "" {
+			logger.Error("client certificates require TLS",
+				"name", TLSClientCAFileEnvVar,
+				"missing", TLSCertFileEnvVar,
+			)
+			os.Exit(1)
+		}
+		return nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(certFile, getEnvVarOrExit(TLSKeyFileEnvVar))
+	if err != nil {
+		logger.Error("failed to load TLS certificate", "err", err)
+		os.Exit(1)
+	}
+	cfg := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if clientCAFile == "" {
+		return cfg
+	}
+
+	pem, err := ioutil.ReadFile(clientCAFile)
+	if err != nil {
+		logger.Error("failed to read TLS client CA certificates", "err", err)
+		os.Exit(1)
+	}
+	cfg.ClientCAs = x509.NewCertPool()
+	if !cfg.ClientCAs.AppendCertsFromPEM(pem) {
+		logger.Error("malformed TLS client CA certificates", "path", clientCAFile)
+		os.Exit(1)
+	}
+	// Clients with a bearer token don't need a certificate, so only verify
+	// certificates if given, unless certificates are the only credentials.
+	cfg.ClientAuth = tls.VerifyClientCertIfGiven
+	if os.Getenv(AuthFileEnvVar) == "" {
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return cfg
+}
+
+// Start serving Prometheus metrics (if configured).
+func startMetricsServer() {
+	addr := os.Getenv(MetricsAddrEnvVar)
+	if addr == "" {
+		return
+	}
+
+	go func() {
+		logger.Info("serving metrics", "addr", addr)
+		if err := http.ListenAndServe(addr, promhttp.Handler()); err != nil {
+			logger.Error("metrics server exited", "err", err)
+		}
+	}()
+}
+
+// subcommands are the subcommands of the gateway binary, which all work
+// offline.
+var subcommands = map[string]func(args []string) error{
+	signCmdName:    runSignCmd,
+	inspectCmdName: runInspectCmd,
+	addressCmdName: runAddressCmd,
+}
+
+// Print the usage of the gateway binary.
+func printUsage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "Usage: %s [flags] [SUBCOMMAND [flags] [args]]\n\n", os.Args[0])
+	fmt.Fprintf(out, "Without a subcommand, run the gateway.\n\nSubcommands:\n")
+	names := make([]string, 0, len(subcommands))
+	for name := range subcommands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(out, "  %s\n", name)
+	}
+	fmt.Fprintf(out, "\nFlags:\n")
+	flag.PrintDefaults()
+}
+
+// Run the given subcommand and exit.
+func runSubcommandAndExit(name string, args []string) {
+	run, ok := subcommands[name]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "ERROR: Unknown subcommand: %s\n", name)
+		flag.Usage()
+		os.Exit(2)
+	}
+	switch err := run(args); err {
+	case nil:
+		os.Exit(0)
+	case flag.ErrHelp:
+		os.Exit(2)
+	default:
+		fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+		os.Exit(1)
+	}
+}
+
 // Print version information.
 func printVersionInfo() {
 	fmt.Printf("Software version: %s\n", common.SoftwareVersion)
@@ -150,24 +692,36 @@ func printVersionInfo() {
 }
 
 func main() {
-	// Initialize logging.
-	if err := logging.Initialize(os.Stdout, logging.FmtLogfmt, logging.LevelDebug, nil); err != nil {
-		fmt.Fprintf(os.Stderr, "ERROR: Unable to initialize logging: %v\n", err)
-		os.Exit(1)
-	}
-
 	// Print version info if -version flag is passed.
+	flag.Usage = printUsage
 	flag.Parse()
 	if *versionFlag {
 		printVersionInfo()
 		return
 	}
 
+	// Initialize logging.  Subcommands print their output to stdout, so
+	// their logs go to stderr.
+	logOutput := os.Stdout
+	if flag.NArg() > 0 {
+		logOutput = os.Stderr
+	}
+	if err := initLogging(logOutput); err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: Unable to initialize logging: %v\n", err)
+		os.Exit(1)
+	}
+
+	// Run a subcommand instead of the gateway if one is given.
+	if flag.NArg() > 0 {
+		runSubcommandAndExit(flag.Arg(0), flag.Args()[1:])
+	}
+
 	// Get server port.
 	port := getPortOrExit()
 
 	var chainID string
 	var oasisClient oasis.Client
+	var balanceHistory *history.Store
 	var err error
 
 	// Check if we should run in offline mode.
@@ -210,6 +764,12 @@ func main() {
 			)
 			os.Exit(1)
 		}
+
+		// Start the balance history indexer (if configured).
+		balanceHistory = startBalanceHistoryOrExit(oasisClient)
+
+		// Start delivering deposit webhooks (if configured).
+		startWebhooksOrExit(oasisClient)
 	}
 
 	// Set the chain context for preparing signing payloads.
@@ -222,16 +782,42 @@ func main() {
 		router, err = NewOfflineBlockchainRouter(chainID)
 	case false:
 		logger.Info("connected to Oasis node", "chain_context", chainID)
-		router, err = NewBlockchainRouter(oasisClient)
+		router, err = NewBlockchainRouter(
+			oasisClient, balanceHistory, getSubmitPreflightOrExit(), getNonceManagerOrExit(),
+		)
 	}
 	if err != nil {
 		logger.Error("unable to create Rosetta blockchain router", "err", err)
 		os.Exit(1)
 	}
 
+	// Limit the rate of requests (if configured), per authenticated client
+	// or else per IP.
+	if rl := getRateLimiterOrExit(); rl != nil {
+		router = rl.wrap(router)
+	}
+
+	// Authenticate clients (if configured) before their requests are rate
+	// limited.
+	tlsConfig := getTLSConfigOrExit()
+	if am := getAuthOrExit(tlsConfig != nil && tlsConfig.ClientCAs != nil); am != nil {
+		router = am.wrap(router)
+	}
+
+	startMetricsServer()
+
 	// Start the server.
-	logger.Info("Oasis Rosetta Gateway listening", "port", port)
-	err = http.ListenAndServe(fmt.Sprintf(":%d", port), router)
+	srv := &http.Server{
+		Addr:      fmt.Sprintf(":%d", port),
+		Handler:   withAccessLog(router),
+		TLSConfig: tlsConfig,
+	}
+	logger.Info("Oasis Rosetta Gateway listening", "port", port, "tls", tlsConfig != nil)
+	if tlsConfig != nil {
+		err = srv.ListenAndServeTLS("", "")
+	} else {
+		err = srv.ListenAndServe()
+	}
 	if err != nil {
 		logger.Error("Oasis Rosetta Gateway server exited",
 			"err", err,
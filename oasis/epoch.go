@@ -0,0 +1,181 @@
+package oasis
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	beacon "github.com/oasisprotocol/oasis-core/go/beacon/api"
+	"github.com/oasisprotocol/oasis-core/go/common/cbor"
+
+	"github.com/oasisprotocol/oasis-core-rosetta-gateway/common"
+)
+
+// tendermintBlockMeta is the part of the metadata of blocks of the Tendermint
+// consensus backend (see the BlockMeta type of oasis-core's
+// consensus/tendermint/api package) that the client uses.
+type tendermintBlockMeta struct {
+	Header *struct {
+		LastBlockID struct {
+			Hash []byte `json:"hash"`
+		} `json:"last_block_id"`
+	} `json:"header"`
+}
+
+// parentHashFromMeta returns the hash of the parent block recorded in the
+// given block metadata, or nil if the metadata doesn't record it.
+func parentHashFromMeta(meta cbor.RawMessage) []byte {
+	var tmMeta tendermintBlockMeta
+	// The metadata is trusted, and only some of its fields are decoded.
+	if err := cbor.UnmarshalTrusted(meta, &tmMeta); err != nil || tmMeta.Header == nil {
+		return nil
+	}
+	return tmMeta.Header.LastBlockID.Hash
+}
+
+// epochObservation is the epoch of a block height.
+type epochObservation struct {
+	height int64
+	epoch  beacon.EpochTime
+}
+
+// epochTable caches the epochs of block heights.  Since epochs don't decrease
+// with height, all heights between two heights of the same epoch are of that
+// epoch too, so the table only keeps the lowest and highest known height of
+// each epoch.
+type epochTable struct {
+	sync.Mutex
+
+	// observations are sorted by height.
+	observations []epochObservation
+}
+
+// search returns the index of the first observation at or above the given
+// height.
+func (t *epochTable) search(height int64) int {
+	return sort.Search(len(t.observations), func(i int) bool {
+		return t.observations[i].height >= height
+	})
+}
+
+// lookup returns the epoch of the given height, if the table knows it.
+func (t *epochTable) lookup(height int64) (beacon.EpochTime, bool) {
+	t.Lock()
+	defer t.Unlock()
+
+	i := t.search(height)
+	switch {
+	case i == len(t.observations):
+		return 0, false
+	case t.observations[i].height == height:
+		return t.observations[i].epoch, true
+	case i > 0 && t.observations[i-1].epoch == t.observations[i].epoch:
+		return t.observations[i].epoch, true
+	default:
+		return 0, false
+	}
+}
+
+// next returns the first observation above the given height (if any).
+func (t *epochTable) next(height int64) (epochObservation, bool) {
+	t.Lock()
+	defer t.Unlock()
+
+	i := t.search(height + 1)
+	if i == len(t.observations) {
+		return epochObservation{}, false
+	}
+	return t.observations[i], true
+}
+
+// latestEpoch returns the highest known epoch (if any).
+func (t *epochTable) latestEpoch() (beacon.EpochTime, bool) {
+	t.Lock()
+	defer t.Unlock()
+
+	if len(t.observations) == 0 {
+		return 0, false
+	}
+	return t.observations[len(t.observations)-1].epoch, true
+}
+
+// add adds the epoch of the given height to the table.
+func (t *epochTable) add(height int64, epoch beacon.EpochTime) {
+	t.Lock()
+	defer t.Unlock()
+
+	i := t.search(height)
+	if i < len(t.observations) && t.observations[i].height == height {
+		return
+	}
+	t.observations = append(t.observations, epochObservation{})
+	copy(t.observations[i+1:], t.observations[i:])
+	t.observations[i] = epochObservation{height, epoch}
+
+	// Drop the observations that are no longer the lowest or highest known
+	// height of their epoch.
+	for _, j := range []int{i + 1, i, i - 1} {
+		if j > 0 && j < len(t.observations)-1 &&
+			t.observations[j-1].epoch == t.observations[j].epoch &&
+			t.observations[j].epoch == t.observations[j+1].epoch {
+			t.observations = append(t.observations[:j], t.observations[j+1:]...)
+		}
+	}
+}
+
+// getEpoch returns the epoch of the given height, from the epoch table if it
+// knows it or else from the node.
+//
+// When it gets the epoch from the node, it also looks for the height at which
+// the epoch ends (by bisection between the height and the next known one), so
+// that the following heights of the epoch are found in the table.  If the
+// height's epoch is above all known ones, the next known height is the start
+// of the current epoch.
+func (c *grpcClient) getEpoch(ctx context.Context, backend beacon.Backend, height int64) (beacon.EpochTime, error) {
+	if epoch, ok := c.epochs.lookup(height); ok {
+		return epoch, nil
+	}
+
+	latest, known := c.epochs.latestEpoch()
+	epoch, err := backend.GetEpoch(ctx, height)
+	if err != nil {
+		return 0, err
+	}
+	c.epochs.add(height, epoch)
+
+	if !known || epoch > latest {
+		// The start of the current epoch is cheap to get, unlike that of
+		// past epochs.
+		if current, cerr := backend.GetEpoch(ctx, LatestHeight); cerr == nil {
+			if start, serr := backend.GetEpochBlock(ctx, current); serr == nil {
+				c.epochs.add(start, current)
+			}
+		}
+	}
+
+	next, ok := c.epochs.next(height)
+	if !ok || next.epoch == epoch {
+		return epoch, nil
+	}
+	lo, hi := height, next.height
+	for hi-lo > 1 {
+		mid := lo + (hi-lo)/2
+		midEpoch, merr := backend.GetEpoch(ctx, mid)
+		if merr != nil {
+			// The table is only an optimization, the epoch is known anyway.
+			common.LoggerWithRequestID(ctx, logger).Debug("failed to find end of epoch",
+				"epoch", epoch,
+				"height", mid,
+				"err", merr,
+			)
+			break
+		}
+		c.epochs.add(mid, midEpoch)
+		if midEpoch == epoch {
+			lo = mid
+		} else {
+			hi = mid
+		}
+	}
+	return epoch, nil
+}
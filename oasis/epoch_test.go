@@ -0,0 +1,131 @@
+package oasis
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	tmtypes "github.com/tendermint/tendermint/types"
+
+	beacon "github.com/oasisprotocol/oasis-core/go/beacon/api"
+	"github.com/oasisprotocol/oasis-core/go/common/cbor"
+	consensus "github.com/oasisprotocol/oasis-core/go/consensus/api"
+	tmapi "github.com/oasisprotocol/oasis-core/go/consensus/tendermint/api"
+)
+
+// fakeBeacon is a beacon backend whose epochs are epochLength blocks long,
+// starting with epoch 0 at height 1, with the latest block at latestHeight.
+type fakeBeacon struct {
+	beacon.Backend
+
+	epochLength  int64
+	latestHeight int64
+	calls        int
+}
+
+func (b *fakeBeacon) GetEpoch(ctx context.Context, height int64) (beacon.EpochTime, error) {
+	b.calls++
+	if height == LatestHeight {
+		height = b.latestHeight
+	}
+	return beacon.EpochTime((height - 1) / b.epochLength), nil
+}
+
+func (b *fakeBeacon) GetEpochBlock(ctx context.Context, epoch beacon.EpochTime) (int64, error) {
+	b.calls++
+	return int64(epoch)*b.epochLength + 1, nil
+}
+
+func TestEpochTable(t *testing.T) {
+	var et epochTable
+	for _, obs := range []epochObservation{{10, 1}, {30, 1}, {20, 1}, {40, 2}, {35, 1}, {50, 3}} {
+		et.add(obs.height, obs.epoch)
+	}
+	// Only the lowest and highest known heights of each epoch are kept.
+	expected := []epochObservation{{10, 1}, {35, 1}, {40, 2}, {50, 3}}
+	if len(et.observations) != len(expected) {
+		t.Fatalf("unexpected observations: %v", et.observations)
+	}
+	for i := range expected {
+		if et.observations[i] != expected[i] {
+			t.Fatalf("unexpected observations: %v", et.observations)
+		}
+	}
+
+	for _, tc := range []struct {
+		height int64
+		epoch  beacon.EpochTime
+		known  bool
+	}{
+		{5, 0, false},
+		{10, 1, true},
+		{25, 1, true},
+		{35, 1, true},
+		{37, 0, false},
+		{40, 2, true},
+		{45, 0, false},
+		{50, 3, true},
+		{60, 0, false},
+	} {
+		epoch, known := et.lookup(tc.height)
+		if known != tc.known || epoch != tc.epoch {
+			t.Fatalf("unexpected epoch of height %d: %d (known: %t)", tc.height, epoch, known)
+		}
+	}
+}
+
+func TestGetEpoch(t *testing.T) {
+	b := &fakeBeacon{epochLength: 100, latestHeight: 10000}
+	c := &grpcClient{}
+	ctx := context.Background()
+
+	// Syncing a few epochs from the start needs far fewer queries than
+	// blocks.
+	for height := int64(1); height <= 500; height++ {
+		epoch, err := c.getEpoch(ctx, b, height)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if expected := beacon.EpochTime((height - 1) / b.epochLength); epoch != expected {
+			t.Fatalf("unexpected epoch of height %d: %d (expected: %d)", height, epoch, expected)
+		}
+	}
+	if b.calls > 60 {
+		t.Fatalf("too many queries: %d", b.calls)
+	}
+}
+
+func TestParentHashFromMeta(t *testing.T) {
+	// newBlock returns a consensus block of the Tendermint backend at the
+	// given height, following the block with the given identifier.
+	newBlock := func(height int64, lastBlockID tmtypes.BlockID) *consensus.Block {
+		blk := tmtypes.MakeBlock(height, nil, tmtypes.NewCommit(height-1, 0, lastBlockID, nil), nil)
+		blk.Header.ChainID = "test"
+		blk.Header.Time = time.Now()
+		blk.Header.LastBlockID = lastBlockID
+		blk.Header.ValidatorsHash = bytes.Repeat([]byte{0x42}, 32)
+		return tmapi.NewBlock(blk)
+	}
+	parent := newBlock(1, tmtypes.BlockID{})
+	blk := newBlock(2, tmtypes.BlockID{
+		Hash:          parent.Hash,
+		PartSetHeader: tmtypes.PartSetHeader{Total: 1, Hash: bytes.Repeat([]byte{0x43}, 32)},
+	})
+
+	if len(parent.Hash) == 0 {
+		t.Fatalf("parent block must have a hash")
+	}
+	if hash := parentHashFromMeta(blk.Meta); !bytes.Equal(hash, parent.Hash) {
+		t.Fatalf("unexpected parent hash: %x (expected: %x)", hash, parent.Hash)
+	}
+	if hash := parentHashFromMeta(parent.Meta); len(hash) != 0 {
+		t.Fatalf("unexpected parent hash of the first block: %x", hash)
+	}
+	if hash := parentHashFromMeta(cbor.Marshal(map[string]string{"backend": "other"})); hash != nil {
+		t.Fatalf("unexpected parent hash: %x", hash)
+	}
+	if hash := parentHashFromMeta(nil); hash != nil {
+		t.Fatalf("unexpected parent hash: %x", hash)
+	}
+}
@@ -0,0 +1,69 @@
+package oasis
+
+import (
+	"encoding/hex"
+	"sync"
+
+	consensus "github.com/oasisprotocol/oasis-core/go/consensus/api"
+)
+
+// headerCacheSize is the number of block headers that the client caches.
+const headerCacheSize = 256
+
+// newBlockHeader returns the header of the given consensus block.
+func newBlockHeader(blk *consensus.Block) *BlockHeader {
+	return &BlockHeader{
+		Height:    blk.Height,
+		Hash:      hex.EncodeToString(blk.Hash),
+		Timestamp: blk.Time.UnixNano() / 1000000, // ms
+	}
+}
+
+// headerCache caches the headers of blocks by height, evicting the ones that
+// were added first.  The latest height is never cached.
+type headerCache struct {
+	sync.Mutex
+
+	headers map[int64]BlockHeader
+	// heights are the heights of the cached headers, in the order in which
+	// they were added, starting at next.
+	heights []int64
+	next    int
+}
+
+// get returns the cached header of the block at the given height (if any).
+func (hc *headerCache) get(height int64) (*BlockHeader, bool) {
+	if height == LatestHeight {
+		return nil, false
+	}
+
+	hc.Lock()
+	defer hc.Unlock()
+
+	hdr, ok := hc.headers[height]
+	if !ok {
+		return nil, false
+	}
+	return &hdr, true
+}
+
+// add caches the given header.
+func (hc *headerCache) add(hdr *BlockHeader) {
+	hc.Lock()
+	defer hc.Unlock()
+
+	if hc.headers == nil {
+		hc.headers = make(map[int64]BlockHeader, headerCacheSize)
+	}
+	if _, ok := hc.headers[hdr.Height]; ok {
+		return
+	}
+	if len(hc.heights) < headerCacheSize {
+		hc.heights = append(hc.heights, hdr.Height)
+	} else {
+		delete(hc.headers, hc.heights[hc.next])
+		hc.heights[hc.next] = hdr.Height
+		hc.next = (hc.next + 1) % headerCacheSize
+	}
+	hc.headers[hdr.Height] = *hdr
+}
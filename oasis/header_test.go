@@ -0,0 +1,39 @@
+package oasis
+
+import "testing"
+
+func TestHeaderCache(t *testing.T) {
+	var hc headerCache
+	for height := int64(1); height <= headerCacheSize+10; height++ {
+		hc.add(&BlockHeader{Height: height, Hash: "hash"})
+	}
+
+	// The headers that were added first are evicted.
+	for height := int64(1); height <= 10; height++ {
+		if _, ok := hc.get(height); ok {
+			t.Fatalf("expected header %d to be evicted", height)
+		}
+	}
+	for height := int64(11); height <= headerCacheSize+10; height++ {
+		hdr, ok := hc.get(height)
+		if !ok || hdr.Height != height {
+			t.Fatalf("expected header %d to be cached", height)
+		}
+	}
+	if len(hc.headers) != headerCacheSize {
+		t.Fatalf("unexpected cache size: %d", len(hc.headers))
+	}
+
+	// Cached headers can't be modified through the returned copies.
+	hdr, _ := hc.get(11)
+	hdr.Hash = "modified"
+	if hdr, _ = hc.get(11); hdr.Hash != "hash" {
+		t.Fatalf("cached header was modified")
+	}
+
+	// The latest height is never served from the cache.
+	hc.add(&BlockHeader{Height: LatestHeight})
+	if _, ok := hc.get(LatestHeight); ok {
+		t.Fatalf("expected the latest height not to be cached")
+	}
+}
@@ -0,0 +1,175 @@
+package oasis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+)
+
+const (
+	// MaxConcurrentCallsEnvVar is the name of the environment variable that
+	// specifies the maximum number of concurrent gRPC calls to the node.
+	MaxConcurrentCallsEnvVar = "OASIS_ROSETTA_GATEWAY_NODE_MAX_CONCURRENT_CALLS"
+	// MaxQueuedCallsEnvVar is the name of the environment variable that
+	// specifies the maximum number of gRPC calls that wait for one of the
+	// concurrent calls to finish.  Calls beyond that fail with ErrOverloaded.
+	MaxQueuedCallsEnvVar = "OASIS_ROSETTA_GATEWAY_NODE_MAX_QUEUED_CALLS"
+	// CallTimeoutEnvVar is the name of the environment variable that
+	// specifies the deadline of each gRPC call to the node (including the
+	// time it waits in the queue), as a Go duration (e.g. 30s).
+	CallTimeoutEnvVar = "OASIS_ROSETTA_GATEWAY_NODE_CALL_TIMEOUT"
+
+	defaultMaxConcurrentCalls = 32
+	defaultMaxQueuedCalls     = 256
+	defaultCallTimeout        = 30 * time.Second
+)
+
+var (
+	// ErrOverloaded is the error returned by calls to the node that are
+	// rejected because too many calls are already waiting.
+	ErrOverloaded = errors.New("oasis: too many queued calls to the node")
+
+	// ErrCallTimeout is the error returned by calls to the node that exceed
+	// their deadline.
+	ErrCallTimeout = errors.New("oasis: call to the node timed out")
+)
+
+// callLimiter limits the number of concurrent gRPC calls to the node.  Calls
+// beyond that wait in a bounded queue, and calls beyond the queue's capacity
+// are rejected right away.
+type callLimiter struct {
+	sync.Mutex
+
+	slots     chan struct{}
+	queued    int
+	maxQueued int
+	timeout   time.Duration
+}
+
+// newCallLimiter creates a new call limiter with the given limits.  A zero
+// timeout means that calls have no deadline of their own.
+func newCallLimiter(maxConcurrent, maxQueued int, timeout time.Duration) *callLimiter {
+	return &callLimiter{
+		slots:     make(chan struct{}, maxConcurrent),
+		maxQueued: maxQueued,
+		timeout:   timeout,
+	}
+}
+
+// newCallLimiterFromEnv creates a new call limiter with the limits given by
+// the environment variables (or the defaults).
+func newCallLimiterFromEnv() (*callLimiter, error) {
+	maxConcurrent, err := intFromEnv(MaxConcurrentCallsEnvVar, defaultMaxConcurrentCalls, 1)
+	if err != nil {
+		return nil, err
+	}
+	maxQueued, err := intFromEnv(MaxQueuedCallsEnvVar, defaultMaxQueuedCalls, 0)
+	if err != nil {
+		return nil, err
+	}
+	timeout, err := durationFromEnv(CallTimeoutEnvVar, defaultCallTimeout)
+	if err != nil {
+		return nil, err
+	}
+	return newCallLimiter(maxConcurrent, maxQueued, timeout), nil
+}
+
+// intFromEnv returns the value of the given environment variable as an
+// integer of at least min, or the given default if it is not set.
+func intFromEnv(name string, def, min int) (int, error) {
+	raw := os.Getenv(name)
+	if raw == "" {
+		return def, nil
+	}
+	value, err := strconv.Atoi(raw)
+	if err != nil || value < min {
+		return 0, fmt.Errorf("malformed %s environment variable: %s", name, raw)
+	}
+	return value, nil
+}
+
+// durationFromEnv returns the value of the given environment variable as a
+// non-negative Go duration, or the given default if it is not set.
+func durationFromEnv(name string, def time.Duration) (time.Duration, error) {
+	raw := os.Getenv(name)
+	if raw == "" {
+		return def, nil
+	}
+	value, err := time.ParseDuration(raw)
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("malformed %s environment variable: %s", name, raw)
+	}
+	return value, nil
+}
+
+// acquire takes one of the concurrent call slots, waiting in the queue for
+// one to be released if there is none and room in the queue.
+func (cl *callLimiter) acquire(ctx context.Context) error {
+	select {
+	case cl.slots <- struct{}{}:
+		return nil
+	default:
+	}
+
+	cl.Lock()
+	if cl.queued >= cl.maxQueued {
+		cl.Unlock()
+		return ErrOverloaded
+	}
+	cl.queued++
+	cl.Unlock()
+
+	defer func() {
+		cl.Lock()
+		cl.queued--
+		cl.Unlock()
+	}()
+
+	select {
+	case cl.slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// release releases a slot taken by acquire.
+func (cl *callLimiter) release() {
+	<-cl.slots
+}
+
+// intercept is a gRPC unary client interceptor that runs each call within
+// the limits and its deadline.
+func (cl *callLimiter) intercept(
+	ctx context.Context,
+	method string,
+	req, reply interface{},
+	cc *grpc.ClientConn,
+	invoker grpc.UnaryInvoker,
+	opts ...grpc.CallOption,
+) error {
+	callCtx := ctx
+	if cl.timeout > 0 {
+		var cancel context.CancelFunc
+		callCtx, cancel = context.WithTimeout(ctx, cl.timeout)
+		defer cancel()
+	}
+
+	err := cl.acquire(callCtx)
+	if err == nil {
+		defer cl.release()
+		err = invoker(callCtx, method, req, reply, cc, opts...)
+	}
+	// Only report our own deadline as a timeout, the caller's context
+	// expiring is the caller's business.
+	if err != nil && ctx.Err() == nil && callCtx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("%w: %s after %s", ErrCallTimeout, method, cl.timeout)
+	}
+	return err
+}
@@ -0,0 +1,83 @@
+package oasis
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+)
+
+func TestCallLimiter(t *testing.T) {
+	cl := newCallLimiter(2, 1, 0)
+
+	unblock := make(chan struct{})
+	started := make(chan struct{}, 3)
+	blockingInvoker := func(
+		ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption,
+	) error {
+		started <- struct{}{}
+		<-unblock
+		return nil
+	}
+
+	// Two calls run and one waits in the queue.
+	errCh := make(chan error, 3)
+	for i := 0; i < 3; i++ {
+		go func() {
+			errCh <- cl.intercept(context.Background(), "test", nil, nil, nil, blockingInvoker)
+		}()
+	}
+	<-started
+	<-started
+	for {
+		cl.Lock()
+		queued := cl.queued
+		cl.Unlock()
+		if queued == 1 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// With the queue full, further calls are shed.
+	err := cl.intercept(context.Background(), "test", nil, nil, nil, blockingInvoker)
+	if !errors.Is(err, ErrOverloaded) {
+		t.Fatalf("expected ErrOverloaded, got %v", err)
+	}
+
+	close(unblock)
+	for i := 0; i < 3; i++ {
+		if err = <-errCh; err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if len(cl.slots) != 0 || cl.queued != 0 {
+		t.Fatalf("expected all slots to be released")
+	}
+}
+
+func TestCallLimiterTimeout(t *testing.T) {
+	cl := newCallLimiter(1, 1, 10*time.Millisecond)
+
+	waitingInvoker := func(
+		ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption,
+	) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+
+	err := cl.intercept(context.Background(), "test", nil, nil, nil, waitingInvoker)
+	if !errors.Is(err, ErrCallTimeout) {
+		t.Fatalf("expected ErrCallTimeout, got %v", err)
+	}
+
+	// The caller's own deadline is not reported as the call's timeout.
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	err = cl.intercept(ctx, "test", nil, nil, nil, waitingInvoker)
+	if !errors.Is(err, context.DeadlineExceeded) || errors.Is(err, ErrCallTimeout) {
+		t.Fatalf("expected the caller's deadline to be exceeded, got %v", err)
+	}
+}
@@ -0,0 +1,828 @@
+// Package mock implements an in-memory oasis.Client for tests that don't have
+// access to an Oasis node.
+package mock
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+
+	beacon "github.com/oasisprotocol/oasis-core/go/beacon/api"
+	"github.com/oasisprotocol/oasis-core/go/common/cbor"
+	"github.com/oasisprotocol/oasis-core/go/common/crypto/hash"
+	cmnErrors "github.com/oasisprotocol/oasis-core/go/common/errors"
+	"github.com/oasisprotocol/oasis-core/go/common/quantity"
+	consensus "github.com/oasisprotocol/oasis-core/go/consensus/api"
+	"github.com/oasisprotocol/oasis-core/go/consensus/api/transaction"
+	"github.com/oasisprotocol/oasis-core/go/consensus/api/transaction/results"
+	control "github.com/oasisprotocol/oasis-core/go/control/api"
+	genesis "github.com/oasisprotocol/oasis-core/go/genesis/api"
+	staking "github.com/oasisprotocol/oasis-core/go/staking/api"
+
+	"github.com/oasisprotocol/oasis-core-rosetta-gateway/oasis"
+)
+
+const (
+	// ChainID is the chain ID of the mock network.
+	ChainID = "6d6f636b2d636861696e2d636f6e74657874000000000000000000000000000a"
+
+	// GenesisHeight is the height of the mock network's genesis block.
+	GenesisHeight int64 = 1
+
+	// EpochInterval is the number of blocks per epoch.
+	EpochInterval = 10
+
+	// DebondingInterval is the number of epochs a debonding delegation takes
+	// to be reclaimed.
+	DebondingInterval = 1
+
+	// SoftwareVersion is the software version reported by the mock node.
+	SoftwareVersion = "mock"
+)
+
+// Names of the Client methods, for use with SetError.
+const (
+	MethodGetChainID                 = "GetChainID"
+	MethodGetBlock                   = "GetBlock"
+	MethodGetBlockHeader             = "GetBlockHeader"
+	MethodResolveHeight              = "ResolveHeight"
+	MethodGetAccount                 = "GetAccount"
+	MethodGetDelegations             = "GetDelegations"
+	MethodGetDebondingDelegations    = "GetDebondingDelegations"
+	MethodGetTransactionsWithResults = "GetTransactionsWithResults"
+	MethodGetUnconfirmedTransactions = "GetUnconfirmedTransactions"
+	MethodGetStakingEvents           = "GetStakingEvents"
+	MethodSubmitTxNoWait             = "SubmitTxNoWait"
+	MethodGetNextNonce               = "GetNextNonce"
+	MethodGetStatus                  = "GetStatus"
+	MethodGetGenesisDocument         = "GetGenesisDocument"
+	MethodStateToGenesis             = "StateToGenesis"
+)
+
+// ErrNoState is the error returned when querying state at a height that the
+// mock node doesn't have (e.g., because it was pruned).  Like a real node's,
+// it is a consensus.ErrVersionNotFound.
+var ErrNoState = fmt.Errorf("mock: state not available: %w", consensus.ErrVersionNotFound)
+
+// block is a committed mock block with everything the Client serves for it.
+type block struct {
+	blk    *oasis.Block
+	time   time.Time
+	txs    *consensus.TransactionsWithResults
+	events []*staking.Event
+	state  *staking.Genesis
+}
+
+// Client is an in-memory implementation of oasis.Client.
+//
+// The client starts with a genesis block holding the given staking state.
+// Transactions submitted with SubmitTxNoWait are put into the mempool and
+// are executed when the next block is committed with CommitBlock.
+type Client struct {
+	sync.RWMutex
+
+	genesis *staking.Genesis
+	blocks  []*block
+
+	// State that the next committed block will start from.
+	state *staking.Genesis
+	// Block-level events of the next committed block.
+	pendingEvents []*staking.Event
+	mempool       [][]byte
+
+	lastRetainedHeight int64
+	errors             map[string]error
+}
+
+// New creates a new mock client whose genesis state is the given staking
+// ledger and delegations.
+func New(genesisState *staking.Genesis) *Client {
+	if genesisState.Ledger == nil {
+		genesisState.Ledger = make(map[staking.Address]*staking.Account)
+	}
+	c := &Client{
+		genesis:            cloneState(genesisState),
+		state:              cloneState(genesisState),
+		lastRetainedHeight: GenesisHeight,
+		errors:             make(map[string]error),
+	}
+	c.commitLocked(nil)
+	return c
+}
+
+// SetError makes all subsequent calls of the given method return the given
+// error (or succeed again if err is nil).
+func (c *Client) SetError(method string, err error) {
+	c.Lock()
+	defer c.Unlock()
+
+	if err == nil {
+		delete(c.errors, method)
+		return
+	}
+	c.errors[method] = err
+}
+
+// SetAccount sets the account of the given address in the state of the next
+// committed block.
+func (c *Client) SetAccount(addr staking.Address, act *staking.Account) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.state.Ledger[addr] = cloneAccount(act)
+}
+
+// SetDelegation sets the delegation from the delegator to the escrow account
+// in the state of the next committed block.
+func (c *Client) SetDelegation(escrow, delegator staking.Address, d *staking.Delegation) {
+	c.Lock()
+	defer c.Unlock()
+
+	if c.state.Delegations == nil {
+		c.state.Delegations = make(map[staking.Address]map[staking.Address]*staking.Delegation)
+	}
+	if c.state.Delegations[escrow] == nil {
+		c.state.Delegations[escrow] = make(map[staking.Address]*staking.Delegation)
+	}
+	dc := *d
+	c.state.Delegations[escrow][delegator] = &dc
+}
+
+// SetDebondingDelegations sets the debonding delegations from the delegator
+// to the escrow account in the state of the next committed block.
+func (c *Client) SetDebondingDelegations(escrow, delegator staking.Address, dds []*staking.DebondingDelegation) {
+	c.Lock()
+	defer c.Unlock()
+
+	if c.state.DebondingDelegations == nil {
+		c.state.DebondingDelegations = make(map[staking.Address]map[staking.Address][]*staking.DebondingDelegation)
+	}
+	if c.state.DebondingDelegations[escrow] == nil {
+		c.state.DebondingDelegations[escrow] = make(map[staking.Address][]*staking.DebondingDelegation)
+	}
+	ddsc := make([]*staking.DebondingDelegation, 0, len(dds))
+	for _, dd := range dds {
+		ddc := *dd
+		ddsc = append(ddsc, &ddc)
+	}
+	c.state.DebondingDelegations[escrow][delegator] = ddsc
+}
+
+// AddBlockEvent adds a block-level (i.e. not emitted by any transaction)
+// staking event to the next committed block. The event is not applied to
+// the state.
+func (c *Client) AddBlockEvent(ev *staking.Event) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.pendingEvents = append(c.pendingEvents, ev)
+}
+
+// AddUnconfirmedTransaction adds a raw transaction to the mempool without
+// any checks.
+func (c *Client) AddUnconfirmedTransaction(rawTx []byte) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.mempool = append(c.mempool, rawTx)
+}
+
+// PruneTo makes the state of all blocks below the given height unavailable.
+func (c *Client) PruneTo(height int64) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.lastRetainedHeight = height
+}
+
+// CommitBlock executes all transactions in the mempool, commits a new block
+// and returns it.
+func (c *Client) CommitBlock() *oasis.Block {
+	c.Lock()
+	defer c.Unlock()
+
+	rawTxs := c.mempool
+	c.mempool = nil
+	return c.commitLocked(rawTxs)
+}
+
+// LatestHeight returns the height of the latest committed block.
+func (c *Client) LatestHeight() int64 {
+	c.RLock()
+	defer c.RUnlock()
+
+	return c.latestLocked().blk.Height
+}
+
+func (c *Client) latestLocked() *block {
+	return c.blocks[len(c.blocks)-1]
+}
+
+func (c *Client) commitLocked(rawTxs [][]byte) *oasis.Block {
+	height := GenesisHeight + int64(len(c.blocks))
+
+	txs := &consensus.TransactionsWithResults{
+		Transactions: [][]byte{},
+		Results:      []*results.Result{},
+	}
+	var events []*staking.Event
+	for _, rawTx := range rawTxs {
+		res := c.executeTx(height, rawTx)
+		txs.Transactions = append(txs.Transactions, rawTx)
+		txs.Results = append(txs.Results, res)
+		for _, ev := range res.Events {
+			events = append(events, ev.Staking)
+		}
+	}
+	for _, ev := range c.pendingEvents {
+		ev.Height = height
+		// Block-level events have an empty transaction hash.
+		ev.TxHash.Empty()
+		events = append(events, ev)
+	}
+	c.pendingEvents = nil
+
+	var rawHeight [8]byte
+	binary.BigEndian.PutUint64(rawHeight[:], uint64(height))
+	blkHash := hash.NewFromBytes(rawHeight[:])
+
+	blkTime := time.Unix(1600000000+height, 0)
+	blk := &oasis.Block{
+		Height:       height,
+		Hash:         hex.EncodeToString(blkHash[:]),
+		Timestamp:    blkTime.UnixNano() / 1000000, // ms
+		ParentHeight: height - 1,
+		Epoch:        uint64(height / EpochInterval),
+	}
+	if len(c.blocks) > 0 {
+		blk.ParentHash = c.latestLocked().blk.Hash
+	} else {
+		blk.ParentHeight = height
+		blk.ParentHash = blk.Hash
+	}
+
+	c.blocks = append(c.blocks, &block{
+		blk:    blk,
+		time:   blkTime,
+		txs:    txs,
+		events: events,
+		state:  cloneState(c.state),
+	})
+
+	bc := *blk
+	return &bc
+}
+
+// executeTx executes the given raw transaction against the next block's
+// state and returns its result.
+func (c *Client) executeTx(height int64, rawTx []byte) *results.Result {
+	var sigTx transaction.SignedTransaction
+	if err := cbor.Unmarshal(rawTx, &sigTx); err != nil {
+		return newErrorResult(transaction.ErrInvalidNonce)
+	}
+	var tx transaction.Transaction
+	if err := sigTx.Open(&tx); err != nil {
+		return newErrorResult(transaction.ErrInvalidNonce)
+	}
+	txHash := sigTx.Hash()
+	signer := staking.NewAddress(sigTx.Signature.PublicKey)
+	act := c.accountLocked(signer)
+
+	if tx.Nonce != act.General.Nonce {
+		return newErrorResult(transaction.ErrInvalidNonce)
+	}
+
+	res := &results.Result{}
+	emit := func(ev *staking.Event) {
+		ev.Height = height
+		ev.TxHash = txHash
+		res.Events = append(res.Events, &results.Event{Staking: ev})
+	}
+
+	// Pay the fee.
+	if tx.Fee != nil && !tx.Fee.Amount.IsZero() {
+		if err := c.moveLocked(&act.General.Balance, staking.FeeAccumulatorAddress, &tx.Fee.Amount); err != nil {
+			return newErrorResult(transaction.ErrInsufficientFeeBalance)
+		}
+		emit(&staking.Event{Transfer: &staking.TransferEvent{
+			From:   signer,
+			To:     staking.FeeAccumulatorAddress,
+			Amount: tx.Fee.Amount,
+		}})
+	}
+	act.General.Nonce++
+
+	// Methods only emit events on success, so on failure only the fee
+	// payment remains in effect.
+	if err := c.executeMethodLocked(signer, act, &tx, emit); err != nil {
+		res.Error = newErrorResult(err).Error
+	}
+	return res
+}
+
+func (c *Client) executeMethodLocked(
+	signer staking.Address,
+	act *staking.Account,
+	tx *transaction.Transaction,
+	emit func(*staking.Event),
+) error {
+	switch tx.Method {
+	case staking.MethodTransfer:
+		var xfer staking.Transfer
+		if err := cbor.Unmarshal(tx.Body, &xfer); err != nil {
+			return staking.ErrInvalidArgument
+		}
+		if err := c.moveLocked(&act.General.Balance, xfer.To, &xfer.Amount); err != nil {
+			return err
+		}
+		emit(&staking.Event{Transfer: &staking.TransferEvent{
+			From:   signer,
+			To:     xfer.To,
+			Amount: xfer.Amount,
+		}})
+	case staking.MethodBurn:
+		var burn staking.Burn
+		if err := cbor.Unmarshal(tx.Body, &burn); err != nil {
+			return staking.ErrInvalidArgument
+		}
+		if err := act.General.Balance.Sub(&burn.Amount); err != nil {
+			return staking.ErrInsufficientBalance
+		}
+		emit(&staking.Event{Burn: &staking.BurnEvent{
+			Owner:  signer,
+			Amount: burn.Amount,
+		}})
+	case staking.MethodAddEscrow:
+		var escrow staking.Escrow
+		if err := cbor.Unmarshal(tx.Body, &escrow); err != nil {
+			return staking.ErrInvalidArgument
+		}
+		escrowAct := c.accountLocked(escrow.Account)
+		shares := quantity.NewQuantity()
+		if err := escrowAct.Escrow.Active.Deposit(shares, &act.General.Balance, &escrow.Amount); err != nil {
+			return staking.ErrInsufficientBalance
+		}
+		d := c.delegationLocked(escrow.Account, signer)
+		if err := d.Shares.Add(shares); err != nil {
+			return staking.ErrInvalidArgument
+		}
+		emit(&staking.Event{Escrow: &staking.EscrowEvent{Add: &staking.AddEscrowEvent{
+			Owner:  signer,
+			Escrow: escrow.Account,
+			Amount: escrow.Amount,
+		}}})
+	case staking.MethodReclaimEscrow:
+		var reclaim staking.ReclaimEscrow
+		if err := cbor.Unmarshal(tx.Body, &reclaim); err != nil {
+			return staking.ErrInvalidArgument
+		}
+		d := c.delegationLocked(reclaim.Account, signer)
+		if d.Shares.Cmp(&reclaim.Shares) < 0 {
+			return staking.ErrInsufficientBalance
+		}
+		escrowAct := c.accountLocked(reclaim.Account)
+		amount := quantity.NewQuantity()
+		if err := escrowAct.Escrow.Active.Withdraw(amount, &d.Shares, &reclaim.Shares); err != nil {
+			return staking.ErrInvalidArgument
+		}
+		dd := &staking.DebondingDelegation{
+			DebondEndTime: beacon.EpochTime(c.latestLocked().blk.Epoch + DebondingInterval),
+		}
+		if err := escrowAct.Escrow.Debonding.Deposit(&dd.Shares, amount, amount.Clone()); err != nil {
+			return staking.ErrInvalidArgument
+		}
+		c.addDebondingDelegationLocked(reclaim.Account, signer, dd)
+	default:
+		return consensus.ErrUnsupported
+	}
+	return nil
+}
+
+// moveLocked moves the given amount from the given balance to the general
+// balance of the given address.
+//
+// Like on a real node, the balances of the common pool and the fee
+// accumulator are kept outside of the ledger.
+func (c *Client) moveLocked(from *quantity.Quantity, to staking.Address, amount *quantity.Quantity) error {
+	if err := from.Sub(amount); err != nil {
+		return staking.ErrInsufficientBalance
+	}
+	switch {
+	case to.Equal(staking.CommonPoolAddress):
+		return c.state.CommonPool.Add(amount)
+	case to.Equal(staking.FeeAccumulatorAddress):
+		return c.state.LastBlockFees.Add(amount)
+	default:
+		return c.accountLocked(to).General.Balance.Add(amount)
+	}
+}
+
+func (c *Client) accountLocked(addr staking.Address) *staking.Account {
+	act, ok := c.state.Ledger[addr]
+	if !ok {
+		act = &staking.Account{}
+		c.state.Ledger[addr] = act
+	}
+	return act
+}
+
+func (c *Client) delegationLocked(escrow, delegator staking.Address) *staking.Delegation {
+	if c.state.Delegations == nil {
+		c.state.Delegations = make(map[staking.Address]map[staking.Address]*staking.Delegation)
+	}
+	if c.state.Delegations[escrow] == nil {
+		c.state.Delegations[escrow] = make(map[staking.Address]*staking.Delegation)
+	}
+	d, ok := c.state.Delegations[escrow][delegator]
+	if !ok {
+		d = &staking.Delegation{}
+		c.state.Delegations[escrow][delegator] = d
+	}
+	return d
+}
+
+func (c *Client) addDebondingDelegationLocked(escrow, delegator staking.Address, dd *staking.DebondingDelegation) {
+	if c.state.DebondingDelegations == nil {
+		c.state.DebondingDelegations = make(map[staking.Address]map[staking.Address][]*staking.DebondingDelegation)
+	}
+	if c.state.DebondingDelegations[escrow] == nil {
+		c.state.DebondingDelegations[escrow] = make(map[staking.Address][]*staking.DebondingDelegation)
+	}
+	c.state.DebondingDelegations[escrow][delegator] = append(c.state.DebondingDelegations[escrow][delegator], dd)
+}
+
+// getBlock returns the block at the given height.
+func (c *Client) getBlock(height int64) (*block, error) {
+	if height == oasis.LatestHeight {
+		return c.latestLocked(), nil
+	}
+	idx := height - GenesisHeight
+	if idx < 0 || idx >= int64(len(c.blocks)) {
+		return nil, fmt.Errorf("mock: block at height %d not found", height)
+	}
+	return c.blocks[idx], nil
+}
+
+// getState returns the state after the block at the given height.
+func (c *Client) getState(height int64) (*staking.Genesis, error) {
+	b, err := c.getBlock(height)
+	if err != nil {
+		return nil, err
+	}
+	if b.blk.Height < c.lastRetainedHeight {
+		return nil, ErrNoState
+	}
+	return b.state, nil
+}
+
+// GetChainID implements oasis.Client.
+func (c *Client) GetChainID(ctx context.Context) (string, error) {
+	c.RLock()
+	defer c.RUnlock()
+
+	if err := c.errors[MethodGetChainID]; err != nil {
+		return "", err
+	}
+	return ChainID, nil
+}
+
+// GetBlock implements oasis.Client.
+func (c *Client) GetBlock(ctx context.Context, height int64) (*oasis.Block, error) {
+	c.RLock()
+	defer c.RUnlock()
+
+	if err := c.errors[MethodGetBlock]; err != nil {
+		return nil, err
+	}
+	b, err := c.getBlock(height)
+	if err != nil {
+		return nil, err
+	}
+	blk := *b.blk
+	return &blk, nil
+}
+
+// GetLatestBlock implements oasis.Client.
+func (c *Client) GetLatestBlock(ctx context.Context) (*oasis.Block, error) {
+	return c.GetBlock(ctx, oasis.LatestHeight)
+}
+
+// GetGenesisBlock implements oasis.Client.
+func (c *Client) GetGenesisBlock(ctx context.Context) (*oasis.Block, error) {
+	return c.GetBlock(ctx, GenesisHeight)
+}
+
+// GetBlockHeader implements oasis.Client.
+func (c *Client) GetBlockHeader(ctx context.Context, height int64) (*oasis.BlockHeader, error) {
+	c.RLock()
+	defer c.RUnlock()
+
+	if err := c.errors[MethodGetBlockHeader]; err != nil {
+		return nil, err
+	}
+	b, err := c.getBlock(height)
+	if err != nil {
+		return nil, err
+	}
+	return &oasis.BlockHeader{
+		Height:    b.blk.Height,
+		Hash:      b.blk.Hash,
+		Timestamp: b.blk.Timestamp,
+	}, nil
+}
+
+// ResolveHeight implements oasis.Client.
+func (c *Client) ResolveHeight(ctx context.Context, height int64) (int64, error) {
+	c.RLock()
+	defer c.RUnlock()
+
+	if err := c.errors[MethodResolveHeight]; err != nil {
+		return 0, err
+	}
+	if height != oasis.LatestHeight {
+		return height, nil
+	}
+	return c.latestLocked().blk.Height, nil
+}
+
+// GetAccount implements oasis.Client.
+func (c *Client) GetAccount(ctx context.Context, height int64, owner staking.Address) (*staking.Account, error) {
+	c.RLock()
+	defer c.RUnlock()
+
+	if err := c.errors[MethodGetAccount]; err != nil {
+		return nil, err
+	}
+	state, err := c.getState(height)
+	if err != nil {
+		return nil, err
+	}
+	act, ok := state.Ledger[owner]
+	if !ok {
+		return &staking.Account{}, nil
+	}
+	return cloneAccount(act), nil
+}
+
+// GetDelegations implements oasis.Client.
+func (c *Client) GetDelegations(
+	ctx context.Context,
+	height int64,
+	owner staking.Address,
+) (map[staking.Address]*staking.Delegation, error) {
+	c.RLock()
+	defer c.RUnlock()
+
+	if err := c.errors[MethodGetDelegations]; err != nil {
+		return nil, err
+	}
+	state, err := c.getState(height)
+	if err != nil {
+		return nil, err
+	}
+	delegations := make(map[staking.Address]*staking.Delegation)
+	for escrow, ds := range state.Delegations {
+		if d, ok := ds[owner]; ok && !d.Shares.IsZero() {
+			dc := *d
+			delegations[escrow] = &dc
+		}
+	}
+	return delegations, nil
+}
+
+// GetDebondingDelegations implements oasis.Client.
+func (c *Client) GetDebondingDelegations(
+	ctx context.Context,
+	height int64,
+	owner staking.Address,
+) (map[staking.Address][]*staking.DebondingDelegation, error) {
+	c.RLock()
+	defer c.RUnlock()
+
+	if err := c.errors[MethodGetDebondingDelegations]; err != nil {
+		return nil, err
+	}
+	state, err := c.getState(height)
+	if err != nil {
+		return nil, err
+	}
+	delegations := make(map[staking.Address][]*staking.DebondingDelegation)
+	for escrow, dds := range state.DebondingDelegations {
+		for _, dd := range dds[owner] {
+			ddc := *dd
+			delegations[escrow] = append(delegations[escrow], &ddc)
+		}
+	}
+	return delegations, nil
+}
+
+// GetTransactionsWithResults implements oasis.Client.
+func (c *Client) GetTransactionsWithResults(
+	ctx context.Context,
+	height int64,
+) (*consensus.TransactionsWithResults, error) {
+	c.RLock()
+	defer c.RUnlock()
+
+	if err := c.errors[MethodGetTransactionsWithResults]; err != nil {
+		return nil, err
+	}
+	b, err := c.getBlock(height)
+	if err != nil {
+		return nil, err
+	}
+	return b.txs, nil
+}
+
+// GetUnconfirmedTransactions implements oasis.Client.
+func (c *Client) GetUnconfirmedTransactions(ctx context.Context) ([][]byte, error) {
+	c.RLock()
+	defer c.RUnlock()
+
+	if err := c.errors[MethodGetUnconfirmedTransactions]; err != nil {
+		return nil, err
+	}
+	return append([][]byte{}, c.mempool...), nil
+}
+
+// GetStakingEvents implements oasis.Client.
+func (c *Client) GetStakingEvents(ctx context.Context, height int64) ([]*staking.Event, error) {
+	c.RLock()
+	defer c.RUnlock()
+
+	if err := c.errors[MethodGetStakingEvents]; err != nil {
+		return nil, err
+	}
+	b, err := c.getBlock(height)
+	if err != nil {
+		return nil, err
+	}
+	return b.events, nil
+}
+
+// SubmitTxNoWait implements oasis.Client.
+//
+// The transaction is checked (signature and nonce) and added to the mempool.
+func (c *Client) SubmitTxNoWait(ctx context.Context, tx *transaction.SignedTransaction) error {
+	c.Lock()
+	defer c.Unlock()
+
+	if err := c.errors[MethodSubmitTxNoWait]; err != nil {
+		return err
+	}
+
+	var t transaction.Transaction
+	if err := tx.Open(&t); err != nil {
+		return transaction.ErrInvalidNonce
+	}
+	rawTx := cbor.Marshal(tx)
+	txHash := hash.NewFromBytes(rawTx)
+
+	signer := staking.NewAddress(tx.Signature.PublicKey)
+	nonce := c.accountLocked(signer).General.Nonce
+	for _, pending := range c.mempool {
+		if hash.NewFromBytes(pending) == txHash {
+			return consensus.ErrDuplicateTx
+		}
+		var pendingTx transaction.SignedTransaction
+		if err := cbor.Unmarshal(pending, &pendingTx); err != nil {
+			continue
+		}
+		if staking.NewAddress(pendingTx.Signature.PublicKey) == signer {
+			nonce++
+		}
+	}
+	if t.Nonce != nonce {
+		return transaction.ErrInvalidNonce
+	}
+
+	c.mempool = append(c.mempool, rawTx)
+	return nil
+}
+
+// GetNextNonce implements oasis.Client.
+func (c *Client) GetNextNonce(ctx context.Context, addr staking.Address, height int64) (uint64, error) {
+	c.RLock()
+	defer c.RUnlock()
+
+	if err := c.errors[MethodGetNextNonce]; err != nil {
+		return 0, err
+	}
+	state, err := c.getState(height)
+	if err != nil {
+		return 0, err
+	}
+	act, ok := state.Ledger[addr]
+	if !ok {
+		return 0, nil
+	}
+	return act.General.Nonce, nil
+}
+
+// GetStatus implements oasis.Client.
+func (c *Client) GetStatus(ctx context.Context) (*control.Status, error) {
+	c.RLock()
+	defer c.RUnlock()
+
+	if err := c.errors[MethodGetStatus]; err != nil {
+		return nil, err
+	}
+
+	latest := c.latestLocked()
+	genesisBlk := c.blocks[0]
+	retained, _ := c.getBlock(c.lastRetainedHeight)
+	latestHash, _ := hex.DecodeString(latest.blk.Hash)
+	genesisHash, _ := hex.DecodeString(genesisBlk.blk.Hash)
+	retainedHash, _ := hex.DecodeString(retained.blk.Hash)
+
+	return &control.Status{
+		SoftwareVersion: SoftwareVersion,
+		Consensus: consensus.Status{
+			NodePeers:          []string{},
+			LatestHeight:       latest.blk.Height,
+			LatestHash:         latestHash,
+			LatestTime:         latest.time,
+			LatestEpoch:        beacon.EpochTime(latest.blk.Epoch),
+			GenesisHeight:      genesisBlk.blk.Height,
+			GenesisHash:        genesisHash,
+			LastRetainedHeight: retained.blk.Height,
+			LastRetainedHash:   retainedHash,
+			ChainContext:       ChainID,
+		},
+	}, nil
+}
+
+// GetGenesisDocument implements oasis.Client.
+func (c *Client) GetGenesisDocument(ctx context.Context) (*genesis.Document, error) {
+	c.RLock()
+	defer c.RUnlock()
+
+	if err := c.errors[MethodGetGenesisDocument]; err != nil {
+		return nil, err
+	}
+	return &genesis.Document{
+		Height:  GenesisHeight,
+		Time:    c.blocks[0].time,
+		ChainID: ChainID,
+		Staking: *cloneState(c.genesis),
+	}, nil
+}
+
+// StateToGenesis implements oasis.Client.
+func (c *Client) StateToGenesis(ctx context.Context, height int64) (*genesis.Document, error) {
+	c.RLock()
+	defer c.RUnlock()
+
+	if err := c.errors[MethodStateToGenesis]; err != nil {
+		return nil, err
+	}
+	b, err := c.getBlock(height)
+	if err != nil {
+		return nil, err
+	}
+	state, err := c.getState(b.blk.Height)
+	if err != nil {
+		return nil, err
+	}
+	return &genesis.Document{
+		Height:  b.blk.Height,
+		Time:    b.time,
+		ChainID: ChainID,
+		Staking: *cloneState(state),
+	}, nil
+}
+
+func newErrorResult(err error) *results.Result {
+	module, code := cmnErrors.Code(err)
+	return &results.Result{
+		Error: results.Error{
+			Module:  module,
+			Code:    code,
+			Message: err.Error(),
+		},
+	}
+}
+
+func cloneState(state *staking.Genesis) *staking.Genesis {
+	var sc staking.Genesis
+	if err := cbor.Unmarshal(cbor.Marshal(state), &sc); err != nil {
+		panic(err)
+	}
+	if sc.Ledger == nil {
+		sc.Ledger = make(map[staking.Address]*staking.Account)
+	}
+	return &sc
+}
+
+func cloneAccount(act *staking.Account) *staking.Account {
+	var ac staking.Account
+	if err := cbor.Unmarshal(cbor.Marshal(act), &ac); err != nil {
+		panic(err)
+	}
+	return &ac
+}
+
+var _ oasis.Client = (*Client)(nil)
@@ -6,16 +6,23 @@ import (
 	"fmt"
 	"os"
 	"sync"
+	"time"
 
+	"golang.org/x/sync/errgroup"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/metadata"
 
+	beacon "github.com/oasisprotocol/oasis-core/go/beacon/api"
 	cmnGrpc "github.com/oasisprotocol/oasis-core/go/common/grpc"
 	"github.com/oasisprotocol/oasis-core/go/common/logging"
 	consensus "github.com/oasisprotocol/oasis-core/go/consensus/api"
 	"github.com/oasisprotocol/oasis-core/go/consensus/api/transaction"
 	control "github.com/oasisprotocol/oasis-core/go/control/api"
+	genesis "github.com/oasisprotocol/oasis-core/go/genesis/api"
 	staking "github.com/oasisprotocol/oasis-core/go/staking/api"
+
+	"github.com/oasisprotocol/oasis-core-rosetta-gateway/common"
 )
 
 // LatestHeight can be used as the height in queries to specify the latest
@@ -26,6 +33,10 @@ const LatestHeight = consensus.HeightLatest
 // gRPC host address of the Oasis node that the client should connect to.
 const GrpcAddrEnvVar = "OASIS_NODE_GRPC_ADDR"
 
+// requestIDMetadataKey is the key of the request ID in the metadata of gRPC
+// calls to the node.
+const requestIDMetadataKey = "x-request-id"
+
 var logger = logging.GetLogger("oasis")
 
 // Client can be used to query an Oasis node for information and to submit
@@ -44,6 +55,16 @@ type Client interface {
 	// GetGenesisBlock returns the Oasis genesis block.
 	GetGenesisBlock(ctx context.Context) (*Block, error)
 
+	// GetBlockHeader returns the header of the Oasis block at given height,
+	// which is cheaper to get than the whole block.
+	GetBlockHeader(ctx context.Context, height int64) (*BlockHeader, error)
+
+	// ResolveHeight returns the given height, or the height of the latest
+	// block if it is LatestHeight.  Requests that make several queries
+	// resolve the height once and make all of them at the resolved height,
+	// so that a new block can't land in between.
+	ResolveHeight(ctx context.Context, height int64) (int64, error)
+
 	// GetAccount returns the Oasis staking account for given owner address
 	// at given height.
 	GetAccount(ctx context.Context, height int64, owner staking.Address) (*staking.Account, error)
@@ -81,6 +102,12 @@ type Client interface {
 
 	// GetStatus returns the status overview of the node.
 	GetStatus(ctx context.Context) (*control.Status, error)
+
+	// GetGenesisDocument returns the original genesis document.
+	GetGenesisDocument(ctx context.Context) (*genesis.Document, error)
+
+	// StateToGenesis returns the genesis state at given height.
+	StateToGenesis(ctx context.Context, height int64) (*genesis.Document, error)
 }
 
 // Block is a representation of the Oasis block metadata, converted to be more
@@ -94,6 +121,14 @@ type Block struct {
 	Epoch        uint64 // Epoch.
 }
 
+// BlockHeader is the part of the Oasis block metadata that identifies a
+// block.
+type BlockHeader struct {
+	Height    int64  // Block height.
+	Hash      string // Block hash.
+	Timestamp int64  // UNIX time, converted to milliseconds.
+}
+
 // grpcClient is an implementation of Client using gRPC.
 type grpcClient struct {
 	sync.RWMutex
@@ -101,11 +136,23 @@ type grpcClient struct {
 	// Connection to an Oasis node's internal socket.
 	grpcConn *grpc.ClientConn
 
+	// Limiter of the calls made over the connection.
+	limiter *callLimiter
+
+	// Retry policy of the idempotent calls made over the connection.
+	retryPolicy *retryPolicy
+
 	// Cached chain ID.
 	chainID string
 
 	// Cached genesis height.
 	genesisHeight int64
+
+	// Cached epochs of block heights.
+	epochs epochTable
+
+	// Cached headers of recent blocks.
+	headers headerCache
 }
 
 // connect() returns a gRPC connection to Oasis node via its internal socket.
@@ -132,10 +179,14 @@ func (c *grpcClient) connect(ctx context.Context) (*grpc.ClientConn, error) {
 
 	// Establish new gRPC connection.
 	var err error
-	logger.Debug("Establishing connection", "grpc_addr", grpcAddr)
-	c.grpcConn, err = cmnGrpc.Dial(grpcAddr, grpc.WithInsecure())
+	reqLogger := common.LoggerWithRequestID(ctx, logger)
+	reqLogger.Debug("Establishing connection", "grpc_addr", grpcAddr)
+	c.grpcConn, err = cmnGrpc.Dial(grpcAddr,
+		grpc.WithInsecure(),
+		grpc.WithChainUnaryInterceptor(c.retryPolicy.intercept, requestIDInterceptor, c.limiter.intercept),
+	)
 	if err != nil {
-		logger.Debug("Failed to establish connection",
+		reqLogger.Debug("Failed to establish connection",
 			"grpc_addr", grpcAddr,
 			"err", err,
 		)
@@ -145,7 +196,7 @@ func (c *grpcClient) connect(ctx context.Context) (*grpc.ClientConn, error) {
 	// Cache genesis height.
 	status, err := control.NewNodeControllerClient(c.grpcConn).GetStatus(ctx)
 	if err != nil {
-		logger.Debug("Failed to get status from node", "err", err)
+		reqLogger.Debug("Failed to get status from node", "err", err)
 		return nil, fmt.Errorf("failed to get status from node: %v", err)
 	}
 	c.genesisHeight = status.Consensus.GenesisHeight
@@ -162,6 +213,7 @@ func (c *grpcClient) GetChainID(ctx context.Context) (string, error) {
 		return cid, nil
 	}
 
+	ctx = idempotent(ctx)
 	conn, err := c.connect(ctx)
 	if err != nil {
 		return "", err
@@ -173,13 +225,14 @@ func (c *grpcClient) GetChainID(ctx context.Context) (string, error) {
 	client := consensus.NewConsensusClient(conn)
 	c.chainID, err = client.GetChainContext(ctx)
 	if err != nil {
-		logger.Debug("GetChainID: failed to get chain context", "err", err)
+		common.LoggerWithRequestID(ctx, logger).Debug("GetChainID: failed to get chain context", "err", err)
 		return "", err
 	}
 	return c.chainID, nil
 }
 
 func (c *grpcClient) GetBlock(ctx context.Context, height int64) (*Block, error) {
+	ctx = idempotent(ctx)
 	conn, err := c.connect(ctx)
 	if err != nil {
 		return nil, err
@@ -187,7 +240,7 @@ func (c *grpcClient) GetBlock(ctx context.Context, height int64) (*Block, error)
 	client := consensus.NewConsensusClient(conn)
 	blk, err := client.GetBlock(ctx, height)
 	if err != nil {
-		logger.Debug("GetBlock: failed to get block",
+		common.LoggerWithRequestID(ctx, logger).Debug("GetBlock: failed to get block",
 			"height", height,
 			"err", err,
 		)
@@ -195,7 +248,6 @@ func (c *grpcClient) GetBlock(ctx context.Context, height int64) (*Block, error)
 	}
 
 	parentHeight := blk.Height - 1
-	var parentHash []byte
 	if parentHeight <= 0 {
 		parentHeight = 1
 	}
@@ -203,22 +255,45 @@ func (c *grpcClient) GetBlock(ctx context.Context, height int64) (*Block, error)
 		parentHeight = c.genesisHeight
 	}
 
-	parentBlk, err := client.GetBlock(ctx, parentHeight)
-	if err != nil {
+	// The parent block and the epoch are independent, so get them in
+	// parallel.  Usually, neither needs a query of its own.
+	var (
+		parentHash []byte
+		epoch      beacon.EpochTime
+	)
+	g, gctx := errgroup.WithContext(ctx)
+	g.Go(func() error {
+		// The first block is its own parent.
+		if parentHeight == blk.Height {
+			parentHash = blk.Hash
+			return nil
+		}
+		if parentHash = parentHashFromMeta(blk.Meta); parentHash != nil {
+			return nil
+		}
+		// Fall back to querying the parent block.
+		parentBlk, gerr := client.GetBlock(gctx, parentHeight)
+		if gerr != nil {
+			return gerr
+		}
+		parentHash = parentBlk.Hash
+		return nil
+	})
+	g.Go(func() (gerr error) {
+		epoch, gerr = c.getEpoch(gctx, client.Beacon(), blk.Height)
+		return
+	})
+	if err = g.Wait(); err != nil {
 		return nil, err
 	}
-	parentHeight = parentBlk.Height
-	parentHash = parentBlk.Hash
 
-	epoch, err := client.Beacon().GetEpoch(ctx, height)
-	if err != nil {
-		return nil, err
-	}
+	hdr := newBlockHeader(blk)
+	c.headers.add(hdr)
 
 	return &Block{
-		Height:       blk.Height,
-		Hash:         hex.EncodeToString(blk.Hash),
-		Timestamp:    blk.Time.UnixNano() / 1000000, // ms
+		Height:       hdr.Height,
+		Hash:         hdr.Hash,
+		Timestamp:    hdr.Timestamp,
 		ParentHeight: parentHeight,
 		ParentHash:   hex.EncodeToString(parentHash),
 		Epoch:        uint64(epoch),
@@ -233,7 +308,45 @@ func (c *grpcClient) GetGenesisBlock(ctx context.Context) (*Block, error) {
 	return c.GetBlock(ctx, c.genesisHeight)
 }
 
+func (c *grpcClient) GetBlockHeader(ctx context.Context, height int64) (*BlockHeader, error) {
+	if hdr, ok := c.headers.get(height); ok {
+		return hdr, nil
+	}
+
+	ctx = idempotent(ctx)
+	conn, err := c.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	client := consensus.NewConsensusClient(conn)
+	blk, err := client.GetBlock(ctx, height)
+	if err != nil {
+		common.LoggerWithRequestID(ctx, logger).Debug("GetBlockHeader: failed to get block",
+			"height", height,
+			"err", err,
+		)
+		return nil, err
+	}
+	hdr := newBlockHeader(blk)
+	c.headers.add(hdr)
+	return hdr, nil
+}
+
+func (c *grpcClient) ResolveHeight(ctx context.Context, height int64) (int64, error) {
+	if height != LatestHeight {
+		return height, nil
+	}
+	// This also caches the latest block's header for the queries that
+	// follow.
+	hdr, err := c.GetBlockHeader(ctx, LatestHeight)
+	if err != nil {
+		return 0, err
+	}
+	return hdr.Height, nil
+}
+
 func (c *grpcClient) GetAccount(ctx context.Context, height int64, owner staking.Address) (*staking.Account, error) {
+	ctx = idempotent(ctx)
 	conn, err := c.connect(ctx)
 	if err != nil {
 		return nil, err
@@ -250,6 +363,7 @@ func (c *grpcClient) GetDelegations(
 	height int64,
 	owner staking.Address,
 ) (map[staking.Address]*staking.Delegation, error) {
+	ctx = idempotent(ctx)
 	conn, err := c.connect(ctx)
 	if err != nil {
 		return nil, err
@@ -266,6 +380,7 @@ func (c *grpcClient) GetDebondingDelegations(
 	height int64,
 	owner staking.Address,
 ) (map[staking.Address][]*staking.DebondingDelegation, error) {
+	ctx = idempotent(ctx)
 	conn, err := c.connect(ctx)
 	if err != nil {
 		return nil, err
@@ -281,6 +396,7 @@ func (c *grpcClient) GetTransactionsWithResults(
 	ctx context.Context,
 	height int64,
 ) (*consensus.TransactionsWithResults, error) {
+	ctx = idempotent(ctx)
 	conn, err := c.connect(ctx)
 	if err != nil {
 		return nil, err
@@ -290,6 +406,7 @@ func (c *grpcClient) GetTransactionsWithResults(
 }
 
 func (c *grpcClient) GetUnconfirmedTransactions(ctx context.Context) ([][]byte, error) {
+	ctx = idempotent(ctx)
 	conn, err := c.connect(ctx)
 	if err != nil {
 		return nil, err
@@ -299,6 +416,7 @@ func (c *grpcClient) GetUnconfirmedTransactions(ctx context.Context) ([][]byte,
 }
 
 func (c *grpcClient) GetStakingEvents(ctx context.Context, height int64) ([]*staking.Event, error) {
+	ctx = idempotent(ctx)
 	conn, err := c.connect(ctx)
 	if err != nil {
 		return nil, err
@@ -308,6 +426,8 @@ func (c *grpcClient) GetStakingEvents(ctx context.Context, height int64) ([]*sta
 }
 
 func (c *grpcClient) SubmitTxNoWait(ctx context.Context, tx *transaction.SignedTransaction) error {
+	// Submits are never retried, since a submit that failed may still have
+	// reached the node.
 	conn, err := c.connect(ctx)
 	if err != nil {
 		return err
@@ -317,6 +437,7 @@ func (c *grpcClient) SubmitTxNoWait(ctx context.Context, tx *transaction.SignedT
 }
 
 func (c *grpcClient) GetNextNonce(ctx context.Context, addr staking.Address, height int64) (uint64, error) {
+	ctx = idempotent(ctx)
 	conn, err := c.connect(ctx)
 	if err != nil {
 		return 0, err
@@ -329,6 +450,7 @@ func (c *grpcClient) GetNextNonce(ctx context.Context, addr staking.Address, hei
 }
 
 func (c *grpcClient) GetStatus(ctx context.Context) (*control.Status, error) {
+	ctx = idempotent(ctx)
 	conn, err := c.connect(ctx)
 	if err != nil {
 		return nil, err
@@ -337,7 +459,65 @@ func (c *grpcClient) GetStatus(ctx context.Context) (*control.Status, error) {
 	return client.GetStatus(ctx)
 }
 
-// New creates a new Oasis gRPC client.
+func (c *grpcClient) GetGenesisDocument(ctx context.Context) (*genesis.Document, error) {
+	ctx = idempotent(ctx)
+	conn, err := c.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	client := consensus.NewConsensusClient(conn)
+	return client.GetGenesisDocument(ctx)
+}
+
+func (c *grpcClient) StateToGenesis(ctx context.Context, height int64) (*genesis.Document, error) {
+	ctx = idempotent(ctx)
+	conn, err := c.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	client := consensus.NewConsensusClient(conn)
+	return client.StateToGenesis(ctx, height)
+}
+
+// requestIDInterceptor logs each gRPC call with the request ID carried by its
+// context and passes the ID on to the node in the call's metadata.
+func requestIDInterceptor(
+	ctx context.Context,
+	method string,
+	req, reply interface{},
+	cc *grpc.ClientConn,
+	invoker grpc.UnaryInvoker,
+	opts ...grpc.CallOption,
+) error {
+	if requestID := common.RequestIDFromContext(ctx); requestID != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, requestIDMetadataKey, requestID)
+	}
+
+	start := time.Now()
+	err := invoker(ctx, method, req, reply, cc, opts...)
+	common.LoggerWithRequestID(ctx, logger).Debug("gRPC call",
+		"method", method,
+		"latency", time.Since(start),
+		"err", err,
+	)
+	return err
+}
+
+// New creates a new Oasis gRPC client, with the limits of its calls to the
+// node given by the MaxConcurrentCallsEnvVar, MaxQueuedCallsEnvVar and
+// CallTimeoutEnvVar environment variables, and the retries of its read calls
+// given by the Retry*EnvVar environment variables.
 func New() (Client, error) {
-	return &grpcClient{}, nil
+	limiter, err := newCallLimiterFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	retryPolicy, err := newRetryPolicyFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	return &grpcClient{
+		limiter:     limiter,
+		retryPolicy: retryPolicy,
+	}, nil
 }
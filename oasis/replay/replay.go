@@ -0,0 +1,563 @@
+// Package replay implements an oasis.Client decorator that records the
+// requests to and responses from an Oasis node into a fixture, and an
+// oasis.Client that deterministically serves a recorded fixture back.
+//
+// Together they make it possible to capture the state of a real network at
+// interesting heights once and to use it in offline regression tests.
+package replay
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"sync"
+
+	"github.com/oasisprotocol/oasis-core/go/common/cbor"
+	cmnErrors "github.com/oasisprotocol/oasis-core/go/common/errors"
+	consensus "github.com/oasisprotocol/oasis-core/go/consensus/api"
+	"github.com/oasisprotocol/oasis-core/go/consensus/api/transaction"
+	control "github.com/oasisprotocol/oasis-core/go/control/api"
+	genesis "github.com/oasisprotocol/oasis-core/go/genesis/api"
+	staking "github.com/oasisprotocol/oasis-core/go/staking/api"
+
+	"github.com/oasisprotocol/oasis-core-rosetta-gateway/oasis"
+)
+
+// ErrNotRecorded is the error returned by the Player for requests that are
+// not in its fixture.
+var ErrNotRecorded = errors.New("replay: request not recorded")
+
+const (
+	methodGetChainID                 = "GetChainID"
+	methodGetBlock                   = "GetBlock"
+	methodGetLatestBlock             = "GetLatestBlock"
+	methodGetGenesisBlock            = "GetGenesisBlock"
+	methodGetBlockHeader             = "GetBlockHeader"
+	methodResolveHeight              = "ResolveHeight"
+	methodGetAccount                 = "GetAccount"
+	methodGetDelegations             = "GetDelegations"
+	methodGetDebondingDelegations    = "GetDebondingDelegations"
+	methodGetTransactionsWithResults = "GetTransactionsWithResults"
+	methodGetUnconfirmedTransactions = "GetUnconfirmedTransactions"
+	methodGetStakingEvents           = "GetStakingEvents"
+	methodSubmitTxNoWait             = "SubmitTxNoWait"
+	methodGetNextNonce               = "GetNextNonce"
+	methodGetStatus                  = "GetStatus"
+	methodGetGenesisDocument         = "GetGenesisDocument"
+	methodStateToGenesis             = "StateToGenesis"
+)
+
+// Fixture is a set of recorded request/response pairs.
+type Fixture struct {
+	Calls []*Call `json:"calls"`
+}
+
+// Call is a recorded request/response pair.
+type Call struct {
+	// Method is the name of the called oasis.Client method.
+	Method string `json:"method"`
+	// Request is the JSON-encoded arguments of the call (if any).
+	Request json.RawMessage `json:"request,omitempty"`
+	// Response is the CBOR-encoded result of the call (if it succeeded).
+	Response []byte `json:"response,omitempty"`
+	// Error is the error returned by the call (if it failed).
+	Error *CallError `json:"error,omitempty"`
+}
+
+// CallError is a recorded error.
+type CallError struct {
+	Module  string `json:"module,omitempty"`
+	Code    uint32 `json:"code,omitempty"`
+	Message string `json:"message"`
+}
+
+// Err returns the recorded error, which is the registered error with the
+// same module and code if there is one.
+func (e *CallError) Err() error {
+	if e.Module != "" {
+		if err := cmnErrors.FromCode(e.Module, e.Code); err != nil {
+			return err
+		}
+	}
+	return errors.New(e.Message)
+}
+
+func (c *Call) key() string {
+	return callKey(c.Method, c.Request)
+}
+
+// callKey returns the key identifying a call. The request is compacted, as
+// saving a fixture indents it.
+func callKey(method string, request []byte) string {
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, request); err != nil {
+		return method + " " + string(request)
+	}
+	return method + " " + buf.String()
+}
+
+type heightRequest struct {
+	Height int64 `json:"height"`
+}
+
+type accountRequest struct {
+	Height  int64           `json:"height"`
+	Address staking.Address `json:"address"`
+}
+
+type submitRequest struct {
+	Tx *transaction.SignedTransaction `json:"tx"`
+}
+
+// Load loads a fixture from the given file.
+func Load(path string) (*Fixture, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("replay: failed to read fixture: %w", err)
+	}
+	var f Fixture
+	if err = json.Unmarshal(raw, &f); err != nil {
+		return nil, fmt.Errorf("replay: malformed fixture: %w", err)
+	}
+	return &f, nil
+}
+
+// Save saves the fixture to the given file.
+//
+// Calls are sorted by method and request, so that re-recording the same
+// requests produces the same file.
+func (f *Fixture) Save(path string) error {
+	calls := append([]*Call{}, f.Calls...)
+	sort.Slice(calls, func(i, j int) bool {
+		return calls[i].key() < calls[j].key()
+	})
+
+	raw, err := json.MarshalIndent(&Fixture{Calls: calls}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("replay: failed to marshal fixture: %w", err)
+	}
+	if err = ioutil.WriteFile(path, append(raw, '\n'), 0o644); err != nil { // nolint: gosec
+		return fmt.Errorf("replay: failed to write fixture: %w", err)
+	}
+	return nil
+}
+
+// Recorder is an oasis.Client that forwards all calls to another client and
+// records them.
+//
+// Only the first response to each distinct request is recorded, so that a
+// Player serving the fixture back always returns the same response.
+type Recorder struct {
+	sync.Mutex
+
+	client  oasis.Client
+	fixture Fixture
+	index   map[string]bool
+	// err is the first error that prevented a call from being recorded.
+	err error
+}
+
+// NewRecorder creates a new recorder that forwards calls to the given client.
+func NewRecorder(client oasis.Client) *Recorder {
+	return &Recorder{
+		client: client,
+		index:  make(map[string]bool),
+	}
+}
+
+// Fixture returns the calls recorded so far, or the first error that
+// prevented a call from being recorded, as the fixture is incomplete then.
+func (r *Recorder) Fixture() (*Fixture, error) {
+	r.Lock()
+	defer r.Unlock()
+
+	if r.err != nil {
+		return nil, r.err
+	}
+	return &Fixture{Calls: append([]*Call{}, r.fixture.Calls...)}, nil
+}
+
+func (r *Recorder) record(method string, request, response interface{}, err error) {
+	r.Lock()
+	defer r.Unlock()
+
+	var rawRequest []byte
+	if request != nil {
+		var merr error
+		if rawRequest, merr = json.Marshal(request); merr != nil {
+			if r.err == nil {
+				r.err = fmt.Errorf("replay: failed to marshal %s request: %w", method, merr)
+			}
+			return
+		}
+	}
+
+	key := callKey(method, rawRequest)
+	if r.index[key] {
+		return
+	}
+	r.index[key] = true
+
+	call := &Call{
+		Method:  method,
+		Request: rawRequest,
+	}
+	switch err {
+	case nil:
+		if response != nil {
+			call.Response = cbor.Marshal(response)
+		}
+	default:
+		module, code := cmnErrors.Code(err)
+		call.Error = &CallError{
+			Module:  module,
+			Code:    code,
+			Message: err.Error(),
+		}
+	}
+	r.fixture.Calls = append(r.fixture.Calls, call)
+}
+
+// GetChainID implements oasis.Client.
+func (r *Recorder) GetChainID(ctx context.Context) (string, error) {
+	chainID, err := r.client.GetChainID(ctx)
+	r.record(methodGetChainID, nil, chainID, err)
+	return chainID, err
+}
+
+// GetBlock implements oasis.Client.
+func (r *Recorder) GetBlock(ctx context.Context, height int64) (*oasis.Block, error) {
+	blk, err := r.client.GetBlock(ctx, height)
+	r.record(methodGetBlock, heightRequest{height}, blk, err)
+	return blk, err
+}
+
+// GetLatestBlock implements oasis.Client.
+func (r *Recorder) GetLatestBlock(ctx context.Context) (*oasis.Block, error) {
+	blk, err := r.client.GetLatestBlock(ctx)
+	r.record(methodGetLatestBlock, nil, blk, err)
+	return blk, err
+}
+
+// GetGenesisBlock implements oasis.Client.
+func (r *Recorder) GetGenesisBlock(ctx context.Context) (*oasis.Block, error) {
+	blk, err := r.client.GetGenesisBlock(ctx)
+	r.record(methodGetGenesisBlock, nil, blk, err)
+	return blk, err
+}
+
+// GetBlockHeader implements oasis.Client.
+func (r *Recorder) GetBlockHeader(ctx context.Context, height int64) (*oasis.BlockHeader, error) {
+	hdr, err := r.client.GetBlockHeader(ctx, height)
+	r.record(methodGetBlockHeader, heightRequest{height}, hdr, err)
+	return hdr, err
+}
+
+// ResolveHeight implements oasis.Client.
+func (r *Recorder) ResolveHeight(ctx context.Context, height int64) (int64, error) {
+	resolved, err := r.client.ResolveHeight(ctx, height)
+	r.record(methodResolveHeight, heightRequest{height}, resolved, err)
+	return resolved, err
+}
+
+// GetAccount implements oasis.Client.
+func (r *Recorder) GetAccount(ctx context.Context, height int64, owner staking.Address) (*staking.Account, error) {
+	act, err := r.client.GetAccount(ctx, height, owner)
+	r.record(methodGetAccount, accountRequest{height, owner}, act, err)
+	return act, err
+}
+
+// GetDelegations implements oasis.Client.
+func (r *Recorder) GetDelegations(
+	ctx context.Context,
+	height int64,
+	owner staking.Address,
+) (map[staking.Address]*staking.Delegation, error) {
+	delegations, err := r.client.GetDelegations(ctx, height, owner)
+	r.record(methodGetDelegations, accountRequest{height, owner}, delegations, err)
+	return delegations, err
+}
+
+// GetDebondingDelegations implements oasis.Client.
+func (r *Recorder) GetDebondingDelegations(
+	ctx context.Context,
+	height int64,
+	owner staking.Address,
+) (map[staking.Address][]*staking.DebondingDelegation, error) {
+	delegations, err := r.client.GetDebondingDelegations(ctx, height, owner)
+	r.record(methodGetDebondingDelegations, accountRequest{height, owner}, delegations, err)
+	return delegations, err
+}
+
+// GetTransactionsWithResults implements oasis.Client.
+func (r *Recorder) GetTransactionsWithResults(
+	ctx context.Context,
+	height int64,
+) (*consensus.TransactionsWithResults, error) {
+	txs, err := r.client.GetTransactionsWithResults(ctx, height)
+	r.record(methodGetTransactionsWithResults, heightRequest{height}, txs, err)
+	return txs, err
+}
+
+// GetUnconfirmedTransactions implements oasis.Client.
+func (r *Recorder) GetUnconfirmedTransactions(ctx context.Context) ([][]byte, error) {
+	txs, err := r.client.GetUnconfirmedTransactions(ctx)
+	r.record(methodGetUnconfirmedTransactions, nil, txs, err)
+	return txs, err
+}
+
+// GetStakingEvents implements oasis.Client.
+func (r *Recorder) GetStakingEvents(ctx context.Context, height int64) ([]*staking.Event, error) {
+	evs, err := r.client.GetStakingEvents(ctx, height)
+	r.record(methodGetStakingEvents, heightRequest{height}, evs, err)
+	return evs, err
+}
+
+// SubmitTxNoWait implements oasis.Client.
+func (r *Recorder) SubmitTxNoWait(ctx context.Context, tx *transaction.SignedTransaction) error {
+	err := r.client.SubmitTxNoWait(ctx, tx)
+	r.record(methodSubmitTxNoWait, submitRequest{tx}, nil, err)
+	return err
+}
+
+// GetNextNonce implements oasis.Client.
+func (r *Recorder) GetNextNonce(ctx context.Context, addr staking.Address, height int64) (uint64, error) {
+	nonce, err := r.client.GetNextNonce(ctx, addr, height)
+	r.record(methodGetNextNonce, accountRequest{height, addr}, nonce, err)
+	return nonce, err
+}
+
+// GetStatus implements oasis.Client.
+func (r *Recorder) GetStatus(ctx context.Context) (*control.Status, error) {
+	status, err := r.client.GetStatus(ctx)
+	r.record(methodGetStatus, nil, status, err)
+	return status, err
+}
+
+// GetGenesisDocument implements oasis.Client.
+func (r *Recorder) GetGenesisDocument(ctx context.Context) (*genesis.Document, error) {
+	doc, err := r.client.GetGenesisDocument(ctx)
+	r.record(methodGetGenesisDocument, nil, doc, err)
+	return doc, err
+}
+
+// StateToGenesis implements oasis.Client.
+func (r *Recorder) StateToGenesis(ctx context.Context, height int64) (*genesis.Document, error) {
+	doc, err := r.client.StateToGenesis(ctx, height)
+	r.record(methodStateToGenesis, heightRequest{height}, doc, err)
+	return doc, err
+}
+
+// Player is an oasis.Client that serves the calls recorded in a fixture.
+//
+// Requests that are not in the fixture fail with ErrNotRecorded.
+type Player struct {
+	calls map[string]*Call
+}
+
+// NewPlayer creates a new player serving the given fixture.
+func NewPlayer(f *Fixture) *Player {
+	p := &Player{
+		calls: make(map[string]*Call, len(f.Calls)),
+	}
+	for _, call := range f.Calls {
+		if _, exists := p.calls[call.key()]; !exists {
+			p.calls[call.key()] = call
+		}
+	}
+	return p
+}
+
+func (p *Player) replay(method string, request, response interface{}) error {
+	var rawRequest []byte
+	if request != nil {
+		var err error
+		if rawRequest, err = json.Marshal(request); err != nil {
+			return fmt.Errorf("replay: failed to marshal request: %w", err)
+		}
+	}
+
+	call, ok := p.calls[callKey(method, rawRequest)]
+	if !ok {
+		return fmt.Errorf("%w: %s %s", ErrNotRecorded, method, rawRequest)
+	}
+	if call.Error != nil {
+		return call.Error.Err()
+	}
+	if response == nil || call.Response == nil {
+		return nil
+	}
+	if err := cbor.Unmarshal(call.Response, response); err != nil {
+		return fmt.Errorf("replay: malformed response to %s: %w", method, err)
+	}
+	return nil
+}
+
+// GetChainID implements oasis.Client.
+func (p *Player) GetChainID(ctx context.Context) (string, error) {
+	var chainID string
+	if err := p.replay(methodGetChainID, nil, &chainID); err != nil {
+		return "", err
+	}
+	return chainID, nil
+}
+
+// GetBlock implements oasis.Client.
+func (p *Player) GetBlock(ctx context.Context, height int64) (*oasis.Block, error) {
+	var blk oasis.Block
+	if err := p.replay(methodGetBlock, heightRequest{height}, &blk); err != nil {
+		return nil, err
+	}
+	return &blk, nil
+}
+
+// GetLatestBlock implements oasis.Client.
+func (p *Player) GetLatestBlock(ctx context.Context) (*oasis.Block, error) {
+	var blk oasis.Block
+	if err := p.replay(methodGetLatestBlock, nil, &blk); err != nil {
+		return nil, err
+	}
+	return &blk, nil
+}
+
+// GetGenesisBlock implements oasis.Client.
+func (p *Player) GetGenesisBlock(ctx context.Context) (*oasis.Block, error) {
+	var blk oasis.Block
+	if err := p.replay(methodGetGenesisBlock, nil, &blk); err != nil {
+		return nil, err
+	}
+	return &blk, nil
+}
+
+// GetBlockHeader implements oasis.Client.
+func (p *Player) GetBlockHeader(ctx context.Context, height int64) (*oasis.BlockHeader, error) {
+	var hdr oasis.BlockHeader
+	if err := p.replay(methodGetBlockHeader, heightRequest{height}, &hdr); err != nil {
+		return nil, err
+	}
+	return &hdr, nil
+}
+
+// ResolveHeight implements oasis.Client.
+func (p *Player) ResolveHeight(ctx context.Context, height int64) (int64, error) {
+	var resolved int64
+	if err := p.replay(methodResolveHeight, heightRequest{height}, &resolved); err != nil {
+		return 0, err
+	}
+	return resolved, nil
+}
+
+// GetAccount implements oasis.Client.
+func (p *Player) GetAccount(ctx context.Context, height int64, owner staking.Address) (*staking.Account, error) {
+	var act staking.Account
+	if err := p.replay(methodGetAccount, accountRequest{height, owner}, &act); err != nil {
+		return nil, err
+	}
+	return &act, nil
+}
+
+// GetDelegations implements oasis.Client.
+func (p *Player) GetDelegations(
+	ctx context.Context,
+	height int64,
+	owner staking.Address,
+) (map[staking.Address]*staking.Delegation, error) {
+	var delegations map[staking.Address]*staking.Delegation
+	if err := p.replay(methodGetDelegations, accountRequest{height, owner}, &delegations); err != nil {
+		return nil, err
+	}
+	return delegations, nil
+}
+
+// GetDebondingDelegations implements oasis.Client.
+func (p *Player) GetDebondingDelegations(
+	ctx context.Context,
+	height int64,
+	owner staking.Address,
+) (map[staking.Address][]*staking.DebondingDelegation, error) {
+	var delegations map[staking.Address][]*staking.DebondingDelegation
+	if err := p.replay(methodGetDebondingDelegations, accountRequest{height, owner}, &delegations); err != nil {
+		return nil, err
+	}
+	return delegations, nil
+}
+
+// GetTransactionsWithResults implements oasis.Client.
+func (p *Player) GetTransactionsWithResults(
+	ctx context.Context,
+	height int64,
+) (*consensus.TransactionsWithResults, error) {
+	var txs consensus.TransactionsWithResults
+	if err := p.replay(methodGetTransactionsWithResults, heightRequest{height}, &txs); err != nil {
+		return nil, err
+	}
+	return &txs, nil
+}
+
+// GetUnconfirmedTransactions implements oasis.Client.
+func (p *Player) GetUnconfirmedTransactions(ctx context.Context) ([][]byte, error) {
+	var txs [][]byte
+	if err := p.replay(methodGetUnconfirmedTransactions, nil, &txs); err != nil {
+		return nil, err
+	}
+	return txs, nil
+}
+
+// GetStakingEvents implements oasis.Client.
+func (p *Player) GetStakingEvents(ctx context.Context, height int64) ([]*staking.Event, error) {
+	var evs []*staking.Event
+	if err := p.replay(methodGetStakingEvents, heightRequest{height}, &evs); err != nil {
+		return nil, err
+	}
+	return evs, nil
+}
+
+// SubmitTxNoWait implements oasis.Client.
+func (p *Player) SubmitTxNoWait(ctx context.Context, tx *transaction.SignedTransaction) error {
+	return p.replay(methodSubmitTxNoWait, submitRequest{tx}, nil)
+}
+
+// GetNextNonce implements oasis.Client.
+func (p *Player) GetNextNonce(ctx context.Context, addr staking.Address, height int64) (uint64, error) {
+	var nonce uint64
+	if err := p.replay(methodGetNextNonce, accountRequest{height, addr}, &nonce); err != nil {
+		return 0, err
+	}
+	return nonce, nil
+}
+
+// GetStatus implements oasis.Client.
+func (p *Player) GetStatus(ctx context.Context) (*control.Status, error) {
+	var status control.Status
+	if err := p.replay(methodGetStatus, nil, &status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// GetGenesisDocument implements oasis.Client.
+func (p *Player) GetGenesisDocument(ctx context.Context) (*genesis.Document, error) {
+	var doc genesis.Document
+	if err := p.replay(methodGetGenesisDocument, nil, &doc); err != nil {
+		return nil, err
+	}
+	return &doc, nil
+}
+
+// StateToGenesis implements oasis.Client.
+func (p *Player) StateToGenesis(ctx context.Context, height int64) (*genesis.Document, error) {
+	var doc genesis.Document
+	if err := p.replay(methodStateToGenesis, heightRequest{height}, &doc); err != nil {
+		return nil, err
+	}
+	return &doc, nil
+}
+
+var (
+	_ oasis.Client = (*Recorder)(nil)
+	_ oasis.Client = (*Player)(nil)
+)
@@ -0,0 +1,188 @@
+package oasis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/oasisprotocol/oasis-core-rosetta-gateway/common"
+)
+
+const (
+	// RetryMaxAttemptsEnvVar is the name of the environment variable that
+	// specifies the maximum number of attempts of a read call to the node
+	// (1 disables retries).
+	RetryMaxAttemptsEnvVar = "OASIS_ROSETTA_GATEWAY_NODE_RETRY_MAX_ATTEMPTS"
+	// RetryInitialBackoffEnvVar is the name of the environment variable that
+	// specifies the backoff before the first retry, as a Go duration.  It
+	// doubles with each retry, and the actual backoff is a random duration
+	// up to it.
+	RetryInitialBackoffEnvVar = "OASIS_ROSETTA_GATEWAY_NODE_RETRY_INITIAL_BACKOFF"
+	// RetryMaxBackoffEnvVar is the name of the environment variable that
+	// specifies the maximum backoff between retries, as a Go duration.
+	RetryMaxBackoffEnvVar = "OASIS_ROSETTA_GATEWAY_NODE_RETRY_MAX_BACKOFF"
+	// RetryCodesEnvVar is the name of the environment variable that specifies
+	// the comma-separated gRPC status codes (e.g. Unavailable) of the failed
+	// calls that are retried.
+	RetryCodesEnvVar = "OASIS_ROSETTA_GATEWAY_NODE_RETRY_CODES"
+
+	defaultRetryMaxAttempts    = 3
+	defaultRetryInitialBackoff = 100 * time.Millisecond
+	defaultRetryMaxBackoff     = 2 * time.Second
+)
+
+var defaultRetryCodes = []codes.Code{codes.Unavailable, codes.DeadlineExceeded}
+
+type idempotentContextKey struct{}
+
+// idempotent returns a copy of the given context that marks the calls made
+// with it as idempotent, so that they are retried on transient failures.
+// Calls that change state (i.e. submits) must never be made with it.
+func idempotent(ctx context.Context) context.Context {
+	return context.WithValue(ctx, idempotentContextKey{}, true)
+}
+
+func isIdempotent(ctx context.Context) bool {
+	idempotent, _ := ctx.Value(idempotentContextKey{}).(bool)
+	return idempotent
+}
+
+// retryPolicy retries idempotent gRPC calls to the node that fail with one of
+// the retryable status codes, with a jittered exponential backoff.
+type retryPolicy struct {
+	maxAttempts    int
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+	codes          map[codes.Code]bool
+}
+
+// newRetryPolicy creates a new retry policy.
+func newRetryPolicy(
+	maxAttempts int,
+	initialBackoff, maxBackoff time.Duration,
+	retryCodes []codes.Code,
+) *retryPolicy {
+	rp := &retryPolicy{
+		maxAttempts:    maxAttempts,
+		initialBackoff: initialBackoff,
+		maxBackoff:     maxBackoff,
+		codes:          make(map[codes.Code]bool),
+	}
+	for _, code := range retryCodes {
+		rp.codes[code] = true
+	}
+	return rp
+}
+
+// newRetryPolicyFromEnv creates a new retry policy configured by the
+// environment variables (or the defaults).
+func newRetryPolicyFromEnv() (*retryPolicy, error) {
+	maxAttempts, err := intFromEnv(RetryMaxAttemptsEnvVar, defaultRetryMaxAttempts, 1)
+	if err != nil {
+		return nil, err
+	}
+	initialBackoff, err := durationFromEnv(RetryInitialBackoffEnvVar, defaultRetryInitialBackoff)
+	if err != nil {
+		return nil, err
+	}
+	maxBackoff, err := durationFromEnv(RetryMaxBackoffEnvVar, defaultRetryMaxBackoff)
+	if err != nil {
+		return nil, err
+	}
+	retryCodes := defaultRetryCodes
+	if raw := os.Getenv(RetryCodesEnvVar); raw != "" {
+		if retryCodes, err = parseCodes(raw); err != nil {
+			return nil, fmt.Errorf("malformed %s environment variable: %w", RetryCodesEnvVar, err)
+		}
+	}
+	return newRetryPolicy(maxAttempts, initialBackoff, maxBackoff, retryCodes), nil
+}
+
+// parseCodes parses comma-separated gRPC status code names, ignoring case.
+func parseCodes(raw string) ([]codes.Code, error) {
+	var parsed []codes.Code
+NAMES:
+	for _, name := range strings.Split(raw, ",") {
+		name = strings.TrimSpace(name)
+		for code := codes.OK; code <= codes.Unauthenticated; code++ {
+			if strings.EqualFold(code.String(), name) {
+				parsed = append(parsed, code)
+				continue NAMES
+			}
+		}
+		return nil, fmt.Errorf("unknown status code: %s", name)
+	}
+	return parsed, nil
+}
+
+// retryable returns true if the given error of a failed call is transient.
+// Calls that timed out count as failing with DeadlineExceeded, while calls
+// that were shed are not retried, so as not to add to the node's load.
+func (rp *retryPolicy) retryable(err error) bool {
+	switch {
+	case errors.Is(err, ErrOverloaded):
+		return false
+	case errors.Is(err, ErrCallTimeout):
+		return rp.codes[codes.DeadlineExceeded]
+	default:
+		return rp.codes[status.Code(err)]
+	}
+}
+
+// backoff returns the jittered backoff before the given retry (starting at 1).
+func (rp *retryPolicy) backoff(retry int) time.Duration {
+	backoff := rp.initialBackoff
+	for i := 1; i < retry && backoff < rp.maxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > rp.maxBackoff {
+		backoff = rp.maxBackoff
+	}
+	if backoff <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(backoff) + 1)) // nolint: gosec
+}
+
+// intercept is a gRPC unary client interceptor that retries idempotent calls
+// according to the policy.
+func (rp *retryPolicy) intercept(
+	ctx context.Context,
+	method string,
+	req, reply interface{},
+	cc *grpc.ClientConn,
+	invoker grpc.UnaryInvoker,
+	opts ...grpc.CallOption,
+) error {
+	err := invoker(ctx, method, req, reply, cc, opts...)
+	if !isIdempotent(ctx) {
+		return err
+	}
+	for attempt := 1; err != nil && attempt < rp.maxAttempts && rp.retryable(err); attempt++ {
+		backoff := rp.backoff(attempt)
+		common.LoggerWithRequestID(ctx, logger).Debug("retrying gRPC call",
+			"method", method,
+			"attempt", attempt+1,
+			"backoff", backoff,
+			"err", err,
+		)
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		}
+		err = invoker(ctx, method, req, reply, cc, opts...)
+	}
+	return err
+}
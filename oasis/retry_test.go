@@ -0,0 +1,81 @@
+package oasis
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestRetryPolicy(t *testing.T) {
+	rp := newRetryPolicy(3, time.Millisecond, 2*time.Millisecond, []codes.Code{codes.Unavailable})
+
+	var calls int
+	failingInvoker := func(errs ...error) grpc.UnaryInvoker {
+		calls = 0
+		return func(
+			ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption,
+		) error {
+			calls++
+			if calls <= len(errs) {
+				return errs[calls-1]
+			}
+			return nil
+		}
+	}
+	unavailable := status.Error(codes.Unavailable, "connection refused")
+
+	for _, tc := range []struct {
+		name          string
+		ctx           context.Context
+		errs          []error
+		expectedCalls int
+		expectedErr   bool
+	}{
+		{"success", idempotent(context.Background()), nil, 1, false},
+		{"transient failure", idempotent(context.Background()), []error{unavailable, unavailable}, 3, false},
+		{"persistent failure", idempotent(context.Background()), []error{unavailable, unavailable, unavailable}, 3, true},
+		{"non-retryable failure", idempotent(context.Background()), []error{status.Error(codes.NotFound, "")}, 1, true},
+		{"timeout", idempotent(context.Background()), []error{fmt.Errorf("%w: test", ErrCallTimeout)}, 1, true},
+		{"shed", idempotent(context.Background()), []error{ErrOverloaded}, 1, true},
+		{"not idempotent", context.Background(), []error{unavailable}, 1, true},
+	} {
+		err := rp.intercept(tc.ctx, "test", nil, nil, nil, failingInvoker(tc.errs...))
+		if calls != tc.expectedCalls {
+			t.Fatalf("%s: expected %d calls, got %d", tc.name, tc.expectedCalls, calls)
+		}
+		if (err != nil) != tc.expectedErr {
+			t.Fatalf("%s: unexpected error: %v", tc.name, err)
+		}
+	}
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	rp := newRetryPolicy(10, 100*time.Millisecond, time.Second, nil)
+	for retry, max := range []time.Duration{
+		100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond, 800 * time.Millisecond, time.Second,
+	} {
+		if backoff := rp.backoff(retry + 1); backoff < 0 || backoff > max {
+			t.Fatalf("backoff before retry %d out of range: %s", retry+1, backoff)
+		}
+	}
+}
+
+func TestParseCodes(t *testing.T) {
+	parsed, err := parseCodes("Unavailable, deadlineexceeded,RESOURCE_EXHAUSTED")
+	if err == nil {
+		t.Fatalf("expected an unknown code to fail, got %v", parsed)
+	}
+	parsed, err = parseCodes("Unavailable, deadlineexceeded,ResourceExhausted")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(parsed) != 3 || parsed[0] != codes.Unavailable || parsed[1] != codes.DeadlineExceeded ||
+		parsed[2] != codes.ResourceExhausted {
+		t.Fatalf("unexpected codes: %v", parsed)
+	}
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/coinbase/rosetta-sdk-go/server"
+	"github.com/coinbase/rosetta-sdk-go/types"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/oasisprotocol/oasis-core-rosetta-gateway/services"
+)
+
+const (
+	// budgetDefault is the name of the rate limit budget of all requests
+	// except for the expensive ones.
+	budgetDefault = "default"
+	// budgetExpensive is the name of the rate limit budget of requests to
+	// expensive endpoints.
+	budgetExpensive = "expensive"
+
+	// rateLimiterIdleTimeout is the duration after which the buckets of a
+	// client without requests are removed (they are full by then anyway).
+	rateLimiterIdleTimeout = 10 * time.Minute
+)
+
+var (
+	rateLimitRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "oasis_rosetta_gateway_rate_limit_requests",
+			Help: "Number of requests checked by the rate limiter.",
+		},
+		[]string{"budget", "result"},
+	)
+	rateLimitClients = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "oasis_rosetta_gateway_rate_limit_clients",
+			Help: "Number of clients tracked by the rate limiter.",
+		},
+	)
+
+	rateLimitCollectors = []prometheus.Collector{
+		rateLimitRequests,
+		rateLimitClients,
+	}
+
+	rateLimitMetricsOnce sync.Once
+)
+
+// rateLimit is the rate limit of one budget.
+type rateLimit struct {
+	// Rate is the number of requests per second.
+	Rate float64
+	// Burst is the maximum number of requests at once.
+	Burst int
+}
+
+// tokenBucket is the token bucket of a client's budget.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// take takes a token from the bucket if there is one, refilling it at the
+// given rate first.  If there is none, it returns how long it will take until
+// there is one.
+func (b *tokenBucket) take(now time.Time, limit rateLimit) (bool, time.Duration) {
+	b.tokens = math.Min(float64(limit.Burst), b.tokens+now.Sub(b.last).Seconds()*limit.Rate)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - b.tokens) / limit.Rate * float64(time.Second))
+}
+
+type bucketKey struct {
+	client string
+	budget string
+}
+
+// rateLimiter limits the rate of requests of each client (identified by its
+// authenticated identity or its IP) with a token bucket per budget.
+type rateLimiter struct {
+	sync.Mutex
+
+	// limits are the rate limits of the budgets.  Expensive requests are
+	// counted against the default budget if it has no limit of its own.
+	limits map[string]rateLimit
+
+	buckets   map[bucketKey]*tokenBucket
+	lastPrune time.Time
+	now       func() time.Time
+}
+
+// newRateLimiter creates a new rate limiter with the given budgets.
+func newRateLimiter(limit rateLimit, expensiveLimit *rateLimit) *rateLimiter {
+	rateLimitMetricsOnce.Do(func() {
+		prometheus.MustRegister(rateLimitCollectors...)
+	})
+
+	limits := map[string]rateLimit{
+		budgetDefault: limit,
+	}
+	if expensiveLimit != nil {
+		limits[budgetExpensive] = *expensiveLimit
+	}
+	return &rateLimiter{
+		limits:  limits,
+		buckets: make(map[bucketKey]*tokenBucket),
+		now:     time.Now,
+	}
+}
+
+// allow takes a token from the given client's bucket of the given budget. If
+// there is none, it returns how long the client should wait before retrying.
+func (rl *rateLimiter) allow(client, budget string) (bool, time.Duration) {
+	limit, ok := rl.limits[budget]
+	if !ok {
+		budget = budgetDefault
+		limit = rl.limits[budget]
+	}
+
+	rl.Lock()
+	defer rl.Unlock()
+
+	now := rl.now()
+	if now.Sub(rl.lastPrune) > rateLimiterIdleTimeout {
+		for key, b := range rl.buckets {
+			if now.Sub(b.last) > rateLimiterIdleTimeout {
+				delete(rl.buckets, key)
+			}
+		}
+		rl.lastPrune = now
+	}
+
+	key := bucketKey{client, budget}
+	b, ok := rl.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: float64(limit.Burst), last: now}
+		rl.buckets[key] = b
+	}
+	allowed, wait := b.take(now, limit)
+	rateLimitClients.Set(float64(len(rl.buckets)))
+
+	result := "allowed"
+	if !allowed {
+		result = "limited"
+	}
+	rateLimitRequests.With(prometheus.Labels{"budget": budget, "result": result}).Inc()
+
+	return allowed, wait
+}
+
+// wrap returns a handler that passes requests within the rate limits on to
+// the given handler and rejects the others with a retriable
+// services.ErrRateLimited.
+func (rl *rateLimiter) wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		budget := budgetDefault
+		if isExpensiveRequest(w, r) {
+			budget = budgetExpensive
+		}
+		allowed, wait := rl.allow(rl.client(r), budget)
+		if !allowed {
+			retryAfter := int(math.Ceil(wait.Seconds()))
+			w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+			terr := *services.ErrRateLimited
+			terr.Details = map[string]interface{}{
+				"budget":      budget,
+				"retry_after": retryAfter,
+			}
+			// Rosetta clients only decode errors of responses with this status.
+			server.EncodeJSONResponse(&terr, http.StatusInternalServerError, w)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// client returns the key that identifies the client of the given request:
+// the identity of the client authenticated by the authentication middleware
+// (which must run first) or else its IP.
+func (rl *rateLimiter) client(r *http.Request) string {
+	if identity := clientIdentityFromContext(r.Context()); identity != "" {
+		return identity
+	}
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+	return "ip:" + ip
+}
+
+// isExpensiveRequest returns true if the given request is for an expensive
+// endpoint: /block, which does several queries per block, /account/balance
+// of an escrow account, which queries all of the account's delegations, or
+// the account_balances_batch /call method, which queries many accounts.
+func isExpensiveRequest(w http.ResponseWriter, r *http.Request) bool {
+	switch r.URL.Path {
+	case "/block":
+		return true
+	case "/account/balance":
+		var request types.AccountBalanceRequest
+		if err := json.Unmarshal(peekRequestBody(w, r), &request); err != nil {
+			return false
+		}
+		ai := request.AccountIdentifier
+		return ai != nil && ai.SubAccount != nil && ai.SubAccount.Address == services.SubAccountEscrow
+	case "/call":
+		var request types.CallRequest
+		if err := json.Unmarshal(peekRequestBody(w, r), &request); err != nil {
+			return false
+		}
+		return request.Method == services.CallMethodAccountBalancesBatch
+	default:
+		return false
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coinbase/rosetta-sdk-go/types"
+
+	"github.com/oasisprotocol/oasis-core-rosetta-gateway/services"
+)
+
+func TestRateLimiter(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	rl := newRateLimiter(rateLimit{Rate: 2, Burst: 3}, &rateLimit{Rate: 1, Burst: 1})
+	rl.now = func() time.Time { return now }
+	handler := rl.wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	serve := func(path, body, remoteAddr, identity string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		req.RemoteAddr = remoteAddr
+		// Set by the authentication middleware for authenticated clients.
+		if identity != "" {
+			req = req.WithContext(withClientIdentity(req.Context(), identity))
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+	requireLimited := func(rec *httptest.ResponseRecorder, limited bool) {
+		t.Helper()
+		if !limited {
+			if rec.Code != http.StatusOK {
+				t.Fatalf("unexpected status: %d", rec.Code)
+			}
+			return
+		}
+		var terr types.Error
+		if err := json.Unmarshal(rec.Body.Bytes(), &terr); err != nil {
+			t.Fatalf("malformed error: %v", err)
+		}
+		if rec.Code != http.StatusInternalServerError || terr.Code != services.ErrRateLimited.Code || !terr.Retriable {
+			t.Fatalf("expected a rate limit error, got %d: %s", rec.Code, rec.Body.String())
+		}
+		if rec.Header().Get("Retry-After") == "" {
+			t.Fatalf("missing Retry-After header")
+		}
+	}
+
+	// The burst of the default budget.
+	for i := 0; i < 3; i++ {
+		requireLimited(serve("/network/status", "{}", "10.0.0.1:1234", ""), false)
+	}
+	requireLimited(serve("/network/status", "{}", "10.0.0.1:1234", ""), true)
+
+	// Other clients and budgets are limited separately.
+	requireLimited(serve("/network/status", "{}", "10.0.0.2:1234", ""), false)
+	requireLimited(serve("/network/status", "{}", "10.0.0.1:1234", "token:client"), false)
+	requireLimited(serve("/block", "{}", "10.0.0.1:1234", ""), false)
+	requireLimited(serve("/block", "{}", "10.0.0.1:1234", ""), true)
+	escrow := `{"account_identifier":{"address":"a","sub_account":{"address":"escrow"}}}`
+	requireLimited(serve("/account/balance", escrow, "10.0.0.3:1234", ""), false)
+	requireLimited(serve("/account/balance", escrow, "10.0.0.3:1234", ""), true)
+	requireLimited(serve("/account/balance", `{"account_identifier":{"address":"a"}}`, "10.0.0.3:1234", ""), false)
+	batch := `{"method":"account_balances_batch","parameters":{}}`
+	requireLimited(serve("/call", batch, "10.0.0.4:1234", ""), false)
+	requireLimited(serve("/call", batch, "10.0.0.4:1234", ""), true)
+	requireLimited(serve("/call", `{"method":"account_balances","parameters":{}}`, "10.0.0.4:1234", ""), false)
+
+	// Buckets refill over time.
+	now = now.Add(time.Second)
+	requireLimited(serve("/network/status", "{}", "10.0.0.1:1234", ""), false)
+	requireLimited(serve("/network/status", "{}", "10.0.0.1:1234", ""), false)
+	requireLimited(serve("/network/status", "{}", "10.0.0.1:1234", ""), true)
+
+	// Buckets of idle clients are removed.
+	now = now.Add(2 * rateLimiterIdleTimeout)
+	requireLimited(serve("/network/status", "{}", "10.0.0.1:1234", ""), false)
+	if len(rl.buckets) != 1 {
+		t.Fatalf("unexpected number of buckets: %d", len(rl.buckets))
+	}
+}
+
+func TestRateLimiterWithAuth(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "credentials")
+	if err := ioutil.WriteFile(path, []byte(testCredentials), 0o600); err != nil {
+		t.Fatalf("unable to write credentials: %v", err)
+	}
+	creds, err := loadCredentialsFile(path)
+	if err != nil {
+		t.Fatalf("unable to load credentials: %v", err)
+	}
+	am := &authMiddleware{authenticators: []authenticator{&bearerTokenAuthenticator{creds: creds}}}
+	rl := newRateLimiter(rateLimit{Rate: 1, Burst: 1}, nil)
+	rl.now = func() time.Time { return time.Unix(1600000000, 0) }
+	handler := am.wrap(rl.wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})))
+
+	serve := func(token, remoteAddr string) int {
+		req := httptest.NewRequest(http.MethodPost, "/block", strings.NewReader("{}"))
+		req.RemoteAddr = remoteAddr
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		var terr types.Error
+		_ = json.Unmarshal(rec.Body.Bytes(), &terr)
+		return int(terr.Code)
+	}
+
+	// Clients are limited by their token, whatever their IP.
+	if code := serve("partner-token", "10.0.0.1:1234"); code != 0 {
+		t.Fatalf("unexpected error: %d", code)
+	}
+	if code := serve("partner-token", "10.0.0.2:1234"); code != int(services.ErrRateLimited.Code) {
+		t.Fatalf("expected a rate limit error, got %d", code)
+	}
+	if code := serve("internal-token", "10.0.0.1:1234"); code != 0 {
+		t.Fatalf("unexpected error: %d", code)
+	}
+
+	// Made-up tokens are rejected without taking up buckets.
+	for i := 0; i < 10; i++ {
+		if code := serve(fmt.Sprintf("made-up-%d", i), "10.0.0.3:1234"); code != int(services.ErrUnauthorized.Code) {
+			t.Fatalf("expected an authentication error, got %d", code)
+		}
+	}
+	if len(rl.buckets) != 2 {
+		t.Fatalf("unexpected number of buckets: %d", len(rl.buckets))
+	}
+}
@@ -1,15 +1,25 @@
 package services
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"fmt"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
 
 	"github.com/coinbase/rosetta-sdk-go/server"
 	"github.com/coinbase/rosetta-sdk-go/types"
 
+	beacon "github.com/oasisprotocol/oasis-core/go/beacon/api"
 	"github.com/oasisprotocol/oasis-core/go/common/logging"
+	"github.com/oasisprotocol/oasis-core/go/common/quantity"
 	staking "github.com/oasisprotocol/oasis-core/go/staking/api"
 
+	"github.com/oasisprotocol/oasis-core-rosetta-gateway/common"
+	"github.com/oasisprotocol/oasis-core-rosetta-gateway/history"
 	"github.com/oasisprotocol/oasis-core-rosetta-gateway/oasis"
 )
 
@@ -52,42 +62,396 @@ const DelegationsKey = "delegations"
 // call.
 const DebondingDelegationsKey = "debonding_delegations"
 
+// DelegationDetailsKey is the name of the key in the Metadata map inside
+// the response of an account balance request for an escrow account.
+// The value in the Metadata map is a list of DelegationDetails of the
+// account's active delegations, sorted by validator address.
+const DelegationDetailsKey = "delegation_details"
+
+// DebondingDelegationDetailsKey is the name of the key in the Metadata map
+// inside the response of an account balance request for an escrow account.
+// The value in the Metadata map is a list of DelegationDetails of the
+// account's debonding delegations, sorted by validator address and debond
+// end epoch.
+const DebondingDelegationDetailsKey = "debonding_delegation_details"
+
+// SharePriceDecimals is the number of decimal places of the share price in
+// DelegationDetails.
+const SharePriceDecimals = 18
+
+// DelegationDetails describes an active or debonding delegation in the
+// Metadata map inside the response of an account balance request for an
+// escrow account.
+type DelegationDetails struct {
+	// Validator is the address of the escrow account the delegation is to.
+	Validator string `json:"validator"`
+	// Shares is the number of shares of the delegation.
+	Shares string `json:"shares"`
+	// Amount is the value of the delegation's shares in base units.
+	Amount string `json:"amount"`
+	// SharePrice is the number of base units per share of the validator's
+	// active (or debonding) share pool, as a decimal number with
+	// SharePriceDecimals decimal places.
+	SharePrice string `json:"share_price"`
+	// DebondEndEpoch is the epoch at which the debonding delegation ends.
+	// Absent for active delegations.
+	DebondEndEpoch *uint64 `json:"debond_end_epoch,omitempty"`
+}
+
+// CoinIdentifierSeparator separates the parts of a coin identifier returned
+// by the /account/coins endpoint.
+const CoinIdentifierSeparator = ":"
+
+// CoinGeneral is the last part of the identifier of the coin representing
+// the general balance, i.e. "<address>:general".
+const CoinGeneral = "general"
+
+// CoinEscrowActive is the part of the identifier of a coin representing an
+// active delegation, i.e. "<address>:escrow:active:<validator_address>".
+const CoinEscrowActive = "active"
+
+// CoinEscrowDebonding is the part of the identifier of a coin representing a
+// debonding delegation, i.e.
+// "<address>:escrow:debonding:<validator_address>:<debond_end_epoch>".
+const CoinEscrowDebonding = "debonding"
+
 var loggerAcct = logging.GetLogger("services/account")
 
 type accountAPIService struct {
-	oasisClient oasis.Client
+	oasisClient    oasis.Client
+	balanceHistory *history.Store
+	mempool        *MempoolCache
 }
 
 // NewAccountAPIService creates a new instance of an AccountAPIService.
-func NewAccountAPIService(oasisClient oasis.Client) server.AccountAPIServicer {
+//
+// The balance history store is optional (nil to disable) and is used to
+// answer historical balance queries when the node no longer has state.
+func NewAccountAPIService(
+	oasisClient oasis.Client,
+	mempool *MempoolCache,
+	balanceHistory *history.Store,
+) server.AccountAPIServicer {
 	return &accountAPIService{
-		oasisClient: oasisClient,
+		oasisClient:    oasisClient,
+		balanceHistory: balanceHistory,
+		mempool:        mempool,
 	}
 }
 
-// AccountCoins implements the /account/coins endpoint. just forward this to /account/balance for now
-func (s *accountAPIService) AccountCoins(ctx context.Context,
+// AccountCoins implements the /account/coins endpoint.
+//
+// Each balance bucket of the account is returned as a separate coin: the
+// general balance, every active delegation and every debonding delegation,
+// with the delegations valued in base units at the returned block.
+func (s *accountAPIService) AccountCoins(
+	ctx context.Context,
 	request *types.AccountCoinsRequest,
 ) (*types.AccountCoinsResponse, *types.Error) {
-	account_balance_req := types.AccountBalanceRequest{
-		NetworkIdentifier: request.NetworkIdentifier,
-		AccountIdentifier: request.AccountIdentifier,
+	logger := common.LoggerWithRequestID(ctx, loggerAcct)
+
+	if err := ValidateNetworkIdentifier(ctx, s.oasisClient, request.NetworkIdentifier); err != nil {
+		logger.Error("AccountCoins: network validation failed", "err", err.Message)
+		return nil, err
+	}
+
+	if request.AccountIdentifier == nil || request.AccountIdentifier.Address == "" {
+		logger.Error("AccountCoins: invalid account address (empty)")
+		return nil, ErrInvalidAccountAddress
+	}
+
+	var owner staking.Address
+	if err := owner.UnmarshalText([]byte(request.AccountIdentifier.Address)); err != nil {
+		logger.Error("AccountCoins: invalid account address", "err", err)
+		return nil, ErrInvalidAccountAddress
+	}
+
+	subAccount := request.AccountIdentifier.SubAccount
+	if subAccount != nil && subAccount.Address != SubAccountEscrow {
+		logger.Error("AccountCoins: invalid subaccount", "sub_account", subAccount)
+		return nil, ErrMustSpecifySubAccount
+	}
+
+	// Resolve the latest height first, so that all state is queried at the
+	// same (concrete) height.
+	height, err := s.oasisClient.ResolveHeight(ctx, oasis.LatestHeight)
+	if err != nil {
+		logger.Error("AccountCoins: unable to get latest block", "err", err)
+		return nil, ErrUnableToGetLatestBlk
+	}
+	blk, err := s.oasisClient.GetBlockHeader(ctx, height)
+	if err != nil {
+		logger.Error("AccountCoins: unable to get block",
+			"height", height,
+			"err", err,
+		)
+		return nil, ErrUnableToGetBlk
+	}
+
+	resp := &types.AccountCoinsResponse{
+		BlockIdentifier: &types.BlockIdentifier{
+			Index: blk.Height,
+			Hash:  blk.Hash,
+		},
+		Coins: []*types.Coin{},
 	}
-	rose_balance, err := s.AccountBalance(ctx, &account_balance_req)
+	if !hasOasisCurrency(request.Currencies) {
+		return resp, nil
+	}
+
+	ownerStr := StringFromAddress(owner)
+	if subAccount == nil {
+		act, err2 := s.oasisClient.GetAccount(ctx, height, owner)
+		if err2 != nil {
+			logger.Error("AccountCoins: unable to get account",
+				"account_address", owner.String(),
+				"height", height,
+				"err", err2,
+			)
+			return nil, ErrUnableToGetAccount
+		}
+
+		balance := act.General.Balance.Clone()
+		if request.IncludeMempool {
+			var pending *quantity.Quantity
+			if pending, err = s.getPendingOutgoing(ctx, ownerStr); err != nil {
+				logger.Error("AccountCoins: unable to get pending transactions",
+					"account_address", owner.String(),
+					"err", err,
+				)
+				return nil, ErrUnableToGetTxns
+			}
+			if _, err = balance.SubUpTo(pending); err != nil {
+				logger.Error("AccountCoins: unable to subtract pending amount",
+					"account_address", owner.String(),
+					"err", err,
+				)
+				return nil, ErrMalformedValue
+			}
+		}
+
+		resp.Coins = append(resp.Coins, newCoin(balance, ownerStr, CoinGeneral))
+	}
+
+	active, debonding, err := s.getDelegationInfos(ctx, height, owner)
+	if err != nil {
+		logger.Error("AccountCoins: unable to get delegations",
+			"account_address", owner.String(),
+			"height", height,
+			"err", err,
+		)
+		return nil, ErrUnableToGetAccount
+	}
+	for _, di := range active {
+		resp.Coins = append(resp.Coins, newCoin(
+			&di.Amount, ownerStr, SubAccountEscrow, CoinEscrowActive, StringFromAddress(di.Validator),
+		))
+	}
+	for _, di := range debonding {
+		resp.Coins = append(resp.Coins, newCoin(
+			&di.Amount, ownerStr, SubAccountEscrow, CoinEscrowDebonding, StringFromAddress(di.Validator),
+			strconv.FormatUint(uint64(di.DebondEndTime), 10),
+		))
+	}
+
+	if debugEnabled() {
+		jsonResp, _ := json.Marshal(resp)
+		logger.Debug("AccountCoins OK",
+			"response", jsonResp,
+			"account_id", owner.String(),
+			"sub_account", subAccount,
+		)
+	}
+
+	return resp, nil
+}
+
+// newCoin returns a ROSE coin with the given amount and an identifier joined
+// from the given parts.
+func newCoin(amount *quantity.Quantity, idParts ...string) *types.Coin {
+	return &types.Coin{
+		CoinIdentifier: &types.CoinIdentifier{
+			Identifier: strings.Join(idParts, CoinIdentifierSeparator),
+		},
+		Amount: &types.Amount{
+			Value:    amount.String(),
+			Currency: OasisCurrency,
+		},
+	}
+}
+
+// hasOasisCurrency returns true if the given currency filter is empty or
+// includes ROSE.
+func hasOasisCurrency(currencies []*types.Currency) bool {
+	if len(currencies) == 0 {
+		return true
+	}
+	for _, c := range currencies {
+		if c != nil && c.Symbol == OasisCurrency.Symbol && c.Decimals == OasisCurrency.Decimals {
+			return true
+		}
+	}
+	return false
+}
+
+// getPendingOutgoing returns the total amount that unconfirmed transactions
+// in the mempool will debit from the given address's general account.
+func (s *accountAPIService) getPendingOutgoing(ctx context.Context, addr string) (*quantity.Quantity, error) {
+	ms, err := s.mempool.Snapshot(ctx)
 	if err != nil {
 		return nil, err
 	}
-	res := types.AccountCoinsResponse{
-		BlockIdentifier: rose_balance.BlockIdentifier,
-		Coins: []*types.Coin{&types.Coin{
-			CoinIdentifier: &types.CoinIdentifier{Identifier: OasisCurrency.Symbol},
-			Amount: &types.Amount{
-				Value:    rose_balance.Balances[0].Value,
-				Currency: OasisCurrency,
-			},
-		}},
+
+	return generalDebit(ms.Transactions(), addr)
+}
+
+// delegationInfo is an active or debonding delegation together with its value
+// in base units.
+type delegationInfo struct {
+	Validator     staking.Address
+	Shares        quantity.Quantity
+	Amount        quantity.Quantity
+	DebondEndTime beacon.EpochTime
+
+	// Pool is the validator's share pool the delegation belongs to.
+	Pool staking.SharePool
+}
+
+// Details returns the details of the delegation for inclusion in an account
+// balance response's Metadata map.
+func (di *delegationInfo) Details(debonding bool) *DelegationDetails {
+	sharePrice := new(big.Rat)
+	if !di.Pool.TotalShares.IsZero() {
+		sharePrice.SetFrac(di.Pool.Balance.ToBigInt(), di.Pool.TotalShares.ToBigInt())
+	}
+
+	dd := &DelegationDetails{
+		Validator:  StringFromAddress(di.Validator),
+		Shares:     di.Shares.String(),
+		Amount:     di.Amount.String(),
+		SharePrice: sharePrice.FloatString(SharePriceDecimals),
+	}
+	if debonding {
+		debondEndEpoch := uint64(di.DebondEndTime)
+		dd.DebondEndEpoch = &debondEndEpoch
+	}
+	return dd
+}
+
+// getDelegationDetails returns the details of the given delegations.
+func getDelegationDetails(dis []*delegationInfo, debonding bool) []*DelegationDetails {
+	details := make([]*DelegationDetails, 0, len(dis))
+	for _, di := range dis {
+		details = append(details, di.Details(debonding))
+	}
+	return details
+}
+
+// getDelegationInfos returns the given owner's active and debonding
+// delegations, valued using the validators' share pools at the given height.
+//
+// Both lists are sorted by validator address, and debonding delegations also
+// by their debond end time.
+func (s *accountAPIService) getDelegationInfos(
+	ctx context.Context,
+	height int64,
+	owner staking.Address,
+) ([]*delegationInfo, []*delegationInfo, error) {
+	delegations, err := s.oasisClient.GetDelegations(ctx, height, owner)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to get delegations: %w", err)
+	}
+	debondingDelegations, err := s.oasisClient.GetDebondingDelegations(ctx, height, owner)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to get debonding delegations: %w", err)
+	}
+	return s.valueDelegations(ctx, height, delegations, debondingDelegations)
+}
+
+// valueDelegations values the given active and debonding delegations using
+// the validators' share pools at the given height, sorted like
+// getDelegationInfos.
+func (s *accountAPIService) valueDelegations(
+	ctx context.Context,
+	height int64,
+	delegations map[staking.Address]*staking.Delegation,
+	debondingDelegations map[staking.Address][]*staking.DebondingDelegation,
+) ([]*delegationInfo, []*delegationInfo, error) {
+	var err error
+	validators := make(map[staking.Address]*staking.Account)
+	getValidator := func(addr staking.Address) (*staking.Account, error) {
+		if act, ok := validators[addr]; ok {
+			return act, nil
+		}
+		act, err2 := s.oasisClient.GetAccount(ctx, height, addr)
+		if err2 != nil {
+			return nil, fmt.Errorf("unable to get validator account %s: %w", addr, err2)
+		}
+		validators[addr] = act
+		return act, nil
+	}
+
+	active := make([]*delegationInfo, 0, len(delegations))
+	for validator, d := range delegations {
+		act, err2 := getValidator(validator)
+		if err2 != nil {
+			return nil, nil, err2
+		}
+		amount, err2 := act.Escrow.Active.StakeForShares(&d.Shares)
+		if err2 != nil {
+			return nil, nil, fmt.Errorf("unable to compute active delegation value: %w", err2)
+		}
+		active = append(active, &delegationInfo{
+			Validator: validator,
+			Shares:    d.Shares,
+			Amount:    *amount,
+			Pool:      act.Escrow.Active,
+		})
+	}
+	sort.Slice(active, func(i, j int) bool {
+		return bytes.Compare(active[i].Validator[:], active[j].Validator[:]) < 0
+	})
+
+	var debonding []*delegationInfo
+	for validator, dds := range debondingDelegations {
+		act, err2 := getValidator(validator)
+		if err2 != nil {
+			return nil, nil, err2
+		}
+
+		// Merge debonding delegations ending at the same epoch.
+		byEnd := make(map[beacon.EpochTime]*delegationInfo)
+		for _, dd := range dds {
+			di, ok := byEnd[dd.DebondEndTime]
+			if !ok {
+				di = &delegationInfo{
+					Validator:     validator,
+					DebondEndTime: dd.DebondEndTime,
+					Pool:          act.Escrow.Debonding,
+				}
+				byEnd[dd.DebondEndTime] = di
+				debonding = append(debonding, di)
+			}
+			if err = di.Shares.Add(&dd.Shares); err != nil {
+				return nil, nil, fmt.Errorf("unable to merge debonding delegations: %w", err)
+			}
+		}
+		for _, di := range byEnd {
+			amount, err2 := act.Escrow.Debonding.StakeForShares(&di.Shares)
+			if err2 != nil {
+				return nil, nil, fmt.Errorf("unable to compute debonding delegation value: %w", err2)
+			}
+			di.Amount = *amount
+		}
 	}
-	return &res, nil
+	sort.Slice(debonding, func(i, j int) bool {
+		if c := bytes.Compare(debonding[i].Validator[:], debonding[j].Validator[:]); c != 0 {
+			return c < 0
+		}
+		return debonding[i].DebondEndTime < debonding[j].DebondEndTime
+	})
+
+	return active, debonding, nil
 }
 
 // AccountBalance implements the /account/balance endpoint.
@@ -95,8 +459,10 @@ func (s *accountAPIService) AccountBalance(
 	ctx context.Context,
 	request *types.AccountBalanceRequest,
 ) (*types.AccountBalanceResponse, *types.Error) {
+	logger := common.LoggerWithRequestID(ctx, loggerAcct)
+
 	if err := ValidateNetworkIdentifier(ctx, s.oasisClient, request.NetworkIdentifier); err != nil {
-		loggerAcct.Error("AccountBalance: network validation failed", "err", err.Message)
+		logger.Error("AccountBalance: network validation failed", "err", err.Message)
 		return nil, err
 	}
 
@@ -105,31 +471,46 @@ func (s *accountAPIService) AccountBalance(
 		if request.BlockIdentifier.Index != nil {
 			height = *request.BlockIdentifier.Index
 		} else if request.BlockIdentifier.Hash != nil {
-			loggerAcct.Error("AccountBalance: must query block by index")
+			logger.Error("AccountBalance: must query block by index")
 			return nil, ErrMustQueryByIndex
 		}
 	}
 
 	if request.AccountIdentifier.Address == "" {
-		loggerAcct.Error("AccountBalance: invalid account address (empty)")
+		logger.Error("AccountBalance: invalid account address (empty)")
 		return nil, ErrInvalidAccountAddress
 	}
 
 	var owner staking.Address
 	if err := owner.UnmarshalText([]byte(request.AccountIdentifier.Address)); err != nil {
-		loggerAcct.Error("AccountBalance: invalid account address", "err", err)
+		logger.Error("AccountBalance: invalid account address", "err", err)
 		return nil, ErrInvalidAccountAddress
 	}
 
 	if request.AccountIdentifier.SubAccount != nil &&
 		request.AccountIdentifier.SubAccount.Address != SubAccountEscrow {
-		loggerAcct.Error("AccountBalance: invalid subaccount", "sub_account", request.AccountIdentifier.SubAccount)
+		logger.Error("AccountBalance: invalid subaccount", "sub_account", request.AccountIdentifier.SubAccount)
 		return nil, ErrMustSpecifySubAccount
 	}
 
+	// Resolve the latest height first, so that the account and the block are
+	// queried at the same (concrete) height even if a new block is committed
+	// in between.
+	latest := height == oasis.LatestHeight
+	height, err := s.oasisClient.ResolveHeight(ctx, height)
+	if err != nil {
+		logger.Error("AccountBalance: unable to get latest block", "err", err)
+		return nil, ErrUnableToGetLatestBlk
+	}
+
 	act, err := s.oasisClient.GetAccount(ctx, height, owner)
 	if err != nil {
-		loggerAcct.Error("AccountBalance: unable to get account",
+		if s.balanceHistory != nil && !latest && isStateNotAvailable(err) {
+			// The node no longer has state at this height, so try to answer
+			// the query from the balance history.
+			return s.historicalAccountBalance(ctx, request.AccountIdentifier, height)
+		}
+		logger.Error("AccountBalance: unable to get account",
 			"account_address", owner.String(),
 			"height", height,
 			"err", err,
@@ -137,9 +518,9 @@ func (s *accountAPIService) AccountBalance(
 		return nil, ErrUnableToGetAccount
 	}
 
-	blk, err := s.oasisClient.GetBlock(ctx, height)
+	blk, err := s.oasisClient.GetBlockHeader(ctx, height)
 	if err != nil {
-		loggerAcct.Error("AccountBalance: unable to get block",
+		logger.Error("AccountBalance: unable to get block",
 			"height", height,
 			"err", err,
 		)
@@ -157,7 +538,7 @@ func (s *accountAPIService) AccountBalance(
 		// Total is Active + Debonding.
 		total := act.Escrow.Active.Balance.Clone()
 		if err := total.Add(&act.Escrow.Debonding.Balance); err != nil {
-			loggerAcct.Error("AccountBalance: escrow: unable to add debonding to active",
+			logger.Error("AccountBalance: escrow: unable to add debonding to active",
 				"account_id", owner.String(),
 				"height", height,
 				"escrow_active_balance", act.Escrow.Active.Balance.String(),
@@ -175,7 +556,7 @@ func (s *accountAPIService) AccountBalance(
 
 		delegations, err := s.oasisClient.GetDelegations(ctx, height, owner)
 		if err != nil {
-			loggerAcct.Error("AccountBalance: unable to get delegations",
+			logger.Error("AccountBalance: unable to get delegations",
 				"account_id", owner.String(),
 				"height", height,
 				"err", err,
@@ -185,7 +566,7 @@ func (s *accountAPIService) AccountBalance(
 		md[DelegationsKey] = delegations
 		debondingDelegations, err := s.oasisClient.GetDebondingDelegations(ctx, height, owner)
 		if err != nil {
-			loggerAcct.Error("AccountBalance: unable to get debonding delegations",
+			logger.Error("AccountBalance: unable to get debonding delegations",
 				"account_id", owner.String(),
 				"height", height,
 				"err", err,
@@ -193,6 +574,18 @@ func (s *accountAPIService) AccountBalance(
 			return nil, ErrUnableToGetAccount
 		}
 		md[DebondingDelegationsKey] = debondingDelegations
+
+		active, debonding, err := s.valueDelegations(ctx, height, delegations, debondingDelegations)
+		if err != nil {
+			logger.Error("AccountBalance: unable to value delegations",
+				"account_id", owner.String(),
+				"height", height,
+				"err", err,
+			)
+			return nil, ErrUnableToGetAccount
+		}
+		md[DelegationDetailsKey] = getDelegationDetails(active, false)
+		md[DebondingDelegationDetailsKey] = getDelegationDetails(debonding, true)
 	}
 
 	resp := &types.AccountBalanceResponse{
@@ -209,12 +602,64 @@ func (s *accountAPIService) AccountBalance(
 		Metadata: md,
 	}
 
-	jsonResp, _ := json.Marshal(resp)
-	loggerAcct.Debug("AccountBalance OK",
-		"response", jsonResp,
-		"account_id", owner.String(),
-		"sub_account", request.AccountIdentifier.SubAccount,
+	if debugEnabled() {
+		jsonResp, _ := json.Marshal(resp)
+		logger.Debug("AccountBalance OK",
+			"response", jsonResp,
+			"account_id", owner.String(),
+			"sub_account", request.AccountIdentifier.SubAccount,
+		)
+	}
+
+	return resp, nil
+}
+
+// historicalAccountBalance returns the balance of the given account at the
+// given height from the balance history store.
+func (s *accountAPIService) historicalAccountBalance(
+	ctx context.Context,
+	account *types.AccountIdentifier,
+	height int64,
+) (*types.AccountBalanceResponse, *types.Error) {
+	logger := common.LoggerWithRequestID(ctx, loggerAcct)
+
+	balance, blk, err := s.balanceHistory.Balance(
+		balanceHistoryKey(account.Address, account.SubAccount), height,
 	)
+	if err != nil {
+		logger.Error("AccountBalance: unable to get historical balance",
+			"account_address", account.Address,
+			"sub_account", account.SubAccount,
+			"height", height,
+			"err", err,
+		)
+		return nil, ErrUnableToGetAccount
+	}
+
+	resp := &types.AccountBalanceResponse{
+		BlockIdentifier: &types.BlockIdentifier{
+			Index: blk.Height,
+			Hash:  blk.Hash,
+		},
+		Balances: []*types.Amount{
+			{
+				Value:    balance.String(),
+				Currency: OasisCurrency,
+			},
+		},
+		Metadata: map[string]interface{}{
+			HistoricalBalanceKey: true,
+		},
+	}
+
+	if debugEnabled() {
+		jsonResp, _ := json.Marshal(resp)
+		logger.Debug("AccountBalance OK (historical)",
+			"response", jsonResp,
+			"account_id", account.Address,
+			"sub_account", account.SubAccount,
+		)
+	}
 
 	return resp, nil
 }
@@ -0,0 +1,244 @@
+package services
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/coinbase/rosetta-sdk-go/types"
+	staking "github.com/oasisprotocol/oasis-core/go/staking/api"
+
+	"github.com/oasisprotocol/oasis-core-rosetta-gateway/oasis/mock"
+)
+
+func TestAccountBalance(t *testing.T) {
+	ctx := context.Background()
+	oc := newTestClient()
+	s := NewAccountAPIService(oc, NewMempoolCache(oc), nil)
+
+	if err := oc.SubmitTxNoWait(ctx, signTestTx(t, 0, 10, staking.MethodTransfer, newTestTransfer(100))); err != nil {
+		t.Fatalf("unable to submit transaction: %v", err)
+	}
+	blk := oc.CommitBlock()
+
+	t.Run("General", func(t *testing.T) {
+		resp, err := s.AccountBalance(ctx, &types.AccountBalanceRequest{
+			NetworkIdentifier: testNetworkIdentifier,
+			AccountIdentifier: &types.AccountIdentifier{Address: testAddrStr},
+		})
+		requireNoError(t, err)
+		if resp.BlockIdentifier.Index != blk.Height || resp.BlockIdentifier.Hash != blk.Hash {
+			t.Fatalf("unexpected block identifier: %v", types.PrettyPrintStruct(resp.BlockIdentifier))
+		}
+		if v := resp.Balances[0].Value; v != strconv.Itoa(testGeneralBalance-110) {
+			t.Fatalf("unexpected balance: %s", v)
+		}
+		if resp.Metadata[NonceKey] != uint64(1) {
+			t.Fatalf("unexpected nonce: %v", resp.Metadata[NonceKey])
+		}
+	})
+
+	t.Run("GeneralAtHeight", func(t *testing.T) {
+		height := mock.GenesisHeight
+		resp, err := s.AccountBalance(ctx, &types.AccountBalanceRequest{
+			NetworkIdentifier: testNetworkIdentifier,
+			AccountIdentifier: &types.AccountIdentifier{Address: testAddrStr},
+			BlockIdentifier:   &types.PartialBlockIdentifier{Index: &height},
+		})
+		requireNoError(t, err)
+		if resp.BlockIdentifier.Index != height {
+			t.Fatalf("unexpected block identifier: %v", types.PrettyPrintStruct(resp.BlockIdentifier))
+		}
+		if v := resp.Balances[0].Value; v != strconv.Itoa(testGeneralBalance) {
+			t.Fatalf("unexpected balance: %s", v)
+		}
+	})
+
+	t.Run("Escrow", func(t *testing.T) {
+		resp, err := s.AccountBalance(ctx, &types.AccountBalanceRequest{
+			NetworkIdentifier: testNetworkIdentifier,
+			AccountIdentifier: &types.AccountIdentifier{
+				Address:    StringFromAddress(testValidator),
+				SubAccount: &types.SubAccountIdentifier{Address: SubAccountEscrow},
+			},
+		})
+		requireNoError(t, err)
+		if v := resp.Balances[0].Value; v != "1300" {
+			t.Fatalf("unexpected balance: %s", v)
+		}
+		for key, expected := range map[string]string{
+			ActiveBalanceKey:    "1000",
+			ActiveSharesKey:     "500",
+			DebondingBalanceKey: "300",
+			DebondingSharesKey:  "150",
+		} {
+			if resp.Metadata[key] != expected {
+				t.Fatalf("unexpected %s: %v", key, resp.Metadata[key])
+			}
+		}
+	})
+
+	t.Run("Delegations", func(t *testing.T) {
+		resp, err := s.AccountBalance(ctx, &types.AccountBalanceRequest{
+			NetworkIdentifier: testNetworkIdentifier,
+			AccountIdentifier: &types.AccountIdentifier{
+				Address:    testAddrStr,
+				SubAccount: &types.SubAccountIdentifier{Address: SubAccountEscrow},
+			},
+		})
+		requireNoError(t, err)
+
+		// The raw delegations are kept for existing consumers.
+		if delegations := resp.Metadata[DelegationsKey].(map[staking.Address]*staking.Delegation); len(delegations) != 1 {
+			t.Fatalf("unexpected raw delegations: %v", delegations)
+		}
+		if debonding := resp.Metadata[DebondingDelegationsKey].(map[staking.Address][]*staking.DebondingDelegation); len(debonding) != 1 {
+			t.Fatalf("unexpected raw debonding delegations: %v", debonding)
+		}
+
+		active := resp.Metadata[DelegationDetailsKey].([]*DelegationDetails)
+		if len(active) != 1 {
+			t.Fatalf("expected 1 active delegation, got %d", len(active))
+		}
+		if d := active[0]; d.Validator != StringFromAddress(testValidator) ||
+			d.Shares != strconv.Itoa(testActiveShares) ||
+			d.Amount != strconv.Itoa(2*testActiveShares) ||
+			!strings.HasPrefix(d.SharePrice, "2.000") ||
+			d.DebondEndEpoch != nil {
+			t.Fatalf("unexpected active delegation: %v", types.PrettyPrintStruct(d))
+		}
+
+		debonding := resp.Metadata[DebondingDelegationDetailsKey].([]*DelegationDetails)
+		if len(debonding) != 1 {
+			t.Fatalf("expected 1 debonding delegation, got %d", len(debonding))
+		}
+		if d := debonding[0]; d.Amount != strconv.Itoa(2*testDebondingShares) ||
+			d.DebondEndEpoch == nil || *d.DebondEndEpoch != testDebondEndEpoch {
+			t.Fatalf("unexpected debonding delegation: %v", types.PrettyPrintStruct(d))
+		}
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		hash := "abcd"
+		_, err := s.AccountBalance(ctx, &types.AccountBalanceRequest{
+			NetworkIdentifier: testNetworkIdentifier,
+			AccountIdentifier: &types.AccountIdentifier{Address: testAddrStr},
+			BlockIdentifier:   &types.PartialBlockIdentifier{Hash: &hash},
+		})
+		requireError(t, ErrMustQueryByIndex, err)
+
+		_, err = s.AccountBalance(ctx, &types.AccountBalanceRequest{
+			NetworkIdentifier: testNetworkIdentifier,
+			AccountIdentifier: &types.AccountIdentifier{Address: "oasis1invalid"},
+		})
+		requireError(t, ErrInvalidAccountAddress, err)
+
+		_, err = s.AccountBalance(ctx, &types.AccountBalanceRequest{
+			NetworkIdentifier: testNetworkIdentifier,
+			AccountIdentifier: &types.AccountIdentifier{
+				Address:    testAddrStr,
+				SubAccount: &types.SubAccountIdentifier{Address: "foo"},
+			},
+		})
+		requireError(t, ErrMustSpecifySubAccount, err)
+
+		// The latest height is pinned before the account is queried.
+		oc.SetError(mock.MethodResolveHeight, context.DeadlineExceeded)
+		_, err = s.AccountBalance(ctx, &types.AccountBalanceRequest{
+			NetworkIdentifier: testNetworkIdentifier,
+			AccountIdentifier: &types.AccountIdentifier{Address: testAddrStr},
+		})
+		requireError(t, ErrUnableToGetLatestBlk, err)
+		oc.SetError(mock.MethodResolveHeight, nil)
+
+		// Pruned state without a balance history.
+		oc.PruneTo(blk.Height)
+		height := mock.GenesisHeight
+		_, err = s.AccountBalance(ctx, &types.AccountBalanceRequest{
+			NetworkIdentifier: testNetworkIdentifier,
+			AccountIdentifier: &types.AccountIdentifier{Address: testAddrStr},
+			BlockIdentifier:   &types.PartialBlockIdentifier{Index: &height},
+		})
+		requireError(t, ErrUnableToGetAccount, err)
+	})
+}
+
+func TestAccountCoins(t *testing.T) {
+	ctx := context.Background()
+	oc := newTestClient()
+	s := NewAccountAPIService(oc, NewMempoolCache(oc), nil)
+
+	getCoins := func(subAccount *types.SubAccountIdentifier, includeMempool bool) map[string]string {
+		resp, err := s.AccountCoins(ctx, &types.AccountCoinsRequest{
+			NetworkIdentifier: testNetworkIdentifier,
+			AccountIdentifier: &types.AccountIdentifier{
+				Address:    testAddrStr,
+				SubAccount: subAccount,
+			},
+			IncludeMempool: includeMempool,
+		})
+		requireNoError(t, err)
+		if resp.BlockIdentifier.Index != oc.LatestHeight() {
+			t.Fatalf("unexpected block identifier: %v", types.PrettyPrintStruct(resp.BlockIdentifier))
+		}
+
+		coins := make(map[string]string)
+		for _, coin := range resp.Coins {
+			coins[coin.CoinIdentifier.Identifier] = coin.Amount.Value
+		}
+		return coins
+	}
+
+	validator := StringFromAddress(testValidator)
+	generalID := testAddrStr + ":" + CoinGeneral
+	activeID := testAddrStr + ":escrow:active:" + validator
+	debondingID := testAddrStr + ":escrow:debonding:" + validator + ":" + strconv.Itoa(testDebondEndEpoch)
+
+	coins := getCoins(nil, false)
+	expected := map[string]string{
+		generalID:   strconv.Itoa(testGeneralBalance),
+		activeID:    strconv.Itoa(2 * testActiveShares),
+		debondingID: strconv.Itoa(2 * testDebondingShares),
+	}
+	if len(coins) != len(expected) {
+		t.Fatalf("unexpected coins: %v", coins)
+	}
+	for id, value := range expected {
+		if coins[id] != value {
+			t.Fatalf("unexpected coin %s: %s (expected %s)", id, coins[id], value)
+		}
+	}
+
+	// The escrow subaccount only has the delegation coins.
+	coins = getCoins(&types.SubAccountIdentifier{Address: SubAccountEscrow}, false)
+	if _, ok := coins[generalID]; ok || len(coins) != 2 {
+		t.Fatalf("unexpected escrow coins: %v", coins)
+	}
+
+	// Pending outgoing transfers are subtracted from the general coin.
+	if err := oc.SubmitTxNoWait(ctx, signTestTx(t, 0, 10, staking.MethodTransfer, newTestTransfer(100))); err != nil {
+		t.Fatalf("unable to submit transaction: %v", err)
+	}
+	coins = getCoins(nil, true)
+	if v := coins[generalID]; v != strconv.Itoa(testGeneralBalance-110) {
+		t.Fatalf("unexpected general coin with mempool: %s", v)
+	}
+
+	resp, err := s.AccountCoins(ctx, &types.AccountCoinsRequest{
+		NetworkIdentifier: testNetworkIdentifier,
+		AccountIdentifier: &types.AccountIdentifier{Address: testAddrStr},
+		Currencies:        []*types.Currency{{Symbol: "BTC", Decimals: 8}},
+	})
+	requireNoError(t, err)
+	if len(resp.Coins) != 0 {
+		t.Fatalf("expected no coins in other currencies, got %d", len(resp.Coins))
+	}
+
+	oc.SetError(mock.MethodGetDelegations, context.DeadlineExceeded)
+	_, err = s.AccountCoins(ctx, &types.AccountCoinsRequest{
+		NetworkIdentifier: testNetworkIdentifier,
+		AccountIdentifier: &types.AccountIdentifier{Address: testAddrStr},
+	})
+	requireError(t, ErrUnableToGetAccount, err)
+}
@@ -3,12 +3,17 @@ package services
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 
 	"github.com/coinbase/rosetta-sdk-go/server"
 	"github.com/coinbase/rosetta-sdk-go/types"
 	"github.com/oasisprotocol/oasis-core/go/common/crypto/hash"
 	"github.com/oasisprotocol/oasis-core/go/common/logging"
+	consensus "github.com/oasisprotocol/oasis-core/go/consensus/api"
+	staking "github.com/oasisprotocol/oasis-core/go/staking/api"
+	"golang.org/x/sync/errgroup"
 
+	"github.com/oasisprotocol/oasis-core-rosetta-gateway/common"
 	"github.com/oasisprotocol/oasis-core-rosetta-gateway/oasis"
 )
 
@@ -34,9 +39,11 @@ func (s *blockAPIService) Block(
 	ctx context.Context,
 	request *types.BlockRequest,
 ) (*types.BlockResponse, *types.Error) {
+	logger := common.LoggerWithRequestID(ctx, loggerBlk)
+
 	terr := ValidateNetworkIdentifier(ctx, s.oasisClient, request.NetworkIdentifier)
 	if terr != nil {
-		loggerBlk.Error("Block: network validation failed", "err", terr.Message)
+		logger.Error("Block: network validation failed", "err", terr.Message)
 		return nil, terr
 	}
 
@@ -46,58 +53,31 @@ func (s *blockAPIService) Block(
 		if request.BlockIdentifier.Index != nil {
 			height = *request.BlockIdentifier.Index
 		} else if request.BlockIdentifier.Hash != nil {
-			loggerBlk.Error("Block: must query block by index")
+			logger.Error("Block: must query block by index")
 			return nil, ErrMustQueryByIndex
 		}
 	}
 
-	blk, err := s.oasisClient.GetBlock(ctx, height)
+	// Resolve the latest height first, so that the block, its transactions
+	// and its events are all queried at the same (concrete) height.
+	height, err := s.oasisClient.ResolveHeight(ctx, height)
 	if err != nil {
-		loggerBlk.Error("Block: unable to get block",
-			"height", height,
-			"err", err,
-		)
-		return nil, ErrUnableToGetBlk
+		logger.Error("Block: unable to get latest block", "err", err)
+		return nil, ErrUnableToGetLatestBlk
 	}
 
-	td := newTransactionsDecoder()
-
-	txsWithRes, err := s.oasisClient.GetTransactionsWithResults(ctx, height)
+	blk, err := s.oasisClient.GetBlock(ctx, height)
 	if err != nil {
-		loggerBlk.Error("Block: unable to get transactions",
+		logger.Error("Block: unable to get block",
 			"height", height,
 			"err", err,
 		)
-		return nil, ErrUnableToGetTxns
-	}
-	for i, res := range txsWithRes.Results {
-		rawTx := txsWithRes.Transactions[i]
-
-		if err = td.DecodeTx(rawTx, res); err != nil {
-			loggerBlk.Warn("Block: malformed transaction",
-				"height", height,
-				"index", i,
-				"raw_tx", rawTx,
-				"err", err,
-			)
-			continue
-		}
+		return nil, ErrUnableToGetBlk
 	}
 
-	evts, err := s.oasisClient.GetStakingEvents(ctx, height)
+	txs, err := decodeBlockTransactions(ctx, s.oasisClient, blk)
 	if err != nil {
-		loggerBlk.Error("Block: unable to get staking events",
-			"height", height,
-			"err", err,
-		)
-		return nil, ErrUnableToGetTxns
-	}
-
-	var blkHash hash.Hash
-	_ = blkHash.UnmarshalHex(blk.Hash)
-
-	if err = td.DecodeBlock(blkHash, evts); err != nil {
-		loggerBlk.Error("Block: unable to decode block events",
+		logger.Error("Block: unable to decode block transactions",
 			"height", height,
 			"err", err,
 		)
@@ -114,7 +94,7 @@ func (s *blockAPIService) Block(
 			Hash:  blk.ParentHash,
 		},
 		Timestamp:    blk.Timestamp,
-		Transactions: td.Transactions(),
+		Transactions: txs,
 		Metadata: map[string]interface{}{
 			EpochKey: blk.Epoch,
 		},
@@ -124,12 +104,74 @@ func (s *blockAPIService) Block(
 		Block: tblk,
 	}
 
-	jr, _ := json.Marshal(resp)
-	loggerBlk.Debug("Block OK", "response", jr)
+	if debugEnabled() {
+		jr, _ := json.Marshal(resp)
+		logger.Debug("Block OK", "response", jr)
+	}
 
 	return resp, nil
 }
 
+// decodeBlockTransactions fetches the transactions and staking events of the
+// given block and decodes them into Rosetta transactions.
+//
+// Block-level events (not emitted by any transaction) are put under a
+// transaction with the block's hash.
+func decodeBlockTransactions(
+	ctx context.Context,
+	oasisClient oasis.Client,
+	blk *oasis.Block,
+) ([]*types.Transaction, error) {
+	logger := common.LoggerWithRequestID(ctx, loggerBlk)
+
+	// The transactions and the events are independent, so query them in
+	// parallel.
+	var (
+		txsWithRes *consensus.TransactionsWithResults
+		evts       []*staking.Event
+	)
+	g, gctx := errgroup.WithContext(ctx)
+	g.Go(func() (err error) {
+		if txsWithRes, err = oasisClient.GetTransactionsWithResults(gctx, blk.Height); err != nil {
+			return fmt.Errorf("unable to get transactions: %w", err)
+		}
+		return nil
+	})
+	g.Go(func() (err error) {
+		if evts, err = oasisClient.GetStakingEvents(gctx, blk.Height); err != nil {
+			return fmt.Errorf("unable to get staking events: %w", err)
+		}
+		return nil
+	})
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+
+	td := newTransactionsDecoder()
+	for i, res := range txsWithRes.Results {
+		rawTx := txsWithRes.Transactions[i]
+
+		if err := td.DecodeTx(rawTx, res); err != nil {
+			logger.Warn("Block: malformed transaction",
+				"height", blk.Height,
+				"index", i,
+				"raw_tx", rawTx,
+				"err", err,
+			)
+			continue
+		}
+	}
+
+	var blkHash hash.Hash
+	_ = blkHash.UnmarshalHex(blk.Hash)
+
+	if err := td.DecodeBlock(blkHash, evts); err != nil {
+		return nil, fmt.Errorf("unable to decode block events: %w", err)
+	}
+
+	return td.Transactions(), nil
+}
+
 // BlockTransaction implements the /block/transaction endpoint.
 // Note: we don't implement this, since we already return all transactions
 // in the /block endpoint response above.
@@ -137,6 +179,8 @@ func (s *blockAPIService) BlockTransaction(
 	ctx context.Context,
 	request *types.BlockTransactionRequest,
 ) (*types.BlockTransactionResponse, *types.Error) {
-	loggerBlk.Error("BlockTransaction: not implemented")
+	logger := common.LoggerWithRequestID(ctx, loggerBlk)
+
+	logger.Error("BlockTransaction: not implemented")
 	return nil, ErrNotImplemented
 }
@@ -0,0 +1,198 @@
+package services
+
+import (
+	"context"
+	"testing"
+
+	"github.com/coinbase/rosetta-sdk-go/types"
+	"github.com/oasisprotocol/oasis-core/go/common/quantity"
+	"github.com/oasisprotocol/oasis-core/go/consensus/api/transaction"
+	staking "github.com/oasisprotocol/oasis-core/go/staking/api"
+
+	"github.com/oasisprotocol/oasis-core-rosetta-gateway/oasis/mock"
+)
+
+// findOps returns the operations of the given type on the given account.
+func findOps(tx *types.Transaction, opType, address string, subAccount *types.SubAccountIdentifier) []*types.Operation {
+	var ops []*types.Operation
+	for _, op := range tx.Operations {
+		if op.Type != opType || op.Account.Address != address {
+			continue
+		}
+		if (op.Account.SubAccount == nil) != (subAccount == nil) {
+			continue
+		}
+		if subAccount != nil && op.Account.SubAccount.Address != subAccount.Address {
+			continue
+		}
+		ops = append(ops, op)
+	}
+	return ops
+}
+
+func TestBlock(t *testing.T) {
+	ctx := context.Background()
+	oc := newTestClient()
+	s := NewBlockAPIService(oc)
+
+	okTx := signTestTx(t, 0, 10, staking.MethodTransfer, newTestTransfer(100))
+	failedTx := signTestTx(t, 1, 10, staking.MethodTransfer, newTestTransfer(2*testGeneralBalance))
+	for _, tx := range []*transaction.SignedTransaction{okTx, failedTx} {
+		if err := oc.SubmitTxNoWait(ctx, tx); err != nil {
+			t.Fatalf("unable to submit transaction: %v", err)
+		}
+	}
+	oc.AddBlockEvent(&staking.Event{
+		Transfer: &staking.TransferEvent{
+			From:   staking.CommonPoolAddress,
+			To:     testAddr,
+			Amount: *quantity.NewFromUint64(5),
+		},
+	})
+	blk := oc.CommitBlock()
+
+	resp, err := s.Block(ctx, &types.BlockRequest{
+		NetworkIdentifier: testNetworkIdentifier,
+		BlockIdentifier:   &types.PartialBlockIdentifier{Index: &blk.Height},
+	})
+	requireNoError(t, err)
+
+	b := resp.Block
+	if b.BlockIdentifier.Index != blk.Height || b.BlockIdentifier.Hash != blk.Hash {
+		t.Fatalf("unexpected block identifier: %v", types.PrettyPrintStruct(b.BlockIdentifier))
+	}
+	if b.ParentBlockIdentifier.Index != blk.ParentHeight || b.ParentBlockIdentifier.Hash != blk.ParentHash {
+		t.Fatalf("unexpected parent block identifier: %v", types.PrettyPrintStruct(b.ParentBlockIdentifier))
+	}
+	if b.Metadata[EpochKey] != blk.Epoch {
+		t.Fatalf("unexpected epoch: %v", b.Metadata[EpochKey])
+	}
+	if len(b.Transactions) != 3 {
+		t.Fatalf("expected 3 transactions, got %d: %v", len(b.Transactions), types.PrettyPrintStruct(b.Transactions))
+	}
+
+	// Successful transfer.
+	tx := b.Transactions[0]
+	if tx.TransactionIdentifier.Hash != okTx.Hash().String() {
+		t.Fatalf("unexpected transaction hash: %s", tx.TransactionIdentifier.Hash)
+	}
+	xfers := findOps(tx, OpTransfer, testAddrStr, nil)
+	if len(xfers) != 2 || xfers[1].Amount.Value != "-100" || *xfers[1].Status != OpStatusOK {
+		t.Fatalf("unexpected transfer operations: %v", types.PrettyPrintStruct(tx.Operations))
+	}
+	if ops := findOps(tx, OpTransfer, StringFromAddress(testOther), nil); len(ops) != 1 || ops[0].Amount.Value != "100" {
+		t.Fatalf("unexpected transfer operations: %v", types.PrettyPrintStruct(tx.Operations))
+	}
+
+	// Failed transfer only pays the fee.
+	tx = b.Transactions[1]
+	for _, op := range tx.Operations {
+		isFee := op.Account.Address == StringFromAddress(staking.FeeAccumulatorAddress) ||
+			(op.Account.Address == testAddrStr && op.Amount.Value == "-10")
+		switch {
+		case isFee && *op.Status != OpStatusOK:
+			t.Fatalf("expected successful fee operation: %v", types.PrettyPrintStruct(op))
+		case !isFee && *op.Status != OpStatusFailed:
+			t.Fatalf("expected failed transfer operation: %v", types.PrettyPrintStruct(op))
+		}
+	}
+
+	// Block-level events are put under the block hash.
+	tx = b.Transactions[2]
+	if tx.TransactionIdentifier.Hash != blk.Hash {
+		t.Fatalf("unexpected block-level transaction hash: %s", tx.TransactionIdentifier.Hash)
+	}
+	if ops := findOps(tx, OpTransfer, testAddrStr, nil); len(ops) != 1 || ops[0].Amount.Value != "5" {
+		t.Fatalf("unexpected block-level operations: %v", types.PrettyPrintStruct(tx.Operations))
+	}
+}
+
+func TestBlockLatest(t *testing.T) {
+	ctx := context.Background()
+	oc := newTestClient()
+	oc.CommitBlock()
+	s := NewBlockAPIService(oc)
+
+	resp, err := s.Block(ctx, &types.BlockRequest{
+		NetworkIdentifier: testNetworkIdentifier,
+		BlockIdentifier:   &types.PartialBlockIdentifier{},
+	})
+	requireNoError(t, err)
+	if resp.Block.BlockIdentifier.Index != oc.LatestHeight() {
+		t.Fatalf("unexpected block height: %d", resp.Block.BlockIdentifier.Index)
+	}
+	if len(resp.Block.Transactions) != 0 {
+		t.Fatalf("expected no transactions, got %d", len(resp.Block.Transactions))
+	}
+}
+
+func TestBlockErrors(t *testing.T) {
+	ctx := context.Background()
+	oc := newTestClient()
+	s := NewBlockAPIService(oc)
+
+	hash := "abcd"
+	_, err := s.Block(ctx, &types.BlockRequest{
+		NetworkIdentifier: testNetworkIdentifier,
+		BlockIdentifier:   &types.PartialBlockIdentifier{Hash: &hash},
+	})
+	requireError(t, ErrMustQueryByIndex, err)
+
+	height := int64(1000)
+	_, err = s.Block(ctx, &types.BlockRequest{
+		NetworkIdentifier: testNetworkIdentifier,
+		BlockIdentifier:   &types.PartialBlockIdentifier{Index: &height},
+	})
+	requireError(t, ErrUnableToGetBlk, err)
+
+	oc.SetError(mock.MethodGetStakingEvents, context.DeadlineExceeded)
+	_, err = s.Block(ctx, &types.BlockRequest{
+		NetworkIdentifier: testNetworkIdentifier,
+		BlockIdentifier:   &types.PartialBlockIdentifier{},
+	})
+	requireError(t, ErrUnableToGetTxns, err)
+
+	_, err = s.BlockTransaction(ctx, &types.BlockTransactionRequest{})
+	requireError(t, ErrNotImplemented, err)
+}
+
+func TestBlockAddEscrow(t *testing.T) {
+	ctx := context.Background()
+	oc := newTestClient()
+	s := NewBlockAPIService(oc)
+
+	escrow := &staking.Escrow{
+		Account: testValidator,
+		Amount:  *quantity.NewFromUint64(100),
+	}
+	if err := oc.SubmitTxNoWait(ctx, signTestTx(t, 0, 0, staking.MethodAddEscrow, escrow)); err != nil {
+		t.Fatalf("unable to submit transaction: %v", err)
+	}
+	blk := oc.CommitBlock()
+
+	resp, err := s.Block(ctx, &types.BlockRequest{
+		NetworkIdentifier: testNetworkIdentifier,
+		BlockIdentifier:   &types.PartialBlockIdentifier{Index: &blk.Height},
+	})
+	requireNoError(t, err)
+	if len(resp.Block.Transactions) != 1 {
+		t.Fatalf("expected 1 transaction, got %d", len(resp.Block.Transactions))
+	}
+	tx := resp.Block.Transactions[0]
+	if ops := findOps(tx, OpTransfer, testAddrStr, nil); len(ops) != 1 || ops[0].Amount.Value != "-100" {
+		t.Fatalf("unexpected add escrow operations: %v", types.PrettyPrintStruct(tx.Operations))
+	}
+	escrowSubAccount := &types.SubAccountIdentifier{Address: SubAccountEscrow}
+	if ops := findOps(tx, OpTransfer, StringFromAddress(testValidator), escrowSubAccount); len(ops) != 1 || ops[0].Amount.Value != "100" {
+		t.Fatalf("unexpected add escrow operations: %v", types.PrettyPrintStruct(tx.Operations))
+	}
+
+	// The delegation is reflected in the state.
+	delegations, derr := oc.GetDelegations(ctx, blk.Height, testAddr)
+	if derr != nil {
+		t.Fatalf("unable to get delegations: %v", derr)
+	}
+	if d := delegations[testValidator]; d == nil || d.Shares.String() != "300" {
+		t.Fatalf("unexpected delegation: %v", d)
+	}
+}
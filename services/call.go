@@ -0,0 +1,403 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/coinbase/rosetta-sdk-go/server"
+	"github.com/coinbase/rosetta-sdk-go/types"
+
+	"github.com/oasisprotocol/oasis-core/go/common/logging"
+	staking "github.com/oasisprotocol/oasis-core/go/staking/api"
+
+	"github.com/oasisprotocol/oasis-core-rosetta-gateway/common"
+	"github.com/oasisprotocol/oasis-core-rosetta-gateway/oasis"
+)
+
+// CallMethodAccountBalances is the name of the /call method that returns the
+// general, escrow and allowance balances of an account at a single height.
+const CallMethodAccountBalances = "account_balances"
+
+// CallMethodAccountBalancesBatch is the name of the /call method that returns
+// the balances of many accounts (like account_balances) at a single height.
+const CallMethodAccountBalancesBatch = "account_balances_batch"
+
+// SupportedCallMethods is a list of the supported /call methods.
+var SupportedCallMethods = []string{
+	CallMethodAccountBalances,
+	CallMethodAccountBalancesBatch,
+}
+
+// MaxBatchAccounts is the maximum number of accounts in an
+// account_balances_batch /call request.
+const MaxBatchAccounts = 1000
+
+// batchConcurrency is the maximum number of accounts of an
+// account_balances_batch /call request that are queried concurrently.
+const batchConcurrency = 16
+
+// CallAccountIdentifierKey is the name of the key in the Parameters map of a
+// /call request that specifies the account identifier to query.
+const CallAccountIdentifierKey = "account_identifier"
+
+// CallAccountIdentifiersKey is the name of the key in the Parameters map of an
+// account_balances_batch /call request that specifies the account identifiers
+// to query.
+const CallAccountIdentifiersKey = "account_identifiers"
+
+// CallBlockIdentifierKey is the name of the key in the Parameters map of a
+// /call request that specifies the (partial) block identifier to query at.
+// If absent, the latest block is used.
+// It is also the name of the key in the Result map of a /call response that
+// specifies the block identifier of the returned state.
+const CallBlockIdentifierKey = "block_identifier"
+
+// GeneralBalanceKey is the name of the key in the Result map of an
+// account_balances /call response that specifies the general balance.
+const GeneralBalanceKey = "general"
+
+// EscrowActiveBalanceKey is the name of the key in the Result map of an
+// account_balances /call response that specifies the active escrow balance.
+const EscrowActiveBalanceKey = "escrow_active"
+
+// EscrowDebondingBalanceKey is the name of the key in the Result map of an
+// account_balances /call response that specifies the debonding escrow balance.
+const EscrowDebondingBalanceKey = "escrow_debonding"
+
+// AllowancesKey is the name of the key in the Result map of an
+// account_balances /call response that maps beneficiary addresses to their
+// allowances.
+const AllowancesKey = "allowances"
+
+// BalancesKey is the name of the key in the Result map of an
+// account_balances_batch /call response that lists the results for the
+// requested accounts, in the order of the request.
+const BalancesKey = "balances"
+
+// CallErrorKey is the name of the key in a result for an account of an
+// account_balances_batch /call response that specifies the error of the
+// failed query of the account, instead of its balances.
+const CallErrorKey = "error"
+
+var loggerCall = logging.GetLogger("services/call")
+
+// accountBalancesParams are the parameters of the account_balances method.
+type accountBalancesParams struct {
+	AccountIdentifier *types.AccountIdentifier      `json:"account_identifier"`
+	BlockIdentifier   *types.PartialBlockIdentifier `json:"block_identifier,omitempty"`
+}
+
+// accountBalancesBatchParams are the parameters of the account_balances_batch
+// method.
+type accountBalancesBatchParams struct {
+	AccountIdentifiers []*types.AccountIdentifier    `json:"account_identifiers"`
+	BlockIdentifier    *types.PartialBlockIdentifier `json:"block_identifier,omitempty"`
+}
+
+type callAPIService struct {
+	oasisClient oasis.Client
+}
+
+// NewCallAPIService creates a new instance of a CallAPIService.
+func NewCallAPIService(oasisClient oasis.Client) server.CallAPIServicer {
+	return &callAPIService{
+		oasisClient: oasisClient,
+	}
+}
+
+// Call implements the /call endpoint.
+func (s *callAPIService) Call(
+	ctx context.Context,
+	request *types.CallRequest,
+) (*types.CallResponse, *types.Error) {
+	logger := common.LoggerWithRequestID(ctx, loggerCall)
+
+	terr := ValidateNetworkIdentifier(ctx, s.oasisClient, request.NetworkIdentifier)
+	if terr != nil {
+		logger.Error("Call: network validation failed", "err", terr.Message)
+		return nil, terr
+	}
+
+	var resp *types.CallResponse
+	switch request.Method {
+	case CallMethodAccountBalances:
+		resp, terr = s.accountBalances(ctx, request.Parameters)
+	case CallMethodAccountBalancesBatch:
+		resp, terr = s.accountBalancesBatch(ctx, request.Parameters)
+	default:
+		logger.Error("Call: unsupported method", "method", request.Method)
+		return nil, ErrNotImplemented
+	}
+	if terr != nil {
+		return nil, terr
+	}
+
+	if debugEnabled() {
+		jr, _ := json.Marshal(resp)
+		logger.Debug("Call OK", "method", request.Method, "response", jr)
+	}
+
+	return resp, nil
+}
+
+// accountBalances implements the account_balances /call method.
+//
+// All balances are read from a single account query at the height of the
+// returned block identifier.
+func (s *callAPIService) accountBalances(
+	ctx context.Context,
+	parameters map[string]interface{},
+) (*types.CallResponse, *types.Error) {
+	logger := common.LoggerWithRequestID(ctx, loggerCall)
+
+	var params accountBalancesParams
+	if err := decodeCallParameters(parameters, &params); err != nil {
+		logger.Error("accountBalances: malformed parameters", "err", err)
+		return nil, ErrMalformedValue
+	}
+
+	height, terr := callHeight(params.BlockIdentifier)
+	if terr != nil {
+		logger.Error("accountBalances: must query block by index")
+		return nil, terr
+	}
+	owner, terr := callAccountAddress(params.AccountIdentifier)
+	if terr != nil {
+		logger.Error("accountBalances: invalid account identifier", "err", terr.Message)
+		return nil, terr
+	}
+
+	blk, terr := s.getBlockHeader(ctx, height)
+	if terr != nil {
+		return nil, terr
+	}
+
+	act, err := s.oasisClient.GetAccount(ctx, blk.Height, owner)
+	if err != nil {
+		logger.Error("accountBalances: unable to get account",
+			"account_address", owner.String(),
+			"height", blk.Height,
+			"err", err,
+		)
+		return nil, ErrUnableToGetAccount
+	}
+
+	result := newAccountBalancesResult(act)
+	result[CallBlockIdentifierKey] = &types.BlockIdentifier{
+		Index: blk.Height,
+		Hash:  blk.Hash,
+	}
+	return &types.CallResponse{
+		Result: result,
+		// State at a given height never changes.
+		Idempotent: height != oasis.LatestHeight,
+	}, nil
+}
+
+// accountBalancesBatch implements the account_balances_batch /call method.
+//
+// The latest height is resolved once, and the accounts are queried
+// concurrently at the height of the returned block identifier.  A failed
+// query of an account doesn't fail the others, but a canceled request stops
+// querying the remaining accounts and fails.
+func (s *callAPIService) accountBalancesBatch(
+	ctx context.Context,
+	parameters map[string]interface{},
+) (*types.CallResponse, *types.Error) {
+	logger := common.LoggerWithRequestID(ctx, loggerCall)
+
+	var params accountBalancesBatchParams
+	if err := decodeCallParameters(parameters, &params); err != nil {
+		logger.Error("accountBalancesBatch: malformed parameters", "err", err)
+		return nil, ErrMalformedValue
+	}
+	if len(params.AccountIdentifiers) == 0 || len(params.AccountIdentifiers) > MaxBatchAccounts {
+		logger.Error("accountBalancesBatch: invalid number of accounts",
+			"accounts", len(params.AccountIdentifiers),
+			"max_accounts", MaxBatchAccounts,
+		)
+		return nil, ErrMalformedValue
+	}
+
+	height, terr := callHeight(params.BlockIdentifier)
+	if terr != nil {
+		logger.Error("accountBalancesBatch: must query block by index")
+		return nil, terr
+	}
+	blk, terr := s.getBlockHeader(ctx, height)
+	if terr != nil {
+		return nil, terr
+	}
+
+	results := make([]map[string]interface{}, len(params.AccountIdentifiers))
+	sem := make(chan struct{}, batchConcurrency)
+	var wg sync.WaitGroup
+	for i, ai := range params.AccountIdentifiers {
+		// Stop scheduling queries once the request is canceled.
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+		go func(i int, ai *types.AccountIdentifier) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			results[i] = s.batchAccountBalances(ctx, blk.Height, ai)
+		}(i, ai)
+	}
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		logger.Error("accountBalancesBatch: request canceled", "err", err)
+		return nil, NewDetailedError(ErrUnableToGetAccount, err)
+	}
+
+	return &types.CallResponse{
+		Result: map[string]interface{}{
+			CallBlockIdentifierKey: &types.BlockIdentifier{
+				Index: blk.Height,
+				Hash:  blk.Hash,
+			},
+			BalancesKey: results,
+		},
+		// State at a given height never changes.
+		Idempotent: height != oasis.LatestHeight,
+	}, nil
+}
+
+// batchAccountBalances returns the result for the given account of an
+// account_balances_batch /call request at the given (concrete) height.
+func (s *callAPIService) batchAccountBalances(
+	ctx context.Context,
+	height int64,
+	ai *types.AccountIdentifier,
+) map[string]interface{} {
+	logger := common.LoggerWithRequestID(ctx, loggerCall)
+
+	owner, terr := callAccountAddress(ai)
+	if terr != nil {
+		logger.Error("accountBalancesBatch: invalid account identifier", "err", terr.Message)
+		return map[string]interface{}{
+			CallAccountIdentifierKey: ai,
+			CallErrorKey:             terr,
+		}
+	}
+
+	act, err := s.oasisClient.GetAccount(ctx, height, owner)
+	if err != nil {
+		logger.Error("accountBalancesBatch: unable to get account",
+			"account_address", owner.String(),
+			"height", height,
+			"err", err,
+		)
+		return map[string]interface{}{
+			CallAccountIdentifierKey: ai,
+			CallErrorKey:             ErrUnableToGetAccount,
+		}
+	}
+
+	result := newAccountBalancesResult(act)
+	result[CallAccountIdentifierKey] = ai
+	return result
+}
+
+// getBlockHeader returns the header of the block at the given height, with
+// the latest height resolved first, so that all accounts are queried at the
+// same (concrete) height.
+func (s *callAPIService) getBlockHeader(ctx context.Context, height int64) (*oasis.BlockHeader, *types.Error) {
+	logger := common.LoggerWithRequestID(ctx, loggerCall)
+
+	resolved, err := s.oasisClient.ResolveHeight(ctx, height)
+	if err != nil {
+		logger.Error("Call: unable to get latest block", "err", err)
+		return nil, ErrUnableToGetLatestBlk
+	}
+	blk, err := s.oasisClient.GetBlockHeader(ctx, resolved)
+	if err != nil {
+		logger.Error("Call: unable to get block",
+			"height", height,
+			"err", err,
+		)
+		return nil, ErrUnableToGetBlk
+	}
+	return blk, nil
+}
+
+// callHeight returns the height given by the (partial) block identifier of a
+// /call request, which must be given by index if at all.
+func callHeight(bi *types.PartialBlockIdentifier) (int64, *types.Error) {
+	switch {
+	case bi == nil:
+		return oasis.LatestHeight, nil
+	case bi.Index != nil:
+		return *bi.Index, nil
+	case bi.Hash != nil:
+		return 0, ErrMustQueryByIndex
+	default:
+		return oasis.LatestHeight, nil
+	}
+}
+
+// callAccountAddress returns the address of the (general) account given by
+// the account identifier of a /call request.
+func callAccountAddress(ai *types.AccountIdentifier) (staking.Address, *types.Error) {
+	var owner staking.Address
+	if ai == nil || ai.Address == "" {
+		return owner, ErrInvalidAccountAddress
+	}
+	if ai.SubAccount != nil {
+		return owner, ErrMalformedValue
+	}
+	if err := owner.UnmarshalText([]byte(ai.Address)); err != nil {
+		return owner, ErrInvalidAccountAddress
+	}
+	return owner, nil
+}
+
+// newAccountBalancesResult returns the balances of the given account, keyed
+// as in the Result map of an account_balances /call response.
+func newAccountBalancesResult(act *staking.Account) map[string]interface{} {
+	allowances := make(map[string]*types.Amount, len(act.General.Allowances))
+	for beneficiary, amount := range act.General.Allowances {
+		allowances[StringFromAddress(beneficiary)] = &types.Amount{
+			Value:    amount.String(),
+			Currency: OasisCurrency,
+		}
+	}
+
+	return map[string]interface{}{
+		GeneralBalanceKey: &types.Amount{
+			Value:    act.General.Balance.String(),
+			Currency: OasisCurrency,
+		},
+		EscrowActiveBalanceKey: &types.Amount{
+			Value:    act.Escrow.Active.Balance.String(),
+			Currency: OasisCurrency,
+		},
+		EscrowDebondingBalanceKey: &types.Amount{
+			Value:    act.Escrow.Debonding.Balance.String(),
+			Currency: OasisCurrency,
+		},
+		AllowancesKey: allowances,
+		NonceKey:      act.General.Nonce,
+	}
+}
+
+// decodeCallParameters decodes the Parameters map of a /call request into the
+// given method-specific parameters struct.
+func decodeCallParameters(parameters map[string]interface{}, dst interface{}) error {
+	raw, err := json.Marshal(parameters)
+	if err != nil {
+		return fmt.Errorf("unable to marshal parameters: %w", err)
+	}
+	if err = json.Unmarshal(raw, dst); err != nil {
+		return fmt.Errorf("unable to unmarshal parameters: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,248 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/coinbase/rosetta-sdk-go/types"
+	"github.com/oasisprotocol/oasis-core/go/common/quantity"
+	staking "github.com/oasisprotocol/oasis-core/go/staking/api"
+
+	"github.com/oasisprotocol/oasis-core-rosetta-gateway/oasis/mock"
+)
+
+// blockingAccountClient is a mock client whose account queries block until
+// their context is done.
+type blockingAccountClient struct {
+	*mock.Client
+
+	calls int32
+}
+
+func (c *blockingAccountClient) GetAccount(
+	ctx context.Context,
+	height int64,
+	owner staking.Address,
+) (*staking.Account, error) {
+	atomic.AddInt32(&c.calls, 1)
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestCallAccountBalances(t *testing.T) {
+	ctx := context.Background()
+	oc := newTestClient()
+	s := NewCallAPIService(oc)
+
+	act, _ := oc.GetAccount(ctx, mock.GenesisHeight, testAddr)
+	act.General.Allowances = map[staking.Address]quantity.Quantity{
+		testOther: *quantity.NewFromUint64(42),
+	}
+	oc.SetAccount(testAddr, act)
+	blk := oc.CommitBlock()
+
+	call := func(params map[string]interface{}) (*types.CallResponse, *types.Error) {
+		return s.Call(ctx, &types.CallRequest{
+			NetworkIdentifier: testNetworkIdentifier,
+			Method:            CallMethodAccountBalances,
+			Parameters:        params,
+		})
+	}
+
+	resp, err := call(map[string]interface{}{
+		CallAccountIdentifierKey: map[string]interface{}{"address": testAddrStr},
+	})
+	requireNoError(t, err)
+	if resp.Idempotent {
+		t.Fatalf("latest state must not be idempotent")
+	}
+	if bi := resp.Result[CallBlockIdentifierKey].(*types.BlockIdentifier); bi.Index != blk.Height || bi.Hash != blk.Hash {
+		t.Fatalf("unexpected block identifier: %v", types.PrettyPrintStruct(bi))
+	}
+	if v := resp.Result[GeneralBalanceKey].(*types.Amount).Value; v != strconv.Itoa(testGeneralBalance) {
+		t.Fatalf("unexpected general balance: %s", v)
+	}
+	allowances := resp.Result[AllowancesKey].(map[string]*types.Amount)
+	if a, ok := allowances[StringFromAddress(testOther)]; !ok || a.Value != "42" {
+		t.Fatalf("unexpected allowances: %v", types.PrettyPrintStruct(allowances))
+	}
+
+	// Validator escrow balances at genesis.
+	resp, err = call(map[string]interface{}{
+		CallAccountIdentifierKey: map[string]interface{}{"address": StringFromAddress(testValidator)},
+		CallBlockIdentifierKey:   map[string]interface{}{"index": mock.GenesisHeight},
+	})
+	requireNoError(t, err)
+	if !resp.Idempotent {
+		t.Fatalf("state at a given height must be idempotent")
+	}
+	if v := resp.Result[EscrowActiveBalanceKey].(*types.Amount).Value; v != "1000" {
+		t.Fatalf("unexpected active escrow balance: %s", v)
+	}
+	if v := resp.Result[EscrowDebondingBalanceKey].(*types.Amount).Value; v != "300" {
+		t.Fatalf("unexpected debonding escrow balance: %s", v)
+	}
+
+	for _, tc := range []struct {
+		name   string
+		params map[string]interface{}
+		err    *types.Error
+	}{
+		{"MissingAccount", map[string]interface{}{}, ErrInvalidAccountAddress},
+		{"InvalidAccount", map[string]interface{}{
+			CallAccountIdentifierKey: map[string]interface{}{"address": "oasis1invalid"},
+		}, ErrInvalidAccountAddress},
+		{"SubAccount", map[string]interface{}{
+			CallAccountIdentifierKey: map[string]interface{}{
+				"address":     testAddrStr,
+				"sub_account": map[string]interface{}{"address": SubAccountEscrow},
+			},
+		}, ErrMalformedValue},
+		{"MalformedAccount", map[string]interface{}{
+			CallAccountIdentifierKey: "foo",
+		}, ErrMalformedValue},
+		{"QueryByHash", map[string]interface{}{
+			CallAccountIdentifierKey: map[string]interface{}{"address": testAddrStr},
+			CallBlockIdentifierKey:   map[string]interface{}{"hash": blk.Hash},
+		}, ErrMustQueryByIndex},
+		{"MissingBlock", map[string]interface{}{
+			CallAccountIdentifierKey: map[string]interface{}{"address": testAddrStr},
+			CallBlockIdentifierKey:   map[string]interface{}{"index": 1000},
+		}, ErrUnableToGetBlk},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := call(tc.params)
+			requireError(t, tc.err, err)
+		})
+	}
+
+	_, err = s.Call(ctx, &types.CallRequest{
+		NetworkIdentifier: testNetworkIdentifier,
+		Method:            "foo",
+	})
+	requireError(t, ErrNotImplemented, err)
+}
+
+func TestCallAccountBalancesBatch(t *testing.T) {
+	ctx := context.Background()
+	oc := newTestClient()
+	s := NewCallAPIService(oc)
+	blk := oc.CommitBlock()
+
+	call := func(params map[string]interface{}) (*types.CallResponse, *types.Error) {
+		return s.Call(ctx, &types.CallRequest{
+			NetworkIdentifier: testNetworkIdentifier,
+			Method:            CallMethodAccountBalancesBatch,
+			Parameters:        params,
+		})
+	}
+	accounts := []interface{}{
+		map[string]interface{}{"address": testAddrStr},
+		map[string]interface{}{"address": "oasis1invalid"},
+		map[string]interface{}{
+			"address":     testAddrStr,
+			"sub_account": map[string]interface{}{"address": SubAccountEscrow},
+		},
+		map[string]interface{}{"address": StringFromAddress(testValidator)},
+	}
+
+	resp, err := call(map[string]interface{}{CallAccountIdentifiersKey: accounts})
+	requireNoError(t, err)
+	if resp.Idempotent {
+		t.Fatalf("latest state must not be idempotent")
+	}
+	if bi := resp.Result[CallBlockIdentifierKey].(*types.BlockIdentifier); bi.Index != blk.Height || bi.Hash != blk.Hash {
+		t.Fatalf("unexpected block identifier: %v", types.PrettyPrintStruct(bi))
+	}
+	balances := resp.Result[BalancesKey].([]map[string]interface{})
+	if len(balances) != len(accounts) {
+		t.Fatalf("unexpected balances: %v", types.PrettyPrintStruct(balances))
+	}
+	if v := balances[0][GeneralBalanceKey].(*types.Amount).Value; v != strconv.Itoa(testGeneralBalance) {
+		t.Fatalf("unexpected general balance: %s", v)
+	}
+	if ai := balances[0][CallAccountIdentifierKey].(*types.AccountIdentifier); ai.Address != testAddrStr {
+		t.Fatalf("unexpected account identifier: %v", types.PrettyPrintStruct(ai))
+	}
+	requireError(t, ErrInvalidAccountAddress, balances[1][CallErrorKey].(*types.Error))
+	requireError(t, ErrMalformedValue, balances[2][CallErrorKey].(*types.Error))
+	if v := balances[3][EscrowActiveBalanceKey].(*types.Amount).Value; v != "1000" {
+		t.Fatalf("unexpected active escrow balance: %s", v)
+	}
+
+	// All accounts are queried at the given height.
+	resp, err = call(map[string]interface{}{
+		CallAccountIdentifiersKey: accounts[:1],
+		CallBlockIdentifierKey:    map[string]interface{}{"index": mock.GenesisHeight},
+	})
+	requireNoError(t, err)
+	if !resp.Idempotent {
+		t.Fatalf("state at a given height must be idempotent")
+	}
+	if bi := resp.Result[CallBlockIdentifierKey].(*types.BlockIdentifier); bi.Index != mock.GenesisHeight {
+		t.Fatalf("unexpected block identifier: %v", types.PrettyPrintStruct(bi))
+	}
+
+	// Failed account queries only fail their results.
+	oc.SetError(mock.MethodGetAccount, errors.New("test"))
+	resp, err = call(map[string]interface{}{CallAccountIdentifiersKey: accounts[:1]})
+	requireNoError(t, err)
+	balances = resp.Result[BalancesKey].([]map[string]interface{})
+	requireError(t, ErrUnableToGetAccount, balances[0][CallErrorKey].(*types.Error))
+	oc.SetError(mock.MethodGetAccount, nil)
+
+	tooMany := make([]interface{}, MaxBatchAccounts+1)
+	for i := range tooMany {
+		tooMany[i] = accounts[0]
+	}
+	for _, tc := range []struct {
+		name   string
+		params map[string]interface{}
+		err    *types.Error
+	}{
+		{"NoAccounts", map[string]interface{}{}, ErrMalformedValue},
+		{"TooManyAccounts", map[string]interface{}{CallAccountIdentifiersKey: tooMany}, ErrMalformedValue},
+		{"MalformedAccounts", map[string]interface{}{CallAccountIdentifiersKey: "foo"}, ErrMalformedValue},
+		{"QueryByHash", map[string]interface{}{
+			CallAccountIdentifiersKey: accounts,
+			CallBlockIdentifierKey:    map[string]interface{}{"hash": blk.Hash},
+		}, ErrMustQueryByIndex},
+		{"MissingBlock", map[string]interface{}{
+			CallAccountIdentifiersKey: accounts,
+			CallBlockIdentifierKey:    map[string]interface{}{"index": 1000},
+		}, ErrUnableToGetBlk},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := call(tc.params)
+			requireError(t, tc.err, err)
+		})
+	}
+}
+
+func TestCallAccountBalancesBatchCanceled(t *testing.T) {
+	oc := &blockingAccountClient{Client: newTestClient()}
+	s := NewCallAPIService(oc)
+
+	accounts := make([]interface{}, MaxBatchAccounts)
+	for i := range accounts {
+		accounts[i] = map[string]interface{}{"address": testAddrStr}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err := s.Call(ctx, &types.CallRequest{
+		NetworkIdentifier: testNetworkIdentifier,
+		Method:            CallMethodAccountBalancesBatch,
+		Parameters:        map[string]interface{}{CallAccountIdentifiersKey: accounts},
+	})
+	requireError(t, ErrUnableToGetAccount, err)
+
+	// No queries are scheduled after the request is canceled.
+	if calls := atomic.LoadInt32(&oc.calls); calls != batchConcurrency {
+		t.Fatalf("unexpected number of account queries: %d (expected: %d)", calls, batchConcurrency)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"os"
 
 	"github.com/coinbase/rosetta-sdk-go/types"
+	"github.com/oasisprotocol/oasis-core/go/common/logging"
 	staking "github.com/oasisprotocol/oasis-core/go/staking/api"
 
 	"github.com/oasisprotocol/oasis-core-rosetta-gateway/oasis"
@@ -83,3 +84,9 @@ func StringFromAddress(address staking.Address) string {
 	}
 	return string(buf)
 }
+
+// debugEnabled returns true if debug logs are emitted.  Responses can be
+// large, so they are only marshalled for the debug logs if this is the case.
+func debugEnabled() bool {
+	return logging.GetLevel() <= logging.LevelDebug
+}
@@ -0,0 +1,170 @@
+package services
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/coinbase/rosetta-sdk-go/types"
+	"github.com/oasisprotocol/oasis-core/go/common/crypto/signature"
+	"github.com/oasisprotocol/oasis-core/go/common/crypto/signature/signers/memory"
+	"github.com/oasisprotocol/oasis-core/go/common/quantity"
+	"github.com/oasisprotocol/oasis-core/go/consensus/api/transaction"
+	staking "github.com/oasisprotocol/oasis-core/go/staking/api"
+
+	"github.com/oasisprotocol/oasis-core-rosetta-gateway/oasis/mock"
+)
+
+var (
+	testSigner    = memory.NewTestSigner("oasis-core-rosetta-gateway/services: test account")
+	testAddr      = staking.NewAddress(testSigner.Public())
+	testAddrStr   = StringFromAddress(testAddr)
+	testValidator = staking.NewAddress(memory.NewTestSigner("oasis-core-rosetta-gateway/services: test validator").Public())
+	testOther     = staking.NewAddress(memory.NewTestSigner("oasis-core-rosetta-gateway/services: test other").Public())
+
+	testNetworkIdentifier = &types.NetworkIdentifier{
+		Blockchain: OasisBlockchainName,
+		Network:    mock.ChainID,
+	}
+)
+
+const (
+	// testGeneralBalance is the general balance of the test account.
+	testGeneralBalance = 1000000
+	// testActiveShares is the number of shares the test account delegated to
+	// the test validator, worth 2 base units each.
+	testActiveShares = 250
+	// testDebondingShares is the number of shares the test account is
+	// debonding from the test validator, worth 2 base units each.
+	testDebondingShares = 100
+	// testDebondEndEpoch is the epoch at which the test account's debonding
+	// delegation ends.
+	testDebondEndEpoch = 5
+)
+
+func TestMain(m *testing.M) {
+	signature.SetChainContext(mock.ChainID)
+	os.Exit(m.Run())
+}
+
+// newTestClient returns a mock client whose genesis state has the test
+// account with a general balance, an active and a debonding delegation to
+// the test validator.
+func newTestClient() *mock.Client {
+	q := quantity.NewFromUint64
+
+	return mock.New(&staking.Genesis{
+		Ledger: map[staking.Address]*staking.Account{
+			testAddr: {
+				General: staking.GeneralAccount{
+					Balance: *q(testGeneralBalance),
+				},
+			},
+			testValidator: {
+				Escrow: staking.EscrowAccount{
+					Active: staking.SharePool{
+						Balance:     *q(1000),
+						TotalShares: *q(500),
+					},
+					Debonding: staking.SharePool{
+						Balance:     *q(300),
+						TotalShares: *q(150),
+					},
+				},
+			},
+		},
+		Delegations: map[staking.Address]map[staking.Address]*staking.Delegation{
+			testValidator: {
+				testAddr: {Shares: *q(testActiveShares)},
+			},
+		},
+		DebondingDelegations: map[staking.Address]map[staking.Address][]*staking.DebondingDelegation{
+			testValidator: {
+				testAddr: {
+					{Shares: *q(testDebondingShares), DebondEndTime: testDebondEndEpoch},
+				},
+			},
+		},
+	})
+}
+
+// signTestTx signs a transaction with the given nonce, method and body by
+// the test account, paying the given fee.
+func signTestTx(
+	t *testing.T,
+	nonce uint64,
+	fee uint64,
+	method transaction.MethodName,
+	body interface{},
+) *transaction.SignedTransaction {
+	t.Helper()
+
+	tx := transaction.NewTransaction(nonce, &transaction.Fee{
+		Amount: *quantity.NewFromUint64(fee),
+		Gas:    DefaultGas,
+	}, method, body)
+	sigTx, err := transaction.Sign(testSigner, tx)
+	if err != nil {
+		t.Fatalf("unable to sign transaction: %v", err)
+	}
+	return sigTx
+}
+
+// newTestTransfer returns a transfer of the given amount to the other test
+// account.
+func newTestTransfer(amount uint64) *staking.Transfer {
+	return &staking.Transfer{
+		To:     testOther,
+		Amount: *quantity.NewFromUint64(amount),
+	}
+}
+
+// requireError fails the test unless the given error is the expected one.
+func requireError(t *testing.T, expected, actual *types.Error) {
+	t.Helper()
+
+	if actual == nil {
+		t.Fatalf("expected error %q, got none", expected.Message)
+	}
+	if actual.Code != expected.Code {
+		t.Fatalf("expected error %q, got %q", expected.Message, actual.Message)
+	}
+}
+
+// requireNoError fails the test if the given error is not nil.
+func requireNoError(t *testing.T, err *types.Error) {
+	t.Helper()
+
+	if err != nil {
+		t.Fatalf("unexpected error: %s (%v)", err.Message, err.Details)
+	}
+}
+
+func TestValidateNetworkIdentifier(t *testing.T) {
+	ctx := context.Background()
+	oc := newTestClient()
+
+	requireNoError(t, ValidateNetworkIdentifier(ctx, oc, testNetworkIdentifier))
+
+	for _, tc := range []struct {
+		name string
+		ni   *types.NetworkIdentifier
+		err  *types.Error
+	}{
+		{"Missing", nil, ErrMissingNID},
+		{"Blockchain", &types.NetworkIdentifier{Blockchain: "Bitcoin", Network: mock.ChainID}, ErrInvalidBlockchain},
+		{"Network", &types.NetworkIdentifier{Blockchain: OasisBlockchainName, Network: "foo"}, ErrInvalidNetwork},
+		{"SubNetwork", &types.NetworkIdentifier{
+			Blockchain:           OasisBlockchainName,
+			Network:              mock.ChainID,
+			SubNetworkIdentifier: &types.SubNetworkIdentifier{Network: "foo"},
+		}, ErrInvalidSubnetwork},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			requireError(t, tc.err, ValidateNetworkIdentifier(ctx, oc, tc.ni))
+		})
+	}
+
+	oc.SetError(mock.MethodGetChainID, context.DeadlineExceeded)
+	requireError(t, ErrUnableToGetChainID, ValidateNetworkIdentifier(ctx, oc, testNetworkIdentifier))
+}
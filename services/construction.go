@@ -18,6 +18,7 @@ import (
 	"github.com/oasisprotocol/oasis-core/go/consensus/api/transaction"
 	staking "github.com/oasisprotocol/oasis-core/go/staking/api"
 
+	"github.com/oasisprotocol/oasis-core-rosetta-gateway/common"
 	"github.com/oasisprotocol/oasis-core-rosetta-gateway/oasis"
 )
 
@@ -29,6 +30,11 @@ const OptionsIDKey = "id"
 // ConstructionMetadataResponse that specifies the next valid nonce.
 const NonceKey = "nonce"
 
+// SignatureValidKey is the name of the key in the Metadata map inside a
+// ConstructionParseResponse of a signed transaction that specifies whether
+// its signature is valid in the current chain context.
+const SignatureValidKey = "signature_valid"
+
 // UnsignedTransaction is a transaction with the account that would sign it.
 type UnsignedTransaction struct {
 	Tx     cbor.RawMessage `json:"tx"`
@@ -39,12 +45,31 @@ var loggerCons = logging.GetLogger("services/construction")
 
 type constructionAPIService struct {
 	oasisClient oasis.Client
+	mempool     *MempoolCache
+	preflight   *SubmitPreflight
+	nonces      *NonceManager
 }
 
 // NewConstructionAPIService creates a new instance of an ConstructionAPIService.
-func NewConstructionAPIService(oasisClient oasis.Client) server.ConstructionAPIServicer {
+//
+// The submit preflight is optional (nil to disable) and is used to check
+// signed transactions against the latest state before submitting them.  The
+// nonce manager is optional (nil to disable) and is used to return the next
+// free nonce, taking pending transactions into account, from
+// /construction/metadata.  The mempool cache is only used by the submit
+// preflight and the nonce manager and may be nil without them (e.g. in
+// offline mode).
+func NewConstructionAPIService(
+	oasisClient oasis.Client,
+	mempool *MempoolCache,
+	preflight *SubmitPreflight,
+	nonces *NonceManager,
+) server.ConstructionAPIServicer {
 	return &constructionAPIService{
 		oasisClient: oasisClient,
+		mempool:     mempool,
+		preflight:   preflight,
+		nonces:      nonces,
 	}
 }
 
@@ -53,30 +78,32 @@ func (s *constructionAPIService) ConstructionMetadata(
 	ctx context.Context,
 	request *types.ConstructionMetadataRequest,
 ) (*types.ConstructionMetadataResponse, *types.Error) {
+	logger := common.LoggerWithRequestID(ctx, loggerCons)
+
 	if s.oasisClient == nil {
-		loggerCons.Error("ConstructionMetadata: not available in offline mode")
+		logger.Error("ConstructionMetadata: not available in offline mode")
 		return nil, ErrNotAvailableInOfflineMode
 	}
 
 	terr := ValidateNetworkIdentifier(ctx, s.oasisClient, request.NetworkIdentifier)
 	if terr != nil {
-		loggerCons.Error("ConstructionMetadata: network validation failed", "err", terr.Message)
+		logger.Error("ConstructionMetadata: network validation failed", "err", terr.Message)
 		return nil, terr
 	}
 
 	// Get the account ID field from the Options object.
 	if request.Options == nil {
-		loggerCons.Error("ConstructionMetadata: missing options")
+		logger.Error("ConstructionMetadata: missing options")
 		return nil, ErrInvalidAccountAddress
 	}
 	idRaw, ok := request.Options[OptionsIDKey]
 	if !ok {
-		loggerCons.Error("ConstructionMetadata: account ID field not given")
+		logger.Error("ConstructionMetadata: account ID field not given")
 		return nil, ErrInvalidAccountAddress
 	}
 	idString, ok := idRaw.(string)
 	if !ok {
-		loggerCons.Error("ConstructionMetadata: malformed account ID field")
+		logger.Error("ConstructionMetadata: malformed account ID field")
 		return nil, ErrInvalidAccountAddress
 	}
 
@@ -84,14 +111,26 @@ func (s *constructionAPIService) ConstructionMetadata(
 	var owner staking.Address
 	err := owner.UnmarshalText([]byte(idString))
 	if err != nil {
-		loggerCons.Error("ConstructionMetadata: invalid account ID", "err", err)
+		logger.Error("ConstructionMetadata: invalid account ID", "err", err)
 		return nil, ErrInvalidAccountAddress
 	}
 
-	nonce, err := s.oasisClient.GetNextNonce(ctx, owner, oasis.LatestHeight)
+	height, err := s.oasisClient.ResolveHeight(ctx, oasis.LatestHeight)
 	if err != nil {
-		loggerCons.Error("ConstructionMetadata: unable to get next nonce",
+		logger.Error("ConstructionMetadata: unable to get latest block", "err", err)
+		return nil, ErrUnableToGetLatestBlk
+	}
+
+	var nonce uint64
+	if s.nonces != nil {
+		nonce, err = s.nonces.next(ctx, s.oasisClient, s.mempool, owner, height)
+	} else {
+		nonce, err = s.oasisClient.GetNextNonce(ctx, owner, height)
+	}
+	if err != nil {
+		logger.Error("ConstructionMetadata: unable to get next nonce",
 			"account_id", owner.String(),
+			"height", height,
 			"err", err,
 		)
 		return nil, ErrUnableToGetNextNonce
@@ -105,8 +144,10 @@ func (s *constructionAPIService) ConstructionMetadata(
 		Metadata: md,
 	}
 
-	jr, _ := json.Marshal(resp)
-	loggerCons.Debug("ConstructionMetadata OK", "response", jr)
+	if debugEnabled() {
+		jr, _ := json.Marshal(resp)
+		logger.Debug("ConstructionMetadata OK", "response", jr)
+	}
 
 	return resp, nil
 }
@@ -116,34 +157,47 @@ func (s *constructionAPIService) ConstructionSubmit(
 	ctx context.Context,
 	request *types.ConstructionSubmitRequest,
 ) (*types.TransactionIdentifierResponse, *types.Error) {
+	logger := common.LoggerWithRequestID(ctx, loggerCons)
+
 	if s.oasisClient == nil {
-		loggerCons.Error("ConstructionSubmit: not available in offline mode")
+		logger.Error("ConstructionSubmit: not available in offline mode")
 		return nil, ErrNotAvailableInOfflineMode
 	}
 
 	terr := ValidateNetworkIdentifier(ctx, s.oasisClient, request.NetworkIdentifier)
 	if terr != nil {
-		loggerCons.Error("ConstructionSubmit: network validation failed", "err", terr.Message)
+		logger.Error("ConstructionSubmit: network validation failed", "err", terr.Message)
 		return nil, terr
 	}
 
 	tx, err := DecodeSignedTransaction(request.SignedTransaction)
 	if err != nil {
-		loggerCons.Error("ConstructionSubmit: failed to unmarshal signed transaction",
+		logger.Error("ConstructionSubmit: failed to unmarshal signed transaction",
 			"err", err,
 			"signed_tx", request.SignedTransaction,
 		)
 		return nil, ErrMalformedValue
 	}
 
+	if s.preflight != nil {
+		if terr = s.preflight.check(ctx, s.oasisClient, s.mempool, tx); terr != nil {
+			return nil, terr
+		}
+	}
+
 	if err := s.oasisClient.SubmitTxNoWait(ctx, tx); err != nil {
-		loggerCons.Error("ConstructionSubmit: SubmitTxNoWait failed", "err", err)
+		logger.Error("ConstructionSubmit: SubmitTxNoWait failed", "err", err)
 		if errors.Is(err, consensus.ErrDuplicateTx) {
-			loggerCons.Info("ConstructionSubmit: treating ErrDuplicateTx as success")
+			logger.Info("ConstructionSubmit: treating ErrDuplicateTx as success")
 		} else {
-			return nil, NewDetailedError(ErrUnableToSubmitTx, err)
+			return nil, NewSubmitError(err)
 		}
 	}
+	if s.mempool != nil {
+		// Make the transaction visible to the next preflight check and nonce
+		// query of its signer.
+		s.mempool.invalidate()
+	}
 
 	resp := &types.TransactionIdentifierResponse{
 		TransactionIdentifier: &types.TransactionIdentifier{
@@ -151,8 +205,10 @@ func (s *constructionAPIService) ConstructionSubmit(
 		},
 	}
 
-	jr, _ := json.Marshal(resp)
-	loggerCons.Debug("ConstructionSubmit OK", "response", jr)
+	if debugEnabled() {
+		jr, _ := json.Marshal(resp)
+		logger.Debug("ConstructionSubmit OK", "response", jr)
+	}
 
 	return resp, nil
 }
@@ -162,15 +218,17 @@ func (s *constructionAPIService) ConstructionHash(
 	ctx context.Context,
 	request *types.ConstructionHashRequest,
 ) (*types.TransactionIdentifierResponse, *types.Error) {
+	logger := common.LoggerWithRequestID(ctx, loggerCons)
+
 	terr := ValidateNetworkIdentifier(ctx, s.oasisClient, request.NetworkIdentifier)
 	if terr != nil {
-		loggerCons.Error("ConstructionHash: network validation failed", "err", terr.Message)
+		logger.Error("ConstructionHash: network validation failed", "err", terr.Message)
 		return nil, terr
 	}
 
 	tx, err := DecodeSignedTransaction(request.SignedTransaction)
 	if err != nil {
-		loggerCons.Error("ConstructionSubmit: failed to unmarshal signed transaction",
+		logger.Error("ConstructionSubmit: failed to unmarshal signed transaction",
 			"err", err,
 			"signed_tx", request.SignedTransaction,
 		)
@@ -183,8 +241,10 @@ func (s *constructionAPIService) ConstructionHash(
 		},
 	}
 
-	jr, _ := json.Marshal(resp)
-	loggerCons.Debug("ConstructionHash OK", "response", jr)
+	if debugEnabled() {
+		jr, _ := json.Marshal(resp)
+		logger.Debug("ConstructionHash OK", "response", jr)
+	}
 
 	return resp, nil
 }
@@ -194,15 +254,17 @@ func (s *constructionAPIService) ConstructionDerive(
 	ctx context.Context,
 	request *types.ConstructionDeriveRequest,
 ) (*types.ConstructionDeriveResponse, *types.Error) {
+	logger := common.LoggerWithRequestID(ctx, loggerCons)
+
 	terr := ValidateNetworkIdentifier(ctx, s.oasisClient, request.NetworkIdentifier)
 	if terr != nil {
-		loggerCons.Error("ConstructionDerive: network validation failed", "err", terr.Message)
+		logger.Error("ConstructionDerive: network validation failed", "err", terr.Message)
 		return nil, terr
 	}
 
 	var pk signature.PublicKey
 	if err := pk.UnmarshalBinary(request.PublicKey.Bytes); err != nil {
-		loggerCons.Error("ConstructionDerive: malformed public key",
+		logger.Error("ConstructionDerive: malformed public key",
 			"public_key_hex_bytes", hex.EncodeToString(request.PublicKey.Bytes),
 			"err", err,
 		)
@@ -215,8 +277,10 @@ func (s *constructionAPIService) ConstructionDerive(
 		},
 	}
 
-	jr, _ := json.Marshal(resp)
-	loggerCons.Debug("ConstructionDerive OK", "response", jr)
+	if debugEnabled() {
+		jr, _ := json.Marshal(resp)
+		logger.Debug("ConstructionDerive OK", "response", jr)
+	}
 
 	return resp, nil
 }
@@ -226,9 +290,11 @@ func (s *constructionAPIService) ConstructionCombine(
 	ctx context.Context,
 	request *types.ConstructionCombineRequest,
 ) (*types.ConstructionCombineResponse, *types.Error) {
+	logger := common.LoggerWithRequestID(ctx, loggerCons)
+
 	terr := ValidateNetworkIdentifier(ctx, s.oasisClient, request.NetworkIdentifier)
 	if terr != nil {
-		loggerCons.Error("ConstructionCombine: network validation failed", "err", terr.Message)
+		logger.Error("ConstructionCombine: network validation failed", "err", terr.Message)
 		return nil, terr
 	}
 
@@ -239,14 +305,14 @@ func (s *constructionAPIService) ConstructionCombine(
 
 	ut, err := DecodeUnsignedTransaction(request.UnsignedTransaction)
 	if err != nil {
-		loggerCons.Error("ConstructionCombine: unmarshal unsigned transaction",
+		logger.Error("ConstructionCombine: unmarshal unsigned transaction",
 			"unsigned_transaction", request.UnsignedTransaction,
 			"err", err,
 		)
 		return nil, ErrMalformedValue
 	}
 	if len(request.Signatures) != 1 {
-		loggerCons.Error("ConstructionCombine: need exactly one signature",
+		logger.Error("ConstructionCombine: need exactly one signature",
 			"len_signatures", len(request.Signatures),
 		)
 		return nil, ErrMalformedValue
@@ -254,7 +320,7 @@ func (s *constructionAPIService) ConstructionCombine(
 	sig := request.Signatures[0]
 	var pk signature.PublicKey
 	if err := pk.UnmarshalBinary(sig.PublicKey.Bytes); err != nil {
-		loggerCons.Error("ConstructionCombine: malformed signature public key",
+		logger.Error("ConstructionCombine: malformed signature public key",
 			"public_key_hex_bytes", hex.EncodeToString(sig.PublicKey.Bytes),
 			"err", err,
 		)
@@ -262,12 +328,26 @@ func (s *constructionAPIService) ConstructionCombine(
 	}
 	var rs signature.RawSignature
 	if err := rs.UnmarshalBinary(sig.Bytes); err != nil {
-		loggerCons.Error("ConstructionCombine: malformed signature",
+		logger.Error("ConstructionCombine: malformed signature",
 			"signature_hex_bytes", hex.EncodeToString(sig.Bytes),
 			"err", err,
 		)
 		return nil, ErrMalformedValue
 	}
+	if signer := StringFromAddress(staking.NewAddress(pk)); signer != ut.Signer {
+		logger.Error("ConstructionCombine: signature public key doesn't match the signer",
+			"public_key_address", signer,
+			"signer", ut.Signer,
+		)
+		return nil, ErrSignerMismatch
+	}
+	if !pk.Verify(transaction.SignatureContext, ut.Tx, rs[:]) {
+		logger.Error("ConstructionCombine: invalid signature",
+			"public_key_hex_bytes", hex.EncodeToString(sig.PublicKey.Bytes),
+			"signature_hex_bytes", hex.EncodeToString(sig.Bytes),
+		)
+		return nil, ErrInvalidSignature
+	}
 	tx := transaction.SignedTransaction{
 		Signed: signature.Signed{
 			Blob: ut.Tx,
@@ -282,8 +362,10 @@ func (s *constructionAPIService) ConstructionCombine(
 		SignedTransaction: base64.StdEncoding.EncodeToString(cbor.Marshal(tx)),
 	}
 
-	jr, _ := json.Marshal(resp)
-	loggerCons.Debug("ConstructionCombine OK", "response", jr)
+	if debugEnabled() {
+		jr, _ := json.Marshal(resp)
+		logger.Debug("ConstructionCombine OK", "response", jr)
+	}
 
 	return resp, nil
 }
@@ -293,9 +375,11 @@ func (s *constructionAPIService) ConstructionParse(
 	ctx context.Context,
 	request *types.ConstructionParseRequest,
 ) (*types.ConstructionParseResponse, *types.Error) {
+	logger := common.LoggerWithRequestID(ctx, loggerCons)
+
 	terr := ValidateNetworkIdentifier(ctx, s.oasisClient, request.NetworkIdentifier)
 	if terr != nil {
-		loggerCons.Error("ConstructionParse: network validation failed", "err", terr.Message)
+		logger.Error("ConstructionParse: network validation failed", "err", terr.Message)
 		return nil, terr
 	}
 
@@ -306,7 +390,7 @@ func (s *constructionAPIService) ConstructionParse(
 
 	rawTx, err := base64.StdEncoding.DecodeString(request.Transaction)
 	if err != nil {
-		loggerCons.Error("ConstructionParse: base64 decoding failed",
+		logger.Error("ConstructionParse: base64 decoding failed",
 			"err", err,
 		)
 		return nil, ErrMalformedValue
@@ -315,23 +399,27 @@ func (s *constructionAPIService) ConstructionParse(
 	var tx transaction.Transaction
 	var from string
 	var signers []*types.AccountIdentifier
+	var sigValid bool
 	switch request.Signed {
 	case true:
 		var signedTx transaction.SignedTransaction
 		if err = cbor.Unmarshal(rawTx, &signedTx); err != nil {
-			loggerCons.Error("ConstructionParse: signed transaction unmarshal",
+			logger.Error("ConstructionParse: signed transaction unmarshal",
 				"src", request.Transaction,
 				"err", err,
 			)
 			return nil, ErrMalformedValue
 		}
-		if err = signedTx.Open(&tx); err != nil {
-			loggerCons.Error("ConstructionParse: signed transaction open",
+		// Don't use Open, so that transactions with invalid signatures can
+		// still be parsed, and report the signature's validity instead.
+		if err = cbor.Unmarshal(signedTx.Blob, &tx); err != nil {
+			logger.Error("ConstructionParse: inner signed transaction unmarshal",
 				"signed_transaction", signedTx,
 				"err", err,
 			)
 			return nil, ErrMalformedValue
 		}
+		sigValid = signedTx.Signature.Verify(transaction.SignatureContext, signedTx.Blob)
 		from = StringFromAddress(staking.NewAddress(signedTx.Signature.PublicKey))
 		signers = []*types.AccountIdentifier{
 			&types.AccountIdentifier{
@@ -341,14 +429,14 @@ func (s *constructionAPIService) ConstructionParse(
 	case false:
 		var unsignedTx UnsignedTransaction
 		if err = cbor.Unmarshal(rawTx, &unsignedTx); err != nil {
-			loggerCons.Error("ConstructionParse: unsigned transaction unmarshal",
+			logger.Error("ConstructionParse: unsigned transaction unmarshal",
 				"src", request.Transaction,
 				"err", err,
 			)
 			return nil, ErrMalformedValue
 		}
 		if err = cbor.Unmarshal(unsignedTx.Tx, &tx); err != nil {
-			loggerCons.Error("ConstructionParse: inner unsigned transaction unmarshal",
+			logger.Error("ConstructionParse: inner unsigned transaction unmarshal",
 				"err", err,
 			)
 			return nil, ErrMalformedValue
@@ -356,25 +444,29 @@ func (s *constructionAPIService) ConstructionParse(
 		from = unsignedTx.Signer
 	}
 
-	om := newTransactionToOperationMapper(&tx, from, "", []*types.Operation{})
-	om.EmitFeeOps()
-	if err := om.EmitTxOps(); err != nil {
-		loggerCons.Error("ConstructionParse: malformed transaction",
+	ops, err := TransactionToOperations(&tx, from)
+	if err != nil {
+		logger.Error("ConstructionParse: malformed transaction",
 			"err", err,
 		)
 		return nil, ErrMalformedValue
 	}
 
 	resp := &types.ConstructionParseResponse{
-		Operations:               om.Operations(),
+		Operations:               ops,
 		AccountIdentifierSigners: signers,
 		Metadata: map[string]interface{}{
 			NonceKey: tx.Nonce,
 		},
 	}
+	if request.Signed {
+		resp.Metadata[SignatureValidKey] = sigValid
+	}
 
-	jr, _ := json.Marshal(resp)
-	loggerCons.Debug("ConstructionParse OK", "response", jr)
+	if debugEnabled() {
+		jr, _ := json.Marshal(resp)
+		logger.Debug("ConstructionParse OK", "response", jr)
+	}
 
 	return resp, nil
 }
@@ -384,9 +476,11 @@ func (s *constructionAPIService) ConstructionPreprocess(
 	ctx context.Context,
 	request *types.ConstructionPreprocessRequest,
 ) (*types.ConstructionPreprocessResponse, *types.Error) {
+	logger := common.LoggerWithRequestID(ctx, loggerCons)
+
 	terr := ValidateNetworkIdentifier(ctx, s.oasisClient, request.NetworkIdentifier)
 	if terr != nil {
-		loggerCons.Error("ConstructionPreprocess: network validation failed", "err", terr.Message)
+		logger.Error("ConstructionPreprocess: network validation failed", "err", terr.Message)
 		return nil, terr
 	}
 
@@ -399,7 +493,7 @@ func (s *constructionAPIService) ConstructionPreprocess(
 	om := newOperationToTransactionMapper(request.Operations)
 	signWithAddr, _, err := om.GetTransaction()
 	if err != nil {
-		loggerCons.Error("ConstructionPreprocess: bad operations",
+		logger.Error("ConstructionPreprocess: bad operations",
 			"err", err,
 		)
 		return nil, NewDetailedError(ErrMalformedValue, err)
@@ -411,8 +505,10 @@ func (s *constructionAPIService) ConstructionPreprocess(
 		},
 	}
 
-	jr, _ := json.Marshal(resp)
-	loggerCons.Debug("ConstructionPreprocess OK", "response", jr)
+	if debugEnabled() {
+		jr, _ := json.Marshal(resp)
+		logger.Debug("ConstructionPreprocess OK", "response", jr)
+	}
 
 	return resp, nil
 }
@@ -422,9 +518,11 @@ func (s *constructionAPIService) ConstructionPayloads(
 	ctx context.Context,
 	request *types.ConstructionPayloadsRequest,
 ) (*types.ConstructionPayloadsResponse, *types.Error) {
+	logger := common.LoggerWithRequestID(ctx, loggerCons)
+
 	terr := ValidateNetworkIdentifier(ctx, s.oasisClient, request.NetworkIdentifier)
 	if terr != nil {
-		loggerCons.Error("ConstructionPayloads: network validation failed", "err", terr.Message)
+		logger.Error("ConstructionPayloads: network validation failed", "err", terr.Message)
 		return nil, terr
 	}
 
@@ -442,12 +540,12 @@ func (s *constructionAPIService) ConstructionPayloads(
 
 	nonceRaw, ok := request.Metadata[NonceKey]
 	if !ok {
-		loggerCons.Error("ConstructionPayloads: nonce metadata not given")
+		logger.Error("ConstructionPayloads: nonce metadata not given")
 		return nil, ErrMalformedValue
 	}
 	nonceF64, ok := nonceRaw.(float64)
 	if !ok {
-		loggerCons.Error("ConstructionPayloads: malformed nonce metadata")
+		logger.Error("ConstructionPayloads: malformed nonce metadata")
 		return nil, ErrMalformedValue
 	}
 	nonce := uint64(nonceF64)
@@ -455,7 +553,7 @@ func (s *constructionAPIService) ConstructionPayloads(
 	om := newOperationToTransactionMapper(request.Operations)
 	signWithAddr, tx, err := om.GetTransaction()
 	if err != nil {
-		loggerCons.Error("ConstructionPayloads: bad operations",
+		logger.Error("ConstructionPayloads: bad operations",
 			"err", err,
 		)
 		return nil, NewDetailedError(ErrMalformedValue, err)
@@ -469,7 +567,7 @@ func (s *constructionAPIService) ConstructionPayloads(
 
 	utCBOR := cbor.Marshal(ut)
 	if err != nil {
-		loggerCons.Error("ConstructionPayloads: marshal unsigned transaction",
+		logger.Error("ConstructionPayloads: marshal unsigned transaction",
 			"unsigned_transaction", ut,
 			"err", err,
 		)
@@ -477,7 +575,7 @@ func (s *constructionAPIService) ConstructionPayloads(
 	}
 	txMessage, err := signature.PrepareSignerMessage(transaction.SignatureContext, ut.Tx)
 	if err != nil {
-		loggerCons.Error("ConstructionPayloads: PrepareSignerMessage",
+		logger.Error("ConstructionPayloads: PrepareSignerMessage",
 			"signature_context", transaction.SignatureContext,
 			"tx_hex", hex.EncodeToString(ut.Tx),
 			"err", err,
@@ -497,8 +595,10 @@ func (s *constructionAPIService) ConstructionPayloads(
 		},
 	}
 
-	jr, _ := json.Marshal(resp)
-	loggerCons.Debug("ConstructionPayloads OK", "response", jr)
+	if debugEnabled() {
+		jr, _ := json.Marshal(resp)
+		logger.Debug("ConstructionPayloads OK", "response", jr)
+	}
 
 	return resp, nil
 }
@@ -0,0 +1,452 @@
+package services
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"strconv"
+	"testing"
+
+	"github.com/coinbase/rosetta-sdk-go/types"
+	"github.com/oasisprotocol/ed25519"
+	"github.com/oasisprotocol/oasis-core/go/common/cbor"
+	"github.com/oasisprotocol/oasis-core/go/common/crypto/signature"
+	"github.com/oasisprotocol/oasis-core/go/common/crypto/signature/signers/memory"
+	"github.com/oasisprotocol/oasis-core/go/common/quantity"
+	"github.com/oasisprotocol/oasis-core/go/consensus/api/transaction"
+	staking "github.com/oasisprotocol/oasis-core/go/staking/api"
+
+	"github.com/oasisprotocol/oasis-core-rosetta-gateway/oasis"
+	"github.com/oasisprotocol/oasis-core-rosetta-gateway/oasis/mock"
+)
+
+// newTestTransferOps returns the operations of a transfer of the given amount
+// from the test account to the other test account, paying the given fee.
+func newTestTransferOps(amount, fee string) []*types.Operation {
+	return []*types.Operation{
+		{
+			OperationIdentifier: &types.OperationIdentifier{Index: 0},
+			Type:                OpTransfer,
+			Account:             &types.AccountIdentifier{Address: testAddrStr},
+			Amount:              &types.Amount{Value: "-" + fee, Currency: OasisCurrency},
+		},
+		{
+			OperationIdentifier: &types.OperationIdentifier{Index: 1},
+			RelatedOperations:   []*types.OperationIdentifier{{Index: 0}},
+			Type:                OpTransfer,
+			Account:             &types.AccountIdentifier{Address: StringFromAddress(staking.FeeAccumulatorAddress)},
+			Amount:              &types.Amount{Value: fee, Currency: OasisCurrency},
+		},
+		{
+			OperationIdentifier: &types.OperationIdentifier{Index: 2},
+			Type:                OpTransfer,
+			Account:             &types.AccountIdentifier{Address: testAddrStr},
+			Amount:              &types.Amount{Value: "-" + amount, Currency: OasisCurrency},
+		},
+		{
+			OperationIdentifier: &types.OperationIdentifier{Index: 3},
+			RelatedOperations:   []*types.OperationIdentifier{{Index: 2}},
+			Type:                OpTransfer,
+			Account:             &types.AccountIdentifier{Address: StringFromAddress(testOther)},
+			Amount:              &types.Amount{Value: amount, Currency: OasisCurrency},
+		},
+	}
+}
+
+// jsonRoundTrip returns the given metadata as it would be received by the
+// server after a JSON round trip.
+func jsonRoundTrip(t *testing.T, md map[string]interface{}) map[string]interface{} {
+	t.Helper()
+
+	raw, err := json.Marshal(md)
+	if err != nil {
+		t.Fatalf("unable to marshal metadata: %v", err)
+	}
+	var result map[string]interface{}
+	if err = json.Unmarshal(raw, &result); err != nil {
+		t.Fatalf("unable to unmarshal metadata: %v", err)
+	}
+	return result
+}
+
+func TestConstructionFlow(t *testing.T) {
+	ctx := context.Background()
+	oc := newTestClient()
+	s := NewConstructionAPIService(oc, nil, nil, nil)
+	ops := newTestTransferOps("1000", "10")
+	pk := testSigner.Public()
+
+	// Derive.
+	deriveResp, err := s.ConstructionDerive(ctx, &types.ConstructionDeriveRequest{
+		NetworkIdentifier: testNetworkIdentifier,
+		PublicKey: &types.PublicKey{
+			Bytes:     pk[:],
+			CurveType: types.Edwards25519,
+		},
+	})
+	requireNoError(t, err)
+	if deriveResp.AccountIdentifier.Address != testAddrStr {
+		t.Fatalf("unexpected derived address: %s", deriveResp.AccountIdentifier.Address)
+	}
+
+	// Preprocess.
+	preprocessResp, err := s.ConstructionPreprocess(ctx, &types.ConstructionPreprocessRequest{
+		NetworkIdentifier: testNetworkIdentifier,
+		Operations:        ops,
+	})
+	requireNoError(t, err)
+	if preprocessResp.Options[OptionsIDKey] != testAddrStr {
+		t.Fatalf("unexpected preprocess options: %v", preprocessResp.Options)
+	}
+
+	// Metadata.
+	metadataResp, err := s.ConstructionMetadata(ctx, &types.ConstructionMetadataRequest{
+		NetworkIdentifier: testNetworkIdentifier,
+		Options:           preprocessResp.Options,
+	})
+	requireNoError(t, err)
+	if metadataResp.Metadata[NonceKey] != uint64(0) {
+		t.Fatalf("unexpected nonce: %v", metadataResp.Metadata[NonceKey])
+	}
+
+	// Payloads.
+	payloadsResp, err := s.ConstructionPayloads(ctx, &types.ConstructionPayloadsRequest{
+		NetworkIdentifier: testNetworkIdentifier,
+		Operations:        ops,
+		Metadata:          jsonRoundTrip(t, metadataResp.Metadata),
+	})
+	requireNoError(t, err)
+	if len(payloadsResp.Payloads) != 1 || payloadsResp.Payloads[0].AccountIdentifier.Address != testAddrStr {
+		t.Fatalf("unexpected payloads: %v", types.PrettyPrintStruct(payloadsResp.Payloads))
+	}
+
+	// Parse unsigned.
+	parseResp, err := s.ConstructionParse(ctx, &types.ConstructionParseRequest{
+		NetworkIdentifier: testNetworkIdentifier,
+		Signed:            false,
+		Transaction:       payloadsResp.UnsignedTransaction,
+	})
+	requireNoError(t, err)
+	if len(parseResp.Operations) != 4 || len(parseResp.AccountIdentifierSigners) != 0 {
+		t.Fatalf("unexpected parsed unsigned transaction: %v", types.PrettyPrintStruct(parseResp))
+	}
+
+	// Sign and combine.
+	privKey := ed25519.PrivateKey(testSigner.(signature.UnsafeSigner).UnsafeBytes())
+	sig := ed25519.Sign(privKey, payloadsResp.Payloads[0].Bytes)
+	combineResp, err := s.ConstructionCombine(ctx, &types.ConstructionCombineRequest{
+		NetworkIdentifier:   testNetworkIdentifier,
+		UnsignedTransaction: payloadsResp.UnsignedTransaction,
+		Signatures: []*types.Signature{
+			{
+				SigningPayload: payloadsResp.Payloads[0],
+				PublicKey: &types.PublicKey{
+					Bytes:     pk[:],
+					CurveType: types.Edwards25519,
+				},
+				SignatureType: types.Ed25519,
+				Bytes:         sig,
+			},
+		},
+	})
+	requireNoError(t, err)
+
+	// Parse signed.
+	parseResp, err = s.ConstructionParse(ctx, &types.ConstructionParseRequest{
+		NetworkIdentifier: testNetworkIdentifier,
+		Signed:            true,
+		Transaction:       combineResp.SignedTransaction,
+	})
+	requireNoError(t, err)
+	if len(parseResp.AccountIdentifierSigners) != 1 || parseResp.AccountIdentifierSigners[0].Address != testAddrStr {
+		t.Fatalf("unexpected signers: %v", types.PrettyPrintStruct(parseResp.AccountIdentifierSigners))
+	}
+	if parseResp.Metadata[SignatureValidKey] != true {
+		t.Fatalf("unexpected signature validity: %v", parseResp.Metadata[SignatureValidKey])
+	}
+
+	// Hash.
+	hashResp, err := s.ConstructionHash(ctx, &types.ConstructionHashRequest{
+		NetworkIdentifier: testNetworkIdentifier,
+		SignedTransaction: combineResp.SignedTransaction,
+	})
+	requireNoError(t, err)
+
+	// Submit.
+	submitResp, err := s.ConstructionSubmit(ctx, &types.ConstructionSubmitRequest{
+		NetworkIdentifier: testNetworkIdentifier,
+		SignedTransaction: combineResp.SignedTransaction,
+	})
+	requireNoError(t, err)
+	if submitResp.TransactionIdentifier.Hash != hashResp.TransactionIdentifier.Hash {
+		t.Fatalf("submitted hash %s differs from computed hash %s",
+			submitResp.TransactionIdentifier.Hash, hashResp.TransactionIdentifier.Hash,
+		)
+	}
+
+	// Resubmitting a transaction that is already in the mempool succeeds.
+	_, err = s.ConstructionSubmit(ctx, &types.ConstructionSubmitRequest{
+		NetworkIdentifier: testNetworkIdentifier,
+		SignedTransaction: combineResp.SignedTransaction,
+	})
+	requireNoError(t, err)
+
+	// Once committed, the transfer shows up in the state.
+	oc.CommitBlock()
+	act, aerr := oc.GetAccount(ctx, oasis.LatestHeight, testOther)
+	if aerr != nil {
+		t.Fatalf("unable to get account: %v", aerr)
+	}
+	if act.General.Balance.String() != "1000" {
+		t.Fatalf("unexpected recipient balance: %s", act.General.Balance)
+	}
+	metadataResp, err = s.ConstructionMetadata(ctx, &types.ConstructionMetadataRequest{
+		NetworkIdentifier: testNetworkIdentifier,
+		Options:           preprocessResp.Options,
+	})
+	requireNoError(t, err)
+	if metadataResp.Metadata[NonceKey] != uint64(1) {
+		t.Fatalf("unexpected nonce after commit: %v", metadataResp.Metadata[NonceKey])
+	}
+}
+
+func TestConstructionSubmitInvalidNonce(t *testing.T) {
+	ctx := context.Background()
+	oc := newTestClient()
+	s := NewConstructionAPIService(oc, nil, nil, nil)
+
+	tx := signTestTx(t, 5, 10, staking.MethodTransfer, newTestTransfer(100))
+	_, err := s.ConstructionSubmit(ctx, &types.ConstructionSubmitRequest{
+		NetworkIdentifier: testNetworkIdentifier,
+		SignedTransaction: encodeTestTx(tx),
+	})
+	requireError(t, ErrInvalidNonce, err)
+	if cause := err.Details[CauseKey].(map[string]interface{}); cause[MsgKey] != transaction.ErrInvalidNonce.Error() {
+		t.Fatalf("unexpected error cause: %v", cause)
+	}
+
+	_, err = s.ConstructionSubmit(ctx, &types.ConstructionSubmitRequest{
+		NetworkIdentifier: testNetworkIdentifier,
+		SignedTransaction: "not base64",
+	})
+	requireError(t, ErrMalformedValue, err)
+}
+
+func TestConstructionSubmitPreflight(t *testing.T) {
+	ctx := context.Background()
+	oc := newTestClient()
+	s := NewConstructionAPIService(oc, NewMempoolCache(oc), &SubmitPreflight{MinGasPrice: *quantity.NewFromUint64(1)}, nil)
+
+	submit := func(tx *transaction.SignedTransaction) *types.Error {
+		_, err := s.ConstructionSubmit(ctx, &types.ConstructionSubmitRequest{
+			NetworkIdentifier: testNetworkIdentifier,
+			SignedTransaction: encodeTestTx(tx),
+		})
+		return err
+	}
+
+	// Gas price below the minimum.
+	err := submit(signTestTx(t, 0, uint64(DefaultGas)/2, staking.MethodTransfer, newTestTransfer(100)))
+	requireError(t, ErrGasPriceTooLow, err)
+	if err.Details[PreflightGasPriceKey] != "0" || err.Details[PreflightMinGasPriceKey] != "1" {
+		t.Fatalf("unexpected error details: %v", err.Details)
+	}
+
+	// Nonce other than the next one.
+	err = submit(signTestTx(t, 5, uint64(DefaultGas), staking.MethodTransfer, newTestTransfer(100)))
+	requireError(t, ErrInvalidNonce, err)
+	if err.Details[PreflightNonceKey] != uint64(5) || err.Details[PreflightExpectedNonceKey] != uint64(0) {
+		t.Fatalf("unexpected error details: %v", err.Details)
+	}
+
+	// Balance that covers the amount, but not the fee.
+	err = submit(signTestTx(t, 0, uint64(DefaultGas), staking.MethodTransfer, newTestTransfer(testGeneralBalance)))
+	requireError(t, ErrInsufficientBalance, err)
+	if err.Details[PreflightBalanceKey] != "1000000" || err.Details[PreflightRequiredKey] != "1010000" {
+		t.Fatalf("unexpected error details: %v", err.Details)
+	}
+	err = submit(signTestTx(t, 0, uint64(DefaultGas), staking.MethodBurn, &staking.Burn{
+		Amount: *quantity.NewFromUint64(testGeneralBalance),
+	}))
+	requireError(t, ErrInsufficientBalance, err)
+
+	// Invalid signature.
+	tx := signTestTx(t, 0, uint64(DefaultGas), staking.MethodTransfer, newTestTransfer(100))
+	tx.Signature.Signature[0] ^= 0xff
+	requireError(t, ErrInvalidSignature, submit(tx))
+
+	// Failing state queries are retriable.
+	oc.SetError(mock.MethodGetNextNonce, context.DeadlineExceeded)
+	err = submit(signTestTx(t, 0, uint64(DefaultGas), staking.MethodTransfer, newTestTransfer(100)))
+	requireError(t, ErrUnableToGetNextNonce, err)
+	oc.SetError(mock.MethodGetNextNonce, nil)
+
+	requireNoError(t, submit(signTestTx(t, 0, uint64(DefaultGas), staking.MethodTransfer, newTestTransfer(100))))
+
+	// Transactions may follow the signer's transactions in the mempool.
+	requireNoError(t, submit(signTestTx(t, 1, uint64(DefaultGas), staking.MethodTransfer, newTestTransfer(100))))
+	err = submit(signTestTx(t, 3, uint64(DefaultGas), staking.MethodTransfer, newTestTransfer(100)))
+	requireError(t, ErrInvalidNonce, err)
+	if err.Details[PreflightNonceKey] != uint64(3) || err.Details[PreflightExpectedNonceKey] != uint64(2) {
+		t.Fatalf("unexpected error details: %v", err.Details)
+	}
+
+	// What the signer's transactions in the mempool debit isn't available.
+	pendingDebit := 2 * (100 + uint64(DefaultGas))
+	err = submit(signTestTx(t, 2, uint64(DefaultGas), staking.MethodTransfer,
+		newTestTransfer(testGeneralBalance-pendingDebit-uint64(DefaultGas)+1)))
+	requireError(t, ErrInsufficientBalance, err)
+	if err.Details[PreflightPendingDebitKey] != strconv.FormatUint(pendingDebit, 10) {
+		t.Fatalf("unexpected error details: %v", err.Details)
+	}
+	requireNoError(t, submit(signTestTx(t, 2, uint64(DefaultGas), staking.MethodTransfer,
+		newTestTransfer(testGeneralBalance-pendingDebit-uint64(DefaultGas)))))
+
+	// Once committed, nonces below the committed next nonce are rejected.
+	oc.CommitBlock()
+	err = submit(signTestTx(t, 2, uint64(DefaultGas), staking.MethodTransfer, newTestTransfer(100)))
+	requireError(t, ErrInvalidNonce, err)
+	if err.Details[PreflightExpectedNonceKey] != uint64(3) {
+		t.Fatalf("unexpected error details: %v", err.Details)
+	}
+}
+
+func TestConstructionOfflineMode(t *testing.T) {
+	ctx := context.Background()
+	s := NewConstructionAPIService(nil, nil, nil, nil)
+
+	_, err := s.ConstructionMetadata(ctx, &types.ConstructionMetadataRequest{
+		NetworkIdentifier: testNetworkIdentifier,
+	})
+	requireError(t, ErrNotAvailableInOfflineMode, err)
+
+	_, err = s.ConstructionSubmit(ctx, &types.ConstructionSubmitRequest{
+		NetworkIdentifier: testNetworkIdentifier,
+	})
+	requireError(t, ErrNotAvailableInOfflineMode, err)
+}
+
+func TestConstructionMetadataErrors(t *testing.T) {
+	ctx := context.Background()
+	oc := newTestClient()
+	s := NewConstructionAPIService(oc, nil, nil, nil)
+
+	for _, options := range []map[string]interface{}{
+		nil,
+		{},
+		{OptionsIDKey: 42},
+		{OptionsIDKey: "oasis1invalid"},
+	} {
+		_, err := s.ConstructionMetadata(ctx, &types.ConstructionMetadataRequest{
+			NetworkIdentifier: testNetworkIdentifier,
+			Options:           options,
+		})
+		requireError(t, ErrInvalidAccountAddress, err)
+	}
+
+	oc.SetError(mock.MethodGetNextNonce, context.DeadlineExceeded)
+	_, err := s.ConstructionMetadata(ctx, &types.ConstructionMetadataRequest{
+		NetworkIdentifier: testNetworkIdentifier,
+		Options:           map[string]interface{}{OptionsIDKey: testAddrStr},
+	})
+	requireError(t, ErrUnableToGetNextNonce, err)
+	oc.SetError(mock.MethodGetNextNonce, nil)
+
+	oc.SetError(mock.MethodResolveHeight, context.DeadlineExceeded)
+	_, err = s.ConstructionMetadata(ctx, &types.ConstructionMetadataRequest{
+		NetworkIdentifier: testNetworkIdentifier,
+		Options:           map[string]interface{}{OptionsIDKey: testAddrStr},
+	})
+	requireError(t, ErrUnableToGetLatestBlk, err)
+}
+
+func TestConstructionPayloadsErrors(t *testing.T) {
+	ctx := context.Background()
+	s := NewConstructionAPIService(newTestClient(), nil, nil, nil)
+
+	_, err := s.ConstructionPayloads(ctx, &types.ConstructionPayloadsRequest{
+		NetworkIdentifier: testNetworkIdentifier,
+		Operations:        newTestTransferOps("1000", "10"),
+	})
+	requireError(t, ErrMalformedValue, err)
+
+	_, err = s.ConstructionPayloads(ctx, &types.ConstructionPayloadsRequest{
+		NetworkIdentifier: testNetworkIdentifier,
+		Operations:        newTestTransferOps("1000", "10")[:3],
+		Metadata:          map[string]interface{}{NonceKey: float64(0)},
+	})
+	requireError(t, ErrMalformedValue, err)
+}
+
+func TestConstructionCombineVerification(t *testing.T) {
+	ctx := context.Background()
+	s := NewConstructionAPIService(newTestClient(), nil, nil, nil)
+
+	payloadsResp, err := s.ConstructionPayloads(ctx, &types.ConstructionPayloadsRequest{
+		NetworkIdentifier: testNetworkIdentifier,
+		Operations:        newTestTransferOps("1000", "10"),
+		Metadata:          map[string]interface{}{NonceKey: float64(0)},
+	})
+	requireNoError(t, err)
+	ut, _ := DecodeUnsignedTransaction(payloadsResp.UnsignedTransaction)
+
+	combine := func(signer signature.Signer, sig []byte) (*types.ConstructionCombineResponse, *types.Error) {
+		pk, _ := signer.Public().MarshalBinary()
+		return s.ConstructionCombine(ctx, &types.ConstructionCombineRequest{
+			NetworkIdentifier:   testNetworkIdentifier,
+			UnsignedTransaction: payloadsResp.UnsignedTransaction,
+			Signatures: []*types.Signature{
+				{
+					SigningPayload: payloadsResp.Payloads[0],
+					PublicKey:      &types.PublicKey{Bytes: pk, CurveType: types.Edwards25519},
+					SignatureType:  types.Ed25519,
+					Bytes:          sig,
+				},
+			},
+		})
+	}
+
+	// Signature by a key other than the signer's.
+	other := memory.NewTestSigner("oasis-core-rosetta-gateway/services: test other")
+	otherSig, serr := other.ContextSign(transaction.SignatureContext, ut.Tx)
+	if serr != nil {
+		t.Fatalf("unable to sign transaction: %v", serr)
+	}
+	_, err = combine(other, otherSig)
+	requireError(t, ErrSignerMismatch, err)
+
+	// Tampered signature.
+	badSig, serr := testSigner.ContextSign(transaction.SignatureContext, ut.Tx)
+	if serr != nil {
+		t.Fatalf("unable to sign transaction: %v", serr)
+	}
+	badSig[0] ^= 0xff
+	_, err = combine(testSigner, badSig)
+	requireError(t, ErrInvalidSignature, err)
+
+	// A signed transaction with an invalid signature is parsed, but reported
+	// as invalid.
+	var rs signature.RawSignature
+	_ = rs.UnmarshalBinary(badSig)
+	invalidTx := &transaction.SignedTransaction{
+		Signed: signature.Signed{
+			Blob:      ut.Tx,
+			Signature: signature.Signature{PublicKey: testSigner.Public(), Signature: rs},
+		},
+	}
+	parseResp, err := s.ConstructionParse(ctx, &types.ConstructionParseRequest{
+		NetworkIdentifier: testNetworkIdentifier,
+		Signed:            true,
+		Transaction:       encodeTestTx(invalidTx),
+	})
+	requireNoError(t, err)
+	if parseResp.Metadata[SignatureValidKey] != false || len(parseResp.Operations) != 4 {
+		t.Fatalf("unexpected parsed transaction: %v", types.PrettyPrintStruct(parseResp))
+	}
+}
+
+// encodeTestTx returns the given signed transaction as expected by the
+// /construction/submit endpoint.
+func encodeTestTx(tx *transaction.SignedTransaction) string {
+	return base64.StdEncoding.EncodeToString(cbor.Marshal(tx))
+}
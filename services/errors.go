@@ -1,8 +1,16 @@
 package services
 
 import (
+	goerrors "errors"
+	"strings"
+
 	"github.com/coinbase/rosetta-sdk-go/types"
 	"github.com/oasisprotocol/oasis-core/go/common/errors"
+	consensus "github.com/oasisprotocol/oasis-core/go/consensus/api"
+	"github.com/oasisprotocol/oasis-core/go/consensus/api/transaction"
+	staking "github.com/oasisprotocol/oasis-core/go/staking/api"
+
+	"github.com/oasisprotocol/oasis-core-rosetta-gateway/oasis"
 )
 
 const (
@@ -138,6 +146,114 @@ var (
 		Retriable: false,
 	}
 
+	ErrInvalidSignature = &types.Error{
+		Code:      21,
+		Message:   "invalid signature",
+		Retriable: false,
+	}
+
+	ErrSignerMismatch = &types.Error{
+		Code:      22,
+		Message:   "signature public key doesn't match the transaction's signer",
+		Retriable: false,
+	}
+
+	ErrInvalidNonce = &types.Error{
+		Code:      23,
+		Message:   "transaction nonce is not the signer's next nonce",
+		Retriable: false,
+	}
+
+	ErrInsufficientBalance = &types.Error{
+		Code:      24,
+		Message:   "signer's general balance doesn't cover the amount and fee",
+		Retriable: false,
+	}
+
+	ErrGasPriceTooLow = &types.Error{
+		Code:      25,
+		Message:   "transaction gas price is below the node's minimum",
+		Retriable: false,
+	}
+
+	ErrOversizedTx = &types.Error{
+		Code:      26,
+		Message:   "transaction is too large",
+		Retriable: false,
+	}
+
+	ErrUpgradePending = &types.Error{
+		Code:      27,
+		Message:   "network upgrade pending",
+		Retriable: true,
+	}
+
+	ErrMempoolFull = &types.Error{
+		Code:      28,
+		Message:   "node's mempool is full",
+		Retriable: true,
+	}
+
+	ErrInvalidTxArgument = &types.Error{
+		Code:      29,
+		Message:   "invalid transaction argument",
+		Retriable: false,
+	}
+
+	ErrInsufficientStake = &types.Error{
+		Code:      30,
+		Message:   "insufficient stake",
+		Retriable: false,
+	}
+
+	ErrForbiddenByPolicy = &types.Error{
+		Code:      31,
+		Message:   "transaction forbidden by staking policy",
+		Retriable: false,
+	}
+
+	ErrTooManyAllowances = &types.Error{
+		Code:      32,
+		Message:   "too many allowances",
+		Retriable: false,
+	}
+
+	ErrUnderMinDelegationAmount = &types.Error{
+		Code:      33,
+		Message:   "amount is lower than the minimum delegation amount",
+		Retriable: false,
+	}
+
+	ErrRateLimited = &types.Error{
+		Code:      34,
+		Message:   "rate limit exceeded",
+		Retriable: true,
+	}
+
+	ErrUnauthorized = &types.Error{
+		Code:      35,
+		Message:   "missing or invalid credentials",
+		Retriable: false,
+	}
+
+	ErrForbidden = &types.Error{
+		Code:      36,
+		Message:   "endpoint not permitted with the given credentials",
+		Retriable: false,
+	}
+
+	ErrNodeOverloaded = &types.Error{
+		Code:      37,
+		Message:   "node is overloaded or not responding",
+		Retriable: true,
+	}
+
+	ErrSubmitOutcomeUnknown = &types.Error{
+		Code:      38,
+		Message:   "transaction submit timed out, outcome unknown",
+		Retriable: false,
+	}
+
 	ErrorList = []*types.Error{
 		ErrUnableToGetChainID,
 		ErrInvalidBlockchain,
@@ -159,9 +275,63 @@ var (
 		ErrUnableToGetNodeStatus,
 		ErrTransactionNotFound,
 		ErrNotAvailableInOfflineMode,
+		ErrInvalidSignature,
+		ErrSignerMismatch,
+		ErrInvalidNonce,
+		ErrInsufficientBalance,
+		ErrGasPriceTooLow,
+		ErrOversizedTx,
+		ErrUpgradePending,
+		ErrMempoolFull,
+		ErrInvalidTxArgument,
+		ErrInsufficientStake,
+		ErrForbiddenByPolicy,
+		ErrTooManyAllowances,
+		ErrUnderMinDelegationAmount,
+		ErrRateLimited,
+		ErrUnauthorized,
+		ErrForbidden,
+		ErrNodeOverloaded,
+		ErrSubmitOutcomeUnknown,
 	}
 )
 
+// errorCode is the module and code of an Oasis error.
+type errorCode struct {
+	module string
+	code   uint32
+}
+
+func codeOf(err error) errorCode {
+	module, code := errors.Code(err)
+	return errorCode{module, code}
+}
+
+// submitErrors maps the module and code of the Oasis errors returned when
+// submitting a transaction to Rosetta errors.
+var submitErrors = map[errorCode]*types.Error{
+	codeOf(consensus.ErrOversizedTx):     ErrOversizedTx,
+	codeOf(consensus.ErrInvalidArgument): ErrInvalidTxArgument,
+
+	codeOf(transaction.ErrInvalidNonce):           ErrInvalidNonce,
+	codeOf(transaction.ErrInsufficientFeeBalance): ErrInsufficientBalance,
+	codeOf(transaction.ErrGasPriceTooLow):         ErrGasPriceTooLow,
+	codeOf(transaction.ErrUpgradePending):         ErrUpgradePending,
+
+	codeOf(staking.ErrInvalidArgument):          ErrInvalidTxArgument,
+	codeOf(staking.ErrInvalidSignature):         ErrInvalidSignature,
+	codeOf(staking.ErrInsufficientBalance):      ErrInsufficientBalance,
+	codeOf(staking.ErrInsufficientStake):        ErrInsufficientStake,
+	codeOf(staking.ErrForbidden):                ErrForbiddenByPolicy,
+	codeOf(staking.ErrInvalidThreshold):         ErrInvalidTxArgument,
+	codeOf(staking.ErrTooManyAllowances):        ErrTooManyAllowances,
+	codeOf(staking.ErrUnderMinDelegationAmount): ErrUnderMinDelegationAmount,
+}
+
+// tendermintMempoolFullMsg is the start of the message of Tendermint's
+// (uncoded) error returned when the mempool is full.
+const tendermintMempoolFullMsg = "mempool is full"
+
 // NewDetailedError returns a new Rosetta error Code, Message, and Retriable
 // set from proto and Details[CauseKey] set from cause.
 func NewDetailedError(proto *types.Error, cause error) *types.Error {
@@ -176,3 +346,26 @@ func NewDetailedError(proto *types.Error, cause error) *types.Error {
 	}
 	return &detailedError
 }
+
+// NewSubmitError returns a new Rosetta error for the given error returned when
+// submitting a transaction, with Code, Message, and Retriable set from the
+// Rosetta error that the cause's module and code map to (ErrUnableToSubmitTx
+// if there is none) and Details[CauseKey] set from cause.  Calls to the node
+// that were shed (and so never sent) map to the retriable ErrNodeOverloaded,
+// while calls that timed out may still have reached the node, so they map to
+// the non-retriable ErrSubmitOutcomeUnknown.
+func NewSubmitError(cause error) *types.Error {
+	proto, ok := submitErrors[codeOf(cause)]
+	switch {
+	case ok:
+	case goerrors.Is(cause, oasis.ErrOverloaded):
+		proto = ErrNodeOverloaded
+	case goerrors.Is(cause, oasis.ErrCallTimeout):
+		proto = ErrSubmitOutcomeUnknown
+	case strings.Contains(cause.Error(), tendermintMempoolFullMsg):
+		proto = ErrMempoolFull
+	default:
+		proto = ErrUnableToSubmitTx
+	}
+	return NewDetailedError(proto, cause)
+}
@@ -0,0 +1,67 @@
+package services
+
+import (
+	"fmt"
+	"testing"
+
+	consensus "github.com/oasisprotocol/oasis-core/go/consensus/api"
+	"github.com/oasisprotocol/oasis-core/go/consensus/api/transaction"
+	staking "github.com/oasisprotocol/oasis-core/go/staking/api"
+
+	"github.com/oasisprotocol/oasis-core-rosetta-gateway/oasis"
+)
+
+func TestErrorList(t *testing.T) {
+	codes := make(map[int32]bool)
+	for _, terr := range ErrorList {
+		if codes[terr.Code] {
+			t.Fatalf("duplicate error code: %d", terr.Code)
+		}
+		codes[terr.Code] = true
+	}
+	for ec, terr := range submitErrors {
+		if !codes[terr.Code] {
+			t.Fatalf("error for %s-%d missing from the error list: %s", ec.module, ec.code, terr.Message)
+		}
+	}
+}
+
+func TestNewSubmitError(t *testing.T) {
+	for _, tc := range []struct {
+		cause    error
+		expected int32
+	}{
+		{transaction.ErrInvalidNonce, ErrInvalidNonce.Code},
+		{transaction.ErrInsufficientFeeBalance, ErrInsufficientBalance.Code},
+		{transaction.ErrGasPriceTooLow, ErrGasPriceTooLow.Code},
+		{transaction.ErrUpgradePending, ErrUpgradePending.Code},
+		{consensus.ErrOversizedTx, ErrOversizedTx.Code},
+		{staking.ErrInsufficientBalance, ErrInsufficientBalance.Code},
+		{staking.ErrForbidden, ErrForbiddenByPolicy.Code},
+		{fmt.Errorf("client: %w", staking.ErrUnderMinDelegationAmount), ErrUnderMinDelegationAmount.Code},
+		{fmt.Errorf("tendermint: failed to submit to local mempool: mempool is full: number of txs 5000"),
+			ErrMempoolFull.Code},
+		{oasis.ErrOverloaded, ErrNodeOverloaded.Code},
+		{fmt.Errorf("%w: /oasis-core.Consensus/SubmitTx after 30s", oasis.ErrCallTimeout), ErrSubmitOutcomeUnknown.Code},
+		{fmt.Errorf("something else"), ErrUnableToSubmitTx.Code},
+	} {
+		terr := NewSubmitError(tc.cause)
+		if terr.Code != tc.expected {
+			t.Fatalf("unexpected error for %q: %s", tc.cause, terr.Message)
+		}
+		cause := terr.Details[CauseKey].(map[string]interface{})
+		if cause[MsgKey] != tc.cause.Error() {
+			t.Fatalf("unexpected error cause: %v", cause)
+		}
+	}
+
+	if !NewSubmitError(transaction.ErrUpgradePending).Retriable || !NewSubmitError(fmt.Errorf("mempool is full")).Retriable {
+		t.Fatalf("expected transient errors to be retriable")
+	}
+	if NewSubmitError(transaction.ErrInvalidNonce).Retriable {
+		t.Fatalf("expected an invalid nonce not to be retriable")
+	}
+	if NewSubmitError(fmt.Errorf("%w: SubmitTx", oasis.ErrCallTimeout)).Retriable {
+		t.Fatalf("expected a timed out submit not to be retriable")
+	}
+}
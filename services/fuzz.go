@@ -0,0 +1,123 @@
+//go:build gofuzz
+// +build gofuzz
+
+package services
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+
+	"github.com/coinbase/rosetta-sdk-go/types"
+	"github.com/oasisprotocol/oasis-core/go/common/cbor"
+	"github.com/oasisprotocol/oasis-core/go/consensus/api/transaction"
+	staking "github.com/oasisprotocol/oasis-core/go/staking/api"
+)
+
+// FuzzOperations is a go-fuzz target that maps JSON-encoded operations to a
+// transaction and checks that the transaction maps back to operations that
+// yield the same transaction again.
+func FuzzOperations(data []byte) int {
+	var ops []*types.Operation
+	if err := json.Unmarshal(data, &ops); err != nil {
+		return 0
+	}
+	signerAddr, tx, err := newOperationToTransactionMapper(ops).GetTransaction()
+	if err != nil {
+		return 0
+	}
+	fuzzRoundTrip(tx, signerAddr)
+	return 1
+}
+
+// FuzzSignedTransaction is a go-fuzz target for decoding and parsing signed
+// transactions, as done by /construction/parse and /construction/submit.
+func FuzzSignedTransaction(data []byte) int {
+	// Also try the input as is to exercise the Base64 decoding.
+	_, _ = DecodeSignedTransaction(string(data))
+
+	sigTx, err := DecodeSignedTransaction(base64.StdEncoding.EncodeToString(data))
+	if err != nil {
+		return 0
+	}
+	var tx transaction.Transaction
+	if err = cbor.Unmarshal(sigTx.Blob, &tx); err != nil {
+		return 0
+	}
+	fuzzParse(&tx, StringFromAddress(staking.NewAddress(sigTx.Signature.PublicKey)))
+	return 1
+}
+
+// FuzzUnsignedTransaction is a go-fuzz target for decoding and parsing
+// unsigned transactions, as done by /construction/parse and
+// /construction/combine.
+func FuzzUnsignedTransaction(data []byte) int {
+	_, _ = DecodeUnsignedTransaction(string(data))
+
+	ut, err := DecodeUnsignedTransaction(base64.StdEncoding.EncodeToString(data))
+	if err != nil {
+		return 0
+	}
+	var tx transaction.Transaction
+	if err = cbor.Unmarshal(ut.Tx, &tx); err != nil {
+		return 0
+	}
+	fuzzParse(&tx, ut.Signer)
+	return 1
+}
+
+// fuzzParse maps the given transaction to operations like /construction/parse
+// and checks the round trip of the ones that map back to a transaction.
+func fuzzParse(tx *transaction.Transaction, signerAddr string) {
+	ops, err := fuzzOperations(tx, signerAddr)
+	if err != nil {
+		return
+	}
+	signerAddr2, tx2, err := newOperationToTransactionMapper(ops).GetTransaction()
+	if err != nil {
+		return
+	}
+	fuzzRoundTrip(tx2, signerAddr2)
+}
+
+// fuzzRoundTrip panics if mapping the given transaction to operations and
+// back doesn't yield the same transaction.
+func fuzzRoundTrip(tx *transaction.Transaction, signerAddr string) {
+	ops, err := fuzzOperations(tx, signerAddr)
+	if err != nil {
+		panic(fmt.Sprintf("failed to map transaction to operations: %v", err))
+	}
+	signerAddr2, tx2, err := newOperationToTransactionMapper(ops).GetTransaction()
+	if err != nil {
+		panic(fmt.Sprintf("failed to map operations to transaction: %v", err))
+	}
+	if signerAddr2 != signerAddr {
+		panic(fmt.Sprintf("signer differs (expected: %s actual: %s)", signerAddr, signerAddr2))
+	}
+	// Zero fees don't map to operations, so neither does their gas.
+	if tx.Fee.Amount.IsZero() {
+		tx.Fee.Gas = DefaultGas
+	}
+	if !bytes.Equal(cbor.Marshal(tx), cbor.Marshal(tx2)) {
+		panic(fmt.Sprintf("transaction differs (expected: %+v actual: %+v)", tx, tx2))
+	}
+}
+
+// fuzzOperations maps the given transaction to operations and returns them
+// after a JSON round trip.
+func fuzzOperations(tx *transaction.Transaction, signerAddr string) ([]*types.Operation, error) {
+	ops, err := TransactionToOperations(tx, signerAddr)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := json.Marshal(ops)
+	if err != nil {
+		return nil, err
+	}
+	var result []*types.Operation
+	if err = json.Unmarshal(raw, &result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
@@ -0,0 +1,373 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/coinbase/rosetta-sdk-go/types"
+	"github.com/oasisprotocol/oasis-core/go/common/crypto/signature"
+	"github.com/oasisprotocol/oasis-core/go/common/quantity"
+	"github.com/oasisprotocol/oasis-core/go/consensus/api/transaction"
+	staking "github.com/oasisprotocol/oasis-core/go/staking/api"
+
+	"github.com/oasisprotocol/oasis-core-rosetta-gateway/oasis"
+	"github.com/oasisprotocol/oasis-core-rosetta-gateway/oasis/mock"
+	"github.com/oasisprotocol/oasis-core-rosetta-gateway/oasis/replay"
+)
+
+var (
+	updateGoldens  = flag.Bool("update-goldens", false, "rewrite the golden outputs of the replay tests")
+	recordFixtures = flag.Bool("record-fixtures", false, "re-record the fixtures of the replay tests")
+)
+
+const (
+	// goldenDir is the directory with the replay test cases, one per
+	// subdirectory.
+	goldenDir = "testdata/replay"
+
+	goldenRequestsFile = "requests.json"
+	goldenFixtureFile  = "fixture.json"
+	goldenOutputFile   = "golden.json"
+
+	// goldenSourceNode is the source of test cases recorded from the node at
+	// oasis.GrpcAddrEnvVar.
+	goldenSourceNode = "node"
+	// goldenSourceMockPrefix is the prefix of the source of test cases
+	// recorded from one of the mock scenarios.
+	goldenSourceMockPrefix = "mock:"
+)
+
+// goldenRequests are the requests of a replay test case.
+type goldenRequests struct {
+	// Source is where the fixture is recorded from: either "node" or
+	// "mock:<scenario>".
+	Source string `json:"source"`
+	// Blocks are the heights of /block requests.
+	Blocks []int64 `json:"blocks,omitempty"`
+	// Balances are the /account/balance requests.
+	Balances []*goldenBalanceRequest `json:"balances,omitempty"`
+}
+
+type goldenBalanceRequest struct {
+	AccountIdentifier *types.AccountIdentifier `json:"account_identifier"`
+	Index             int64                    `json:"index"`
+}
+
+// goldenOutput is the output of a single request of a replay test case.
+type goldenOutput struct {
+	Endpoint string       `json:"endpoint"`
+	Request  interface{}  `json:"request"`
+	Response interface{}  `json:"response,omitempty"`
+	Error    *types.Error `json:"error,omitempty"`
+}
+
+// goldenScenarios are the mock scenarios that fixtures can be recorded from.
+var goldenScenarios = map[string]func(t *testing.T) *mock.Client{
+	"failed-transactions": scenarioFailedTransactions,
+	"slashing":            scenarioSlashing,
+	"reclaim":             scenarioReclaim,
+}
+
+// scenarioFailedTransactions commits a block with a successful transfer, a
+// transfer and an escrow exceeding the balance (which only pay the fee) and
+// a transfer that can't even pay its fee.
+func scenarioFailedTransactions(t *testing.T) *mock.Client {
+	ctx := context.Background()
+	oc := newTestClient()
+
+	for nonce, tx := range []struct {
+		fee    uint64
+		method transaction.MethodName
+		body   interface{}
+	}{
+		{10, staking.MethodTransfer, newTestTransfer(100)},
+		{10, staking.MethodTransfer, newTestTransfer(2 * testGeneralBalance)},
+		{10, staking.MethodAddEscrow, &staking.Escrow{
+			Account: testValidator,
+			Amount:  *quantity.NewFromUint64(2 * testGeneralBalance),
+		}},
+		{2 * testGeneralBalance, staking.MethodTransfer, newTestTransfer(1)},
+	} {
+		sigTx := signTestTx(t, uint64(nonce), tx.fee, tx.method, tx.body)
+		if err := oc.SubmitTxNoWait(ctx, sigTx); err != nil {
+			t.Fatalf("unable to submit transaction: %v", err)
+		}
+	}
+	oc.CommitBlock()
+	return oc
+}
+
+// scenarioSlashing commits a block in which the test validator is slashed.
+func scenarioSlashing(t *testing.T) *mock.Client {
+	ctx := context.Background()
+	oc := newTestClient()
+
+	act, err := oc.GetAccount(ctx, oasis.LatestHeight, testValidator)
+	if err != nil {
+		t.Fatalf("unable to get validator account: %v", err)
+	}
+	slashed := quantity.NewFromUint64(100)
+	if err = act.Escrow.Active.Balance.Sub(slashed); err != nil {
+		t.Fatalf("unable to slash validator: %v", err)
+	}
+	oc.SetAccount(testValidator, act)
+	oc.AddBlockEvent(&staking.Event{
+		Escrow: &staking.EscrowEvent{Take: &staking.TakeEscrowEvent{
+			Owner:  testValidator,
+			Amount: *slashed,
+		}},
+	})
+	oc.CommitBlock()
+	return oc
+}
+
+// scenarioReclaim commits a block with a reclaim escrow transaction and a
+// block in which the test account's debonding delegation is reclaimed.
+func scenarioReclaim(t *testing.T) *mock.Client {
+	ctx := context.Background()
+	oc := newTestClient()
+
+	reclaim := &staking.ReclaimEscrow{
+		Account: testValidator,
+		Shares:  *quantity.NewFromUint64(50),
+	}
+	if err := oc.SubmitTxNoWait(ctx, signTestTx(t, 0, 10, staking.MethodReclaimEscrow, reclaim)); err != nil {
+		t.Fatalf("unable to submit transaction: %v", err)
+	}
+	oc.CommitBlock()
+
+	// The original debonding delegation ends.
+	shares := quantity.NewFromUint64(testDebondingShares)
+	amount := quantity.NewQuantity()
+	validator, _ := oc.GetAccount(ctx, oasis.LatestHeight, testValidator)
+	if err := validator.Escrow.Debonding.Withdraw(amount, shares.Clone(), shares); err != nil {
+		t.Fatalf("unable to withdraw debonding shares: %v", err)
+	}
+	oc.SetAccount(testValidator, validator)
+	owner, _ := oc.GetAccount(ctx, oasis.LatestHeight, testAddr)
+	if err := owner.General.Balance.Add(amount); err != nil {
+		t.Fatalf("unable to credit reclaimed amount: %v", err)
+	}
+	oc.SetAccount(testAddr, owner)
+	debonding, _ := oc.GetDebondingDelegations(ctx, oasis.LatestHeight, testAddr)
+	var remaining []*staking.DebondingDelegation
+	for _, dd := range debonding[testValidator] {
+		if dd.DebondEndTime != testDebondEndEpoch {
+			remaining = append(remaining, dd)
+		}
+	}
+	oc.SetDebondingDelegations(testValidator, testAddr, remaining)
+	oc.AddBlockEvent(&staking.Event{
+		Escrow: &staking.EscrowEvent{Reclaim: &staking.ReclaimEscrowEvent{
+			Owner:  testAddr,
+			Escrow: testValidator,
+			Amount: *amount,
+		}},
+	})
+	oc.CommitBlock()
+	return oc
+}
+
+// TestGolden replays the recorded fixtures of all test cases through the
+// /block and /account/balance endpoints and compares the responses with the
+// golden outputs.
+//
+// Run with -update-goldens to accept changed outputs, and with
+// -record-fixtures to re-record the fixtures from their sources.
+func TestGolden(t *testing.T) {
+	dirs, err := ioutil.ReadDir(goldenDir)
+	if err != nil {
+		t.Fatalf("unable to list test cases: %v", err)
+	}
+	for _, dir := range dirs {
+		if !dir.IsDir() {
+			continue
+		}
+		name := dir.Name()
+		t.Run(name, func(t *testing.T) {
+			testGoldenCase(t, filepath.Join(goldenDir, name))
+		})
+	}
+}
+
+func testGoldenCase(t *testing.T, dir string) {
+	var requests goldenRequests
+	readGoldenJSON(t, filepath.Join(dir, goldenRequestsFile), &requests)
+
+	fixturePath := filepath.Join(dir, goldenFixtureFile)
+	if *recordFixtures {
+		recordGoldenFixture(t, &requests, fixturePath)
+	}
+	if _, err := os.Stat(fixturePath); os.IsNotExist(err) && requests.Source == goldenSourceNode {
+		t.Skipf("fixture not recorded yet, run with -record-fixtures against a node at %s", oasis.GrpcAddrEnvVar)
+	}
+	fixture, err := replay.Load(fixturePath)
+	if err != nil {
+		t.Fatalf("unable to load fixture: %v", err)
+	}
+	oc := replay.NewPlayer(fixture)
+
+	chainID, err := oc.GetChainID(context.Background())
+	if err != nil {
+		t.Fatalf("unable to get recorded chain ID: %v", err)
+	}
+	var outputs []*goldenOutput
+	withChainContext(chainID, func() {
+		outputs = runGoldenRequests(oc, &requests)
+	})
+
+	actual, err := json.MarshalIndent(outputs, "", "  ")
+	if err != nil {
+		t.Fatalf("unable to marshal outputs: %v", err)
+	}
+	actual = append(actual, '\n')
+
+	goldenPath := filepath.Join(dir, goldenOutputFile)
+	if *updateGoldens {
+		if err = ioutil.WriteFile(goldenPath, actual, 0o644); err != nil { // nolint: gosec
+			t.Fatalf("unable to write golden output: %v", err)
+		}
+		return
+	}
+	expected, err := ioutil.ReadFile(goldenPath)
+	if err != nil {
+		t.Fatalf("unable to read golden output: %v", err)
+	}
+	if !bytes.Equal(expected, actual) {
+		t.Fatalf("output differs from %s (run with -update-goldens to accept):\n%s",
+			goldenPath, diffLines(string(expected), string(actual)),
+		)
+	}
+}
+
+// runGoldenRequests runs the requests of a test case against the given
+// client.
+func runGoldenRequests(oc oasis.Client, requests *goldenRequests) []*goldenOutput {
+	ctx := context.Background()
+	chainID, _ := oc.GetChainID(ctx)
+	ni := &types.NetworkIdentifier{
+		Blockchain: OasisBlockchainName,
+		Network:    chainID,
+	}
+
+	outputs := []*goldenOutput{}
+	bs := NewBlockAPIService(oc)
+	for _, height := range requests.Blocks {
+		h := height
+		req := &types.BlockRequest{
+			NetworkIdentifier: ni,
+			BlockIdentifier:   &types.PartialBlockIdentifier{Index: &h},
+		}
+		resp, err := bs.Block(ctx, req)
+		outputs = append(outputs, &goldenOutput{"/block", req.BlockIdentifier, resp, err})
+	}
+
+	as := NewAccountAPIService(oc, NewMempoolCache(oc), nil)
+	for _, br := range requests.Balances {
+		h := br.Index
+		req := &types.AccountBalanceRequest{
+			NetworkIdentifier: ni,
+			AccountIdentifier: br.AccountIdentifier,
+			BlockIdentifier:   &types.PartialBlockIdentifier{Index: &h},
+		}
+		resp, err := as.AccountBalance(ctx, req)
+		outputs = append(outputs, &goldenOutput{"/account/balance", br, resp, err})
+	}
+	return outputs
+}
+
+// recordGoldenFixture records the fixture of a test case from its source.
+func recordGoldenFixture(t *testing.T, requests *goldenRequests, path string) {
+	var source oasis.Client
+	switch {
+	case requests.Source == goldenSourceNode:
+		if os.Getenv(oasis.GrpcAddrEnvVar) == "" {
+			t.Skipf("%s not set, unable to record fixture from node", oasis.GrpcAddrEnvVar)
+		}
+		var err error
+		if source, err = oasis.New(); err != nil {
+			t.Fatalf("unable to create node client: %v", err)
+		}
+	case strings.HasPrefix(requests.Source, goldenSourceMockPrefix):
+		scenario, ok := goldenScenarios[strings.TrimPrefix(requests.Source, goldenSourceMockPrefix)]
+		if !ok {
+			t.Fatalf("unknown mock scenario: %s", requests.Source)
+		}
+		source = scenario(t)
+	default:
+		t.Fatalf("unknown fixture source: %s", requests.Source)
+	}
+
+	rec := replay.NewRecorder(source)
+	chainID, err := rec.GetChainID(context.Background())
+	if err != nil {
+		t.Fatalf("unable to get chain ID: %v", err)
+	}
+	withChainContext(chainID, func() {
+		runGoldenRequests(rec, requests)
+	})
+	fixture, err := rec.Fixture()
+	if err != nil {
+		t.Fatalf("unable to record fixture: %v", err)
+	}
+	if err = fixture.Save(path); err != nil {
+		t.Fatalf("unable to save fixture: %v", err)
+	}
+}
+
+// withChainContext runs the given function with the signature chain context
+// set to the given chain ID, as needed to verify the recorded transactions.
+func withChainContext(chainID string, fn func()) {
+	if chainID == mock.ChainID {
+		fn()
+		return
+	}
+
+	signature.UnsafeResetChainContext()
+	signature.SetChainContext(chainID)
+	defer func() {
+		signature.UnsafeResetChainContext()
+		signature.SetChainContext(mock.ChainID)
+	}()
+	fn()
+}
+
+func readGoldenJSON(t *testing.T, path string, dst interface{}) {
+	t.Helper()
+
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("unable to read %s: %v", path, err)
+	}
+	if err = json.Unmarshal(raw, dst); err != nil {
+		t.Fatalf("malformed %s: %v", path, err)
+	}
+}
+
+// diffLines returns the lines that differ between the expected and the
+// actual output.
+func diffLines(expected, actual string) string {
+	el, al := strings.Split(expected, "\n"), strings.Split(actual, "\n")
+	var b strings.Builder
+	for i := 0; i < len(el) || i < len(al); i++ {
+		var e, a string
+		if i < len(el) {
+			e = el[i]
+		}
+		if i < len(al) {
+			a = al[i]
+		}
+		if e != a {
+			fmt.Fprintf(&b, "line %d:\n-%s\n+%s\n", i+1, e, a)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,204 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/coinbase/rosetta-sdk-go/types"
+
+	"github.com/oasisprotocol/oasis-core/go/common/logging"
+	"github.com/oasisprotocol/oasis-core/go/common/quantity"
+	consensus "github.com/oasisprotocol/oasis-core/go/consensus/api"
+	genesis "github.com/oasisprotocol/oasis-core/go/genesis/api"
+	staking "github.com/oasisprotocol/oasis-core/go/staking/api"
+	mkvsDB "github.com/oasisprotocol/oasis-core/go/storage/mkvs/db/api"
+
+	"github.com/oasisprotocol/oasis-core-rosetta-gateway/history"
+	"github.com/oasisprotocol/oasis-core-rosetta-gateway/oasis"
+)
+
+// HistoricalBalanceKey is the name of the key in the Metadata map inside the
+// response of an account balance request that is set to true if the balance
+// was reconstructed from the balance history store, because the node no
+// longer has state at the requested height.
+const HistoricalBalanceKey = "historical"
+
+// balanceHistoryPollInterval is the interval at which the balance history
+// indexer checks the node for new blocks once it has caught up.
+const balanceHistoryPollInterval = 5 * time.Second
+
+var loggerHistory = logging.GetLogger("services/history")
+
+// balanceHistoryKey returns the key of the given account in the balance
+// history store.
+func balanceHistoryKey(address string, subAccount *types.SubAccountIdentifier) string {
+	if subAccount == nil {
+		return address
+	}
+	return address + CoinIdentifierSeparator + subAccount.Address
+}
+
+// isStateNotAvailable returns true if the given error of a state query shows
+// that the node doesn't have state at the queried height (e.g., because it
+// was pruned), as opposed to a transient failure.
+func isStateNotAvailable(err error) bool {
+	return errors.Is(err, consensus.ErrVersionNotFound) || errors.Is(err, mkvsDB.ErrVersionNotFound)
+}
+
+// BalanceHistoryIndexer follows new blocks and feeds the balance changes of
+// their operations, as returned by the /block endpoint, into a balance
+// history store.
+type BalanceHistoryIndexer struct {
+	oasisClient oasis.Client
+	store       *history.Store
+}
+
+// NewBalanceHistoryIndexer creates a new balance history indexer.
+func NewBalanceHistoryIndexer(oasisClient oasis.Client, store *history.Store) *BalanceHistoryIndexer {
+	return &BalanceHistoryIndexer{
+		oasisClient: oasisClient,
+		store:       store,
+	}
+}
+
+// InitializeFromGenesis initializes an empty store with the balances from the
+// genesis document. Indexing then starts at the genesis height, which
+// requires the node to still have all blocks since genesis.
+func (ix *BalanceHistoryIndexer) InitializeFromGenesis(ctx context.Context) error {
+	doc, err := ix.oasisClient.GetGenesisDocument(ctx)
+	if err != nil {
+		return fmt.Errorf("unable to get genesis document: %w", err)
+	}
+
+	// The genesis state precedes the block at the genesis height.
+	return ix.store.SetCheckpoint(&history.Block{Height: doc.Height - 1}, getLedgerBalances(doc))
+}
+
+// InitializeFromHeight initializes an empty store with the balances from the
+// state at the given height, which the node must still have.
+func (ix *BalanceHistoryIndexer) InitializeFromHeight(ctx context.Context, height int64) error {
+	blk, err := ix.oasisClient.GetBlock(ctx, height)
+	if err != nil {
+		return fmt.Errorf("unable to get checkpoint block: %w", err)
+	}
+	doc, err := ix.oasisClient.StateToGenesis(ctx, blk.Height)
+	if err != nil {
+		return fmt.Errorf("unable to get state at height %d: %w", blk.Height, err)
+	}
+
+	return ix.store.SetCheckpoint(&history.Block{Height: blk.Height, Hash: blk.Hash}, getLedgerBalances(doc))
+}
+
+// Run indexes new blocks until the given context is canceled.
+func (ix *BalanceHistoryIndexer) Run(ctx context.Context) {
+	for {
+		if err := ix.catchUp(ctx); err != nil {
+			loggerHistory.Error("Run: unable to index blocks", "err", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(balanceHistoryPollInterval):
+		}
+	}
+}
+
+// catchUp indexes all blocks between the store's latest block and the node's
+// latest block.
+func (ix *BalanceHistoryIndexer) catchUp(ctx context.Context) error {
+	latest, ok, err := ix.store.LatestHeight()
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("balance history store not initialized")
+	}
+
+	status, err := ix.oasisClient.GetStatus(ctx)
+	if err != nil {
+		return fmt.Errorf("unable to get node status: %w", err)
+	}
+
+	for height := latest + 1; height <= status.Consensus.LatestHeight; height++ {
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err = ix.indexBlock(ctx, height); err != nil {
+			return fmt.Errorf("unable to index block at height %d: %w", height, err)
+		}
+	}
+	return nil
+}
+
+// indexBlock feeds the balance changes of the block at the given height into
+// the store.
+func (ix *BalanceHistoryIndexer) indexBlock(ctx context.Context, height int64) error {
+	blk, err := ix.oasisClient.GetBlock(ctx, height)
+	if err != nil {
+		return fmt.Errorf("unable to get block: %w", err)
+	}
+	txs, err := decodeBlockTransactions(ctx, ix.oasisClient, blk)
+	if err != nil {
+		return err
+	}
+
+	changes := make(map[string]*big.Int)
+	for _, tx := range txs {
+		for _, op := range tx.Operations {
+			if op.Status == nil || *op.Status != OpStatusOK || op.Amount == nil {
+				continue
+			}
+			amount, ok := new(big.Int).SetString(op.Amount.Value, 10)
+			if !ok {
+				return fmt.Errorf("malformed operation amount: %s", op.Amount.Value)
+			}
+
+			key := balanceHistoryKey(op.Account.Address, op.Account.SubAccount)
+			if change, exists := changes[key]; exists {
+				change.Add(change, amount)
+			} else {
+				changes[key] = amount
+			}
+		}
+	}
+
+	return ix.store.ApplyBlock(&history.Block{Height: blk.Height, Hash: blk.Hash}, changes)
+}
+
+// getLedgerBalances returns the general and escrow balances of all accounts
+// in the given genesis document's staking ledger, together with the balances
+// of the common pool, the fee accumulator and the governance deposits, which
+// aren't part of the ledger but are the general balances of their reserved
+// addresses in operations.
+func getLedgerBalances(doc *genesis.Document) map[string]*big.Int {
+	escrow := &types.SubAccountIdentifier{Address: SubAccountEscrow}
+
+	balances := make(map[string]*big.Int, 2*len(doc.Staking.Ledger)+3)
+	for addr, act := range doc.Staking.Ledger {
+		address := StringFromAddress(addr)
+		balances[balanceHistoryKey(address, nil)] = act.General.Balance.ToBigInt()
+
+		// The escrow subaccount balance is Active + Debonding.
+		escrowBalance := act.Escrow.Active.Balance.ToBigInt()
+		escrowBalance.Add(escrowBalance, act.Escrow.Debonding.Balance.ToBigInt())
+		balances[balanceHistoryKey(address, escrow)] = escrowBalance
+	}
+
+	for addr, balance := range map[staking.Address]*quantity.Quantity{
+		staking.CommonPoolAddress:         &doc.Staking.CommonPool,
+		staking.FeeAccumulatorAddress:     &doc.Staking.LastBlockFees,
+		staking.GovernanceDepositsAddress: &doc.Staking.GovernanceDeposits,
+	} {
+		key := balanceHistoryKey(StringFromAddress(addr), nil)
+		if existing, ok := balances[key]; ok {
+			existing.Add(existing, balance.ToBigInt())
+		} else {
+			balances[key] = balance.ToBigInt()
+		}
+	}
+	return balances
+}
@@ -0,0 +1,190 @@
+package services
+
+import (
+	"context"
+	"strconv"
+	"testing"
+
+	"github.com/coinbase/rosetta-sdk-go/server"
+	"github.com/coinbase/rosetta-sdk-go/types"
+	"github.com/oasisprotocol/oasis-core/go/common/quantity"
+	staking "github.com/oasisprotocol/oasis-core/go/staking/api"
+
+	"github.com/oasisprotocol/oasis-core-rosetta-gateway/history"
+	"github.com/oasisprotocol/oasis-core-rosetta-gateway/oasis/mock"
+)
+
+// requireHistoricalBalance returns the balance of the given account at the
+// given height, which must be answered from the balance history.
+func requireHistoricalBalance(
+	ctx context.Context,
+	t *testing.T,
+	s server.AccountAPIServicer,
+	account *types.AccountIdentifier,
+	height int64,
+) string {
+	t.Helper()
+
+	resp, err := s.AccountBalance(ctx, &types.AccountBalanceRequest{
+		NetworkIdentifier: testNetworkIdentifier,
+		AccountIdentifier: account,
+		BlockIdentifier:   &types.PartialBlockIdentifier{Index: &height},
+	})
+	requireNoError(t, err)
+	if resp.Metadata[HistoricalBalanceKey] != true {
+		t.Fatalf("expected historical balance at height %d", height)
+	}
+	if resp.BlockIdentifier.Index != height {
+		t.Fatalf("unexpected block identifier: %v", types.PrettyPrintStruct(resp.BlockIdentifier))
+	}
+	return resp.Balances[0].Value
+}
+
+func TestBalanceHistory(t *testing.T) {
+	ctx := context.Background()
+	oc := newTestClient()
+
+	store, herr := history.Open(t.TempDir())
+	if herr != nil {
+		t.Fatalf("unable to open balance history store: %v", herr)
+	}
+	defer store.Close()
+
+	ix := NewBalanceHistoryIndexer(oc, store)
+	if herr = ix.InitializeFromGenesis(ctx); herr != nil {
+		t.Fatalf("unable to initialize balance history: %v", herr)
+	}
+
+	if herr = oc.SubmitTxNoWait(ctx, signTestTx(t, 0, 10, staking.MethodTransfer, newTestTransfer(100))); herr != nil {
+		t.Fatalf("unable to submit transaction: %v", herr)
+	}
+	blk := oc.CommitBlock()
+	oc.CommitBlock()
+	if herr = ix.catchUp(ctx); herr != nil {
+		t.Fatalf("unable to index blocks: %v", herr)
+	}
+
+	// Make the node forget all state before the latest block.
+	oc.PruneTo(oc.LatestHeight())
+
+	s := NewAccountAPIService(oc, NewMempoolCache(oc), store)
+	getBalance := func(account *types.AccountIdentifier, height int64) string {
+		return requireHistoricalBalance(ctx, t, s, account, height)
+	}
+
+	general := &types.AccountIdentifier{Address: testAddrStr}
+	if v := getBalance(general, mock.GenesisHeight); v != strconv.Itoa(testGeneralBalance) {
+		t.Fatalf("unexpected balance at genesis: %s", v)
+	}
+	if v := getBalance(general, blk.Height); v != strconv.Itoa(testGeneralBalance-110) {
+		t.Fatalf("unexpected balance after transfer: %s", v)
+	}
+	escrow := &types.AccountIdentifier{
+		Address:    StringFromAddress(testValidator),
+		SubAccount: &types.SubAccountIdentifier{Address: SubAccountEscrow},
+	}
+	if v := getBalance(escrow, blk.Height); v != "1300" {
+		t.Fatalf("unexpected escrow balance: %s", v)
+	}
+
+	// Transient errors are not answered from the balance history.
+	oc.SetError(mock.MethodGetAccount, context.DeadlineExceeded)
+	height := blk.Height
+	_, aerr := s.AccountBalance(ctx, &types.AccountBalanceRequest{
+		NetworkIdentifier: testNetworkIdentifier,
+		AccountIdentifier: general,
+		BlockIdentifier:   &types.PartialBlockIdentifier{Index: &height},
+	})
+	requireError(t, ErrUnableToGetAccount, aerr)
+	oc.SetError(mock.MethodGetAccount, nil)
+
+	// The network status advertises the history's oldest block.
+	ns := NewNetworkAPIService(oc, store)
+	resp, err := ns.NetworkStatus(ctx, &types.NetworkRequest{NetworkIdentifier: testNetworkIdentifier})
+	requireNoError(t, err)
+	if resp.OldestBlockIdentifier.Index != mock.GenesisHeight {
+		t.Fatalf("unexpected oldest block: %v", types.PrettyPrintStruct(resp.OldestBlockIdentifier))
+	}
+}
+
+func TestBalanceHistoryReservedAccounts(t *testing.T) {
+	ctx := context.Background()
+	q := quantity.NewFromUint64
+
+	// The transaction's fee goes to the fee accumulator, and the block with
+	// it is followed by another one.
+	const txHeight = mock.GenesisHeight + 1
+	newClient := func(t *testing.T) *mock.Client {
+		oc := mock.New(&staking.Genesis{
+			CommonPool:         *q(5000),
+			LastBlockFees:      *q(7),
+			GovernanceDeposits: *q(3),
+			Ledger: map[staking.Address]*staking.Account{
+				testAddr: {General: staking.GeneralAccount{Balance: *q(testGeneralBalance)}},
+			},
+		})
+		if err := oc.SubmitTxNoWait(ctx, signTestTx(t, 0, 10, staking.MethodTransfer, newTestTransfer(100))); err != nil {
+			t.Fatalf("unable to submit transaction: %v", err)
+		}
+		oc.CommitBlock()
+		oc.CommitBlock()
+		return oc
+	}
+
+	feeAccumulator := &types.AccountIdentifier{Address: StringFromAddress(staking.FeeAccumulatorAddress)}
+	commonPool := &types.AccountIdentifier{Address: StringFromAddress(staking.CommonPoolAddress)}
+	governanceDeposits := &types.AccountIdentifier{Address: StringFromAddress(staking.GovernanceDepositsAddress)}
+
+	for _, tc := range []struct {
+		name       string
+		initialize func(ix *BalanceHistoryIndexer) error
+		// Expected fee accumulator balance at the checkpoint.
+		fees string
+	}{
+		{"Genesis", func(ix *BalanceHistoryIndexer) error {
+			return ix.InitializeFromGenesis(ctx)
+		}, "7"},
+		{"Height", func(ix *BalanceHistoryIndexer) error {
+			return ix.InitializeFromHeight(ctx, txHeight)
+		}, "17"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			oc := newClient(t)
+			store, err := history.Open(t.TempDir())
+			if err != nil {
+				t.Fatalf("unable to open balance history store: %v", err)
+			}
+			defer store.Close()
+
+			ix := NewBalanceHistoryIndexer(oc, store)
+			if err = tc.initialize(ix); err != nil {
+				t.Fatalf("unable to initialize balance history: %v", err)
+			}
+			if err = ix.catchUp(ctx); err != nil {
+				t.Fatalf("unable to index blocks: %v", err)
+			}
+
+			// Make the node forget all state before the latest block.
+			oc.PruneTo(oc.LatestHeight())
+
+			s := NewAccountAPIService(oc, NewMempoolCache(oc), store)
+			getBalance := func(account *types.AccountIdentifier, height int64) string {
+				return requireHistoricalBalance(ctx, t, s, account, height)
+			}
+
+			oldest, _, _, _ := store.Range()
+			if v := getBalance(feeAccumulator, oldest.Height); v != tc.fees {
+				t.Fatalf("unexpected fee accumulator balance at height %d: %s", oldest.Height, v)
+			}
+			if v := getBalance(feeAccumulator, txHeight); v != "17" {
+				t.Fatalf("unexpected fee accumulator balance after transaction: %s", v)
+			}
+			if v := getBalance(commonPool, txHeight); v != "5000" {
+				t.Fatalf("unexpected common pool balance after transaction: %s", v)
+			}
+			if v := getBalance(governanceDeposits, txHeight); v != "3" {
+				t.Fatalf("unexpected governance deposits balance after transaction: %s", v)
+			}
+		})
+	}
+}
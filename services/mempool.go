@@ -3,26 +3,240 @@ package services
 import (
 	"context"
 	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/coinbase/rosetta-sdk-go/server"
 	"github.com/coinbase/rosetta-sdk-go/types"
+	"golang.org/x/sync/singleflight"
 
 	"github.com/oasisprotocol/oasis-core/go/common/crypto/hash"
 	"github.com/oasisprotocol/oasis-core/go/common/logging"
+	"github.com/oasisprotocol/oasis-core/go/common/quantity"
 
+	"github.com/oasisprotocol/oasis-core-rosetta-gateway/common"
 	"github.com/oasisprotocol/oasis-core-rosetta-gateway/oasis"
 )
 
+// mempoolSnapshotTTL is the duration for which a fetched mempool snapshot is
+// reused before the node is queried for unconfirmed transactions again.
+const mempoolSnapshotTTL = 1 * time.Second
+
+// mempoolRefreshTimeout is the timeout of fetching a new mempool snapshot,
+// which isn't bound to the context of any of the requests waiting for it.
+const mempoolRefreshTimeout = 30 * time.Second
+
 var loggerMempool = logging.GetLogger("services/mempool")
 
+// mempoolSnapshot is an immutable view of the node's mempool with all of the
+// unconfirmed transactions already decoded.
+type mempoolSnapshot struct {
+	// Time when the snapshot was taken.
+	fetched time.Time
+
+	// Hashes of all unconfirmed transactions, in the order returned by the node.
+	hashes []hash.Hash
+
+	// Decoded transactions, indexed by transaction hash. Transactions that
+	// failed to decode map to nil.
+	txs map[hash.Hash]*types.Transaction
+}
+
+// Transaction returns the decoded unconfirmed transaction with the given hash.
+//
+// The second return value is false if there is no such transaction in the
+// snapshot. A nil transaction with true means the transaction is malformed.
+func (ms *mempoolSnapshot) Transaction(h hash.Hash) (*types.Transaction, bool) {
+	tx, ok := ms.txs[h]
+	return tx, ok
+}
+
+// Transactions returns all successfully decoded unconfirmed transactions.
+func (ms *mempoolSnapshot) Transactions() []*types.Transaction {
+	txs := make([]*types.Transaction, 0, len(ms.hashes))
+	for _, h := range ms.hashes {
+		if tx := ms.txs[h]; tx != nil {
+			txs = append(txs, tx)
+		}
+	}
+	return txs
+}
+
+// signerTransactions returns the successfully decoded unconfirmed
+// transactions of the given signer with a nonce at or above the given one,
+// i.e. those that aren't committed yet, indexed by nonce.
+func (ms *mempoolSnapshot) signerTransactions(signer string, minNonce uint64) map[uint64]*types.Transaction {
+	txs := make(map[uint64]*types.Transaction)
+	for _, tx := range ms.Transactions() {
+		if tx.Metadata[TxSignerKey] != signer {
+			continue
+		}
+		if nonce, ok := tx.Metadata[NonceKey].(uint64); ok && nonce >= minNonce {
+			txs[nonce] = tx
+		}
+	}
+	return txs
+}
+
+// generalDebit returns the total amount that the given transactions debit
+// from the given address's general account, including fees.
+func generalDebit(txs []*types.Transaction, addr string) (*quantity.Quantity, error) {
+	debit := quantity.NewQuantity()
+	for _, tx := range txs {
+		for _, op := range tx.Operations {
+			if op.Account == nil || op.Account.Address != addr || op.Account.SubAccount != nil ||
+				op.Amount == nil || !strings.HasPrefix(op.Amount.Value, "-") {
+				continue
+			}
+			amount, err := readOasisCurrencyNeg(op.Amount)
+			if err != nil {
+				return nil, fmt.Errorf("malformed pending operation amount: %w", err)
+			}
+			if err = debit.Add(amount); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return debit, nil
+}
+
+// MempoolCache caches a short-lived snapshot of the node's mempool so that
+// repeated queries don't each fetch and decode all unconfirmed transactions.
+// One cache is shared by all services that look at the mempool.
+type MempoolCache struct {
+	sync.Mutex
+
+	oasisClient oasis.Client
+	snapshot    *mempoolSnapshot
+	refreshes   singleflight.Group
+	// generation is incremented whenever the cached snapshot is invalidated,
+	// so that refreshes started before don't cache their outdated results.
+	generation uint64
+}
+
+// Snapshot returns the cached mempool snapshot, refreshing it if it is older
+// than mempoolSnapshotTTL.
+//
+// Concurrent callers share a single refresh, which is not canceled with the
+// context of the caller that started it, while each caller only waits for it
+// as long as its own context allows.
+func (c *MempoolCache) Snapshot(ctx context.Context) (*mempoolSnapshot, error) {
+	c.Lock()
+	ms, generation := c.snapshot, c.generation
+	c.Unlock()
+	if ms != nil && time.Since(ms.fetched) < mempoolSnapshotTTL {
+		return ms, nil
+	}
+
+	ch := c.refreshes.DoChan(strconv.FormatUint(generation, 10), func() (interface{}, error) {
+		refreshCtx, cancel := context.WithTimeout(detachedContext{ctx}, mempoolRefreshTimeout)
+		defer cancel()
+		return c.refresh(refreshCtx, generation)
+	})
+	select {
+	case res := <-ch:
+		if res.Err != nil {
+			return nil, res.Err
+		}
+		return res.Val.(*mempoolSnapshot), nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// invalidate drops the cached snapshot, so that the next Snapshot call
+// fetches the node's mempool again, e.g. after a transaction was submitted.
+func (c *MempoolCache) invalidate() {
+	c.Lock()
+	defer c.Unlock()
+
+	c.snapshot = nil
+	c.generation++
+}
+
+// refresh fetches and decodes a new mempool snapshot and caches it, unless
+// the cache was invalidated since the given generation.
+func (c *MempoolCache) refresh(ctx context.Context, generation uint64) (*mempoolSnapshot, error) {
+	rawTxs, err := c.oasisClient.GetUnconfirmedTransactions(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	ms := &mempoolSnapshot{
+		fetched: time.Now(),
+		hashes:  make([]hash.Hash, 0, len(rawTxs)),
+		txs:     make(map[hash.Hash]*types.Transaction, len(rawTxs)),
+	}
+	for _, rawTx := range rawTxs {
+		h := hash.NewFromBytes(rawTx)
+		if _, exists := ms.txs[h]; exists {
+			continue
+		}
+		ms.hashes = append(ms.hashes, h)
+
+		td := newMempoolTransactionsDecoder()
+		if err = td.DecodeTx(rawTx, nil); err != nil {
+			loggerMempool.Warn("Snapshot: malformed unconfirmed transaction",
+				"tx_hash", h.String(),
+				"err", err,
+			)
+			ms.txs[h] = nil
+			continue
+		}
+		ms.txs[h] = td.Transactions()[0]
+	}
+
+	c.Lock()
+	if c.generation == generation {
+		c.snapshot = ms
+	}
+	c.Unlock()
+
+	return ms, nil
+}
+
+// detachedContext is a context with the values of its parent, but without
+// its deadline and cancellation.
+type detachedContext struct {
+	parent context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func (detachedContext) Done() <-chan struct{} {
+	return nil
+}
+
+func (detachedContext) Err() error {
+	return nil
+}
+
+func (c detachedContext) Value(key interface{}) interface{} {
+	return c.parent.Value(key)
+}
+
+// NewMempoolCache creates a new mempool cache for the given client.
+func NewMempoolCache(oasisClient oasis.Client) *MempoolCache {
+	return &MempoolCache{
+		oasisClient: oasisClient,
+	}
+}
+
 type mempoolAPIService struct {
 	oasisClient oasis.Client
+	cache       *MempoolCache
 }
 
-// NewMempoolAPIService creates a new instance of a NetworkAPIService.
-func NewMempoolAPIService(oasisClient oasis.Client) server.MempoolAPIServicer {
+// NewMempoolAPIService creates a new instance of a MempoolAPIService.
+func NewMempoolAPIService(oasisClient oasis.Client, mempool *MempoolCache) server.MempoolAPIServicer {
 	return &mempoolAPIService{
 		oasisClient: oasisClient,
+		cache:       mempool,
 	}
 }
 
@@ -31,22 +245,24 @@ func (s *mempoolAPIService) Mempool(
 	ctx context.Context,
 	request *types.NetworkRequest,
 ) (*types.MempoolResponse, *types.Error) {
+	logger := common.LoggerWithRequestID(ctx, loggerMempool)
+
 	terr := ValidateNetworkIdentifier(ctx, s.oasisClient, request.NetworkIdentifier)
 	if terr != nil {
-		loggerMempool.Error("Mempool: network validation failed", "err", terr.Message)
+		logger.Error("Mempool: network validation failed", "err", terr.Message)
 		return nil, terr
 	}
 
-	txs, err := s.oasisClient.GetUnconfirmedTransactions(ctx)
+	ms, err := s.cache.Snapshot(ctx)
 	if err != nil {
-		loggerMempool.Error("Mempool: unable to get unconfirmed transactions", "err", err)
+		logger.Error("Mempool: unable to get unconfirmed transactions", "err", err)
 		return nil, ErrUnableToGetTxns
 	}
 
-	tids := make([]*types.TransactionIdentifier, 0, len(txs))
-	for _, tx := range txs {
+	tids := make([]*types.TransactionIdentifier, 0, len(ms.hashes))
+	for _, h := range ms.hashes {
 		tids = append(tids, &types.TransactionIdentifier{
-			Hash: hash.NewFromBytes(tx).String(),
+			Hash: h.String(),
 		})
 	}
 
@@ -54,8 +270,10 @@ func (s *mempoolAPIService) Mempool(
 		TransactionIdentifiers: tids,
 	}
 
-	jr, _ := json.Marshal(resp)
-	loggerMempool.Debug("Mempool OK", "response", jr)
+	if debugEnabled() {
+		jr, _ := json.Marshal(resp)
+		logger.Debug("Mempool OK", "response", jr)
+	}
 
 	return resp, nil
 }
@@ -65,41 +283,48 @@ func (s *mempoolAPIService) MempoolTransaction(
 	ctx context.Context,
 	request *types.MempoolTransactionRequest,
 ) (*types.MempoolTransactionResponse, *types.Error) {
+	logger := common.LoggerWithRequestID(ctx, loggerMempool)
+
 	terr := ValidateNetworkIdentifier(ctx, s.oasisClient, request.NetworkIdentifier)
 	if terr != nil {
-		loggerMempool.Error("MempoolTransaction: network validation failed", "err", terr.Message)
+		logger.Error("MempoolTransaction: network validation failed", "err", terr.Message)
 		return nil, terr
 	}
 
-	txs, err := s.oasisClient.GetUnconfirmedTransactions(ctx)
+	var txHash hash.Hash
+	if err := txHash.UnmarshalHex(request.TransactionIdentifier.Hash); err != nil {
+		logger.Error("MempoolTransaction: malformed transaction hash",
+			"tx_hash", request.TransactionIdentifier.Hash,
+			"err", err,
+		)
+		return nil, ErrMalformedValue
+	}
+
+	ms, err := s.cache.Snapshot(ctx)
 	if err != nil {
-		loggerMempool.Error("MempoolTransaction: unable to get unconfirmed transactions", "err", err)
+		logger.Error("MempoolTransaction: unable to get unconfirmed transactions", "err", err)
 		return nil, ErrUnableToGetTxns
 	}
 
-	var foundTx []byte
-	for _, tx := range txs {
-		if hash.NewFromBytes(tx).String() == request.TransactionIdentifier.Hash {
-			foundTx = tx
-			break
-		}
-	}
-	if foundTx == nil {
+	tx, ok := ms.Transaction(txHash)
+	if !ok {
 		return nil, ErrTransactionNotFound
 	}
-
-	td := newTransactionsDecoder()
-	if err = td.DecodeTx(foundTx, nil); err != nil {
-		loggerMempool.Error("MempoolTransaction: unable to decode unconfirmed transaction", "err", err)
+	if tx == nil {
+		logger.Error("MempoolTransaction: unable to decode unconfirmed transaction",
+			"tx_hash", request.TransactionIdentifier.Hash,
+		)
 		return nil, ErrUnableToGetTxns
 	}
 
 	resp := &types.MempoolTransactionResponse{
-		Transaction: td.Transactions()[0],
+		Transaction: tx,
 	}
 
-	jr, _ := json.Marshal(resp)
-	loggerMempool.Debug("MempoolTransaction OK", "response", jr)
+	if debugEnabled() {
+		jr, _ := json.Marshal(resp)
+		logger.Debug("MempoolTransaction OK", "response", jr)
+	}
 
 	return resp, nil
 }
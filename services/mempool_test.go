@@ -0,0 +1,136 @@
+package services
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+
+	"github.com/coinbase/rosetta-sdk-go/types"
+	"github.com/oasisprotocol/oasis-core/go/common/crypto/hash"
+	staking "github.com/oasisprotocol/oasis-core/go/staking/api"
+
+	"github.com/oasisprotocol/oasis-core-rosetta-gateway/oasis/mock"
+)
+
+func TestMempool(t *testing.T) {
+	ctx := context.Background()
+	oc := newTestClient()
+
+	tx := signTestTx(t, 0, 10, staking.MethodTransfer, newTestTransfer(100))
+	if err := oc.SubmitTxNoWait(ctx, tx); err != nil {
+		t.Fatalf("unable to submit transaction: %v", err)
+	}
+	malformed := []byte("not a transaction")
+	oc.AddUnconfirmedTransaction(malformed)
+
+	s := NewMempoolAPIService(oc, NewMempoolCache(oc))
+
+	resp, err := s.Mempool(ctx, &types.NetworkRequest{NetworkIdentifier: testNetworkIdentifier})
+	requireNoError(t, err)
+	if len(resp.TransactionIdentifiers) != 2 || resp.TransactionIdentifiers[0].Hash != tx.Hash().String() {
+		t.Fatalf("unexpected mempool transactions: %v", types.PrettyPrintStruct(resp.TransactionIdentifiers))
+	}
+
+	txResp, err := s.MempoolTransaction(ctx, &types.MempoolTransactionRequest{
+		NetworkIdentifier:     testNetworkIdentifier,
+		TransactionIdentifier: &types.TransactionIdentifier{Hash: tx.Hash().String()},
+	})
+	requireNoError(t, err)
+	mtx := txResp.Transaction
+	if mtx.Metadata[TxSignerKey] != testAddrStr || mtx.Metadata[NonceKey] != uint64(0) ||
+		mtx.Metadata[TxMethodKey] != string(staking.MethodTransfer) || mtx.Metadata[TxFeeAmountKey] != "10" {
+		t.Fatalf("unexpected transaction metadata: %v", mtx.Metadata)
+	}
+	if ops := findOps(mtx, OpTransfer, testAddrStr, nil); len(ops) != 2 || *ops[1].Status != OpStatusOK {
+		t.Fatalf("unexpected transaction operations: %v", types.PrettyPrintStruct(mtx.Operations))
+	}
+
+	_, err = s.MempoolTransaction(ctx, &types.MempoolTransactionRequest{
+		NetworkIdentifier:     testNetworkIdentifier,
+		TransactionIdentifier: &types.TransactionIdentifier{Hash: hash.NewFromBytes(malformed).String()},
+	})
+	requireError(t, ErrUnableToGetTxns, err)
+
+	_, err = s.MempoolTransaction(ctx, &types.MempoolTransactionRequest{
+		NetworkIdentifier:     testNetworkIdentifier,
+		TransactionIdentifier: &types.TransactionIdentifier{Hash: hash.NewFromBytes([]byte("missing")).String()},
+	})
+	requireError(t, ErrTransactionNotFound, err)
+
+	_, err = s.MempoolTransaction(ctx, &types.MempoolTransactionRequest{
+		NetworkIdentifier:     testNetworkIdentifier,
+		TransactionIdentifier: &types.TransactionIdentifier{Hash: "xyz"},
+	})
+	requireError(t, ErrMalformedValue, err)
+}
+
+func TestMempoolError(t *testing.T) {
+	ctx := context.Background()
+	oc := newTestClient()
+	oc.SetError(mock.MethodGetUnconfirmedTransactions, context.DeadlineExceeded)
+	s := NewMempoolAPIService(oc, NewMempoolCache(oc))
+
+	_, err := s.Mempool(ctx, &types.NetworkRequest{NetworkIdentifier: testNetworkIdentifier})
+	requireError(t, ErrUnableToGetTxns, err)
+}
+
+// blockingMempoolClient is a mock client whose GetUnconfirmedTransactions
+// calls block until released.
+type blockingMempoolClient struct {
+	*mock.Client
+
+	calls   int32
+	started chan struct{}
+	release chan struct{}
+}
+
+func (c *blockingMempoolClient) GetUnconfirmedTransactions(ctx context.Context) ([][]byte, error) {
+	atomic.AddInt32(&c.calls, 1)
+	c.started <- struct{}{}
+	<-c.release
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return c.Client.GetUnconfirmedTransactions(ctx)
+}
+
+func TestMempoolCacheSharedRefresh(t *testing.T) {
+	oc := &blockingMempoolClient{
+		Client:  newTestClient(),
+		started: make(chan struct{}, 1),
+		release: make(chan struct{}),
+	}
+	c := NewMempoolCache(oc)
+
+	// The caller that started the refresh gives up on it.
+	ctx, cancel := context.WithCancel(context.Background())
+	errCh := make(chan error, 1)
+	go func() {
+		_, err := c.Snapshot(ctx)
+		errCh <- err
+	}()
+	<-oc.started
+	cancel()
+	if err := <-errCh; err != context.Canceled {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+
+	// Other callers share the refresh, which isn't canceled.
+	snapshots := make(chan *mempoolSnapshot, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			ms, err := c.Snapshot(context.Background())
+			if err != nil {
+				t.Errorf("unable to get mempool snapshot: %v", err)
+			}
+			snapshots <- ms
+		}()
+	}
+	close(oc.release)
+	if ms1, ms2 := <-snapshots, <-snapshots; ms1 == nil || ms1 != ms2 {
+		t.Fatalf("expected a shared snapshot")
+	}
+	if calls := atomic.LoadInt32(&oc.calls); calls != 1 {
+		t.Fatalf("unexpected number of refreshes: %d", calls)
+	}
+}
@@ -11,19 +11,25 @@ import (
 	"github.com/oasisprotocol/oasis-core/go/common/logging"
 
 	"github.com/oasisprotocol/oasis-core-rosetta-gateway/common"
+	"github.com/oasisprotocol/oasis-core-rosetta-gateway/history"
 	"github.com/oasisprotocol/oasis-core-rosetta-gateway/oasis"
 )
 
 var loggerNet = logging.GetLogger("services/network")
 
 type networkAPIService struct {
-	oasisClient oasis.Client
+	oasisClient    oasis.Client
+	balanceHistory *history.Store
 }
 
 // NewNetworkAPIService creates a new instance of a NetworkAPIService.
-func NewNetworkAPIService(oasisClient oasis.Client) server.NetworkAPIServicer {
+//
+// The balance history store is optional (nil to disable) and extends the
+// range of blocks advertised as available for balance queries.
+func NewNetworkAPIService(oasisClient oasis.Client, balanceHistory *history.Store) server.NetworkAPIServicer {
 	return &networkAPIService{
-		oasisClient: oasisClient,
+		oasisClient:    oasisClient,
+		balanceHistory: balanceHistory,
 	}
 }
 
@@ -32,9 +38,11 @@ func (s *networkAPIService) NetworkList(
 	ctx context.Context,
 	request *types.MetadataRequest,
 ) (*types.NetworkListResponse, *types.Error) {
+	logger := common.LoggerWithRequestID(ctx, loggerNet)
+
 	chainID, err := GetChainID(ctx, s.oasisClient)
 	if err != nil {
-		loggerNet.Error("NetworkList: unable to get chain ID")
+		logger.Error("NetworkList: unable to get chain ID")
 		return nil, err
 	}
 
@@ -47,8 +55,10 @@ func (s *networkAPIService) NetworkList(
 		},
 	}
 
-	jr, _ := json.Marshal(resp)
-	loggerNet.Debug("NetworkList OK", "response", jr)
+	if debugEnabled() {
+		jr, _ := json.Marshal(resp)
+		logger.Debug("NetworkList OK", "response", jr)
+	}
 
 	return resp, nil
 }
@@ -58,18 +68,28 @@ func (s *networkAPIService) NetworkStatus(
 	ctx context.Context,
 	request *types.NetworkRequest,
 ) (*types.NetworkStatusResponse, *types.Error) {
+	logger := common.LoggerWithRequestID(ctx, loggerNet)
+
 	terr := ValidateNetworkIdentifier(ctx, s.oasisClient, request.NetworkIdentifier)
 	if terr != nil {
-		loggerNet.Error("NetworkStatus: network validation failed", "err", terr.Message)
+		logger.Error("NetworkStatus: network validation failed", "err", terr.Message)
 		return nil, terr
 	}
 
 	status, err := s.oasisClient.GetStatus(ctx)
 	if err != nil {
-		loggerNet.Error("NetworkStatus: unable to get node status", "err", err)
+		logger.Error("NetworkStatus: unable to get node status", "err", err)
 		return nil, ErrUnableToGetNodeStatus
 	}
 
+	// Report the latest block the way the other endpoints see it, so that its
+	// header is cached for the queries that follow.
+	latest, err := s.oasisClient.GetBlockHeader(ctx, oasis.LatestHeight)
+	if err != nil {
+		logger.Error("NetworkStatus: unable to get latest block", "err", err)
+		return nil, ErrUnableToGetLatestBlk
+	}
+
 	peers := []*types.Peer{}
 	for _, p := range status.Consensus.NodePeers {
 		peers = append(peers, &types.Peer{
@@ -94,12 +114,26 @@ func (s *networkAPIService) NetworkStatus(
 		}
 	}
 
+	if s.balanceHistory != nil {
+		oldest, _, ok, err := s.balanceHistory.Range()
+		switch {
+		case err != nil:
+			logger.Warn("NetworkStatus: unable to get balance history range", "err", err)
+		case ok && (oldestBlockIdentifier == nil || oldest.Height < oldestBlockIdentifier.Index):
+			// Balances can be queried as of the oldest block in the history.
+			oldestBlockIdentifier = &types.BlockIdentifier{
+				Index: oldest.Height,
+				Hash:  oldest.Hash,
+			}
+		}
+	}
+
 	resp := &types.NetworkStatusResponse{
 		CurrentBlockIdentifier: &types.BlockIdentifier{
-			Index: status.Consensus.LatestHeight,
-			Hash:  hex.EncodeToString(status.Consensus.LatestHash),
+			Index: latest.Height,
+			Hash:  latest.Hash,
 		},
-		CurrentBlockTimestamp: status.Consensus.LatestTime.UnixNano() / 1000000, // ms
+		CurrentBlockTimestamp: latest.Timestamp,
 		GenesisBlockIdentifier: &types.BlockIdentifier{
 			Index: status.Consensus.GenesisHeight,
 			Hash:  genesisBlockIdentifierHash,
@@ -108,8 +142,10 @@ func (s *networkAPIService) NetworkStatus(
 		Peers:                 peers,
 	}
 
-	jr, _ := json.Marshal(resp)
-	loggerNet.Debug("NetworkStatus OK", "response", jr)
+	if debugEnabled() {
+		jr, _ := json.Marshal(resp)
+		logger.Debug("NetworkStatus OK", "response", jr)
+	}
 
 	return resp, nil
 }
@@ -119,15 +155,17 @@ func (s *networkAPIService) NetworkOptions(
 	ctx context.Context,
 	request *types.NetworkRequest,
 ) (*types.NetworkOptionsResponse, *types.Error) {
+	logger := common.LoggerWithRequestID(ctx, loggerNet)
+
 	terr := ValidateNetworkIdentifier(ctx, s.oasisClient, request.NetworkIdentifier)
 	if terr != nil {
-		loggerNet.Error("NetworkStatus: network validation failed", "err", terr.Message)
+		logger.Error("NetworkStatus: network validation failed", "err", terr.Message)
 		return nil, terr
 	}
 
 	status, err := s.oasisClient.GetStatus(ctx)
 	if err != nil {
-		loggerNet.Error("NetworkStatus: unable to get node status", "err", err)
+		logger.Error("NetworkStatus: unable to get node status", "err", err)
 		return nil, ErrUnableToGetNodeStatus
 	}
 
@@ -149,6 +187,8 @@ func (s *networkAPIService) NetworkOptions(
 			},
 			OperationTypes: SupportedOperationTypes,
 			Errors:         ErrorList,
+			CallMethods:    SupportedCallMethods,
+			MempoolCoins:   true,
 		},
 	}, nil
 }
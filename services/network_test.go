@@ -0,0 +1,77 @@
+package services
+
+import (
+	"context"
+	"testing"
+
+	"github.com/coinbase/rosetta-sdk-go/types"
+
+	"github.com/oasisprotocol/oasis-core-rosetta-gateway/common"
+	"github.com/oasisprotocol/oasis-core-rosetta-gateway/oasis/mock"
+)
+
+func TestNetworkList(t *testing.T) {
+	s := NewNetworkAPIService(newTestClient(), nil)
+
+	resp, err := s.NetworkList(context.Background(), &types.MetadataRequest{})
+	requireNoError(t, err)
+	if len(resp.NetworkIdentifiers) != 1 || types.Hash(resp.NetworkIdentifiers[0]) != types.Hash(testNetworkIdentifier) {
+		t.Fatalf("unexpected network identifiers: %v", types.PrettyPrintStruct(resp.NetworkIdentifiers))
+	}
+}
+
+func TestNetworkStatus(t *testing.T) {
+	ctx := context.Background()
+	oc := newTestClient()
+	for i := 0; i < 3; i++ {
+		oc.CommitBlock()
+	}
+	latest, _ := oc.GetLatestBlock(ctx)
+	s := NewNetworkAPIService(oc, nil)
+
+	resp, err := s.NetworkStatus(ctx, &types.NetworkRequest{NetworkIdentifier: testNetworkIdentifier})
+	requireNoError(t, err)
+	if resp.CurrentBlockIdentifier.Index != latest.Height || resp.CurrentBlockIdentifier.Hash != latest.Hash {
+		t.Fatalf("unexpected current block: %v", types.PrettyPrintStruct(resp.CurrentBlockIdentifier))
+	}
+	if resp.CurrentBlockTimestamp != latest.Timestamp {
+		t.Fatalf("unexpected current block timestamp: %d", resp.CurrentBlockTimestamp)
+	}
+	if resp.GenesisBlockIdentifier.Index != mock.GenesisHeight {
+		t.Fatalf("unexpected genesis block: %v", types.PrettyPrintStruct(resp.GenesisBlockIdentifier))
+	}
+	if resp.OldestBlockIdentifier.Index != mock.GenesisHeight {
+		t.Fatalf("unexpected oldest block: %v", types.PrettyPrintStruct(resp.OldestBlockIdentifier))
+	}
+
+	// The oldest block follows pruning.
+	oc.PruneTo(mock.GenesisHeight + 2)
+	resp, err = s.NetworkStatus(ctx, &types.NetworkRequest{NetworkIdentifier: testNetworkIdentifier})
+	requireNoError(t, err)
+	if resp.OldestBlockIdentifier.Index != mock.GenesisHeight+2 {
+		t.Fatalf("unexpected oldest block after pruning: %v", types.PrettyPrintStruct(resp.OldestBlockIdentifier))
+	}
+
+	oc.SetError(mock.MethodGetStatus, context.DeadlineExceeded)
+	_, err = s.NetworkStatus(ctx, &types.NetworkRequest{NetworkIdentifier: testNetworkIdentifier})
+	requireError(t, ErrUnableToGetNodeStatus, err)
+}
+
+func TestNetworkOptions(t *testing.T) {
+	s := NewNetworkAPIService(newTestClient(), nil)
+
+	resp, err := s.NetworkOptions(context.Background(), &types.NetworkRequest{NetworkIdentifier: testNetworkIdentifier})
+	requireNoError(t, err)
+	if resp.Version.RosettaVersion != common.RosettaAPIVersion || resp.Version.NodeVersion != mock.SoftwareVersion {
+		t.Fatalf("unexpected version: %v", types.PrettyPrintStruct(resp.Version))
+	}
+	if len(resp.Allow.OperationTypes) != len(SupportedOperationTypes) {
+		t.Fatalf("unexpected operation types: %v", resp.Allow.OperationTypes)
+	}
+	if len(resp.Allow.Errors) != len(ErrorList) {
+		t.Fatalf("unexpected number of errors: %d", len(resp.Allow.Errors))
+	}
+
+	_, err = s.NetworkOptions(context.Background(), &types.NetworkRequest{})
+	requireError(t, ErrMissingNID, err)
+}
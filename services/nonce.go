@@ -0,0 +1,115 @@
+package services
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	staking "github.com/oasisprotocol/oasis-core/go/staking/api"
+
+	"github.com/oasisprotocol/oasis-core-rosetta-gateway/oasis"
+)
+
+// NonceManager hands out the next free nonce of a signer in
+// /construction/metadata, taking into account the signer's transactions in
+// the mempool and the nonces it already handed out for transactions that
+// haven't been submitted yet, so that a signer can construct several
+// transactions per block without their nonces colliding.
+//
+// A handed out nonce is reserved until a transaction with it shows up in the
+// mempool or in committed state, or until the reservation times out, after
+// which the nonce is handed out again.
+type NonceManager struct {
+	sync.Mutex
+
+	timeout time.Duration
+	// reservations are the expiry times of the reserved nonces of each signer.
+	reservations map[staking.Address]map[uint64]time.Time
+	// lastSweep is the time when expired reservations of all signers were
+	// last released.
+	lastSweep time.Time
+
+	now func() time.Time
+}
+
+// NewNonceManager creates a new nonce manager that releases reservations
+// after the given timeout.
+func NewNonceManager(timeout time.Duration) *NonceManager {
+	return &NonceManager{
+		timeout:      timeout,
+		reservations: make(map[staking.Address]map[uint64]time.Time),
+		now:          time.Now,
+	}
+}
+
+// next returns the lowest nonce of the signer at or above its next nonce at
+// the given height that isn't used by a transaction in the mempool or
+// reserved, and reserves it.
+func (m *NonceManager) next(
+	ctx context.Context,
+	oc oasis.Client,
+	mempool *MempoolCache,
+	signer staking.Address,
+	height int64,
+) (uint64, error) {
+	committed, err := oc.GetNextNonce(ctx, signer, height)
+	if err != nil {
+		return 0, err
+	}
+	ms, err := mempool.Snapshot(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	pending := make(map[uint64]bool)
+	for nonce := range ms.signerTransactions(StringFromAddress(signer), committed) {
+		pending[nonce] = true
+	}
+
+	m.Lock()
+	defer m.Unlock()
+
+	now := m.now()
+	if now.Sub(m.lastSweep) >= m.timeout {
+		for addr, reserved := range m.reservations {
+			m.releaseLocked(addr, reserved, now, 0, nil)
+		}
+		m.lastSweep = now
+	}
+
+	if reserved := m.reservations[signer]; reserved != nil {
+		m.releaseLocked(signer, reserved, now, committed, pending)
+	}
+	reserved := m.reservations[signer]
+	if reserved == nil {
+		reserved = make(map[uint64]time.Time)
+		m.reservations[signer] = reserved
+	}
+
+	nonce := committed
+	for pending[nonce] || !reserved[nonce].IsZero() {
+		nonce++
+	}
+	reserved[nonce] = now.Add(m.timeout)
+	return nonce, nil
+}
+
+// releaseLocked releases the given reservations of the signer that expired,
+// that are below its committed next nonce or that are used by a transaction
+// in the mempool.
+func (m *NonceManager) releaseLocked(
+	signer staking.Address,
+	reserved map[uint64]time.Time,
+	now time.Time,
+	committed uint64,
+	pending map[uint64]bool,
+) {
+	for nonce, expiry := range reserved {
+		if nonce < committed || pending[nonce] || !now.Before(expiry) {
+			delete(reserved, nonce)
+		}
+	}
+	if len(reserved) == 0 {
+		delete(m.reservations, signer)
+	}
+}
@@ -0,0 +1,84 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/coinbase/rosetta-sdk-go/types"
+	staking "github.com/oasisprotocol/oasis-core/go/staking/api"
+
+	"github.com/oasisprotocol/oasis-core-rosetta-gateway/oasis"
+)
+
+func TestNonceManager(t *testing.T) {
+	ctx := context.Background()
+	oc := newTestClient()
+	m := NewNonceManager(time.Minute)
+	now := time.Now()
+	m.now = func() time.Time { return now }
+
+	requireNext := func(expected uint64) {
+		t.Helper()
+		// A fresh cache, so that the mempool is never stale.
+		nonce, err := m.next(ctx, oc, NewMempoolCache(oc), testAddr, oasis.LatestHeight)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if nonce != expected {
+			t.Fatalf("unexpected nonce: %d (expected: %d)", nonce, expected)
+		}
+	}
+
+	// Handed out nonces are reserved.
+	requireNext(0)
+	requireNext(1)
+
+	// Transactions in the mempool take over the reservations of their
+	// nonces.
+	if err := oc.SubmitTxNoWait(ctx, signTestTx(t, 0, 10, staking.MethodTransfer, newTestTransfer(100))); err != nil {
+		t.Fatalf("unable to submit transaction: %v", err)
+	}
+	requireNext(2)
+
+	// Stale reservations are released, and their nonces handed out again.
+	now = now.Add(time.Minute)
+	requireNext(1)
+	requireNext(2)
+
+	// Committed transactions release the reservations below the next nonce.
+	if err := oc.SubmitTxNoWait(ctx, signTestTx(t, 1, 10, staking.MethodTransfer, newTestTransfer(100))); err != nil {
+		t.Fatalf("unable to submit transaction: %v", err)
+	}
+	oc.CommitBlock()
+	requireNext(3)
+	if reserved := m.reservations[testAddr]; len(reserved) != 2 {
+		t.Fatalf("unexpected reservations: %v", reserved)
+	}
+
+	// Expired reservations of other signers are released too.
+	now = now.Add(time.Minute)
+	if _, err := m.next(ctx, oc, NewMempoolCache(oc), testOther, oasis.LatestHeight); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, ok := m.reservations[testAddr]; ok || len(m.reservations) != 1 {
+		t.Fatalf("unexpected reservations: %v", m.reservations)
+	}
+}
+
+func TestConstructionMetadataNonceManager(t *testing.T) {
+	ctx := context.Background()
+	oc := newTestClient()
+	s := NewConstructionAPIService(oc, NewMempoolCache(oc), nil, NewNonceManager(time.Minute))
+
+	for _, expected := range []uint64{0, 1, 2} {
+		resp, err := s.ConstructionMetadata(ctx, &types.ConstructionMetadataRequest{
+			NetworkIdentifier: testNetworkIdentifier,
+			Options:           map[string]interface{}{OptionsIDKey: testAddrStr},
+		})
+		requireNoError(t, err)
+		if resp.Metadata[NonceKey] != expected {
+			t.Fatalf("unexpected nonce: %v (expected: %d)", resp.Metadata[NonceKey], expected)
+		}
+	}
+}
@@ -2,6 +2,7 @@ package services
 
 import (
 	"fmt"
+	"math"
 	"math/big"
 
 	"github.com/coinbase/rosetta-sdk-go/types"
@@ -48,9 +49,25 @@ var (
 	OpStatusFailed = "Failed"
 )
 
+// TxSignerKey is the name of the key in the Metadata map of a decoded
+// transaction that specifies the address of the transaction's signer.
+const TxSignerKey = "signer"
+
+// TxFeeAmountKey is the name of the key in the Metadata map of a decoded
+// transaction that specifies the fee amount in base units.
+const TxFeeAmountKey = "fee_amount"
+
+// TxMethodKey is the name of the key in the Metadata map of a decoded
+// transaction that specifies the transaction's method name.
+const TxMethodKey = "method"
+
 type transactionsDecoder struct {
 	txs   []*types.Transaction
 	index map[hash.Hash]*types.Transaction
+
+	// withTxMetadata specifies whether decoded transactions should include
+	// signer, nonce, fee and method metadata.
+	withTxMetadata bool
 }
 
 func (d *transactionsDecoder) DecodeTx(rawTx []byte, result *results.Result) error {
@@ -64,7 +81,11 @@ func (d *transactionsDecoder) DecodeTx(rawTx []byte, result *results.Result) err
 	}
 
 	txHash := sigTx.Hash()
+	txSignerAddress := StringFromAddress(staking.NewAddress(sigTx.Signature.PublicKey))
 	rosettaTx := d.getOrCreateTx(txHash)
+	if d.withTxMetadata {
+		rosettaTx.Metadata = getTxMetadata(&tx, txSignerAddress)
+	}
 
 	// Decode events emitted by the transaction.
 	if result != nil {
@@ -88,7 +109,6 @@ func (d *transactionsDecoder) DecodeTx(rawTx []byte, result *results.Result) err
 	// * Result is not provided because the transaction has not yet been executed. In this case
 	//   nothing has been emitted yet so we need to generate OK operations.
 	if result == nil || !result.IsSuccess() {
-		txSignerAddress := StringFromAddress(staking.NewAddress(sigTx.Signature.PublicKey))
 		o2t := newOperationToTransactionMapper(rosettaTx.Operations)
 
 		var status string
@@ -113,6 +133,20 @@ func (d *transactionsDecoder) DecodeTx(rawTx []byte, result *results.Result) err
 	return nil
 }
 
+// getTxMetadata returns the Metadata map of a decoded transaction.
+func getTxMetadata(tx *transaction.Transaction, txSignerAddress string) map[string]interface{} {
+	md := map[string]interface{}{
+		TxSignerKey: txSignerAddress,
+		NonceKey:    tx.Nonce,
+		TxMethodKey: string(tx.Method),
+	}
+	if tx.Fee != nil {
+		md[TxFeeAmountKey] = tx.Fee.Amount.String()
+		md[FeeGasKey] = tx.Fee.Gas
+	}
+	return md
+}
+
 func (d *transactionsDecoder) DecodeBlock(blkHash hash.Hash, events []*staking.Event) error {
 	for _, ev := range events {
 		// We put all block-level events under an empty "transaction". All other events are skipped
@@ -280,6 +314,15 @@ func newTransactionsDecoder() *transactionsDecoder {
 	}
 }
 
+// newMempoolTransactionsDecoder creates a transactions decoder that also
+// emits transaction metadata, as there are no events from which the effects of
+// unconfirmed transactions could be determined.
+func newMempoolTransactionsDecoder() *transactionsDecoder {
+	d := newTransactionsDecoder()
+	d.withTxMetadata = true
+	return d
+}
+
 type operationToTransactionMapper struct {
 	ops []*types.Operation
 }
@@ -292,6 +335,15 @@ func (m *operationToTransactionMapper) HasFee() bool {
 		m.ops[1].Account.Address == StringFromAddress(staking.FeeAccumulatorAddress)
 }
 
+// hasTxFee verifies whether the given operation list contains fee payment
+// operations followed by the operations of a transaction.
+//
+// Without the latter, the operations of a transfer to the fee accumulator that
+// doesn't pay a fee would be mistaken for fee payment operations.
+func (m *operationToTransactionMapper) hasTxFee() bool {
+	return len(m.ops) > 2 && m.HasFee()
+}
+
 // GetFee returns the fee (if any) extracted from the fee payment operations.
 //
 // If fee payment operations are present, this method also returns the signer address.
@@ -299,7 +351,7 @@ func (m *operationToTransactionMapper) GetFee() (string, *transaction.Fee, error
 	fee := transaction.Fee{
 		Gas: DefaultGas,
 	}
-	if !m.HasFee() {
+	if !m.hasTxFee() {
 		return "", &fee, nil
 	}
 
@@ -315,7 +367,7 @@ func (m *operationToTransactionMapper) GetFee() (string, *transaction.Fee, error
 	}
 	if feeGasRaw, ok := m.ops[0].Metadata[FeeGasKey]; ok {
 		feeGasF64, ok := feeGasRaw.(float64)
-		if !ok {
+		if !ok || feeGasF64 < 0 || feeGasF64 >= math.MaxUint64 || feeGasF64 != math.Trunc(feeGasF64) {
 			return "", nil, fmt.Errorf("malformed fee transfer gas metadata")
 		}
 		fee.Gas = transaction.Gas(feeGasF64)
@@ -338,7 +390,10 @@ func (m *operationToTransactionMapper) GetFee() (string, *transaction.Fee, error
 }
 
 func readCurrency(amount *types.Amount, currency *types.Currency, negative bool) (*quantity.Quantity, error) {
-	if amount.Currency.Symbol != currency.Symbol {
+	if amount == nil {
+		return nil, fmt.Errorf("missing amount")
+	}
+	if amount.Currency == nil || amount.Currency.Symbol != currency.Symbol {
 		return nil, fmt.Errorf("wrong currency")
 	}
 	bi := new(big.Int)
@@ -465,6 +520,23 @@ func getStakingReclaimEscrow(ops []*types.Operation) (*staking.ReclaimEscrow, er
 	return &reclaim, nil
 }
 
+// checkOperations ensures that the given operations can be inspected, i.e.
+// that there are some and that each of them has an account.
+func checkOperations(ops []*types.Operation) error {
+	if len(ops) == 0 {
+		return fmt.Errorf("no operations")
+	}
+	for i, op := range ops {
+		switch {
+		case op == nil:
+			return fmt.Errorf("operation %d missing", i)
+		case op.Account == nil:
+			return fmt.Errorf("operation %d missing account", i)
+		}
+	}
+	return nil
+}
+
 // checkSigner ensures the operation's signer address matches the given signer
 // address (if specified) and returns the operation's signer address.
 func checkOpSignerAddress(op *types.Operation, signerAddr string) (string, error) {
@@ -535,13 +607,16 @@ func decodeOpsToTransactionKind(ops []*types.Operation) TransactionKind {
 //
 // The method also returns the signer address.
 func (m *operationToTransactionMapper) GetTransaction() (string, *transaction.Transaction, error) {
+	if err := checkOperations(m.ops); err != nil {
+		return "", nil, fmt.Errorf("malformed operations: %w", err)
+	}
 	signerAddr, fee, err := m.GetFee()
 	if err != nil {
 		return "", nil, fmt.Errorf("malformed fee operations: %w", err)
 	}
 
 	remainingOps := m.ops
-	if m.HasFee() {
+	if m.hasTxFee() {
 		remainingOps = remainingOps[2:]
 	}
 
@@ -865,3 +940,15 @@ func newTransactionToOperationMapper(
 		ops:             ops,
 	}
 }
+
+// TransactionToOperations returns the Rosetta operations of the given
+// transaction signed by the given address, as returned by the
+// /construction/parse endpoint.
+func TransactionToOperations(tx *transaction.Transaction, txSignerAddress string) ([]*types.Operation, error) {
+	om := newTransactionToOperationMapper(tx, txSignerAddress, "", []*types.Operation{})
+	om.EmitFeeOps()
+	if err := om.EmitTxOps(); err != nil {
+		return nil, err
+	}
+	return om.Operations(), nil
+}
@@ -0,0 +1,322 @@
+package services
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"math/rand"
+	"testing"
+
+	"github.com/coinbase/rosetta-sdk-go/types"
+	"github.com/oasisprotocol/oasis-core/go/common/cbor"
+	"github.com/oasisprotocol/oasis-core/go/common/crypto/signature"
+	"github.com/oasisprotocol/oasis-core/go/common/quantity"
+	"github.com/oasisprotocol/oasis-core/go/consensus/api/transaction"
+	staking "github.com/oasisprotocol/oasis-core/go/staking/api"
+)
+
+// propertyTestIterations is the number of random inputs checked by each of
+// the property tests.
+const propertyTestIterations = 500
+
+// testTransactionMethods are the methods of all transaction kinds that map to
+// operations.
+var testTransactionMethods = []transaction.MethodName{
+	staking.MethodTransfer,
+	staking.MethodBurn,
+	staking.MethodAddEscrow,
+	staking.MethodReclaimEscrow,
+}
+
+// randomTestQuantity returns a random quantity, biased towards edge cases.
+func randomTestQuantity(rng *rand.Rand) quantity.Quantity {
+	var q quantity.Quantity
+	switch rng.Intn(4) {
+	case 0:
+		// Zero.
+	case 1:
+		_ = q.FromUint64(uint64(rng.Intn(1000)))
+	case 2:
+		_ = q.FromUint64(rng.Uint64())
+	default:
+		_ = q.FromBigInt(new(big.Int).Rand(rng, new(big.Int).Lsh(big.NewInt(1), 256)))
+	}
+	return q
+}
+
+// randomTestAddress returns a random address, sometimes one of the special
+// staking addresses.
+func randomTestAddress(rng *rand.Rand) staking.Address {
+	switch rng.Intn(8) {
+	case 0:
+		return staking.FeeAccumulatorAddress
+	case 1:
+		return staking.CommonPoolAddress
+	default:
+		var pk signature.PublicKey
+		_, _ = rng.Read(pk[:])
+		return staking.NewAddress(pk)
+	}
+}
+
+// randomTestTransaction returns a random transaction with the given method.
+func randomTestTransaction(rng *rand.Rand, method transaction.MethodName) *transaction.Transaction {
+	// The gas is only carried by the fee operations, which are omitted for
+	// zero fees, and it is a JSON number in the operations' metadata.
+	fee := &transaction.Fee{
+		Amount: randomTestQuantity(rng),
+		Gas:    DefaultGas,
+	}
+	if !fee.Amount.IsZero() {
+		fee.Gas = transaction.Gas(rng.Int63n(1 << 53))
+	}
+
+	var body interface{}
+	switch method {
+	case staking.MethodTransfer:
+		body = &staking.Transfer{To: randomTestAddress(rng), Amount: randomTestQuantity(rng)}
+	case staking.MethodBurn:
+		body = &staking.Burn{Amount: randomTestQuantity(rng)}
+	case staking.MethodAddEscrow:
+		body = &staking.Escrow{Account: randomTestAddress(rng), Amount: randomTestQuantity(rng)}
+	case staking.MethodReclaimEscrow:
+		body = &staking.ReclaimEscrow{Account: randomTestAddress(rng), Shares: randomTestQuantity(rng)}
+	}
+	return transaction.NewTransaction(0, fee, method, body)
+}
+
+// transactionToTestOperations maps the given transaction to operations the
+// same way /construction/parse does, and returns them as they would be
+// received by the server after a JSON round trip.
+func transactionToTestOperations(tx *transaction.Transaction, signerAddr string) ([]*types.Operation, error) {
+	ops, err := TransactionToOperations(tx, signerAddr)
+	if err != nil {
+		return nil, fmt.Errorf("unable to map transaction to operations: %w", err)
+	}
+
+	raw, err := json.Marshal(ops)
+	if err != nil {
+		return nil, fmt.Errorf("unable to marshal operations: %w", err)
+	}
+	var result []*types.Operation
+	if err = json.Unmarshal(raw, &result); err != nil {
+		return nil, fmt.Errorf("unable to unmarshal operations: %w", err)
+	}
+	return result, nil
+}
+
+// checkOperationsRoundTrip checks that mapping the given transaction to
+// operations and back yields the same transaction, and that mapping the
+// resulting transaction to operations again yields the same operations.
+func checkOperationsRoundTrip(tx *transaction.Transaction, signerAddr string) error {
+	ops, err := transactionToTestOperations(tx, signerAddr)
+	if err != nil {
+		return err
+	}
+
+	signerAddr2, tx2, err := newOperationToTransactionMapper(ops).GetTransaction()
+	if err != nil {
+		return fmt.Errorf("unable to map operations to transaction: %w (operations: %s)",
+			err, types.PrettyPrintStruct(ops),
+		)
+	}
+	if signerAddr2 != signerAddr {
+		return fmt.Errorf("signer differs (expected: %s actual: %s)", signerAddr, signerAddr2)
+	}
+	tx2.Nonce = tx.Nonce
+	if !bytes.Equal(cbor.Marshal(tx), cbor.Marshal(tx2)) {
+		return fmt.Errorf("transaction differs (expected: %+v actual: %+v)", tx, tx2)
+	}
+
+	ops2, err := transactionToTestOperations(tx2, signerAddr2)
+	if err != nil {
+		return err
+	}
+	if expected, actual := types.PrettyPrintStruct(ops), types.PrettyPrintStruct(ops2); expected != actual {
+		return fmt.Errorf("operations differ (expected: %s actual: %s)", expected, actual)
+	}
+	return nil
+}
+
+func TestOperationsRoundTrip(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for _, method := range testTransactionMethods {
+		t.Run(string(method), func(t *testing.T) {
+			for i := 0; i < propertyTestIterations; i++ {
+				tx := randomTestTransaction(rng, method)
+				signerAddr := StringFromAddress(randomTestAddress(rng))
+				if err := checkOperationsRoundTrip(tx, signerAddr); err != nil {
+					t.Fatalf("round trip failed: %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestOperationsMalformed(t *testing.T) {
+	validOps := func() []*types.Operation {
+		return newTestTransferOps("100", "10")
+	}
+
+	for _, tc := range []struct {
+		name   string
+		mutate func(ops []*types.Operation) []*types.Operation
+	}{
+		{"NoOperations", func(ops []*types.Operation) []*types.Operation {
+			return nil
+		}},
+		{"NilOperation", func(ops []*types.Operation) []*types.Operation {
+			ops[1] = nil
+			return ops
+		}},
+		{"NilFeeAccount", func(ops []*types.Operation) []*types.Operation {
+			ops[1].Account = nil
+			return ops
+		}},
+		{"NilAccount", func(ops []*types.Operation) []*types.Operation {
+			ops[3].Account = nil
+			return ops
+		}},
+		{"NilFeeAmount", func(ops []*types.Operation) []*types.Operation {
+			ops[0].Amount = nil
+			return ops
+		}},
+		{"NilAmount", func(ops []*types.Operation) []*types.Operation {
+			ops[3].Amount = nil
+			return ops
+		}},
+		{"NilCurrency", func(ops []*types.Operation) []*types.Operation {
+			ops[2].Amount.Currency = nil
+			return ops
+		}},
+		{"WrongCurrency", func(ops []*types.Operation) []*types.Operation {
+			ops[2].Amount.Currency = &types.Currency{Symbol: "BTC", Decimals: 8}
+			return ops
+		}},
+		{"MalformedAmount", func(ops []*types.Operation) []*types.Operation {
+			ops[3].Amount.Value = "1e9"
+			return ops
+		}},
+		{"PositiveDebit", func(ops []*types.Operation) []*types.Operation {
+			ops[2].Amount.Value = "100"
+			return ops
+		}},
+		{"MalformedFeeGas", func(ops []*types.Operation) []*types.Operation {
+			ops[0].Metadata = map[string]interface{}{FeeGasKey: -1.0}
+			return ops
+		}},
+		{"MissingTransferTo", func(ops []*types.Operation) []*types.Operation {
+			return ops[:3]
+		}},
+		{"UnknownType", func(ops []*types.Operation) []*types.Operation {
+			ops[2].Type = "Mint"
+			return ops
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ops := tc.mutate(validOps())
+			if _, _, err := newOperationToTransactionMapper(ops).GetTransaction(); err == nil {
+				t.Fatalf("expected an error for operations: %s", types.PrettyPrintStruct(ops))
+			}
+		})
+	}
+
+	// Randomly mutated operations of every transaction kind must never make
+	// the mapper panic.
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < propertyTestIterations; i++ {
+		tx := randomTestTransaction(rng, testTransactionMethods[rng.Intn(len(testTransactionMethods))])
+		ops, err := transactionToTestOperations(tx, StringFromAddress(randomTestAddress(rng)))
+		if err != nil {
+			t.Fatalf("unable to map transaction: %v", err)
+		}
+		for j := rng.Intn(4); j >= 0 && len(ops) > 0; j-- {
+			mutateTestOperation(rng, ops, rng.Intn(len(ops)))
+		}
+		_, _, _ = newOperationToTransactionMapper(ops).GetTransaction()
+	}
+}
+
+// mutateTestOperation applies a random mutation to the i-th of the given
+// operations.
+func mutateTestOperation(rng *rand.Rand, ops []*types.Operation, i int) {
+	op := ops[i]
+	if op == nil || op.Account == nil {
+		return
+	}
+	switch rng.Intn(9) {
+	case 0:
+		ops[i] = nil
+	case 1:
+		op.Account = nil
+	case 2:
+		op.Account.SubAccount = &types.SubAccountIdentifier{Address: SubAccountEscrow}
+	case 3:
+		op.Amount = nil
+	case 4:
+		if op.Amount != nil {
+			op.Amount.Currency = nil
+		}
+	case 5:
+		if op.Amount != nil {
+			op.Amount.Value = []string{"", "-", "--1", "0x10", "-0", "1.5"}[rng.Intn(6)]
+		}
+	case 6:
+		op.Type = SupportedOperationTypes[rng.Intn(len(SupportedOperationTypes))]
+	case 7:
+		op.Metadata = map[string]interface{}{
+			FeeGasKey:              []interface{}{"foo", -1.0, 1e300, nil}[rng.Intn(4)],
+			ReclaimEscrowSharesKey: []interface{}{"foo", "-1", 1.0, nil}[rng.Intn(4)],
+		}
+	default:
+		op.Account.Address = []string{"", "oasis1", StringFromAddress(staking.FeeAccumulatorAddress)}[rng.Intn(3)]
+	}
+}
+
+func TestDecodeTransactionMalformed(t *testing.T) {
+	tx := transaction.NewTransaction(0, &transaction.Fee{Gas: DefaultGas}, staking.MethodTransfer, newTestTransfer(100))
+	sigTx, err := transaction.Sign(testSigner, tx)
+	if err != nil {
+		t.Fatalf("unable to sign transaction: %v", err)
+	}
+	seeds := [][]byte{
+		cbor.Marshal(sigTx),
+		cbor.Marshal(&UnsignedTransaction{Tx: cbor.Marshal(tx), Signer: testAddrStr}),
+	}
+
+	// Truncated, bit-flipped and random inputs must never make the decoders
+	// panic.
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < propertyTestIterations; i++ {
+		var raw []byte
+		switch seed := seeds[rng.Intn(len(seeds))]; rng.Intn(3) {
+		case 0:
+			raw = seed[:rng.Intn(len(seed))]
+		case 1:
+			raw = append([]byte{}, seed...)
+			for j := rng.Intn(8); j >= 0; j-- {
+				raw[rng.Intn(len(raw))] ^= byte(1 << rng.Intn(8))
+			}
+		default:
+			raw = make([]byte, rng.Intn(256))
+			_, _ = rng.Read(raw)
+		}
+
+		for _, s := range []string{base64.StdEncoding.EncodeToString(raw), string(raw)} {
+			if sigTx2, err2 := DecodeSignedTransaction(s); err2 == nil {
+				var tx2 transaction.Transaction
+				if sigTx2.Open(&tx2) == nil {
+					_, _ = transactionToTestOperations(&tx2, testAddrStr)
+				}
+			}
+			if ut, err2 := DecodeUnsignedTransaction(s); err2 == nil {
+				var tx2 transaction.Transaction
+				if cbor.Unmarshal(ut.Tx, &tx2) == nil {
+					_, _ = transactionToTestOperations(&tx2, ut.Signer)
+				}
+			}
+		}
+	}
+}
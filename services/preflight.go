@@ -0,0 +1,202 @@
+package services
+
+import (
+	"context"
+
+	"github.com/coinbase/rosetta-sdk-go/types"
+	"github.com/oasisprotocol/oasis-core/go/common/cbor"
+	"github.com/oasisprotocol/oasis-core/go/common/quantity"
+	"github.com/oasisprotocol/oasis-core/go/consensus/api/transaction"
+	staking "github.com/oasisprotocol/oasis-core/go/staking/api"
+
+	"github.com/oasisprotocol/oasis-core-rosetta-gateway/common"
+	"github.com/oasisprotocol/oasis-core-rosetta-gateway/oasis"
+)
+
+// Keys in the details map of the errors returned by a failed submit
+// preflight check.
+const (
+	PreflightNonceKey         = "nonce"
+	PreflightExpectedNonceKey = "expected_nonce"
+	PreflightBalanceKey       = "balance"
+	PreflightPendingDebitKey  = "pending_debit"
+	PreflightRequiredKey      = "required"
+	PreflightGasPriceKey      = "gas_price"
+	PreflightMinGasPriceKey   = "min_gas_price"
+)
+
+// SubmitPreflight configures the checks of a signed transaction against the
+// latest state that /construction/submit does before submitting it, so that a
+// transaction that the node would reject fails with an error saying why
+// instead of with ErrUnableToSubmitTx.
+//
+// The signer's transactions in the mempool are taken into account, so a
+// transaction may follow them, and what they debit isn't available to it.
+type SubmitPreflight struct {
+	// MinGasPrice is the minimum gas price accepted by the node (its
+	// consensus.tendermint.min_gas_price setting, which can't be queried).
+	MinGasPrice quantity.Quantity
+}
+
+// check checks that the given signed transaction has a valid signature, pays
+// at least the minimum gas price, has a nonce between the signer's committed
+// next nonce and the one following its transactions in the mempool, and that
+// the signer's general balance less what those transactions debit covers the
+// fee and the amount it debits.
+func (p *SubmitPreflight) check(
+	ctx context.Context,
+	oc oasis.Client,
+	mempool *MempoolCache,
+	sigTx *transaction.SignedTransaction,
+) *types.Error {
+	logger := common.LoggerWithRequestID(ctx, loggerCons)
+
+	if !sigTx.Signature.Verify(transaction.SignatureContext, sigTx.Blob) {
+		logger.Error("ConstructionSubmit: preflight: invalid signature")
+		return ErrInvalidSignature
+	}
+	var tx transaction.Transaction
+	if err := cbor.Unmarshal(sigTx.Blob, &tx); err != nil {
+		logger.Error("ConstructionSubmit: preflight: malformed transaction", "err", err)
+		return ErrMalformedValue
+	}
+	debit, err := transactionDebit(&tx)
+	if err != nil {
+		logger.Error("ConstructionSubmit: preflight: malformed transaction body", "err", err)
+		return ErrMalformedValue
+	}
+
+	gasPrice := quantity.NewQuantity()
+	if tx.Fee != nil {
+		gasPrice = tx.Fee.GasPrice()
+		if err = debit.Add(&tx.Fee.Amount); err != nil {
+			logger.Error("ConstructionSubmit: preflight: malformed fee", "err", err)
+			return ErrMalformedValue
+		}
+	}
+	if gasPrice.Cmp(&p.MinGasPrice) < 0 {
+		logger.Error("ConstructionSubmit: preflight: gas price too low",
+			"gas_price", gasPrice,
+			"min_gas_price", p.MinGasPrice,
+		)
+		return newPreflightError(ErrGasPriceTooLow, map[string]interface{}{
+			PreflightGasPriceKey:    gasPrice.String(),
+			PreflightMinGasPriceKey: p.MinGasPrice.String(),
+		})
+	}
+
+	// Check the nonce and the balance at the same (concrete) height.
+	height, err := oc.ResolveHeight(ctx, oasis.LatestHeight)
+	if err != nil {
+		logger.Error("ConstructionSubmit: preflight: unable to get latest block", "err", err)
+		return NewDetailedError(ErrUnableToGetLatestBlk, err)
+	}
+
+	signer := staking.NewAddress(sigTx.Signature.PublicKey)
+	committed, err := oc.GetNextNonce(ctx, signer, height)
+	if err != nil {
+		logger.Error("ConstructionSubmit: preflight: unable to get next nonce",
+			"account_id", signer.String(),
+			"err", err,
+		)
+		return NewDetailedError(ErrUnableToGetNextNonce, err)
+	}
+	ms, err := mempool.Snapshot(ctx)
+	if err != nil {
+		logger.Error("ConstructionSubmit: preflight: unable to get unconfirmed transactions", "err", err)
+		return NewDetailedError(ErrUnableToGetTxns, err)
+	}
+
+	// The transaction may have any nonce from the committed next nonce up to
+	// the one following the signer's highest pending nonce.
+	signerStr := StringFromAddress(signer)
+	pendingTxs := ms.signerTransactions(signerStr, committed)
+	pending := make([]*types.Transaction, 0, len(pendingTxs))
+	nonce := committed
+	for pendingNonce, pendingTx := range pendingTxs {
+		pending = append(pending, pendingTx)
+		if pendingNonce >= nonce {
+			nonce = pendingNonce + 1
+		}
+	}
+	if tx.Nonce < committed || tx.Nonce > nonce {
+		logger.Error("ConstructionSubmit: preflight: invalid nonce",
+			"account_id", signer.String(),
+			"nonce", tx.Nonce,
+			"expected_nonce", nonce,
+		)
+		return newPreflightError(ErrInvalidNonce, map[string]interface{}{
+			PreflightNonceKey:         tx.Nonce,
+			PreflightExpectedNonceKey: nonce,
+		})
+	}
+	pendingDebit, err := generalDebit(pending, signerStr)
+	if err != nil {
+		logger.Error("ConstructionSubmit: preflight: malformed unconfirmed transaction", "err", err)
+		return ErrMalformedValue
+	}
+
+	act, err := oc.GetAccount(ctx, height, signer)
+	if err != nil {
+		logger.Error("ConstructionSubmit: preflight: unable to get account",
+			"account_id", signer.String(),
+			"err", err,
+		)
+		return NewDetailedError(ErrUnableToGetAccount, err)
+	}
+	available := act.General.Balance.Clone()
+	if _, err = available.SubUpTo(pendingDebit); err != nil {
+		logger.Error("ConstructionSubmit: preflight: unable to subtract pending debit", "err", err)
+		return ErrMalformedValue
+	}
+	if available.Cmp(debit) < 0 {
+		logger.Error("ConstructionSubmit: preflight: insufficient balance",
+			"account_id", signer.String(),
+			"balance", act.General.Balance,
+			"pending_debit", pendingDebit,
+			"required", debit,
+		)
+		return newPreflightError(ErrInsufficientBalance, map[string]interface{}{
+			PreflightBalanceKey:      act.General.Balance.String(),
+			PreflightPendingDebitKey: pendingDebit.String(),
+			PreflightRequiredKey:     debit.String(),
+		})
+	}
+
+	return nil
+}
+
+// transactionDebit returns the amount (excluding the fee) that the given
+// transaction debits from the signer's general balance.
+func transactionDebit(tx *transaction.Transaction) (*quantity.Quantity, error) {
+	switch tx.Method {
+	case staking.MethodTransfer:
+		var body staking.Transfer
+		if err := cbor.Unmarshal(tx.Body, &body); err != nil {
+			return nil, err
+		}
+		return body.Amount.Clone(), nil
+	case staking.MethodBurn:
+		var body staking.Burn
+		if err := cbor.Unmarshal(tx.Body, &body); err != nil {
+			return nil, err
+		}
+		return body.Amount.Clone(), nil
+	case staking.MethodAddEscrow:
+		var body staking.Escrow
+		if err := cbor.Unmarshal(tx.Body, &body); err != nil {
+			return nil, err
+		}
+		return body.Amount.Clone(), nil
+	default:
+		return quantity.NewQuantity(), nil
+	}
+}
+
+// newPreflightError returns a new Rosetta error Code, Message, and Retriable
+// set from proto and the given details.
+func newPreflightError(proto *types.Error, details map[string]interface{}) *types.Error {
+	preflightError := *proto
+	preflightError.Details = details
+	return &preflightError
+}
@@ -0,0 +1,163 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/coinbase/rosetta-sdk-go/types"
+
+	"github.com/oasisprotocol/oasis-core/go/common/logging"
+
+	"github.com/oasisprotocol/oasis-core-rosetta-gateway/oasis"
+	"github.com/oasisprotocol/oasis-core-rosetta-gateway/webhook"
+)
+
+// depositWatcherPollInterval is the interval at which the deposit watcher
+// checks the node for new blocks once it has caught up.
+const depositWatcherPollInterval = 5 * time.Second
+
+var loggerWebhook = logging.GetLogger("services/webhook")
+
+// Deposit is the payload of a deposit webhook: an operation of a block that
+// credits the general account of a watched address.
+type Deposit struct {
+	BlockIdentifier       *types.BlockIdentifier       `json:"block_identifier"`
+	Timestamp             int64                        `json:"timestamp"`
+	TransactionIdentifier *types.TransactionIdentifier `json:"transaction_identifier"`
+	OperationIdentifier   *types.OperationIdentifier   `json:"operation_identifier"`
+	Type                  string                       `json:"type"`
+	Status                string                       `json:"status"`
+	Account               *types.AccountIdentifier     `json:"account"`
+	Amount                *types.Amount                `json:"amount"`
+}
+
+// DepositWatcher follows new blocks and enqueues a webhook delivery for each
+// of their successful operations, as returned by the /block endpoint, that
+// credits the general account of one of the webhook store's watched
+// addresses.
+type DepositWatcher struct {
+	oasisClient oasis.Client
+	store       *webhook.Store
+	dispatcher  *webhook.Dispatcher
+}
+
+// NewDepositWatcher creates a new deposit watcher that notifies the given
+// dispatcher of new deliveries.
+func NewDepositWatcher(
+	oasisClient oasis.Client,
+	store *webhook.Store,
+	dispatcher *webhook.Dispatcher,
+) *DepositWatcher {
+	return &DepositWatcher{
+		oasisClient: oasisClient,
+		store:       store,
+		dispatcher:  dispatcher,
+	}
+}
+
+// InitializeFromLatest initializes an empty store to watch the blocks after
+// the latest one.
+func (w *DepositWatcher) InitializeFromLatest(ctx context.Context) error {
+	height, err := w.oasisClient.ResolveHeight(ctx, oasis.LatestHeight)
+	if err != nil {
+		return fmt.Errorf("unable to get latest block: %w", err)
+	}
+	return w.store.SetLatestHeight(height)
+}
+
+// Run watches new blocks until the given context is canceled.
+func (w *DepositWatcher) Run(ctx context.Context) {
+	for {
+		if err := w.catchUp(ctx); err != nil {
+			loggerWebhook.Error("Run: unable to watch blocks", "err", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(depositWatcherPollInterval):
+		}
+	}
+}
+
+// catchUp watches all blocks between the store's latest block and the node's
+// latest block.
+func (w *DepositWatcher) catchUp(ctx context.Context) error {
+	latest, ok, err := w.store.LatestHeight()
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("webhook store not initialized")
+	}
+
+	nodeLatest, err := w.oasisClient.ResolveHeight(ctx, oasis.LatestHeight)
+	if err != nil {
+		return fmt.Errorf("unable to get latest block: %w", err)
+	}
+
+	for height := latest + 1; height <= nodeLatest; height++ {
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err = w.watchBlock(ctx, height); err != nil {
+			return fmt.Errorf("unable to watch block at height %d: %w", height, err)
+		}
+	}
+	return nil
+}
+
+// watchBlock enqueues the deposits of the block at the given height.
+func (w *DepositWatcher) watchBlock(ctx context.Context, height int64) error {
+	blk, err := w.oasisClient.GetBlock(ctx, height)
+	if err != nil {
+		return fmt.Errorf("unable to get block: %w", err)
+	}
+	txs, err := decodeBlockTransactions(ctx, w.oasisClient, blk)
+	if err != nil {
+		return err
+	}
+
+	var payloads []json.RawMessage
+	for _, tx := range txs {
+		for _, op := range tx.Operations {
+			if op.Status == nil || *op.Status != OpStatusOK ||
+				op.Account == nil || op.Account.SubAccount != nil || op.Amount == nil ||
+				op.Amount.Value == "0" || strings.HasPrefix(op.Amount.Value, "-") ||
+				!w.store.IsWatched(op.Account.Address) {
+				continue
+			}
+
+			deposit := &Deposit{
+				BlockIdentifier:       &types.BlockIdentifier{Index: blk.Height, Hash: blk.Hash},
+				Timestamp:             blk.Timestamp,
+				TransactionIdentifier: tx.TransactionIdentifier,
+				OperationIdentifier:   op.OperationIdentifier,
+				Type:                  op.Type,
+				Status:                *op.Status,
+				Account:               op.Account,
+				Amount:                op.Amount,
+			}
+			payload, merr := json.Marshal(deposit)
+			if merr != nil {
+				return fmt.Errorf("unable to encode deposit: %w", merr)
+			}
+			payloads = append(payloads, payload)
+		}
+	}
+
+	if err = w.store.Enqueue(blk.Height, payloads); err != nil {
+		return err
+	}
+	if len(payloads) > 0 {
+		loggerWebhook.Debug("enqueued deposits",
+			"height", blk.Height,
+			"deposits", len(payloads),
+		)
+		w.dispatcher.Notify()
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	staking "github.com/oasisprotocol/oasis-core/go/staking/api"
+
+	"github.com/oasisprotocol/oasis-core-rosetta-gateway/webhook"
+)
+
+func TestDepositWatcher(t *testing.T) {
+	ctx := context.Background()
+	oc := newTestClient()
+
+	store, werr := webhook.Open(t.TempDir())
+	if werr != nil {
+		t.Fatalf("unable to open webhook store: %v", werr)
+	}
+	defer store.Close()
+	if werr = store.Watch(StringFromAddress(testOther)); werr != nil {
+		t.Fatalf("unable to watch address: %v", werr)
+	}
+
+	w := NewDepositWatcher(oc, store, webhook.NewDispatcher(store, "http://localhost", nil, 1))
+	if werr = w.InitializeFromLatest(ctx); werr != nil {
+		t.Fatalf("unable to initialize webhook store: %v", werr)
+	}
+
+	tx := signTestTx(t, 0, 10, staking.MethodTransfer, newTestTransfer(100))
+	if werr = oc.SubmitTxNoWait(ctx, tx); werr != nil {
+		t.Fatalf("unable to submit transaction: %v", werr)
+	}
+	failedTx := signTestTx(t, 1, 10, staking.MethodTransfer, newTestTransfer(2*testGeneralBalance))
+	if werr = oc.SubmitTxNoWait(ctx, failedTx); werr != nil {
+		t.Fatalf("unable to submit transaction: %v", werr)
+	}
+	blk := oc.CommitBlock()
+	oc.CommitBlock()
+	if werr = w.catchUp(ctx); werr != nil {
+		t.Fatalf("unable to watch blocks: %v", werr)
+	}
+	if height, _, _ := store.LatestHeight(); height != oc.LatestHeight() {
+		t.Fatalf("unexpected latest height: %d", height)
+	}
+
+	// Only the transfer to the watched address is a deposit, and not the
+	// debit of the sender, the fee or the failed transfer.
+	due, werr := store.Due(time.Now(), 10)
+	if werr != nil {
+		t.Fatalf("unable to get due deliveries: %v", werr)
+	}
+	if len(due) != 1 {
+		t.Fatalf("unexpected deliveries: %d", len(due))
+	}
+	var deposit Deposit
+	if werr = json.Unmarshal(due[0].Payload, &deposit); werr != nil {
+		t.Fatalf("malformed deposit: %v", werr)
+	}
+	if deposit.BlockIdentifier.Index != blk.Height || deposit.BlockIdentifier.Hash != blk.Hash ||
+		deposit.TransactionIdentifier.Hash != tx.Hash().String() || deposit.Type != OpTransfer ||
+		deposit.Status != OpStatusOK || deposit.Account.Address != StringFromAddress(testOther) ||
+		deposit.Amount.Value != "100" {
+		t.Fatalf("unexpected deposit: %s", due[0].Payload)
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/coinbase/rosetta-sdk-go/types"
+	"github.com/oasisprotocol/oasis-core/go/common/cbor"
+	"github.com/oasisprotocol/oasis-core/go/common/crypto/signature"
+	"github.com/oasisprotocol/oasis-core/go/consensus/api/transaction"
+	staking "github.com/oasisprotocol/oasis-core/go/staking/api"
+
+	"github.com/oasisprotocol/oasis-core-rosetta-gateway/services"
+)
+
+// signCmdName is the name of the subcommand that signs the payload of a
+// /construction/payloads response.
+const signCmdName = "sign"
+
+// runSignCmd signs the payload of a /construction/payloads response read from
+// a file (or the standard input) and writes the signature, as expected by the
+// /construction/combine endpoint, to the standard output.
+//
+// A summary of the signed transaction is written to the standard error.
+func runSignCmd(args []string) error {
+	fs := flag.NewFlagSet(signCmdName, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s %s [flags] [PAYLOADS_FILE]\n\n", os.Args[0], signCmdName)
+		fmt.Fprintf(fs.Output(), "Sign the payload of a /construction/payloads response read from "+
+			"PAYLOADS_FILE (or the standard input) and print the signature for /construction/combine.\n\n")
+		fs.PrintDefaults()
+	}
+	chainContext := fs.String("chain-context", os.Getenv(services.OfflineModeChainIDEnvVar),
+		"chain context (genesis document's hash) of the network, defaults to $"+services.OfflineModeChainIDEnvVar,
+	)
+	var ks keySource
+	fs.StringVar(&ks.File, "key-file", "", "path to a PEM file with the signing key")
+	fs.StringVar(&ks.EntityDir, "entity-dir", "", "path to an entity directory with the signing key")
+	fs.StringVar(&ks.MnemonicFile, "mnemonic-file", "", "path to a file with the mnemonic of the signing key")
+	accountIndex := fs.Uint("account-index", 0, "account index of the signing key derived from the mnemonic")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 1 {
+		fs.Usage()
+		return fmt.Errorf("too many arguments")
+	}
+	ks.AccountIndex = uint32(*accountIndex)
+
+	if *chainContext == "" {
+		return fmt.Errorf("chain context not given")
+	}
+	signature.SetChainContext(*chainContext)
+
+	raw, err := readFileOrStdin(fs.Arg(0))
+	if err != nil {
+		return fmt.Errorf("failed to read payloads: %w", err)
+	}
+	var payloads types.ConstructionPayloadsResponse
+	if err = json.Unmarshal(raw, &payloads); err != nil {
+		return fmt.Errorf("malformed payloads: %w", err)
+	}
+
+	signer, err := ks.load()
+	if err != nil {
+		return err
+	}
+	defer signer.Reset()
+
+	sig, err := signPayloads(&payloads, signer, os.Stderr)
+	if err != nil {
+		return err
+	}
+	out, err := json.MarshalIndent(sig, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal signature: %w", err)
+	}
+	fmt.Println(string(out))
+	return nil
+}
+
+// signPayloads verifies that the payload of the given /construction/payloads
+// response is the signer message of its unsigned transaction, writes a
+// summary of the transaction to the given writer and signs it.
+func signPayloads(
+	payloads *types.ConstructionPayloadsResponse,
+	signer signature.Signer,
+	summary io.Writer,
+) (*types.Signature, error) {
+	ut, err := services.DecodeUnsignedTransaction(payloads.UnsignedTransaction)
+	if err != nil {
+		return nil, fmt.Errorf("malformed unsigned transaction: %w", err)
+	}
+	var tx transaction.Transaction
+	if err = cbor.Unmarshal(ut.Tx, &tx); err != nil {
+		return nil, fmt.Errorf("malformed unsigned transaction: %w", err)
+	}
+
+	if len(payloads.Payloads) != 1 {
+		return nil, fmt.Errorf("expected exactly one payload, got %d", len(payloads.Payloads))
+	}
+	payload := payloads.Payloads[0]
+	if payload.SignatureType != "" && payload.SignatureType != types.Ed25519 {
+		return nil, fmt.Errorf("unsupported signature type: %s", payload.SignatureType)
+	}
+	message, err := signature.PrepareSignerMessage(transaction.SignatureContext, ut.Tx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to prepare signer message: %w", err)
+	}
+	if !bytes.Equal(payload.Bytes, message) {
+		return nil, fmt.Errorf("payload doesn't match the unsigned transaction (wrong chain context?)")
+	}
+
+	signerAddr := services.StringFromAddress(staking.NewAddress(signer.Public()))
+	if ut.Signer != signerAddr {
+		return nil, fmt.Errorf("signing key doesn't match the transaction's signer (key: %s signer: %s)",
+			signerAddr, ut.Signer,
+		)
+	}
+	if payload.AccountIdentifier != nil && payload.AccountIdentifier.Address != signerAddr {
+		return nil, fmt.Errorf("signing key doesn't match the payload's account (key: %s account: %s)",
+			signerAddr, payload.AccountIdentifier.Address,
+		)
+	}
+
+	if err = printTransactionSummary(summary, &tx, signerAddr); err != nil {
+		return nil, err
+	}
+
+	rawSig, err := signer.ContextSign(transaction.SignatureContext, ut.Tx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign transaction: %w", err)
+	}
+	pk, _ := signer.Public().MarshalBinary()
+	return &types.Signature{
+		SigningPayload: payload,
+		PublicKey: &types.PublicKey{
+			Bytes:     pk,
+			CurveType: types.Edwards25519,
+		},
+		SignatureType: types.Ed25519,
+		Bytes:         rawSig,
+	}, nil
+}
+
+// readFileOrStdin reads the given file, or the standard input if the path is
+// empty or "-".
+func readFileOrStdin(path string) ([]byte, error) {
+	if path == "" || path == "-" {
+		return ioutil.ReadAll(os.Stdin)
+	}
+	return ioutil.ReadFile(path)
+}
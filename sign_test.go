@@ -0,0 +1,254 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/coinbase/rosetta-sdk-go/types"
+	"github.com/oasisprotocol/oasis-core/go/common/crypto/signature"
+	fileSigner "github.com/oasisprotocol/oasis-core/go/common/crypto/signature/signers/file"
+	"github.com/oasisprotocol/oasis-core/go/common/crypto/signature/signers/memory"
+	"github.com/oasisprotocol/oasis-core/go/consensus/api/transaction"
+	staking "github.com/oasisprotocol/oasis-core/go/staking/api"
+
+	"github.com/oasisprotocol/oasis-core-rosetta-gateway/services"
+)
+
+const testChainContext = "6d61696e2d746573742d636861696e2d636f6e74657874000000000000000000"
+
+var testSigner = memory.NewTestSigner("oasis-core-rosetta-gateway: test signer")
+
+func TestMain(m *testing.M) {
+	// Construct transactions offline.
+	os.Setenv(services.OfflineModeChainIDEnvVar, testChainContext)
+	signature.SetChainContext(testChainContext)
+	os.Exit(m.Run())
+}
+
+// newTestPayloads returns the /construction/payloads response of a transfer
+// signed by the given signer.
+func newTestPayloads(t *testing.T, signer signature.Signer) *types.ConstructionPayloadsResponse {
+	t.Helper()
+
+	from := services.StringFromAddress(staking.NewAddress(signer.Public()))
+	to := services.StringFromAddress(staking.CommonPoolAddress)
+	amount := func(value string) *types.Amount {
+		return &types.Amount{Value: value, Currency: services.OasisCurrency}
+	}
+	resp, err := services.NewConstructionAPIService(nil, nil, nil, nil).ConstructionPayloads(context.Background(),
+		&types.ConstructionPayloadsRequest{
+			NetworkIdentifier: &types.NetworkIdentifier{
+				Blockchain: services.OasisBlockchainName,
+				Network:    testChainContext,
+			},
+			Operations: []*types.Operation{
+				{
+					OperationIdentifier: &types.OperationIdentifier{Index: 0},
+					Type:                services.OpTransfer,
+					Account:             &types.AccountIdentifier{Address: from},
+					Amount:              amount("-2000"),
+				},
+				{
+					OperationIdentifier: &types.OperationIdentifier{Index: 1},
+					Type:                services.OpTransfer,
+					Account:             &types.AccountIdentifier{Address: services.StringFromAddress(staking.FeeAccumulatorAddress)},
+					Amount:              amount("2000"),
+				},
+				{
+					OperationIdentifier: &types.OperationIdentifier{Index: 2},
+					Type:                services.OpTransfer,
+					Account:             &types.AccountIdentifier{Address: from},
+					Amount:              amount("-1500000000"),
+				},
+				{
+					OperationIdentifier: &types.OperationIdentifier{Index: 3},
+					Type:                services.OpTransfer,
+					Account:             &types.AccountIdentifier{Address: to},
+					Amount:              amount("1500000000"),
+				},
+			},
+			Metadata: map[string]interface{}{services.NonceKey: float64(7)},
+		},
+	)
+	if err != nil {
+		t.Fatalf("unable to create payloads: %v", types.PrettyPrintStruct(err))
+	}
+	return resp
+}
+
+func TestSignPayloads(t *testing.T) {
+	payloads := newTestPayloads(t, testSigner)
+
+	var summary bytes.Buffer
+	sig, err := signPayloads(payloads, testSigner, &summary)
+	if err != nil {
+		t.Fatalf("unable to sign payloads: %v", err)
+	}
+	ut, _ := services.DecodeUnsignedTransaction(payloads.UnsignedTransaction)
+	pk, _ := testSigner.Public().MarshalBinary()
+	if !testSigner.Public().Verify(transaction.SignatureContext, ut.Tx, sig.Bytes) {
+		t.Fatalf("invalid signature")
+	}
+	if sig.SigningPayload != payloads.Payloads[0] || sig.SignatureType != types.Ed25519 ||
+		sig.PublicKey.CurveType != types.Edwards25519 || !bytes.Equal(sig.PublicKey.Bytes, pk) {
+		t.Fatalf("unexpected signature: %v", types.PrettyPrintStruct(sig))
+	}
+	for _, s := range []string{"staking.Transfer", "Nonce:      7", "0.000002 ROSE (gas: 10000)", "-1.5 ROSE"} {
+		if !strings.Contains(summary.String(), s) {
+			t.Fatalf("summary doesn't contain %q:\n%s", s, summary.String())
+		}
+	}
+
+	// Signing with a different key.
+	other := memory.NewTestSigner("oasis-core-rosetta-gateway: other test signer")
+	if _, err = signPayloads(payloads, other, ioutil.Discard); err == nil {
+		t.Fatalf("expected an error when signing with a different key")
+	}
+
+	// Payload that doesn't match the unsigned transaction.
+	tampered := newTestPayloads(t, testSigner)
+	tampered.Payloads[0].Bytes = append([]byte{}, tampered.Payloads[0].Bytes...)
+	tampered.Payloads[0].Bytes[0] ^= 0xff
+	if _, err = signPayloads(tampered, testSigner, ioutil.Discard); err == nil {
+		t.Fatalf("expected an error when signing a mismatched payload")
+	}
+
+	// Unsigned transaction of a different signer.
+	tampered = newTestPayloads(t, testSigner)
+	tampered.UnsignedTransaction = newTestPayloads(t, other).UnsignedTransaction
+	if _, err = signPayloads(tampered, testSigner, ioutil.Discard); err == nil {
+		t.Fatalf("expected an error when signing another signer's transaction")
+	}
+}
+
+func TestKeySource(t *testing.T) {
+	dir := t.TempDir()
+	factory, err := fileSigner.NewFactory(dir, signature.SignerEntity)
+	if err != nil {
+		t.Fatalf("unable to create signer factory: %v", err)
+	}
+	entitySigner, err := factory.Generate(signature.SignerEntity, bytes.NewReader(make([]byte, 32)))
+	if err != nil {
+		t.Fatalf("unable to generate entity key: %v", err)
+	}
+
+	mnemonicFile := filepath.Join(dir, "mnemonic")
+	mnemonic := strings.Repeat("abandon ", 11) + "about\n"
+	if err = ioutil.WriteFile(mnemonicFile, []byte(mnemonic), 0o600); err != nil {
+		t.Fatalf("unable to write mnemonic: %v", err)
+	}
+
+	for _, tc := range []struct {
+		name     string
+		ks       keySource
+		expected signature.PublicKey
+	}{
+		{"File", keySource{File: filepath.Join(dir, fileSigner.FileEntityKey)}, entitySigner.Public()},
+		{"EntityDir", keySource{EntityDir: dir}, entitySigner.Public()},
+		{"Mnemonic", keySource{MnemonicFile: mnemonicFile}, mustSignerFromMnemonic(t, mnemonic, 0).Public()},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			signer, err := tc.ks.load()
+			if err != nil {
+				t.Fatalf("unable to load signer: %v", err)
+			}
+			if !signer.Public().Equal(tc.expected) {
+				t.Fatalf("unexpected public key: %s", signer.Public())
+			}
+		})
+	}
+
+	if _, err = (&keySource{}).load(); err == nil {
+		t.Fatalf("expected an error without a key source")
+	}
+	if _, err = (&keySource{File: filepath.Join(dir, "missing.pem")}).load(); err == nil {
+		t.Fatalf("expected an error for a missing key file")
+	}
+	if _, err = (&keySource{EntityDir: dir, MnemonicFile: mnemonicFile}).load(); err == nil {
+		t.Fatalf("expected an error with multiple key sources")
+	}
+	if mustSignerFromMnemonic(t, mnemonic, 1).Public().Equal(mustSignerFromMnemonic(t, mnemonic, 0).Public()) {
+		t.Fatalf("expected different keys for different account indexes")
+	}
+	if _, err = signerFromMnemonic("abandon about", 0); err == nil {
+		t.Fatalf("expected an error for a short mnemonic")
+	}
+}
+
+func mustSignerFromMnemonic(t *testing.T, mnemonic string, index uint32) signature.Signer {
+	t.Helper()
+
+	signer, err := signerFromMnemonic(mnemonic, index)
+	if err != nil {
+		t.Fatalf("unable to derive signer from mnemonic: %v", err)
+	}
+	return signer
+}
+
+func TestKeyDerivation(t *testing.T) {
+	// Test vectors from BIP-0039 and SLIP-0010.
+	seed := bip39Seed(strings.Repeat("abandon ", 11)+"about", "TREZOR")
+	if s := hex.EncodeToString(seed); s != "c55257c360c07c72029aebc1b53c05ed0362ada38ead3e3e9efa3708e53495531f"+
+		"09a6987599d18264c1e1c92f2cf141630c7a3c4ab7c81b2f001698e7463b04" {
+		t.Fatalf("unexpected BIP-0039 seed: %s", s)
+	}
+
+	seed, _ = hex.DecodeString("000102030405060708090a0b0c0d0e0f")
+	for _, tc := range []struct {
+		path     []uint32
+		expected string
+	}{
+		{nil, "2b4be7f19ee27bbf30c667b642d5f4aa69fd169872f8fc3059c08ebae2eb19e7"},
+		{[]uint32{0}, "68e0fe46dfb67e368c75379acec591dad19df3cde26e63b93a8e704f1dade7a3"},
+	} {
+		key, err := slip10DeriveEd25519(seed, tc.path)
+		if err != nil {
+			t.Fatalf("unable to derive key: %v", err)
+		}
+		if s := hex.EncodeToString(key); s != tc.expected {
+			t.Fatalf("unexpected key for path %v: %s", tc.path, s)
+		}
+	}
+	if _, err := slip10DeriveEd25519(seed, []uint32{slip10HardenedOffset}); err == nil {
+		t.Fatalf("expected an error for an out of range child index")
+	}
+}
+
+func TestSignerFromMnemonic(t *testing.T) {
+	// Keys along the full ADR 0008 path m/44'/474'/index' of the BIP-0039 test
+	// mnemonic without a passphrase, computed with an independent
+	// implementation of BIP-0039, SLIP-0010 and Ed25519 (checked against the
+	// test vectors of each).
+	mnemonic := strings.Repeat("abandon ", 11) + "about"
+	for _, tc := range []struct {
+		index   uint32
+		private string
+		public  string
+	}{
+		{
+			0,
+			"fb181e94e95cc6bedd2da03e6c4aca9951053f3e9865945dbc8975a6afd217c3",
+			"ad55bbb7c192b8ecfeb6ad18bbd7681c0923f472d5b0c212fbde33008005ad61",
+		},
+		{
+			1,
+			"1792482bcb001f45bc8ab15436e62d60fe3eb8c86e8944bfc12da4dc67a5c89b",
+			"73fd7c51a0f059ea34d8dca305e0fdb21134ca32216ca1681ae1d12b3d350e16",
+		},
+	} {
+		signer := mustSignerFromMnemonic(t, mnemonic, tc.index)
+		// The private key is the seed followed by the public key.
+		if s := hex.EncodeToString(signer.(signature.UnsafeSigner).UnsafeBytes()[:32]); s != tc.private {
+			t.Fatalf("unexpected private key of account %d: %s", tc.index, s)
+		}
+		if pub := signer.Public(); hex.EncodeToString(pub[:]) != tc.public {
+			t.Fatalf("unexpected public key of account %d: %s", tc.index, pub)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"strings"
+
+	"github.com/coinbase/rosetta-sdk-go/types"
+	"github.com/oasisprotocol/oasis-core/go/common/cbor"
+	"github.com/oasisprotocol/oasis-core/go/consensus/api/transaction"
+	staking "github.com/oasisprotocol/oasis-core/go/staking/api"
+
+	"github.com/oasisprotocol/oasis-core-rosetta-gateway/services"
+)
+
+// printTransactionSummary writes a human-readable summary of the given
+// transaction and its Rosetta operations, as returned by /construction/parse,
+// to the given writer.
+func printTransactionSummary(w io.Writer, tx *transaction.Transaction, signerAddr string) error {
+	ops, err := services.TransactionToOperations(tx, signerAddr)
+	if err != nil {
+		return fmt.Errorf("malformed transaction: %w", err)
+	}
+
+	fmt.Fprintf(w, "Signer:     %s\n", signerAddr)
+	fmt.Fprintf(w, "Method:     %s\n", tx.Method)
+	fmt.Fprintf(w, "Nonce:      %d\n", tx.Nonce)
+	if tx.Fee != nil {
+		fmt.Fprintf(w, "Fee:        %s (gas: %d)\n",
+			formatAmount(&types.Amount{Value: tx.Fee.Amount.String(), Currency: services.OasisCurrency}),
+			tx.Fee.Gas,
+		)
+	}
+	fmt.Fprintf(w, "Body:       %s\n", formatBody(tx))
+	fmt.Fprintf(w, "Operations:\n")
+	for _, op := range ops {
+		account := op.Account.Address
+		if op.Account.SubAccount != nil {
+			account += " (" + op.Account.SubAccount.Address + ")"
+		}
+		var amount string
+		switch {
+		case op.Amount != nil:
+			amount = formatAmount(op.Amount)
+		case op.Metadata[services.ReclaimEscrowSharesKey] != nil:
+			amount = fmt.Sprintf("%s shares", op.Metadata[services.ReclaimEscrowSharesKey])
+		}
+		fmt.Fprintf(w, "  %d. %-13s %s %s\n", op.OperationIdentifier.Index, op.Type, account, amount)
+	}
+	return nil
+}
+
+// formatAmount formats the given amount in whole units of its currency.
+func formatAmount(amount *types.Amount) string {
+	value, ok := new(big.Int).SetString(amount.Value, 10)
+	if !ok || amount.Currency == nil {
+		return amount.Value
+	}
+
+	sign := ""
+	if value.Sign() < 0 {
+		sign = "-"
+		value.Neg(value)
+	}
+	decimals := int(amount.Currency.Decimals)
+	digits := value.String()
+	if len(digits) <= decimals {
+		digits = strings.Repeat("0", decimals+1-len(digits)) + digits
+	}
+	whole, fraction := digits[:len(digits)-decimals], strings.TrimRight(digits[len(digits)-decimals:], "0")
+	if fraction != "" {
+		whole += "." + fraction
+	}
+	return fmt.Sprintf("%s%s %s", sign, whole, amount.Currency.Symbol)
+}
+
+// formatBody formats the body of the given transaction as indented JSON, or
+// as hex if the method is not a staking one.
+func formatBody(tx *transaction.Transaction) string {
+	var body interface{}
+	switch tx.Method {
+	case staking.MethodTransfer:
+		body = &staking.Transfer{}
+	case staking.MethodBurn:
+		body = &staking.Burn{}
+	case staking.MethodAddEscrow:
+		body = &staking.Escrow{}
+	case staking.MethodReclaimEscrow:
+		body = &staking.ReclaimEscrow{}
+	case staking.MethodAmendCommissionSchedule:
+		body = &staking.AmendCommissionSchedule{}
+	case staking.MethodAllow:
+		body = &staking.Allow{}
+	case staking.MethodWithdraw:
+		body = &staking.Withdraw{}
+	}
+	if body != nil && cbor.Unmarshal(tx.Body, body) == nil {
+		if raw, err := json.MarshalIndent(body, "  ", "  "); err == nil {
+			return string(raw)
+		}
+	}
+	return hex.EncodeToString(tx.Body)
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/coinbase/rosetta-sdk-go/types"
+
+	"github.com/oasisprotocol/oasis-core-rosetta-gateway/services"
+)
+
+func TestFormatAmount(t *testing.T) {
+	for _, tc := range []struct {
+		value    string
+		expected string
+	}{
+		{"0", "0 ROSE"},
+		{"1", "0.000000001 ROSE"},
+		{"-1500000000", "-1.5 ROSE"},
+		{"123000000000", "123 ROSE"},
+		{"foo", "foo"},
+	} {
+		if s := formatAmount(&types.Amount{Value: tc.value, Currency: services.OasisCurrency}); s != tc.expected {
+			t.Fatalf("unexpected formatted amount of %s: %s", tc.value, s)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/oasisprotocol/oasis-core/go/common/logging"
+	staking "github.com/oasisprotocol/oasis-core/go/staking/api"
+
+	"github.com/oasisprotocol/oasis-core-rosetta-gateway/services"
+	"github.com/oasisprotocol/oasis-core-rosetta-gateway/webhook"
+)
+
+// webhookAdminAddressesPath is the path of the webhook admin endpoint that
+// manages the watched addresses.
+const webhookAdminAddressesPath = "/addresses"
+
+// maxWebhookAdminBodyLength is the maximum length of a request's body that the
+// webhook admin endpoint accepts.
+const maxWebhookAdminBodyLength = 16 * 1024 * 1024
+
+var loggerWebhookAdmin = logging.GetLogger("oasis-rosetta-gateway/webhook")
+
+// watchedAddresses is the body of the webhook admin endpoint's requests and
+// responses.
+type watchedAddresses struct {
+	Addresses []string `json:"addresses"`
+}
+
+// parseWatchedAddresses parses the given addresses, returning them in their
+// canonical form.
+func parseWatchedAddresses(raw []string) ([]string, error) {
+	addresses := make([]string, 0, len(raw))
+	for _, r := range raw {
+		var addr staking.Address
+		if err := addr.UnmarshalText([]byte(r)); err != nil {
+			return nil, fmt.Errorf("invalid address %s: %w", r, err)
+		}
+		addresses = append(addresses, services.StringFromAddress(addr))
+	}
+	return addresses, nil
+}
+
+// loadWatchedAddresses loads a file of addresses, with one address per line.
+// Empty lines and lines starting with # are ignored.
+func loadWatchedAddresses(path string) ([]string, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var lines []string
+	scanner := bufio.NewScanner(bytes.NewReader(raw))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+	return parseWatchedAddresses(lines)
+}
+
+// newWebhookAdminHandler returns the handler of the webhook admin endpoint,
+// which lists (GET), adds (POST) and removes (DELETE) the watched addresses
+// of the given store.
+func newWebhookAdminHandler(store *webhook.Store) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(webhookAdminAddressesPath, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(&watchedAddresses{Addresses: store.Watched()})
+			return
+		}
+		if r.Method != http.MethodPost && r.Method != http.MethodDelete {
+			w.Header().Set("Allow", "GET, POST, DELETE")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var body watchedAddresses
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxWebhookAdminBodyLength)).Decode(&body); err != nil {
+			http.Error(w, fmt.Sprintf("malformed body: %s", err), http.StatusBadRequest)
+			return
+		}
+		addresses, err := parseWatchedAddresses(body.Addresses)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if r.Method == http.MethodPost {
+			err = store.Watch(addresses...)
+		} else {
+			err = store.Unwatch(addresses...)
+		}
+		if err != nil {
+			loggerWebhookAdmin.Error("failed to update watched addresses",
+				"method", r.Method,
+				"err", err,
+			)
+			http.Error(w, "failed to update watched addresses", http.StatusInternalServerError)
+			return
+		}
+		loggerWebhookAdmin.Info("updated watched addresses",
+			"method", r.Method,
+			"addresses", len(addresses),
+		)
+		w.WriteHeader(http.StatusNoContent)
+	})
+	return mux
+}
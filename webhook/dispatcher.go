@@ -0,0 +1,221 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// IDHeader is the name of the header of a webhook request that specifies
+	// the unique ID of the delivery, which stays the same across retries.
+	IDHeader = "X-Webhook-ID"
+	// TimestampHeader is the name of the header of a webhook request that
+	// specifies the UNIX time (in seconds) at which the request was signed.
+	TimestampHeader = "X-Webhook-Timestamp"
+	// SignatureHeader is the name of the header of a webhook request that
+	// specifies the request's signature (see Sign).
+	SignatureHeader = "X-Webhook-Signature"
+)
+
+const (
+	// dispatchPollInterval is the interval at which the dispatcher checks the
+	// outbox for due deliveries when it isn't notified of new ones.
+	dispatchPollInterval = 1 * time.Second
+	// dispatchConcurrency is the maximum number of deliveries that the
+	// dispatcher attempts at once.
+	dispatchConcurrency = 16
+	// deliveryTimeout is the timeout of a webhook request.
+	deliveryTimeout = 10 * time.Second
+	// deliveryLease is the time for which an attempted delivery isn't due
+	// again, which outlasts the attempt, so that a delivery is only retried
+	// while being attempted if the gateway stopped in the middle of it.
+	deliveryLease = 2 * deliveryTimeout
+
+	initialRetryBackoff = 1 * time.Second
+	maxRetryBackoff     = 10 * time.Minute
+)
+
+// Sign returns the signature of a webhook request with the given timestamp
+// and body: the hex-encoded HMAC-SHA256, keyed with the secret, of the
+// timestamp, a dot and the body.
+func Sign(secret []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	_, _ = io.WriteString(mac, timestamp+".")
+	_, _ = mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Dispatcher delivers the deliveries in the outbox of a store by POSTing
+// their signed payloads to a URL, retrying failed deliveries with an
+// exponential backoff.
+//
+// Several deliveries are attempted concurrently, so that deliveries that
+// time out don't hold up the others.
+type Dispatcher struct {
+	store       *Store
+	url         string
+	secret      []byte
+	maxAttempts int
+
+	client *http.Client
+	notify chan struct{}
+	now    func() time.Time
+
+	// slots holds a value for each delivery being attempted.
+	slots    chan struct{}
+	inFlight sync.WaitGroup
+}
+
+// NewDispatcher creates a new dispatcher that gives up on a delivery after
+// the given number of failed attempts.
+func NewDispatcher(store *Store, url string, secret []byte, maxAttempts int) *Dispatcher {
+	return &Dispatcher{
+		store:       store,
+		url:         url,
+		secret:      secret,
+		maxAttempts: maxAttempts,
+		client:      &http.Client{Timeout: deliveryTimeout},
+		notify:      make(chan struct{}, 1),
+		now:         time.Now,
+		slots:       make(chan struct{}, dispatchConcurrency),
+	}
+}
+
+// Notify makes the dispatcher check the outbox for new deliveries.
+func (d *Dispatcher) Notify() {
+	select {
+	case d.notify <- struct{}{}:
+	default:
+	}
+}
+
+// Run delivers due deliveries until the given context is canceled.
+func (d *Dispatcher) Run(ctx context.Context) {
+	defer d.inFlight.Wait()
+
+	for {
+		if err := d.dispatch(ctx); err != nil {
+			logger.Error("Run: unable to dispatch deliveries", "err", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-d.notify:
+		case <-time.After(dispatchPollInterval):
+		}
+	}
+}
+
+// dispatch starts attempts of due deliveries while fewer than
+// dispatchConcurrency deliveries are being attempted.  A finished attempt
+// notifies the dispatcher, so that it starts the next ones.
+func (d *Dispatcher) dispatch(ctx context.Context) error {
+	for {
+		free := cap(d.slots) - len(d.slots)
+		if free == 0 || ctx.Err() != nil {
+			return nil
+		}
+		due, err := d.store.Due(d.now(), free)
+		if err != nil {
+			return err
+		}
+		for _, del := range due {
+			// Lease the delivery, so that it isn't due again while it is
+			// being attempted.
+			leased := *del
+			leased.NextAttempt = d.now().Add(deliveryLease)
+			if err = d.store.Update(&leased); err != nil {
+				return err
+			}
+
+			d.slots <- struct{}{}
+			d.inFlight.Add(1)
+			go func(del *Delivery) {
+				defer func() {
+					<-d.slots
+					d.inFlight.Done()
+					d.Notify()
+				}()
+				if err := d.attempt(ctx, del); err != nil {
+					logger.Error("unable to update webhook delivery", "id", del.ID, "err", err)
+				}
+			}(del)
+		}
+		if len(due) < free {
+			return nil
+		}
+	}
+}
+
+// attempt attempts the given delivery, and removes it from the outbox if it
+// succeeded or failed too many times, or schedules its retry otherwise.
+func (d *Dispatcher) attempt(ctx context.Context, del *Delivery) error {
+	err := d.post(ctx, del)
+	if err == nil {
+		logger.Debug("delivered webhook", "id", del.ID)
+		return d.store.Remove(del)
+	}
+
+	del.Attempts++
+	if del.Attempts >= d.maxAttempts {
+		logger.Error("giving up on webhook delivery",
+			"id", del.ID,
+			"attempts", del.Attempts,
+			"payload", string(del.Payload),
+			"err", err,
+		)
+		return d.store.Remove(del)
+	}
+
+	backoff := initialRetryBackoff
+	for i := 1; i < del.Attempts && backoff < maxRetryBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > maxRetryBackoff {
+		backoff = maxRetryBackoff
+	}
+	del.NextAttempt = d.now().Add(backoff)
+	logger.Warn("webhook delivery failed",
+		"id", del.ID,
+		"attempts", del.Attempts,
+		"next_attempt", del.NextAttempt,
+		"err", err,
+	)
+	return d.store.Update(del)
+}
+
+// post POSTs the given delivery's signed payload to the dispatcher's URL.
+func (d *Dispatcher) post(ctx context.Context, del *Delivery) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.url, bytes.NewReader(del.Payload))
+	if err != nil {
+		return err
+	}
+	timestamp := strconv.FormatInt(d.now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(IDHeader, strconv.FormatUint(del.ID, 10))
+	req.Header.Set(TimestampHeader, timestamp)
+	req.Header.Set(SignatureHeader, Sign(d.secret, timestamp, del.Payload))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected response status: %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,374 @@
+// Package webhook implements a persistent outbox of webhook deliveries,
+// together with the set of watched addresses whose deposits are delivered,
+// and a dispatcher that delivers them.
+package webhook
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/dgraph-io/badger"
+
+	"github.com/oasisprotocol/oasis-core/go/common/logging"
+
+	"github.com/oasisprotocol/oasis-core-rosetta-gateway/common/badgerdb"
+)
+
+var (
+	// ErrNotContiguous is the error returned when enqueueing the deliveries
+	// of a block that does not directly follow the latest followed block.
+	ErrNotContiguous = errors.New("webhook: block does not follow latest block")
+
+	// ErrAlreadyInitialized is the error returned when trying to set the
+	// starting height of a store that already has one.
+	ErrAlreadyInitialized = errors.New("webhook: store already initialized")
+)
+
+var (
+	// Key prefixes.
+	watchedPrefix  = []byte("w/")
+	deliveryPrefix = []byte("d/")
+	// queuePrefix is the prefix of the index of deliveries by their next
+	// attempt, so that due deliveries can be found without reading the
+	// whole outbox.
+	queuePrefix = []byte("q/")
+
+	// Metadata keys.
+	latestKey = []byte("m/latest")
+	nextIDKey = []byte("m/next_id")
+)
+
+var logger = logging.GetLogger("webhook")
+
+// Delivery is a webhook payload waiting in the outbox to be delivered.
+type Delivery struct {
+	// ID is the unique ID of the delivery, increasing in the order in which
+	// deliveries were enqueued.
+	ID uint64 `json:"-"`
+	// Payload is the JSON-encoded body of the webhook request.
+	Payload json.RawMessage `json:"payload"`
+	// Attempts is the number of failed delivery attempts so far.
+	Attempts int `json:"attempts"`
+	// NextAttempt is the time before which the delivery isn't attempted
+	// again.
+	NextAttempt time.Time `json:"next_attempt"`
+}
+
+// Store is a persistent store of the watched addresses, the latest block
+// whose deposits were enqueued and the outbox of webhook deliveries.
+//
+// The deliveries of a block are enqueued together with advancing the latest
+// block, so that no deposit is lost or enqueued twice if the gateway stops.
+type Store struct {
+	sync.Mutex
+
+	db *badger.DB
+	// watched is the in-memory copy of the set of watched addresses.
+	watched map[string]bool
+}
+
+// Open opens (or creates) a webhook store in the given directory.
+func Open(dir string) (*Store, error) {
+	db, err := badgerdb.Open(dir, logger)
+	if err != nil {
+		return nil, fmt.Errorf("webhook: failed to open database: %w", err)
+	}
+
+	s := &Store{
+		db:      db,
+		watched: make(map[string]bool),
+	}
+	err = db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		for it.Seek(watchedPrefix); it.ValidForPrefix(watchedPrefix); it.Next() {
+			s.watched[string(it.Item().Key()[len(watchedPrefix):])] = true
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("webhook: failed to read watched addresses: %w", err)
+	}
+	return s, nil
+}
+
+// Close closes the store.
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// Watch adds the given addresses to the set of watched addresses.
+func (s *Store) Watch(addresses ...string) error {
+	s.Lock()
+	defer s.Unlock()
+
+	err := s.db.Update(func(txn *badger.Txn) error {
+		for _, addr := range addresses {
+			if err := txn.Set(watchedKey(addr), nil); err != nil {
+				return fmt.Errorf("webhook: failed to write watched address: %w", err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, addr := range addresses {
+		s.watched[addr] = true
+	}
+	return nil
+}
+
+// Unwatch removes the given addresses from the set of watched addresses.
+func (s *Store) Unwatch(addresses ...string) error {
+	s.Lock()
+	defer s.Unlock()
+
+	err := s.db.Update(func(txn *badger.Txn) error {
+		for _, addr := range addresses {
+			if err := txn.Delete(watchedKey(addr)); err != nil {
+				return fmt.Errorf("webhook: failed to delete watched address: %w", err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, addr := range addresses {
+		delete(s.watched, addr)
+	}
+	return nil
+}
+
+// IsWatched returns true if the given address is watched.
+func (s *Store) IsWatched(address string) bool {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.watched[address]
+}
+
+// Watched returns all watched addresses, sorted.
+func (s *Store) Watched() []string {
+	s.Lock()
+	defer s.Unlock()
+
+	addresses := make([]string, 0, len(s.watched))
+	for addr := range s.watched {
+		addresses = append(addresses, addr)
+	}
+	sort.Strings(addresses)
+	return addresses
+}
+
+// LatestHeight returns the height of the latest block whose deliveries were
+// enqueued.  The second return value is false if the store has not been
+// initialized yet.
+func (s *Store) LatestHeight() (int64, bool, error) {
+	var (
+		height int64
+		ok     bool
+	)
+	err := s.db.View(func(txn *badger.Txn) error {
+		raw, found, err := getMeta(txn, latestKey)
+		height, ok = int64(raw), found
+		return err
+	})
+	return height, ok, err
+}
+
+// SetLatestHeight initializes the store to enqueue the deliveries of the
+// blocks after the given height.
+func (s *Store) SetLatestHeight(height int64) error {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.db.Update(func(txn *badger.Txn) error {
+		if _, ok, err := getMeta(txn, latestKey); err != nil {
+			return err
+		} else if ok {
+			return ErrAlreadyInitialized
+		}
+		return setMeta(txn, latestKey, uint64(height))
+	})
+}
+
+// Enqueue adds deliveries with the given payloads of the block at the given
+// height, which must directly follow the latest block, to the outbox.
+func (s *Store) Enqueue(height int64, payloads []json.RawMessage) error {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.db.Update(func(txn *badger.Txn) error {
+		latest, ok, err := getMeta(txn, latestKey)
+		if err != nil {
+			return err
+		}
+		if !ok || uint64(height) != latest+1 {
+			return ErrNotContiguous
+		}
+
+		nextID, _, err := getMeta(txn, nextIDKey)
+		if err != nil {
+			return err
+		}
+		for _, payload := range payloads {
+			if err = setDelivery(txn, &Delivery{ID: nextID, Payload: payload}); err != nil {
+				return err
+			}
+			nextID++
+		}
+		if err = setMeta(txn, nextIDKey, nextID); err != nil {
+			return err
+		}
+		return setMeta(txn, latestKey, uint64(height))
+	})
+}
+
+// Due returns up to limit deliveries whose next attempt is due at the given
+// time, in the order of their next attempts.
+func (s *Store) Due(now time.Time, limit int) ([]*Delivery, error) {
+	var due []*Delivery
+	err := s.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		end := queueKey(&Delivery{ID: math.MaxUint64, NextAttempt: now})
+		for it.Seek(queuePrefix); it.ValidForPrefix(queuePrefix) && len(due) < limit; it.Next() {
+			key := it.Item().Key()
+			if bytes.Compare(key, end) > 0 {
+				break
+			}
+			d, err := getDelivery(txn, binary.BigEndian.Uint64(key[len(key)-8:]))
+			if err != nil {
+				return err
+			}
+			due = append(due, d)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return due, nil
+}
+
+// Update stores the given delivery's attempts and next attempt.
+func (s *Store) Update(d *Delivery) error {
+	return s.db.Update(func(txn *badger.Txn) error {
+		if err := deleteDelivery(txn, d.ID); err != nil {
+			return err
+		}
+		return setDelivery(txn, d)
+	})
+}
+
+// Remove removes the given delivery from the outbox.
+func (s *Store) Remove(d *Delivery) error {
+	return s.db.Update(func(txn *badger.Txn) error {
+		return deleteDelivery(txn, d.ID)
+	})
+}
+
+func getDelivery(txn *badger.Txn, id uint64) (*Delivery, error) {
+	item, err := txn.Get(deliveryKey(id))
+	if err != nil {
+		return nil, fmt.Errorf("webhook: failed to read delivery %d: %w", id, err)
+	}
+	raw, err := item.ValueCopy(nil)
+	if err != nil {
+		return nil, fmt.Errorf("webhook: failed to read delivery %d: %w", id, err)
+	}
+	var d Delivery
+	if err = json.Unmarshal(raw, &d); err != nil {
+		return nil, fmt.Errorf("webhook: malformed delivery %d: %w", id, err)
+	}
+	d.ID = id
+	return &d, nil
+}
+
+func setDelivery(txn *badger.Txn, d *Delivery) error {
+	raw, err := json.Marshal(d)
+	if err != nil {
+		return fmt.Errorf("webhook: failed to encode delivery: %w", err)
+	}
+	if err = txn.Set(deliveryKey(d.ID), raw); err != nil {
+		return fmt.Errorf("webhook: failed to write delivery: %w", err)
+	}
+	if err = txn.Set(queueKey(d), nil); err != nil {
+		return fmt.Errorf("webhook: failed to write delivery: %w", err)
+	}
+	return nil
+}
+
+// deleteDelivery deletes the stored delivery with the given ID (if any)
+// together with its entry in the queue.
+func deleteDelivery(txn *badger.Txn, id uint64) error {
+	d, err := getDelivery(txn, id)
+	switch {
+	case err == nil:
+	case errors.Is(err, badger.ErrKeyNotFound):
+		return nil
+	default:
+		return err
+	}
+	if err = txn.Delete(queueKey(d)); err != nil {
+		return fmt.Errorf("webhook: failed to delete delivery: %w", err)
+	}
+	if err = txn.Delete(deliveryKey(id)); err != nil {
+		return fmt.Errorf("webhook: failed to delete delivery: %w", err)
+	}
+	return nil
+}
+
+func getMeta(txn *badger.Txn, metaKey []byte) (uint64, bool, error) {
+	value, ok, err := badgerdb.GetUint64(txn, metaKey)
+	if err != nil {
+		return 0, false, fmt.Errorf("webhook: %w", err)
+	}
+	return value, ok, nil
+}
+
+func setMeta(txn *badger.Txn, metaKey []byte, value uint64) error {
+	if err := badgerdb.SetUint64(txn, metaKey, value); err != nil {
+		return fmt.Errorf("webhook: %w", err)
+	}
+	return nil
+}
+
+func watchedKey(address string) []byte {
+	return append(append([]byte{}, watchedPrefix...), address...)
+}
+
+func deliveryKey(id uint64) []byte {
+	var raw [8]byte
+	binary.BigEndian.PutUint64(raw[:], id)
+	return append(append([]byte{}, deliveryPrefix...), raw[:]...)
+}
+
+// queueKey returns the key of the given delivery in the queue, which sorts by
+// next attempt and then by ID.
+func queueKey(d *Delivery) []byte {
+	var nextAttempt int64
+	if !d.NextAttempt.IsZero() {
+		nextAttempt = d.NextAttempt.UnixNano()
+	}
+	var raw [16]byte
+	binary.BigEndian.PutUint64(raw[:8], uint64(nextAttempt))
+	binary.BigEndian.PutUint64(raw[8:], d.ID)
+	return append(append([]byte{}, queuePrefix...), raw[:]...)
+}
@@ -0,0 +1,179 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestStore(t *testing.T) {
+	dir := t.TempDir()
+	store, err := Open(dir)
+	if err != nil {
+		t.Fatalf("unable to open store: %v", err)
+	}
+
+	if err = store.Watch("b", "a", "c"); err != nil {
+		t.Fatalf("unable to watch addresses: %v", err)
+	}
+	if err = store.Unwatch("c"); err != nil {
+		t.Fatalf("unable to unwatch addresses: %v", err)
+	}
+
+	if _, ok, _ := store.LatestHeight(); ok {
+		t.Fatalf("uninitialized store must not have a latest height")
+	}
+	if err = store.Enqueue(1, nil); err != ErrNotContiguous {
+		t.Fatalf("expected ErrNotContiguous, got %v", err)
+	}
+	if err = store.SetLatestHeight(10); err != nil {
+		t.Fatalf("unable to set latest height: %v", err)
+	}
+	if err = store.SetLatestHeight(10); err != ErrAlreadyInitialized {
+		t.Fatalf("expected ErrAlreadyInitialized, got %v", err)
+	}
+	if err = store.Enqueue(12, nil); err != ErrNotContiguous {
+		t.Fatalf("expected ErrNotContiguous, got %v", err)
+	}
+	if err = store.Enqueue(11, []json.RawMessage{json.RawMessage(`1`), json.RawMessage(`2`)}); err != nil {
+		t.Fatalf("unable to enqueue deliveries: %v", err)
+	}
+
+	// The store survives a restart.
+	store.Close()
+	if store, err = Open(dir); err != nil {
+		t.Fatalf("unable to reopen store: %v", err)
+	}
+	defer store.Close()
+
+	if watched := store.Watched(); len(watched) != 2 || watched[0] != "a" || watched[1] != "b" {
+		t.Fatalf("unexpected watched addresses: %v", watched)
+	}
+	if !store.IsWatched("a") || store.IsWatched("c") {
+		t.Fatalf("unexpected watched addresses: %v", store.Watched())
+	}
+	if height, _, _ := store.LatestHeight(); height != 11 {
+		t.Fatalf("unexpected latest height: %d", height)
+	}
+
+	now := time.Now()
+	due, err := store.Due(now, 10)
+	if err != nil {
+		t.Fatalf("unable to get due deliveries: %v", err)
+	}
+	if len(due) != 2 || string(due[0].Payload) != "1" || string(due[1].Payload) != "2" || due[0].ID >= due[1].ID {
+		t.Fatalf("unexpected due deliveries: %v", due)
+	}
+
+	// Deliveries scheduled for later aren't due.
+	due[0].Attempts, due[0].NextAttempt = 1, now.Add(time.Minute)
+	if err = store.Update(due[0]); err != nil {
+		t.Fatalf("unable to update delivery: %v", err)
+	}
+	if err = store.Remove(due[1]); err != nil {
+		t.Fatalf("unable to remove delivery: %v", err)
+	}
+	if due, _ = store.Due(now, 10); len(due) != 0 {
+		t.Fatalf("unexpected due deliveries: %v", due)
+	}
+	if due, _ = store.Due(now.Add(time.Minute), 10); len(due) != 1 || due[0].Attempts != 1 {
+		t.Fatalf("unexpected due deliveries: %v", due)
+	}
+}
+
+func TestDispatcher(t *testing.T) {
+	store, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatalf("unable to open store: %v", err)
+	}
+	defer store.Close()
+
+	if err = store.SetLatestHeight(0); err != nil {
+		t.Fatalf("unable to set latest height: %v", err)
+	}
+	if err = store.Enqueue(1, []json.RawMessage{json.RawMessage(`{"ok":true}`), json.RawMessage(`{"ok":false}`)}); err != nil {
+		t.Fatalf("unable to enqueue deliveries: %v", err)
+	}
+
+	secret := []byte("secret")
+	var mu sync.Mutex
+	received := make(map[string]int)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if r.Header.Get(SignatureHeader) != Sign(secret, r.Header.Get(TimestampHeader), body) {
+			t.Errorf("invalid signature of delivery %s", r.Header.Get(IDHeader))
+		}
+		mu.Lock()
+		received[string(body)]++
+		mu.Unlock()
+		if string(body) != `{"ok":true}` {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer srv.Close()
+
+	d := NewDispatcher(store, srv.URL, secret, 3)
+	now := time.Now()
+	d.now = func() time.Time { return now }
+
+	ctx := context.Background()
+	for i := 0; i < 5; i++ {
+		if err = d.dispatch(ctx); err != nil {
+			t.Fatalf("unable to dispatch deliveries: %v", err)
+		}
+		d.inFlight.Wait()
+		now = now.Add(maxRetryBackoff)
+	}
+
+	// Successful deliveries are removed, and failed ones retried until they
+	// are given up on.
+	if received[`{"ok":true}`] != 1 || received[`{"ok":false}`] != 3 {
+		t.Fatalf("unexpected deliveries: %v", received)
+	}
+	if due, _ := store.Due(now, 10); len(due) != 0 {
+		t.Fatalf("unexpected due deliveries: %v", due)
+	}
+}
+
+func TestDispatcherConcurrency(t *testing.T) {
+	store, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatalf("unable to open store: %v", err)
+	}
+	defer store.Close()
+
+	if err = store.SetLatestHeight(0); err != nil {
+		t.Fatalf("unable to set latest height: %v", err)
+	}
+	if err = store.Enqueue(1, []json.RawMessage{json.RawMessage(`1`), json.RawMessage(`2`)}); err != nil {
+		t.Fatalf("unable to enqueue deliveries: %v", err)
+	}
+
+	// The endpoint hangs until both deliveries were attempted at once.
+	var arrived sync.WaitGroup
+	arrived.Add(2)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		arrived.Done()
+		arrived.Wait()
+	}))
+	defer srv.Close()
+
+	d := NewDispatcher(store, srv.URL, nil, 3)
+	if err = d.dispatch(context.Background()); err != nil {
+		t.Fatalf("unable to dispatch deliveries: %v", err)
+	}
+
+	// Deliveries being attempted are not due again.
+	if due, _ := store.Due(time.Now(), 10); len(due) != 0 {
+		t.Fatalf("unexpected due deliveries: %v", due)
+	}
+	d.inFlight.Wait()
+	if due, _ := store.Due(time.Now().Add(deliveryLease), 10); len(due) != 0 {
+		t.Fatalf("unexpected due deliveries: %v", due)
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	staking "github.com/oasisprotocol/oasis-core/go/staking/api"
+
+	"github.com/oasisprotocol/oasis-core-rosetta-gateway/services"
+	"github.com/oasisprotocol/oasis-core-rosetta-gateway/webhook"
+)
+
+func TestLoadWatchedAddresses(t *testing.T) {
+	addr := services.StringFromAddress(staking.NewAddress(testSigner.Public()))
+	path := filepath.Join(t.TempDir(), "addresses")
+	if err := ioutil.WriteFile(path, []byte("# Deposit addresses.\n"+addr+"\n\n"), 0o600); err != nil {
+		t.Fatalf("unable to write addresses file: %v", err)
+	}
+	addresses, err := loadWatchedAddresses(path)
+	if err != nil {
+		t.Fatalf("unable to load addresses: %v", err)
+	}
+	if len(addresses) != 1 || addresses[0] != addr {
+		t.Fatalf("unexpected addresses: %v", addresses)
+	}
+
+	if err = ioutil.WriteFile(path, []byte("oasis1invalid\n"), 0o600); err != nil {
+		t.Fatalf("unable to write addresses file: %v", err)
+	}
+	if _, err = loadWatchedAddresses(path); err == nil {
+		t.Fatalf("expected an invalid address to fail")
+	}
+}
+
+func TestWebhookAdminHandler(t *testing.T) {
+	store, err := webhook.Open(t.TempDir())
+	if err != nil {
+		t.Fatalf("unable to open webhook store: %v", err)
+	}
+	defer store.Close()
+
+	h := newWebhookAdminHandler(store)
+	serve := func(method, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(method, webhookAdminAddressesPath, strings.NewReader(body)))
+		return rec
+	}
+	list := func() []string {
+		rec := serve(http.MethodGet, "")
+		var resp watchedAddresses
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); rec.Code != http.StatusOK || err != nil {
+			t.Fatalf("unexpected response %d: %s", rec.Code, rec.Body.String())
+		}
+		return resp.Addresses
+	}
+
+	addr := services.StringFromAddress(staking.NewAddress(testSigner.Public()))
+	if rec := serve(http.MethodPost, `{"addresses":["`+addr+`"]}`); rec.Code != http.StatusNoContent {
+		t.Fatalf("unexpected response %d: %s", rec.Code, rec.Body.String())
+	}
+	if addresses := list(); len(addresses) != 1 || addresses[0] != addr {
+		t.Fatalf("unexpected addresses: %v", addresses)
+	}
+
+	// Invalid requests don't change the watched addresses.
+	for _, tc := range []struct {
+		method string
+		body   string
+		code   int
+	}{
+		{http.MethodPost, `{"addresses":["oasis1invalid"]}`, http.StatusBadRequest},
+		{http.MethodDelete, `{"addresses":`, http.StatusBadRequest},
+		{http.MethodPut, `{"addresses":[]}`, http.StatusMethodNotAllowed},
+	} {
+		if rec := serve(tc.method, tc.body); rec.Code != tc.code {
+			t.Fatalf("unexpected response to %s %s: %d", tc.method, tc.body, rec.Code)
+		}
+	}
+	if addresses := list(); len(addresses) != 1 {
+		t.Fatalf("unexpected addresses: %v", addresses)
+	}
+
+	if rec := serve(http.MethodDelete, `{"addresses":["`+addr+`"]}`); rec.Code != http.StatusNoContent {
+		t.Fatalf("unexpected response %d: %s", rec.Code, rec.Body.String())
+	}
+	if addresses := list(); len(addresses) != 0 {
+		t.Fatalf("unexpected addresses: %v", addresses)
+	}
+}